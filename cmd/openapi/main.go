@@ -0,0 +1,41 @@
+// Command openapi writes the OpenAPI document of the REST API, with -check it fails if the checked in
+// document is out of date instead
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/cybre/fingerbot-web/internal/api"
+)
+
+func main() {
+	output := flag.String("o", "internal/api/openapi.json", "path of the generated document")
+	check := flag.Bool("check", false, "fail if the document at -o is out of date instead of writing it")
+	flag.Parse()
+
+	document, err := api.Document()
+	if err != nil {
+		log.Fatalf("error generating OpenAPI document: %s", err)
+	}
+	document = append(document, '\n')
+
+	if *check {
+		existing, err := os.ReadFile(*output)
+		if err != nil {
+			log.Fatalf("error reading OpenAPI document: %s", err)
+		}
+		if !bytes.Equal(existing, document) {
+			log.Fatalf("%s is out of date, run go generate ./internal/api", *output)
+		}
+		fmt.Printf("%s is up to date\n", *output)
+		return
+	}
+
+	if err := os.WriteFile(*output, document, 0o644); err != nil {
+		log.Fatalf("error writing OpenAPI document: %s", err)
+	}
+}
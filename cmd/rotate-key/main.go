@@ -0,0 +1,77 @@
+// Command rotate-key re-encrypts the device keys in the database with a new master key. Stop the app first, then
+// configure it with the new key, which is read from -new-key-file or written to it with a fresh key when the file
+// does not exist. The current key is read from the configuration like the app does.
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/cybre/fingerbot-web/internal/config"
+	"github.com/cybre/fingerbot-web/internal/secrets"
+)
+
+func main() {
+	database := flag.String("db", "./fingerbot-web.db", "path of the database")
+	newKeyFile := flag.String("new-key-file", "", "path of the new master key, created when it does not exist")
+	flag.Parse()
+
+	if *newKeyFile == "" {
+		log.Fatalf("-new-key-file is required")
+	}
+
+	config, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	key, err := secrets.LoadKey(config.SecretsKey, config.SecretsKeyFile)
+	if err != nil {
+		log.Fatalf("error loading current master key: %s", err)
+	}
+	from, err := secrets.NewCipher(key)
+	if err != nil {
+		log.Fatalf("error creating cipher: %s", err)
+	}
+
+	newKey, err := secrets.LoadKey("", *newKeyFile)
+	if errors.Is(err, os.ErrNotExist) {
+		newKey, err = secrets.CreateKeyFile(*newKeyFile)
+	}
+	if err != nil {
+		log.Fatalf("error loading new master key: %s", err)
+	}
+	to, err := secrets.NewCipher(newKey)
+	if err != nil {
+		log.Fatalf("error creating cipher: %s", err)
+	}
+	if from.KeyID() == to.KeyID() {
+		log.Fatalf("the new master key is the current one")
+	}
+
+	if _, err := os.Stat(*database); err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	db, err := sql.Open("sqlite3", *database)
+	if err != nil {
+		log.Fatalf("error opening database: %s", err)
+	}
+	defer db.Close()
+
+	count, err := secrets.RotateKey(context.Background(), db, from, to, secrets.Columns...)
+	if err != nil {
+		log.Fatalf("error rotating master key: %s", err)
+	}
+
+	fmt.Printf(
+		"re-encrypted %d values from key %s to key %s, set SECRETS_KEY_FILE=%s or copy it over the current key\n",
+		count, from.KeyID(), to.KeyID(), *newKeyFile,
+	)
+}
@@ -0,0 +1,114 @@
+// Command sri pins the scripts and stylesheets the pages load from CDNs with subresource integrity hashes. It
+// downloads every one of them and writes its integrity attribute into the templates, with -check it fails if an
+// attribute is missing or does not match instead. Run it after changing the version of a CDN asset.
+package main
+
+import (
+	"crypto/sha512"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	// tagPattern matches the script and link tags, template actions inside them contain no >
+	tagPattern = regexp.MustCompile(`<(script|link)\b[^>]*>`)
+	// cdnPattern matches the URL of an asset loaded from a CDN
+	cdnPattern = regexp.MustCompile(`\b(?:src|href)="(https://[^"]+)"`)
+	// integrityPattern matches the attributes written for the asset, they are replaced on every run
+	integrityPattern = regexp.MustCompile(`\s+(?:integrity|crossorigin)="[^"]*"`)
+)
+
+func main() {
+	dir := flag.String("dir", "public", "directory of the templates")
+	check := flag.Bool("check", false, "fail if an integrity attribute is missing or out of date instead of writing it")
+	flag.Parse()
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	hashes := map[string]string{}
+	outdated := 0
+
+	err := filepath.WalkDir(*dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || filepath.Ext(path) != ".html" {
+			return err
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var tagErr error
+		pinned := tagPattern.ReplaceAllStringFunc(string(content), func(tag string) string {
+			match := cdnPattern.FindStringSubmatch(tag)
+			if match == nil || tagErr != nil {
+				return tag
+			}
+			if strings.HasPrefix(tag, "<link") && !strings.Contains(tag, `rel="stylesheet"`) {
+				return tag
+			}
+
+			hash, ok := hashes[match[1]]
+			if !ok {
+				if hash, tagErr = integrity(client, match[1]); tagErr != nil {
+					return tag
+				}
+				hashes[match[1]] = hash
+			}
+
+			tag = integrityPattern.ReplaceAllString(tag, "")
+			return strings.TrimSuffix(tag, ">") + fmt.Sprintf(` integrity="%s" crossorigin="anonymous">`, hash)
+		})
+		if tagErr != nil {
+			return fmt.Errorf("%s: %w", path, tagErr)
+		}
+		if pinned == string(content) {
+			return nil
+		}
+
+		outdated++
+		if *check {
+			fmt.Printf("%s has missing or outdated integrity attributes\n", path)
+			return nil
+		}
+
+		return os.WriteFile(path, []byte(pinned), entry.Type().Perm()|0o644)
+	})
+	if err != nil {
+		log.Fatalf("error pinning assets: %s", err)
+	}
+
+	if *check && outdated > 0 {
+		log.Fatalf("%d templates are out of date, run go run ./cmd/sri", outdated)
+	}
+	fmt.Printf("%d assets pinned, %d templates updated\n", len(hashes), outdated)
+}
+
+// integrity downloads the asset and returns its SHA-384 integrity value
+func integrity(client *http.Client, url string) (string, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return "", fmt.Errorf("error downloading %s: %w", url, err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error downloading %s: %s", url, response.Status)
+	}
+
+	hash := sha512.New384()
+	if _, err := io.Copy(hash, response.Body); err != nil {
+		return "", fmt.Errorf("error downloading %s: %w", url, err)
+	}
+
+	return "sha384-" + base64.StdEncoding.EncodeToString(hash.Sum(nil)), nil
+}
@@ -16,9 +16,15 @@ import (
 	"github.com/labstack/echo/v4/middleware"
 	_ "github.com/mattn/go-sqlite3"
 
+	"github.com/cybre/fingerbot-web/internal/auth"
 	"github.com/cybre/fingerbot-web/internal/config"
 	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
 	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/cybre/fingerbot-web/internal/rules"
+	"github.com/cybre/fingerbot-web/internal/scheduler"
+	"github.com/cybre/fingerbot-web/internal/secrets"
+	"github.com/cybre/fingerbot-web/internal/telemetry"
 	"github.com/cybre/fingerbot-web/internal/tuyable"
 	"github.com/cybre/fingerbot-web/internal/webapp"
 )
@@ -57,13 +63,87 @@ func main() {
 		log.Fatalf("error opening database: %s", err)
 	}
 
-	deviceManager := devices.NewManager(devices.NewRepository(db), tuyable.NewDiscoverer(logger), logger)
+	key, err := secrets.LoadKey(config.SecretsKey, config.SecretsKeyFile)
+	if errors.Is(err, os.ErrNotExist) && config.SecretsKey == "" {
+		key, err = secrets.CreateKeyFile(config.SecretsKeyFile)
+		if err == nil {
+			logger.Info(
+				"created master key, back it up along with the database", slog.String("file", config.SecretsKeyFile),
+			)
+		}
+	}
+	if err != nil {
+		log.Fatalf("error loading master key: %s", err)
+	}
+	cipher, err := secrets.NewCipher(key)
+	if err != nil {
+		log.Fatalf("error creating cipher: %s", err)
+	}
+
+	if err := tuyable.Init(); err != nil {
+		log.Fatalf("error initializing bluetooth: %s", err)
+	}
+
+	deviceManager := devices.NewManager(
+		devices.NewRepository(db, cipher), history.NewRepository(db), tuyable.NewDiscoverer(logger), logger,
+	)
 
 	if err := deviceManager.ConnectToSavedDevices(ctx); err != nil {
 		log.Fatalf("error connecting to existing devices: %s", err)
 	}
 
-	application := webapp.NewWebApp(deviceManager)
+	taskScheduler := scheduler.NewScheduler(
+		scheduler.NewRepository(db), deviceManager, config.SchedulerLatitude, config.SchedulerLongitude, logger,
+	)
+	go taskScheduler.Run(ctx)
+
+	rulesEngine := rules.NewEngine(rules.NewRepository(db), deviceManager, logger)
+	go rulesEngine.Run(ctx)
+
+	batteryMonitor := telemetry.NewBatteryMonitor(
+		telemetry.NewRepository(db),
+		deviceManager,
+		telemetry.Thresholds{
+			LowPercent:            config.BatteryLowPercent,
+			ChargeCompletePercent: config.BatteryChargeCompletePercent,
+		},
+		config.BatterySampleInterval,
+		logger,
+	)
+	go batteryMonitor.Run(ctx)
+
+	var oidc *auth.OIDC
+	if config.AuthOIDCIssuer != "" {
+		oidc, err = auth.NewOIDC(auth.OIDCConfig{
+			Issuer:        config.AuthOIDCIssuer,
+			ClientID:      config.AuthOIDCClientID,
+			ClientSecret:  config.AuthOIDCClientSecret,
+			RedirectURL:   config.AuthOIDCRedirectURL,
+			Scopes:        config.AuthOIDCScopes,
+			UsernameClaim: config.AuthOIDCUsernameClaim,
+			RoleClaim:     config.AuthOIDCRoleClaim,
+			RoleValues: map[auth.Role][]string{
+				auth.RoleAdmin:    config.AuthOIDCAdminValues,
+				auth.RoleOperator: config.AuthOIDCOperatorValues,
+				auth.RoleViewer:   config.AuthOIDCViewerValues,
+			},
+			DefaultRole: auth.Role(config.AuthOIDCDefaultRole),
+		})
+		if err != nil {
+			log.Fatalf("error configuring single sign-on: %s", err)
+		}
+	} else if !config.AuthLocalPasswords {
+		log.Fatalf("local passwords can only be disabled when single sign-on is configured with AUTH_OIDC_ISSUER")
+	}
+
+	authManager := auth.NewManager(auth.NewRepository(db), config.AuthSessionTTL, config.AuthLocalPasswords, logger)
+	if err := authManager.Bootstrap(ctx, config.AuthAdminUsername, config.AuthAdminPassword); err != nil {
+		log.Fatalf("error bootstrapping users: %s", err)
+	}
+
+	application := webapp.NewWebApp(
+		deviceManager, taskScheduler, rulesEngine, batteryMonitor, authManager, oidc, config.AuthSecureCookies,
+	)
 	e := echo.New()
 	e.Renderer = application
 	e.Use(middleware.Recover())
@@ -81,7 +161,7 @@ func main() {
 			if v.Error == nil {
 				logger.LogAttrs(ctx, slog.LevelInfo, "REQUEST",
 					slog.String("method", v.Method),
-					slog.String("uri", v.URI),
+					slog.String("uri", webapp.RedactURI(v.URI)),
 					slog.Int("status", v.Status),
 					slog.Duration("latency", v.Latency),
 					slog.Int64("response_size", v.ResponseSize),
@@ -89,7 +169,7 @@ func main() {
 			} else {
 				logger.LogAttrs(ctx, slog.LevelError, "REQUEST_ERROR",
 					slog.String("method", v.Method),
-					slog.String("uri", v.URI),
+					slog.String("uri", webapp.RedactURI(v.URI)),
 					slog.Int("status", v.Status),
 					slog.Duration("latency", v.Latency),
 					slog.Int64("response_size", v.ResponseSize),
@@ -3,13 +3,17 @@ module github.com/cybre/fingerbot-web
 go 1.23.2
 
 require (
+	github.com/coreos/go-oidc/v3 v3.11.0
 	github.com/go-ble/ble v0.0.0-20240122180141-8c5522f54333
+	github.com/go-jose/go-jose/v4 v4.0.2
 	github.com/golang-cz/devslog v0.0.11
 	github.com/google/uuid v1.6.0
 	github.com/joho/godotenv v1.5.1
 	github.com/kelseyhightower/envconfig v1.4.0
 	github.com/labstack/echo/v4 v4.12.0
 	github.com/mattn/go-sqlite3 v1.14.24
+	golang.org/x/crypto v0.25.0
+	golang.org/x/oauth2 v0.23.0
 	golang.org/x/sys v0.26.0
 )
 
@@ -25,8 +29,7 @@ require (
 	github.com/stretchr/testify v1.9.0 // indirect
 	github.com/valyala/bytebufferpool v1.0.0 // indirect
 	github.com/valyala/fasttemplate v1.2.2 // indirect
-	golang.org/x/crypto v0.22.0 // indirect
-	golang.org/x/net v0.24.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
+	golang.org/x/net v0.27.0 // indirect
+	golang.org/x/text v0.16.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 )
@@ -0,0 +1,223 @@
+package api
+
+import (
+	_ "embed"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:generate go run ../../cmd/openapi -o openapi.json
+
+// Spec is the checked in OpenAPI document, run go generate after changing the routes or types.
+// go run ./cmd/openapi -check fails if it is out of date.
+//
+//go:embed openapi.json
+var Spec []byte
+
+// Version is the version of the API described by the document
+const Version = "1.0.0"
+
+// Document generates the OpenAPI document describing Routes
+func Document() ([]byte, error) {
+	schemas := map[string]any{}
+	paths := map[string]map[string]any{}
+
+	for _, route := range Routes {
+		operation := map[string]any{
+			"operationId": route.OperationID,
+			"summary":     route.Summary,
+			"description": "Needs the " + route.Scope + " scope when called with an API token.",
+		}
+
+		parameters := slices.Clone(route.Parameters)
+		if route.Paginated {
+			parameters = append(parameters, limitParameter, offsetParameter)
+		}
+		if route.Conditional && route.Method != http.MethodGet {
+			parameters = append(parameters, Parameter{
+				Name: "If-Match", In: "header", Type: "string", Required: true,
+				Description: "ETag of the configuration the change is based on, the request fails with 428 without it and with 409 if it changed since",
+			})
+		}
+		if len(parameters) > 0 {
+			operation["parameters"] = documentParameters(parameters)
+		}
+
+		if route.Request != nil {
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(schemaOf(reflect.TypeOf(route.Request), schemas)),
+			}
+		}
+
+		success := map[string]any{"description": http.StatusText(route.Status)}
+		if route.Response != nil {
+			success["content"] = jsonContent(schemaOf(reflect.TypeOf(route.Response), schemas))
+		}
+		if route.Conditional && route.Method == http.MethodGet {
+			success["headers"] = map[string]any{
+				"ETag": map[string]any{
+					"description": "Identifies the configuration, send it back in If-Match when saving",
+					"schema":      map[string]any{"type": "string"},
+				},
+			}
+		}
+		errorContent := jsonContent(schemaOf(reflect.TypeOf(ErrorResponse{}), schemas))
+		operation["responses"] = map[string]any{
+			strconv.Itoa(route.Status): success,
+			"default":                  map[string]any{"description": "Error", "content": errorContent},
+		}
+
+		path := Prefix + route.Path
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		paths[path][strings.ToLower(route.Method)] = operation
+	}
+
+	return json.MarshalIndent(map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "Fingerbot Web API",
+			"version":     Version,
+			"description": "Control and configure Fingerbot devices. Errors are returned as an ErrorResponse.",
+		},
+		"paths": paths,
+		"components": map[string]any{
+			"schemas": schemas,
+			"securitySchemes": map[string]any{
+				"apiToken": map[string]any{
+					"type": "http", "scheme": "bearer",
+					"description": "An API token created on the API tokens page",
+				},
+				"session": map[string]any{"type": "apiKey", "in": "cookie", "name": "session"},
+			},
+		},
+		"security": []any{
+			map[string]any{"apiToken": []string{}},
+			map[string]any{"session": []string{}},
+		},
+	}, "", "  ")
+}
+
+func documentParameters(parameters []Parameter) []map[string]any {
+	documented := make([]map[string]any, 0, len(parameters))
+	for _, p := range parameters {
+		parameter := map[string]any{
+			"name":   p.Name,
+			"in":     p.In,
+			"schema": map[string]any{"type": p.Type},
+		}
+		if p.Description != "" {
+			parameter["description"] = p.Description
+		}
+		if p.Required {
+			parameter["required"] = true
+		}
+		documented = append(documented, parameter)
+	}
+
+	return documented
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// schemaOf returns the schema of a type, named structs are added to schemas and referenced
+func schemaOf(t reflect.Type, schemas map[string]any) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == reflect.TypeOf(time.Time{}):
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Kind() == reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			// Registered before the fields so recursive types terminate
+			schemas[t.Name()] = nil
+			schemas[t.Name()] = structSchema(t, schemas)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), schemas)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), schemas)}
+	default:
+		// Interfaces accept any value
+		return map[string]any{}
+	}
+}
+
+func structSchema(t reflect.Type, schemas map[string]any) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	addFields(t, properties, &required, schemas)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+
+	return schema
+}
+
+// addFields adds the JSON fields of a struct, embedded structs are flattened like encoding/json does
+func addFields(t reflect.Type, properties map[string]any, required *[]string, schemas map[string]any) {
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			addFields(field.Type, properties, required, schemas)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		property := schemaOf(field.Type, schemas)
+		if doc := field.Tag.Get("doc"); doc != "" || field.Tag.Get("enum") != "" {
+			// Siblings of $ref are ignored, so documented references are wrapped
+			if _, ok := property["$ref"]; ok {
+				property = map[string]any{"allOf": []any{property}}
+			}
+			if doc != "" {
+				property["description"] = doc
+			}
+			if enum := field.Tag.Get("enum"); enum != "" {
+				property["enum"] = strings.Split(enum, ",")
+			}
+		}
+		properties[name] = property
+
+		if !strings.Contains(options, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSpecIsUpToDate(t *testing.T) {
+	document, err := Document()
+	if err != nil {
+		t.Fatalf("error generating OpenAPI document: %s", err)
+	}
+
+	if !bytes.Equal(Spec, append(document, '\n')) {
+		t.Fatal("openapi.json is out of date, run go generate ./internal/api")
+	}
+}
+
+func TestDocumentCoversRoutes(t *testing.T) {
+	var document struct {
+		Paths map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+		} `json:"paths"`
+	}
+	if err := json.Unmarshal(Spec, &document); err != nil {
+		t.Fatalf("error parsing OpenAPI document: %s", err)
+	}
+
+	for _, route := range Routes {
+		operation, ok := document.Paths[Prefix+route.Path][strings.ToLower(route.Method)]
+		if !ok {
+			t.Errorf("%s %s is missing from the document", route.Method, route.Path)
+			continue
+		}
+		if operation.OperationID != route.OperationID {
+			t.Errorf("%s %s has operation %s, want %s", route.Method, route.Path, operation.OperationID, route.OperationID)
+		}
+	}
+}
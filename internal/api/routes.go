@@ -0,0 +1,148 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+)
+
+// Prefix is the path every API route is served under
+const Prefix = "/api/v1"
+
+// API token scopes, see Route.Scope
+const (
+	ScopePress     = "press"
+	ScopeConfigure = "configure"
+	ScopeAdmin     = "admin"
+)
+
+type Parameter struct {
+	Name        string
+	In          string
+	Description string
+	Required    bool
+	// Type is the JSON schema type of the parameter
+	Type string
+}
+
+var (
+	addressParameter      = Parameter{Name: "address", In: "path", Required: true, Type: "string", Description: "Bluetooth address of the device"}
+	limitParameter        = Parameter{Name: "limit", In: "query", Type: "integer", Description: "Page size, at most 200"}
+	offsetParameter       = Parameter{Name: "offset", In: "query", Type: "integer", Description: "Number of items to skip"}
+	pinParameter          = Parameter{Name: "X-Press-PIN", In: "header", Type: "string", Description: "PIN of a device protected with one"}
+	confirmationParameter = Parameter{Name: "X-Press-Confirmation", In: "header", Type: "string", Description: "Confirmation of a press of a protected device, from the X-Press-Confirmation header of the 428 response. If X-Press-Hold-Ms is set too, start the hold with X-Press-Hold-Start first and confirm the press once it was held that long"}
+	holdStartParameter    = Parameter{Name: "X-Press-Hold-Start", In: "header", Type: "boolean", Description: "Set to true with X-Press-Confirmation to start holding the confirmation, answered with 202 without pressing"}
+)
+
+// Route is an API operation. Request and Response are zero values of the body types, nil when there is no body.
+type Route struct {
+	OperationID string
+	Method      string
+	// Path is relative to Prefix and uses the OpenAPI {param} syntax
+	Path        string
+	Summary     string
+	Parameters  []Parameter
+	Request     any
+	Response    any
+	Status      int
+	Paginated   bool
+	Conditional bool
+	// Scope is the API token scope the operation needs: press, configure or admin
+	Scope string
+}
+
+var pathParameter = regexp.MustCompile(`\{(\w+)\}`)
+
+// EchoPath returns the path in the echo :param syntax
+func (r Route) EchoPath() string {
+	return pathParameter.ReplaceAllString(r.Path, ":$1")
+}
+
+// Routes lists every API operation, the handlers are registered and the OpenAPI document is generated from it
+var Routes = []Route{
+	{
+		OperationID: "listDevices", Method: http.MethodGet, Path: "/devices",
+		Summary:  "List the saved devices",
+		Response: DevicePage{}, Status: http.StatusOK, Paginated: true, Scope: ScopePress,
+	},
+	{
+		OperationID: "createDevice", Method: http.MethodPost, Path: "/devices",
+		Summary: "Pair, save and connect a device",
+		Request: CreateDeviceRequest{}, Response: Device{}, Status: http.StatusCreated, Scope: ScopeAdmin,
+	},
+	{
+		OperationID: "getDevice", Method: http.MethodGet, Path: "/devices/{address}",
+		Summary:    "Get a saved device",
+		Parameters: []Parameter{addressParameter},
+		Response:   Device{}, Status: http.StatusOK, Scope: ScopePress,
+	},
+	{
+		OperationID: "updateDevice", Method: http.MethodPatch, Path: "/devices/{address}",
+		Summary:    "Rename a saved device",
+		Parameters: []Parameter{addressParameter},
+		Request:    UpdateDeviceRequest{}, Response: Device{}, Status: http.StatusOK, Scope: ScopeConfigure,
+	},
+	{
+		OperationID: "deleteDevice", Method: http.MethodDelete, Path: "/devices/{address}",
+		Summary:    "Forget a device",
+		Parameters: []Parameter{addressParameter},
+		Status:     http.StatusNoContent, Scope: ScopeAdmin,
+	},
+	{
+		OperationID: "connectDevice", Method: http.MethodPost, Path: "/devices/{address}/connect",
+		Summary:    "Connect to a saved device",
+		Parameters: []Parameter{addressParameter},
+		Response:   Device{}, Status: http.StatusOK, Scope: ScopeConfigure,
+	},
+	{
+		OperationID: "disconnectDevice", Method: http.MethodPost, Path: "/devices/{address}/disconnect",
+		Summary:    "Disconnect a device",
+		Parameters: []Parameter{addressParameter},
+		Response:   Device{}, Status: http.StatusOK, Scope: ScopeConfigure,
+	},
+	{
+		OperationID: "pressDevice", Method: http.MethodPost, Path: "/devices/{address}/press",
+		Summary:    "Press once, optionally with one-off options",
+		Parameters: []Parameter{addressParameter, pinParameter, confirmationParameter, holdStartParameter},
+		Request:    PressRequest{}, Response: PressResult{}, Status: http.StatusOK, Scope: ScopePress,
+	},
+	{
+		OperationID: "getConfiguration", Method: http.MethodGet, Path: "/devices/{address}/configuration",
+		Summary:    "Get the configuration, the last known one if the device is not connected",
+		Parameters: []Parameter{addressParameter},
+		Response:   Configuration{}, Status: http.StatusOK, Conditional: true, Scope: ScopePress,
+	},
+	{
+		OperationID: "updateConfiguration", Method: http.MethodPut, Path: "/devices/{address}/configuration",
+		Summary:    "Save the configuration, it is queued until reconnect if the device is not connected",
+		Parameters: []Parameter{addressParameter},
+		Request:    Configuration{}, Status: http.StatusNoContent, Conditional: true, Scope: ScopeConfigure,
+	},
+	{
+		OperationID: "getBattery", Method: http.MethodGet, Path: "/devices/{address}/battery",
+		Summary:    "Get the battery status",
+		Parameters: []Parameter{addressParameter},
+		Response:   BatteryStatus{}, Status: http.StatusOK, Scope: ScopePress,
+	},
+	{
+		OperationID: "listDatapoints", Method: http.MethodGet, Path: "/devices/{address}/datapoints",
+		Summary:    "List the datapoints reported by the device",
+		Parameters: []Parameter{addressParameter},
+		Response:   DatapointPage{}, Status: http.StatusOK, Paginated: true, Scope: ScopePress,
+	},
+	{
+		OperationID: "setDatapoint", Method: http.MethodPut, Path: "/devices/{address}/datapoints/{name}",
+		Summary: "Set a writable datapoint",
+		Parameters: []Parameter{addressParameter, {
+			Name: "name", In: "path", Required: true, Type: "string", Description: "Datapoint name, e.g. arm_down_percent",
+		}},
+		Request: SetDatapointRequest{}, Status: http.StatusNoContent, Scope: ScopeConfigure,
+	},
+	{
+		OperationID: "discoverDevices", Method: http.MethodGet, Path: "/discovery",
+		Summary: "Scan for nearby devices",
+		Parameters: []Parameter{{
+			Name: "timeout", In: "query", Type: "integer", Description: "Scan duration in seconds, 5 by default and at most 60",
+		}},
+		Response: DevicePage{}, Status: http.StatusOK, Paginated: true, Scope: ScopeAdmin,
+	},
+}
@@ -0,0 +1,116 @@
+// Package api describes the JSON REST API served under /api/v1, its types and routes are the source of the
+// generated OpenAPI document. It must not depend on the device packages so the document can be generated anywhere.
+package api
+
+import "time"
+
+const (
+	// DefaultLimit is the page size of list endpoints when no limit is given
+	DefaultLimit = 50
+	// MaxLimit is the largest page size of list endpoints
+	MaxLimit = 200
+)
+
+// Error codes returned in ErrorResponse
+const (
+	CodeBadRequest         = "bad_request"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeValidationFailed   = "validation_failed"
+	CodeNotFound           = "not_found"
+	CodeNotConnected       = "not_connected"
+	CodeConflict           = "conflict"
+	CodeProtectionRequired = "protection_required"
+	CodeLocked             = "locked"
+	CodeRateLimited        = "rate_limited"
+	CodeDeviceError        = "device_error"
+	CodeInternal           = "internal"
+)
+
+type Error struct {
+	Code    string `json:"code" doc:"Machine readable error code" enum:"bad_request,unauthorized,forbidden,validation_failed,not_found,not_connected,conflict,protection_required,locked,rate_limited,device_error,internal"`
+	Message string `json:"message"`
+	// Fields maps invalid request fields to their validation messages
+	Fields map[string]string `json:"fields,omitempty" doc:"Validation messages by field"`
+}
+
+// ErrorResponse is the body of every failed request
+type ErrorResponse struct {
+	Error Error `json:"error"`
+}
+
+// Pagination describes the page of a list response
+type Pagination struct {
+	Total  int `json:"total" doc:"Number of items matching the request"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+type Device struct {
+	Address   string `json:"address"`
+	Name      string `json:"name"`
+	Saved     bool   `json:"saved"`
+	Connected bool   `json:"connected"`
+	RSSI      int    `json:"rssi,omitempty" doc:"Signal strength, only known for discovered devices"`
+}
+
+type DevicePage struct {
+	Pagination
+	Items []Device `json:"items"`
+}
+
+type CreateDeviceRequest struct {
+	Address  string `json:"address"`
+	Name     string `json:"name"`
+	DeviceID string `json:"deviceId"`
+	LocalKey string `json:"localKey"`
+}
+
+type UpdateDeviceRequest struct {
+	Name string `json:"name"`
+}
+
+type PressRequest struct {
+	Mode           *string `json:"mode,omitempty" enum:"click,long_press"`
+	HoldTime       *int32  `json:"holdTime,omitempty" doc:"Click sustain time in seconds"`
+	ArmDownPercent *int32  `json:"armDownPercent,omitempty"`
+	ArmUpPercent   *int32  `json:"armUpPercent,omitempty"`
+	ControlBack    *string `json:"controlBack,omitempty" enum:"up,down"`
+}
+
+type PressResult struct {
+	Confirmed       bool  `json:"confirmed" doc:"The device reported the new switch state"`
+	LatencyMs       int64 `json:"latencyMs"`
+	Returned        bool  `json:"returned" doc:"The device reported the arm returning, click mode only"`
+	ReturnLatencyMs int64 `json:"returnLatencyMs,omitempty"`
+}
+
+type Configuration struct {
+	Mode             string `json:"mode" enum:"click,long_press"`
+	ClickSustainTime int32  `json:"clickSustainTime"`
+	ControlBack      string `json:"controlBack" enum:"up,down"`
+	ArmDownPercent   int32  `json:"armDownPercent"`
+	ArmUpPercent     int32  `json:"armUpPercent"`
+}
+
+type BatteryStatus struct {
+	Percent      int32      `json:"percent"`
+	ChargeStatus string     `json:"chargeStatus"`
+	AsOf         *time.Time `json:"asOf,omitempty" doc:"Set when the device is not connected and the status is the last known one"`
+}
+
+type Datapoint struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
+}
+
+type DatapointPage struct {
+	Pagination
+	Items []Datapoint `json:"items"`
+}
+
+type SetDatapointRequest struct {
+	Value string `json:"value" doc:"The value in its text form, enums accept their names"`
+}
@@ -0,0 +1,29 @@
+package auth
+
+import "context"
+
+type userKey struct{}
+
+// WithUser returns a context carrying the authenticated user
+func WithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// UserFromContext returns the authenticated user carried by the context, nil if there is none
+func UserFromContext(ctx context.Context) *User {
+	user, _ := ctx.Value(userKey{}).(*User)
+	return user
+}
+
+type apiTokenKey struct{}
+
+// WithAPIToken returns a context carrying the API token the request was authenticated with
+func WithAPIToken(ctx context.Context, token *APIToken) context.Context {
+	return context.WithValue(ctx, apiTokenKey{}, token)
+}
+
+// APITokenFromContext returns the API token carried by the context, nil if the request was not authenticated with one
+func APITokenFromContext(ctx context.Context) *APIToken {
+	token, _ := ctx.Value(apiTokenKey{}).(*APIToken)
+	return token
+}
@@ -0,0 +1,449 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// GuestAction is what a guest link does when it is used
+type GuestAction string
+
+const (
+	// GuestActionPress presses the device with its current configuration
+	GuestActionPress GuestAction = "press"
+	// GuestActionPreset presses the device with one of its presets
+	GuestActionPreset GuestAction = "preset"
+)
+
+var GuestActions = []GuestAction{GuestActionPress, GuestActionPreset}
+
+const (
+	// MaxGuestLinkPINAttempts is the number of wrong PINs after which a guest link is revoked
+	MaxGuestLinkPINAttempts = 5
+	// MaxGuestLinkTTL is the longest a guest link may be valid for
+	MaxGuestLinkTTL = 30 * 24 * time.Hour
+
+	guestLinkKeyName = "guest_link_key"
+)
+
+var (
+	ErrInvalidGuestAction     = errors.New("invalid guest link action")
+	ErrInvalidGuestLinkName   = errors.New("guest link name must not be empty")
+	ErrInvalidGuestLinkExpiry = fmt.Errorf("guest links must expire within %d days", MaxGuestLinkTTL/(24*time.Hour))
+	ErrInvalidGuestLinkUses   = errors.New("guest links must allow at least one use")
+	ErrInvalidGuestLinkPIN    = errors.New("PIN must be 4 to 8 digits")
+	ErrGuestLinkNotFound      = errors.New("guest link not found")
+	ErrGuestLinkInvalid       = errors.New("this link is not valid")
+	ErrGuestLinkRevoked       = errors.New("this link was revoked")
+	ErrGuestLinkExpired       = errors.New("this link expired")
+	ErrGuestLinkUsedUp        = errors.New("this link was already used up")
+	ErrGuestLinkWrongPIN      = errors.New("wrong PIN")
+)
+
+// GuestLink allows anyone with its URL to run one action on one device, a limited number of times until it expires.
+// The URL is signed rather than stored, so it can be shown again for as long as the link is valid.
+type GuestLink struct {
+	ID      int64       `sql:"id"`
+	Name    string      `sql:"name"`
+	Address string      `sql:"address"`
+	Action  GuestAction `sql:"action"`
+	// PresetID is the preset pressed by GuestActionPreset
+	PresetID int64 `sql:"preset_id"`
+	MaxUses  int   `sql:"max_uses"`
+	Uses     int   `sql:"uses"`
+	// PINHash is the bcrypt hash of the PIN guests must enter, empty if the link has none
+	PINHash           string       `sql:"pin_hash"`
+	FailedPINAttempts int          `sql:"failed_pin_attempts"`
+	CreatedBy         string       `sql:"created_by"`
+	CreatedAt         time.Time    `sql:"created_at"`
+	ExpiresAt         time.Time    `sql:"expires_at"`
+	LastUsedAt        sql.NullTime `sql:"last_used_at"`
+	RevokedAt         sql.NullTime `sql:"revoked_at"`
+}
+
+func (l *GuestLink) HasPIN() bool {
+	return l.PINHash != ""
+}
+
+func (l *GuestLink) Expired(now time.Time) bool {
+	return now.After(l.ExpiresAt)
+}
+
+func (l *GuestLink) Revoked() bool {
+	return l.RevokedAt.Valid
+}
+
+func (l *GuestLink) UsedUp() bool {
+	return l.Uses >= l.MaxUses
+}
+
+// Usable returns why the link can no longer be used, nil if it can
+func (l *GuestLink) Usable(now time.Time) error {
+	switch {
+	case l.Revoked():
+		return ErrGuestLinkRevoked
+	case l.Expired(now):
+		return ErrGuestLinkExpired
+	case l.UsedUp():
+		return ErrGuestLinkUsedUp
+	default:
+		return nil
+	}
+}
+
+// signedFields are the fields the signature covers, changing any of them invalidates the URL
+func (l *GuestLink) signedFields() string {
+	return fmt.Sprintf("%d|%s|%s|%d|%d", l.ID, l.Address, l.Action, l.PresetID, l.ExpiresAt.Unix())
+}
+
+func (r *Repository) initGuestLinks() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS guest_links (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			address TEXT NOT NULL,
+			action TEXT NOT NULL,
+			preset_id INTEGER NOT NULL,
+			max_uses INTEGER NOT NULL,
+			uses INTEGER NOT NULL DEFAULT 0,
+			pin_hash TEXT NOT NULL,
+			failed_pin_attempts INTEGER NOT NULL DEFAULT 0,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating guest links table: %w", err)
+	}
+
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS secrets (
+			name TEXT PRIMARY KEY,
+			value BLOB NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating secrets table: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return fmt.Errorf("error generating guest link key: %w", err)
+	}
+	if _, err := r.db.Exec(
+		"INSERT OR IGNORE INTO secrets (name, value) VALUES ($1, $2)", guestLinkKeyName, key,
+	); err != nil {
+		return fmt.Errorf("error creating guest link key: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetSecret(ctx context.Context, name string) ([]byte, error) {
+	var value []byte
+	if err := r.db.QueryRowContext(ctx, "SELECT value FROM secrets WHERE name = $1", name).Scan(&value); err != nil {
+		return nil, fmt.Errorf("error getting secret %s: %w", name, err)
+	}
+
+	return value, nil
+}
+
+func (r *Repository) CreateGuestLink(ctx context.Context, l *GuestLink) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO guest_links (name, address, action, preset_id, max_uses, pin_hash, created_by, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		l.Name, l.Address, l.Action, l.PresetID, l.MaxUses, l.PINHash, l.CreatedBy, l.CreatedAt.UTC(), l.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating guest link: %w", err)
+	}
+
+	l.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting guest link id: %w", err)
+	}
+
+	return nil
+}
+
+const guestLinkColumns = "id, name, address, action, preset_id, max_uses, uses, pin_hash, failed_pin_attempts, created_by, created_at, expires_at, last_used_at, revoked_at"
+
+func (r *Repository) GetGuestLink(ctx context.Context, id int64) (*GuestLink, error) {
+	link, err := scanGuestLink(r.db.QueryRowContext(ctx, "SELECT "+guestLinkColumns+" FROM guest_links WHERE id = $1", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error getting guest link: %w", err)
+	}
+
+	return link, nil
+}
+
+// GetGuestLinks returns every guest link, the newest first
+func (r *Repository) GetGuestLinks(ctx context.Context) ([]*GuestLink, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+guestLinkColumns+" FROM guest_links ORDER BY id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("error getting guest links: %w", err)
+	}
+	defer rows.Close()
+
+	var links []*GuestLink
+	for rows.Next() {
+		link, err := scanGuestLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning guest link: %w", err)
+		}
+
+		links = append(links, link)
+	}
+
+	return links, nil
+}
+
+// UseGuestLink counts a use of the link, it returns false without counting it if the link was revoked or used up
+// meanwhile
+func (r *Repository) UseGuestLink(ctx context.Context, id int64, usedAt time.Time) (bool, error) {
+	result, err := r.db.ExecContext(
+		ctx,
+		"UPDATE guest_links SET uses = uses + 1, last_used_at = $1 WHERE id = $2 AND uses < max_uses AND revoked_at IS NULL",
+		usedAt.UTC(), id,
+	)
+	if err != nil {
+		return false, fmt.Errorf("error using guest link: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error getting used guest links: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+// FailGuestLinkPIN counts a wrong PIN and revokes the link once there were too many, it returns the new count
+func (r *Repository) FailGuestLinkPIN(ctx context.Context, id int64, failedAt time.Time) (int, error) {
+	var attempts int
+	if err := r.db.QueryRowContext(
+		ctx,
+		"UPDATE guest_links SET failed_pin_attempts = failed_pin_attempts + 1 WHERE id = $1 RETURNING failed_pin_attempts",
+		id,
+	).Scan(&attempts); err != nil {
+		return 0, fmt.Errorf("error counting wrong guest link PIN: %w", err)
+	}
+
+	if attempts >= MaxGuestLinkPINAttempts {
+		if err := r.RevokeGuestLink(ctx, id, failedAt); err != nil {
+			return 0, err
+		}
+	}
+
+	return attempts, nil
+}
+
+func (r *Repository) RevokeGuestLink(ctx context.Context, id int64, revokedAt time.Time) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE guest_links SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", revokedAt.UTC(), id,
+	); err != nil {
+		return fmt.Errorf("error revoking guest link: %w", err)
+	}
+
+	return nil
+}
+
+func scanGuestLink(row scanner) (*GuestLink, error) {
+	var link GuestLink
+	if err := row.Scan(
+		&link.ID, &link.Name, &link.Address, &link.Action, &link.PresetID, &link.MaxUses, &link.Uses, &link.PINHash,
+		&link.FailedPINAttempts, &link.CreatedBy, &link.CreatedAt, &link.ExpiresAt, &link.LastUsedAt, &link.RevokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &link, nil
+}
+
+// CreateGuestLink creates a guest link, createdBy describes who created it. The PIN is optional.
+func (m *Manager) CreateGuestLink(ctx context.Context, createdBy string, link *GuestLink, pin string) error {
+	link.Name = strings.TrimSpace(link.Name)
+	if link.Name == "" {
+		return ErrInvalidGuestLinkName
+	}
+	if !slices.Contains(GuestActions, link.Action) {
+		return fmt.Errorf("%w: %s", ErrInvalidGuestAction, link.Action)
+	}
+	if link.Action != GuestActionPreset {
+		link.PresetID = 0
+	}
+	if link.MaxUses < 1 {
+		return ErrInvalidGuestLinkUses
+	}
+
+	now := time.Now()
+	if !link.ExpiresAt.After(now) || link.ExpiresAt.Sub(now) > MaxGuestLinkTTL {
+		return ErrInvalidGuestLinkExpiry
+	}
+
+	if pin != "" {
+		if len(pin) < 4 || len(pin) > 8 || strings.Trim(pin, "0123456789") != "" {
+			return ErrInvalidGuestLinkPIN
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("failed to hash PIN: %w", err)
+		}
+		link.PINHash = string(hash)
+	}
+
+	link.CreatedBy = createdBy
+	link.CreatedAt = now
+	link.Uses, link.FailedPINAttempts = 0, 0
+	if err := m.repository.CreateGuestLink(ctx, link); err != nil {
+		return fmt.Errorf("failed to create guest link: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) GetGuestLinks(ctx context.Context) ([]*GuestLink, error) {
+	links, err := m.repository.GetGuestLinks(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest links: %w", err)
+	}
+
+	return links, nil
+}
+
+// RevokeGuestLink revokes the link for good, it is kept so its uses stay traceable
+func (m *Manager) RevokeGuestLink(ctx context.Context, id int64) (*GuestLink, error) {
+	if err := m.repository.RevokeGuestLink(ctx, id, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to revoke guest link: %w", err)
+	}
+
+	link, err := m.repository.GetGuestLink(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest link: %w", err)
+	}
+	if link == nil {
+		return nil, ErrGuestLinkNotFound
+	}
+
+	return link, nil
+}
+
+// GuestLinkToken returns the signed token identifying the link in its URL
+func (m *Manager) GuestLinkToken(ctx context.Context, link *GuestLink) (string, error) {
+	signature, err := m.signGuestLink(ctx, link)
+	if err != nil {
+		return "", err
+	}
+
+	return strconv.FormatInt(link.ID, 10) + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+func (m *Manager) signGuestLink(ctx context.Context, link *GuestLink) ([]byte, error) {
+	key, err := m.repository.GetSecret(ctx, guestLinkKeyName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest link key: %w", err)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(link.signedFields()))
+
+	return mac.Sum(nil), nil
+}
+
+// GetGuestLink returns the link identified by the signed token, ErrGuestLinkInvalid if the token was not signed by this
+// app. The link is returned even if it can no longer be used, see GuestLink.Usable.
+func (m *Manager) GetGuestLink(ctx context.Context, token string) (*GuestLink, error) {
+	rawID, rawSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrGuestLinkInvalid
+	}
+	id, err := strconv.ParseInt(rawID, 10, 64)
+	if err != nil {
+		return nil, ErrGuestLinkInvalid
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(rawSignature)
+	if err != nil {
+		return nil, ErrGuestLinkInvalid
+	}
+
+	link, err := m.repository.GetGuestLink(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get guest link: %w", err)
+	}
+	if link == nil {
+		return nil, ErrGuestLinkInvalid
+	}
+
+	expected, err := m.signGuestLink(ctx, link)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrGuestLinkInvalid
+	}
+
+	return link, nil
+}
+
+// UseGuestLink checks the PIN and counts a use of the link identified by the signed token, the caller runs the action
+// afterwards. Too many wrong PINs revoke the link.
+func (m *Manager) UseGuestLink(ctx context.Context, token, pin string) (*GuestLink, error) {
+	link, err := m.GetGuestLink(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if err := link.Usable(now); err != nil {
+		return link, err
+	}
+
+	if link.HasPIN() {
+		if err := bcrypt.CompareHashAndPassword([]byte(link.PINHash), []byte(pin)); err != nil {
+			attempts, err := m.repository.FailGuestLinkPIN(ctx, link.ID, now)
+			if err != nil {
+				return link, fmt.Errorf("failed to record wrong PIN: %w", err)
+			}
+			link.FailedPINAttempts = attempts
+			if attempts >= MaxGuestLinkPINAttempts {
+				m.logger.Warn("revoked guest link after too many wrong PINs", slog.Int64("id", link.ID), slog.String("name", link.Name))
+				link.RevokedAt = sql.NullTime{Time: now, Valid: true}
+				return link, ErrGuestLinkRevoked
+			}
+
+			return link, ErrGuestLinkWrongPIN
+		}
+	}
+
+	used, err := m.repository.UseGuestLink(ctx, link.ID, now)
+	if err != nil {
+		return link, fmt.Errorf("failed to use guest link: %w", err)
+	}
+	if !used {
+		// Revoked or used up by a concurrent request since it was read
+		return link, ErrGuestLinkUsedUp
+	}
+	link.Uses++
+	link.LastUsedAt = sql.NullTime{Time: now, Valid: true}
+
+	return link, nil
+}
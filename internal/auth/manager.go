@@ -0,0 +1,212 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// MinPasswordLength is the minimum number of characters of a password
+const MinPasswordLength = 8
+
+var (
+	ErrInvalidCredentials = errors.New("invalid username or password")
+	ErrUsernameTaken      = errors.New("username already taken")
+	ErrInvalidUsername    = errors.New("username must not be empty")
+	ErrPasswordTooShort   = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrNoAdminPassword    = errors.New("no users exist, set AUTH_ADMIN_PASSWORD to create the initial admin")
+	// ErrLocalPasswordsDisabled is returned by password logins and new users when users log in with single sign-on
+	ErrLocalPasswordsDisabled = errors.New("logging in with a password is disabled, use single sign-on")
+)
+
+// dummyHash is compared against when the user does not exist, so logins take as long for unknown usernames
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("fingerbot-web"), bcrypt.DefaultCost)
+
+type Manager struct {
+	repository *Repository
+	sessionTTL time.Duration
+	// localPasswords allows logging in with a password, users log in with single sign-on only when it is off
+	localPasswords bool
+	logger         *slog.Logger
+}
+
+func NewManager(repository *Repository, sessionTTL time.Duration, localPasswords bool, logger *slog.Logger) *Manager {
+	return &Manager{
+		repository:     repository,
+		sessionTTL:     sessionTTL,
+		localPasswords: localPasswords,
+		logger:         logger,
+	}
+}
+
+// LocalPasswords reports whether users may log in with a password
+func (m *Manager) LocalPasswords() bool {
+	return m.localPasswords
+}
+
+// Bootstrap creates the initial admin when there are no users, the credentials are ignored afterwards. Without local
+// passwords the first admin logs in with single sign-on instead.
+func (m *Manager) Bootstrap(ctx context.Context, username, password string) error {
+	if !m.localPasswords {
+		return nil
+	}
+
+	count, err := m.repository.CountUsers(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to count users: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if password == "" {
+		return ErrNoAdminPassword
+	}
+
+	if _, err := m.CreateUser(ctx, username, password, RoleAdmin); err != nil {
+		return fmt.Errorf("failed to create initial admin: %w", err)
+	}
+	m.logger.Info("created initial admin", slog.String("username", username))
+
+	return nil
+}
+
+func (m *Manager) CreateUser(ctx context.Context, username, password string, role Role) (*User, error) {
+	if !m.localPasswords {
+		return nil, ErrLocalPasswordsDisabled
+	}
+
+	username = strings.TrimSpace(username)
+	if username == "" {
+		return nil, ErrInvalidUsername
+	}
+	if _, err := ParseRole(string(role)); err != nil {
+		return nil, err
+	}
+	if len(password) < MinPasswordLength {
+		return nil, ErrPasswordTooShort
+	}
+
+	existing, err := m.repository.GetUserByUsername(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if existing != nil {
+		return nil, ErrUsernameTaken
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	user := &User{
+		Username:     username,
+		PasswordHash: string(hash),
+		Role:         role,
+		CreatedAt:    time.Now(),
+	}
+	if err := m.repository.CreateUser(ctx, user); err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	return user, nil
+}
+
+// Login checks the credentials and starts a session, the returned token identifies it and is only known to the caller
+func (m *Manager) Login(ctx context.Context, username, password string) (string, *Session, error) {
+	if !m.localPasswords {
+		return "", nil, ErrLocalPasswordsDisabled
+	}
+
+	user, err := m.repository.GetUserByUsername(ctx, strings.TrimSpace(username))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", nil, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return "", nil, ErrInvalidCredentials
+	}
+
+	return m.startSession(ctx, user)
+}
+
+// startSession starts a session of the logged in user
+func (m *Manager) startSession(ctx context.Context, user *User) (string, *Session, error) {
+	now := time.Now()
+	if err := m.repository.DeleteExpiredSessions(ctx, now); err != nil {
+		m.logger.Error("failed to delete expired sessions", slog.Any("error", err))
+	}
+
+	token, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	session := &Session{
+		TokenHash: hashToken(token),
+		UserID:    user.ID,
+		CreatedAt: now,
+		ExpiresAt: now.Add(m.sessionTTL),
+	}
+	if err := m.repository.CreateSession(ctx, session); err != nil {
+		return "", nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return token, session, nil
+}
+
+// Authenticate returns the user of the session identified by the token, nil if it does not exist or expired
+func (m *Manager) Authenticate(ctx context.Context, token string) (*User, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	session, err := m.repository.GetSession(ctx, hashToken(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session: %w", err)
+	}
+	if session == nil || time.Now().After(session.ExpiresAt) {
+		return nil, nil
+	}
+
+	user, err := m.repository.GetUser(ctx, session.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (m *Manager) Logout(ctx context.Context, token string) error {
+	if err := m.repository.DeleteSession(ctx, hashToken(token)); err != nil {
+		return fmt.Errorf("failed to delete session: %w", err)
+	}
+
+	return nil
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrInvalidOIDCConfig = errors.New("invalid single sign-on configuration")
+	ErrOIDCLoginInvalid  = errors.New("the single sign-on login expired or was started elsewhere, try again")
+	ErrOIDCNoRole        = errors.New("your account has no role in fingerbot-web, ask an admin for access")
+)
+
+// OIDCConfig configures single sign-on with an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the callback registered with the provider
+	RedirectURL string
+	// Scopes are requested on top of openid
+	Scopes []string
+	// UsernameClaim names the users, the subject is used when the ID token does not have it
+	UsernameClaim string
+	// RoleClaim is the claim holding the groups or roles of the user, a dotted path selects a nested claim, e.g.
+	// realm_access.roles
+	RoleClaim string
+	// RoleValues are the values of the role claim granting each role, the most privileged matching role wins
+	RoleValues map[Role][]string
+	// DefaultRole is given to users matching no value, users are refused when it is empty
+	DefaultRole Role
+}
+
+// OIDC logs users in with the authorization code flow and PKCE. The provider is discovered on the first login, so
+// the app starts while it is unreachable.
+type OIDC struct {
+	config OIDCConfig
+
+	mutex    sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDC(config OIDCConfig) (*OIDC, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, fmt.Errorf("%w: the issuer, client ID and redirect URL are required", ErrInvalidOIDCConfig)
+	}
+	if config.DefaultRole != "" {
+		if _, err := ParseRole(string(config.DefaultRole)); err != nil {
+			return nil, fmt.Errorf("%w: default role: %w", ErrInvalidOIDCConfig, err)
+		}
+	}
+
+	return &OIDC{config: config}, nil
+}
+
+// OIDCLogin is a login waiting for the provider to redirect back, the caller keeps it until then
+type OIDCLogin struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// Next is the page to return to after logging in
+	Next string `json:"next"`
+}
+
+// Identity is the user the provider vouched for
+type Identity struct {
+	Subject  string
+	Username string
+	Role     Role
+}
+
+func (o *OIDC) getProvider(ctx context.Context) (*oidc.Provider, error) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.provider == nil {
+		// The provider keeps the context to refresh its keys, so it must outlive the request
+		provider, err := oidc.NewProvider(context.WithoutCancel(ctx), o.config.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover provider: %w", err)
+		}
+		o.provider = provider
+	}
+
+	return o.provider, nil
+}
+
+func (o *OIDC) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     o.config.ClientID,
+		ClientSecret: o.config.ClientSecret,
+		RedirectURL:  o.config.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, o.config.Scopes...),
+	}
+}
+
+// Start begins a login, the user is sent to the returned URL of the provider
+func (o *OIDC) Start(ctx context.Context, next string) (string, *OIDCLogin, error) {
+	provider, err := o.getProvider(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+
+	login := &OIDCLogin{Verifier: oauth2.GenerateVerifier(), Next: next}
+	if login.State, err = randomString(); err != nil {
+		return "", nil, err
+	}
+	if login.Nonce, err = randomString(); err != nil {
+		return "", nil, err
+	}
+
+	url := o.oauth2Config(provider).AuthCodeURL(
+		login.State, oidc.Nonce(login.Nonce), oauth2.S256ChallengeOption(login.Verifier),
+	)
+
+	return url, login, nil
+}
+
+// Finish exchanges the code the provider redirected back with for the identity of the user
+func (o *OIDC) Finish(ctx context.Context, login *OIDCLogin, state, code string) (*Identity, error) {
+	if login == nil || login.State == "" || state != login.State {
+		return nil, ErrOIDCLoginInvalid
+	}
+
+	provider, err := o.getProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := o.oauth2Config(provider).Exchange(ctx, code, oauth2.VerifierOption(login.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("failed to get ID token: the provider did not return one")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: o.config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify ID token: %w", err)
+	}
+	if idToken.Nonce != login.Nonce {
+		return nil, ErrOIDCLoginInvalid
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse ID token claims: %w", err)
+	}
+
+	identity := &Identity{Subject: idToken.Subject, Username: idToken.Subject}
+	if username := claimValues(claims, o.config.UsernameClaim); len(username) > 0 && username[0] != "" {
+		identity.Username = username[0]
+	}
+	if identity.Role = o.role(claimValues(claims, o.config.RoleClaim)); identity.Role == "" {
+		return nil, ErrOIDCNoRole
+	}
+
+	return identity, nil
+}
+
+// role returns the most privileged role granted by the values of the role claim
+func (o *OIDC) role(values []string) Role {
+	for _, role := range slices.Backward(Roles) {
+		for _, value := range o.config.RoleValues[role] {
+			if slices.Contains(values, value) {
+				return role
+			}
+		}
+	}
+
+	return o.config.DefaultRole
+}
+
+// claimValues returns the strings of the claim at the dotted path, a single string or a list of them
+func claimValues(claims map[string]any, path string) []string {
+	if path == "" {
+		return nil
+	}
+
+	var claim any = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := claim.(map[string]any)
+		if !ok {
+			return nil
+		}
+		claim = object[key]
+	}
+
+	switch claim := claim.(type) {
+	case string:
+		return []string{claim}
+	case []any:
+		var values []string
+		for _, value := range claim {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+
+	return nil
+}
+
+// LoginOIDC starts a session for the identity, creating its user on the first login. A user with the same username
+// who has not logged in with single sign-on before is linked to it. The role is taken from the provider every time.
+func (m *Manager) LoginOIDC(ctx context.Context, identity *Identity) (string, *Session, error) {
+	user, err := m.repository.GetUserBySubject(ctx, identity.Subject)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	if user == nil {
+		user, err = m.repository.GetUserByUsername(ctx, identity.Username)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to get user: %w", err)
+		}
+
+		switch {
+		case user == nil:
+			user = &User{Username: identity.Username, Role: identity.Role, Subject: identity.Subject, CreatedAt: time.Now()}
+			if err := m.repository.CreateUser(ctx, user); err != nil {
+				return "", nil, fmt.Errorf("failed to create user: %w", err)
+			}
+			m.logger.Info("created single sign-on user", slog.String("username", user.Username))
+		case user.Subject != "":
+			return "", nil, ErrUsernameTaken
+		default:
+			if err := m.repository.SetUserSubject(ctx, user.ID, identity.Subject); err != nil {
+				return "", nil, fmt.Errorf("failed to link user: %w", err)
+			}
+			user.Subject = identity.Subject
+			m.logger.Info("linked user to single sign-on", slog.String("username", user.Username))
+		}
+	}
+
+	if user.Role != identity.Role {
+		if err := m.repository.UpdateUserRole(ctx, user.ID, identity.Role); err != nil {
+			return "", nil, fmt.Errorf("failed to update user role: %w", err)
+		}
+		user.Role = identity.Role
+	}
+
+	return m.startSession(ctx, user)
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random string: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
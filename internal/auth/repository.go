@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+type User struct {
+	ID           int64     `sql:"id"`
+	Username     string    `sql:"username"`
+	PasswordHash string    `sql:"password_hash"`
+	Role         Role      `sql:"role"`
+	CreatedAt    time.Time `sql:"created_at"`
+	// Subject identifies the user at the single sign-on provider, empty for users who never logged in with it
+	Subject string `sql:"oidc_subject"`
+}
+
+// Session is a login, only the hash of its token is stored so a leaked database does not leak sessions
+type Session struct {
+	TokenHash string    `sql:"token_hash"`
+	UserID    int64     `sql:"user_id"`
+	CreatedAt time.Time `sql:"created_at"`
+	ExpiresAt time.Time `sql:"expires_at"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	repo := &Repository{db: db}
+	if err := repo.init(); err != nil {
+		panic(err)
+	}
+
+	return repo
+}
+
+func (r *Repository) init() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL DEFAULT 'admin',
+			created_at TIMESTAMP NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating users table: %w", err)
+	}
+
+	// Users created before roles existed could do everything
+	if err := r.addColumn("users", "role", "TEXT NOT NULL DEFAULT 'admin'"); err != nil {
+		return err
+	}
+	if err := r.addColumn("users", "oidc_subject", "TEXT"); err != nil {
+		return err
+	}
+	if _, err := r.db.Exec(
+		"CREATE UNIQUE INDEX IF NOT EXISTS users_oidc_subject ON users (oidc_subject)",
+	); err != nil {
+		return fmt.Errorf("error creating users oidc subject index: %w", err)
+	}
+
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS device_grants (
+			user_id INTEGER NOT NULL,
+			address TEXT NOT NULL,
+			role TEXT NOT NULL,
+			PRIMARY KEY (user_id, address)
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating device grants table: %w", err)
+	}
+
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS sessions (
+			token_hash TEXT PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating sessions table: %w", err)
+	}
+
+	if err := r.initAPITokens(); err != nil {
+		return err
+	}
+
+	return r.initGuestLinks()
+}
+
+// addColumn adds the column to a table created before it existed
+func (r *Repository) addColumn(table, column, definition string) error {
+	var count int
+	if err := r.db.QueryRow(
+		"SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2", table, column,
+	).Scan(&count); err != nil {
+		return fmt.Errorf("error checking %s columns: %w", table, err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if _, err := r.db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("error adding %s column to %s: %w", column, table, err)
+	}
+
+	return nil
+}
+
+func (r *Repository) CountUsers(ctx context.Context) (int, error) {
+	var count int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting users: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *Repository) CreateUser(ctx context.Context, u *User) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO users (username, password_hash, role, oidc_subject, created_at) VALUES ($1, $2, $3, NULLIF($4, ''), $5)",
+		u.Username, u.PasswordHash, u.Role, u.Subject, u.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating user: %w", err)
+	}
+
+	u.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting user id: %w", err)
+	}
+
+	return nil
+}
+
+const userColumns = "id, username, password_hash, role, created_at, COALESCE(oidc_subject, '')"
+
+func (r *Repository) GetUser(ctx context.Context, id int64) (*User, error) {
+	return r.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1", id)
+}
+
+func (r *Repository) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	return r.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE username = $1", username)
+}
+
+// GetUserBySubject returns the user the single sign-on subject was linked to
+func (r *Repository) GetUserBySubject(ctx context.Context, subject string) (*User, error) {
+	return r.getUser(ctx, "SELECT "+userColumns+" FROM users WHERE oidc_subject = $1", subject)
+}
+
+func (r *Repository) getUser(ctx context.Context, query string, arg any) (*User, error) {
+	u, err := scanUser(r.db.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error getting user: %w", err)
+	}
+
+	return u, nil
+}
+
+// GetUsers returns every user ordered by username
+func (r *Repository) GetUsers(ctx context.Context) ([]*User, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+userColumns+" FROM users ORDER BY username")
+	if err != nil {
+		return nil, fmt.Errorf("error getting users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning user: %w", err)
+		}
+
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+func scanUser(row scanner) (*User, error) {
+	var u User
+	if err := row.Scan(&u.ID, &u.Username, &u.PasswordHash, &u.Role, &u.CreatedAt, &u.Subject); err != nil {
+		return nil, err
+	}
+
+	return &u, nil
+}
+
+func (r *Repository) CountUsersWithRole(ctx context.Context, role Role) (int, error) {
+	var count int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE role = $1", role).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting users: %w", err)
+	}
+
+	return count, nil
+}
+
+func (r *Repository) UpdateUserRole(ctx context.Context, id int64, role Role) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2", role, id); err != nil {
+		return fmt.Errorf("error updating user role: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) SetUserSubject(ctx context.Context, id int64, subject string) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE users SET oidc_subject = $1 WHERE id = $2", subject, id); err != nil {
+		return fmt.Errorf("error setting user subject: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUser deletes the user along with their sessions and grants
+func (r *Repository) DeleteUser(ctx context.Context, id int64) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM sessions WHERE user_id = $1",
+		"DELETE FROM device_grants WHERE user_id = $1",
+		"DELETE FROM users WHERE id = $1",
+	} {
+		if _, err := tx.ExecContext(ctx, query, id); err != nil {
+			return fmt.Errorf("error deleting user: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetGrants(ctx context.Context, userID int64) (Grants, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT address, role FROM device_grants WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting grants: %w", err)
+	}
+	defer rows.Close()
+
+	grants := Grants{}
+	for rows.Next() {
+		var (
+			address string
+			role    Role
+		)
+		if err := rows.Scan(&address, &role); err != nil {
+			return nil, fmt.Errorf("error scanning grant: %w", err)
+		}
+
+		grants[address] = role
+	}
+
+	return grants, nil
+}
+
+// SetGrants replaces the grants of the user
+func (r *Repository) SetGrants(ctx context.Context, userID int64, grants Grants) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM device_grants WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error deleting grants: %w", err)
+	}
+	for address, role := range grants {
+		if _, err := tx.ExecContext(
+			ctx, "INSERT INTO device_grants (user_id, address, role) VALUES ($1, $2, $3)", userID, address, role,
+		); err != nil {
+			return fmt.Errorf("error creating grant: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteDeviceGrants deletes the grants on a device of every user
+func (r *Repository) DeleteDeviceGrants(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM device_grants WHERE address = $1", address); err != nil {
+		return fmt.Errorf("error deleting device grants: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) CreateSession(ctx context.Context, s *Session) error {
+	if _, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO sessions (token_hash, user_id, created_at, expires_at) VALUES ($1, $2, $3, $4)",
+		s.TokenHash, s.UserID, s.CreatedAt.UTC(), s.ExpiresAt.UTC(),
+	); err != nil {
+		return fmt.Errorf("error creating session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetSession(ctx context.Context, tokenHash string) (*Session, error) {
+	var s Session
+	if err := r.db.QueryRowContext(
+		ctx,
+		"SELECT token_hash, user_id, created_at, expires_at FROM sessions WHERE token_hash = $1",
+		tokenHash,
+	).Scan(&s.TokenHash, &s.UserID, &s.CreatedAt, &s.ExpiresAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error getting session: %w", err)
+	}
+
+	return &s, nil
+}
+
+func (r *Repository) DeleteSession(ctx context.Context, tokenHash string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE token_hash = $1", tokenHash); err != nil {
+		return fmt.Errorf("error deleting session: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredSessions deletes the sessions that expired before the time
+func (r *Repository) DeleteExpiredSessions(ctx context.Context, before time.Time) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM sessions WHERE expires_at < $1", before.UTC()); err != nil {
+		return fmt.Errorf("error deleting expired sessions: %w", err)
+	}
+
+	return nil
+}
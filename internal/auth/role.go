@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// Role is what a user may do, globally or on a single device through a grant. Each role includes the ones before it.
+type Role string
+
+const (
+	// RoleViewer allows seeing devices and their state
+	RoleViewer Role = "viewer"
+	// RoleOperator additionally allows pressing and connecting devices
+	RoleOperator Role = "operator"
+	// RoleAdmin additionally allows configuring, disconnecting and forgetting devices. Only users with the admin role,
+	// rather than an admin grant, may manage users, tokens, macros, schedules and rules.
+	RoleAdmin Role = "admin"
+)
+
+// Roles lists the roles from the least to the most privileged
+var Roles = []Role{RoleViewer, RoleOperator, RoleAdmin}
+
+var (
+	ErrInvalidRole      = errors.New("invalid role")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrLastAdmin        = errors.New("there must be at least one admin")
+)
+
+func ParseRole(s string) (Role, error) {
+	role := Role(s)
+	if !slices.Contains(Roles, role) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidRole, s)
+	}
+
+	return role, nil
+}
+
+// Includes reports whether the role allows what the required role allows
+func (r Role) Includes(required Role) bool {
+	return slices.Index(Roles, r) >= slices.Index(Roles, required)
+}
+
+// Grants are the roles of a user on single devices by address, they only ever raise the role of the user
+type Grants map[string]Role
+
+// DeviceRole returns the role of the user on the device, the global role when the address is empty
+func (m *Manager) DeviceRole(ctx context.Context, user *User, address string) (Role, error) {
+	if user == nil {
+		return "", nil
+	}
+	if address == "" || user.Role == RoleAdmin {
+		return user.Role, nil
+	}
+
+	grants, err := m.repository.GetGrants(ctx, user.ID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get grants: %w", err)
+	}
+	if grant, ok := grants[address]; ok && grant.Includes(user.Role) {
+		return grant, nil
+	}
+
+	return user.Role, nil
+}
+
+// Authorize returns ErrPermissionDenied unless the user has the role on the device, or globally when the address is
+// empty
+func (m *Manager) Authorize(ctx context.Context, user *User, address string, role Role) error {
+	actual, err := m.DeviceRole(ctx, user, address)
+	if err != nil {
+		return err
+	}
+	if actual == "" || !actual.Includes(role) {
+		if address == "" {
+			return fmt.Errorf("%w: this needs the %s role", ErrPermissionDenied, role)
+		}
+		return fmt.Errorf("%w: this needs the %s role on the device", ErrPermissionDenied, role)
+	}
+
+	return nil
+}
+
+func (m *Manager) GetUsers(ctx context.Context) ([]*User, error) {
+	users, err := m.repository.GetUsers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+
+	return users, nil
+}
+
+func (m *Manager) GetGrants(ctx context.Context, userID int64) (Grants, error) {
+	grants, err := m.repository.GetGrants(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get grants: %w", err)
+	}
+
+	return grants, nil
+}
+
+// UpdateUser sets the role and grants of the user, the grants replace the existing ones
+func (m *Manager) UpdateUser(ctx context.Context, id int64, role Role, grants Grants) (*User, error) {
+	if _, err := ParseRole(string(role)); err != nil {
+		return nil, err
+	}
+	for _, grant := range grants {
+		if _, err := ParseRole(string(grant)); err != nil {
+			return nil, err
+		}
+	}
+
+	user, err := m.getUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == RoleAdmin && role != RoleAdmin {
+		if err := m.checkNotLastAdmin(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := m.repository.UpdateUserRole(ctx, id, role); err != nil {
+		return nil, fmt.Errorf("failed to update user role: %w", err)
+	}
+	if err := m.repository.SetGrants(ctx, id, grants); err != nil {
+		return nil, fmt.Errorf("failed to set grants: %w", err)
+	}
+	user.Role = role
+
+	return user, nil
+}
+
+// DeleteUser deletes the user along with their sessions and grants
+func (m *Manager) DeleteUser(ctx context.Context, id int64) error {
+	user, err := m.getUser(ctx, id)
+	if err != nil {
+		return err
+	}
+	if user.Role == RoleAdmin {
+		if err := m.checkNotLastAdmin(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := m.repository.DeleteUser(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) getUser(ctx context.Context, id int64) (*User, error) {
+	user, err := m.repository.GetUser(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	return user, nil
+}
+
+func (m *Manager) checkNotLastAdmin(ctx context.Context) error {
+	admins, err := m.repository.CountUsersWithRole(ctx, RoleAdmin)
+	if err != nil {
+		return fmt.Errorf("failed to count admins: %w", err)
+	}
+	if admins <= 1 {
+		return ErrLastAdmin
+	}
+
+	return nil
+}
+
+// DeleteDeviceGrants deletes the grants on a device, e.g. when it is forgotten
+func (m *Manager) DeleteDeviceGrants(ctx context.Context, address string) error {
+	if err := m.repository.DeleteDeviceGrants(ctx, address); err != nil {
+		return fmt.Errorf("failed to delete device grants: %w", err)
+	}
+
+	return nil
+}
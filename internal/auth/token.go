@@ -0,0 +1,317 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Scope is what an API token may do, each scope includes the ones before it
+type Scope string
+
+const (
+	// ScopePress allows pressing and reading the state of devices
+	ScopePress Scope = "press"
+	// ScopeConfigure additionally allows changing the configuration and connection of devices
+	ScopeConfigure Scope = "configure"
+	// ScopeAdmin allows everything a user can do
+	ScopeAdmin Scope = "admin"
+)
+
+// Scopes lists the scopes from the least to the most privileged
+var Scopes = []Scope{ScopePress, ScopeConfigure, ScopeAdmin}
+
+// APITokenPrefix starts every API token so they are recognizable, e.g. by secret scanners
+const APITokenPrefix = "fbw_"
+
+var (
+	ErrInvalidScope       = errors.New("invalid scope")
+	ErrInvalidTokenName   = errors.New("token name must not be empty")
+	ErrAPITokenNotFound   = errors.New("API token not found")
+	ErrExpiryInThePast    = errors.New("expiry must be in the future")
+	ErrInsufficientScope  = errors.New("the API token does not allow this")
+	ErrDeviceNotPermitted = errors.New("the API token is not allowed to use this device")
+)
+
+func ParseScope(s string) (Scope, error) {
+	scope := Scope(s)
+	if !slices.Contains(Scopes, scope) {
+		return "", fmt.Errorf("%w: %s", ErrInvalidScope, s)
+	}
+
+	return scope, nil
+}
+
+// Includes reports whether the scope allows what the required scope allows
+func (s Scope) Includes(required Scope) bool {
+	return slices.Index(Scopes, s) >= slices.Index(Scopes, required)
+}
+
+type APIToken struct {
+	ID        int64  `sql:"id"`
+	Name      string `sql:"name"`
+	TokenHash string `sql:"token_hash"`
+	Scope     Scope  `sql:"scope"`
+	// Devices are the addresses of the devices the token may use, all devices when empty
+	Devices    []string     `sql:"devices"`
+	CreatedBy  string       `sql:"created_by"`
+	CreatedAt  time.Time    `sql:"created_at"`
+	ExpiresAt  sql.NullTime `sql:"expires_at"`
+	LastUsedAt sql.NullTime `sql:"last_used_at"`
+	RevokedAt  sql.NullTime `sql:"revoked_at"`
+}
+
+func (t *APIToken) Expired(now time.Time) bool {
+	return t.ExpiresAt.Valid && now.After(t.ExpiresAt.Time)
+}
+
+func (t *APIToken) Revoked() bool {
+	return t.RevokedAt.Valid
+}
+
+// Allows reports whether the token may do what the scope allows, on the device when the address is not empty
+func (t *APIToken) Allows(scope Scope, address string) error {
+	if !t.Scope.Includes(scope) {
+		return fmt.Errorf("%w, it needs the %s scope", ErrInsufficientScope, scope)
+	}
+	if address != "" && len(t.Devices) > 0 && !slices.Contains(t.Devices, address) {
+		return ErrDeviceNotPermitted
+	}
+
+	return nil
+}
+
+func (r *Repository) initAPITokens() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scope TEXT NOT NULL,
+			devices TEXT NOT NULL,
+			created_by TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL,
+			expires_at TIMESTAMP,
+			last_used_at TIMESTAMP,
+			revoked_at TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating API tokens table: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) CreateAPIToken(ctx context.Context, t *APIToken) error {
+	devices, err := json.Marshal(t.Devices)
+	if err != nil {
+		return fmt.Errorf("error encoding API token devices: %w", err)
+	}
+
+	result, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO api_tokens (name, token_hash, scope, devices, created_by, created_at, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		t.Name, t.TokenHash, t.Scope, string(devices), t.CreatedBy, t.CreatedAt.UTC(), utcNullTime(t.ExpiresAt),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating API token: %w", err)
+	}
+
+	t.ID, err = result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting API token id: %w", err)
+	}
+
+	return nil
+}
+
+const apiTokenColumns = "id, name, token_hash, scope, devices, created_by, created_at, expires_at, last_used_at, revoked_at"
+
+func (r *Repository) GetAPIToken(ctx context.Context, id int64) (*APIToken, error) {
+	return r.getAPIToken(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE id = $1", id)
+}
+
+func (r *Repository) GetAPITokenByHash(ctx context.Context, tokenHash string) (*APIToken, error) {
+	return r.getAPIToken(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens WHERE token_hash = $1", tokenHash)
+}
+
+func (r *Repository) getAPIToken(ctx context.Context, query string, arg any) (*APIToken, error) {
+	token, err := scanAPIToken(r.db.QueryRowContext(ctx, query, arg))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error getting API token: %w", err)
+	}
+
+	return token, nil
+}
+
+// GetAPITokens returns every token, the newest first
+func (r *Repository) GetAPITokens(ctx context.Context) ([]*APIToken, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+apiTokenColumns+" FROM api_tokens ORDER BY id DESC")
+	if err != nil {
+		return nil, fmt.Errorf("error getting API tokens: %w", err)
+	}
+	defer rows.Close()
+
+	var tokens []*APIToken
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning API token: %w", err)
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, nil
+}
+
+func (r *Repository) UpdateAPITokenLastUsed(ctx context.Context, id int64, lastUsedAt time.Time) error {
+	if _, err := r.db.ExecContext(ctx, "UPDATE api_tokens SET last_used_at = $1 WHERE id = $2", lastUsedAt.UTC(), id); err != nil {
+		return fmt.Errorf("error updating API token last use: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) RevokeAPIToken(ctx context.Context, id int64, revokedAt time.Time) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE api_tokens SET revoked_at = $1 WHERE id = $2 AND revoked_at IS NULL", revokedAt.UTC(), id,
+	); err != nil {
+		return fmt.Errorf("error revoking API token: %w", err)
+	}
+
+	return nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIToken(row scanner) (*APIToken, error) {
+	var (
+		token   APIToken
+		devices string
+	)
+	if err := row.Scan(
+		&token.ID, &token.Name, &token.TokenHash, &token.Scope, &devices, &token.CreatedBy, &token.CreatedAt,
+		&token.ExpiresAt, &token.LastUsedAt, &token.RevokedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(devices), &token.Devices); err != nil {
+		return nil, fmt.Errorf("error decoding API token devices: %w", err)
+	}
+
+	return &token, nil
+}
+
+func utcNullTime(t sql.NullTime) sql.NullTime {
+	if t.Valid {
+		t.Time = t.Time.UTC()
+	}
+
+	return t
+}
+
+// CreateAPIToken creates a token, createdBy describes who created it. The returned secret is only known to the caller.
+func (m *Manager) CreateAPIToken(
+	ctx context.Context, createdBy string, name string, scope Scope, devices []string, expiresAt sql.NullTime,
+) (string, *APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", nil, ErrInvalidTokenName
+	}
+	if _, err := ParseScope(string(scope)); err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	if expiresAt.Valid && !expiresAt.Time.After(now) {
+		return "", nil, ErrExpiryInThePast
+	}
+
+	secret, err := newToken()
+	if err != nil {
+		return "", nil, err
+	}
+	secret = APITokenPrefix + secret
+
+	if devices == nil {
+		devices = []string{}
+	}
+	token := &APIToken{
+		Name:      name,
+		TokenHash: hashToken(secret),
+		Scope:     scope,
+		Devices:   devices,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: expiresAt,
+	}
+	if err := m.repository.CreateAPIToken(ctx, token); err != nil {
+		return "", nil, fmt.Errorf("failed to create API token: %w", err)
+	}
+
+	return secret, token, nil
+}
+
+func (m *Manager) GetAPITokens(ctx context.Context) ([]*APIToken, error) {
+	tokens, err := m.repository.GetAPITokens(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API tokens: %w", err)
+	}
+
+	return tokens, nil
+}
+
+// RevokeAPIToken revokes the token for good, it is kept so its use stays traceable
+func (m *Manager) RevokeAPIToken(ctx context.Context, id int64) (*APIToken, error) {
+	if err := m.repository.RevokeAPIToken(ctx, id, time.Now()); err != nil {
+		return nil, fmt.Errorf("failed to revoke API token: %w", err)
+	}
+
+	token, err := m.repository.GetAPIToken(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+	if token == nil {
+		return nil, ErrAPITokenNotFound
+	}
+
+	return token, nil
+}
+
+// AuthenticateAPIToken returns the token with the secret and records its use, nil if it does not exist, expired or
+// was revoked
+func (m *Manager) AuthenticateAPIToken(ctx context.Context, secret string) (*APIToken, error) {
+	if !strings.HasPrefix(secret, APITokenPrefix) {
+		return nil, nil
+	}
+
+	token, err := m.repository.GetAPITokenByHash(ctx, hashToken(secret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get API token: %w", err)
+	}
+
+	now := time.Now()
+	if token == nil || token.Revoked() || token.Expired(now) {
+		return nil, nil
+	}
+
+	if err := m.repository.UpdateAPITokenLastUsed(ctx, token.ID, now); err != nil {
+		return nil, fmt.Errorf("failed to record API token use: %w", err)
+	}
+	token.LastUsedAt = sql.NullTime{Time: now, Valid: true}
+
+	return token, nil
+}
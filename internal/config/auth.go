@@ -0,0 +1,30 @@
+package config
+
+import "time"
+
+type Auth struct {
+	// AuthAdminUsername and AuthAdminPassword create the initial admin when there are no users yet
+	AuthAdminUsername string        `envconfig:"AUTH_ADMIN_USERNAME" default:"admin"`
+	AuthAdminPassword string        `envconfig:"AUTH_ADMIN_PASSWORD"`
+	AuthSessionTTL    time.Duration `envconfig:"AUTH_SESSION_TTL" default:"168h"`
+	// AuthSecureCookies marks the session cookie secure even when the request does not look like it came over HTTPS
+	AuthSecureCookies bool `envconfig:"AUTH_SECURE_COOKIES" default:"false"`
+	// AuthLocalPasswords allows logging in with a password, turning it off requires single sign-on
+	AuthLocalPasswords bool `envconfig:"AUTH_LOCAL_PASSWORDS" default:"true"`
+
+	// AuthOIDCIssuer turns on single sign-on with the OpenID Connect provider at the URL
+	AuthOIDCIssuer       string `envconfig:"AUTH_OIDC_ISSUER"`
+	AuthOIDCClientID     string `envconfig:"AUTH_OIDC_CLIENT_ID"`
+	AuthOIDCClientSecret string `envconfig:"AUTH_OIDC_CLIENT_SECRET"`
+	// AuthOIDCRedirectURL is the callback registered with the provider, https://<host>/login/oidc/callback
+	AuthOIDCRedirectURL   string   `envconfig:"AUTH_OIDC_REDIRECT_URL"`
+	AuthOIDCScopes        []string `envconfig:"AUTH_OIDC_SCOPES" default:"profile,email"`
+	AuthOIDCUsernameClaim string   `envconfig:"AUTH_OIDC_USERNAME_CLAIM" default:"preferred_username"`
+	// AuthOIDCRoleClaim holds the groups or roles of the user, the values listed for each role grant it
+	AuthOIDCRoleClaim      string   `envconfig:"AUTH_OIDC_ROLE_CLAIM" default:"groups"`
+	AuthOIDCAdminValues    []string `envconfig:"AUTH_OIDC_ADMIN_VALUES"`
+	AuthOIDCOperatorValues []string `envconfig:"AUTH_OIDC_OPERATOR_VALUES"`
+	AuthOIDCViewerValues   []string `envconfig:"AUTH_OIDC_VIEWER_VALUES"`
+	// AuthOIDCDefaultRole is given to users matching none of the values, they are refused when it is empty
+	AuthOIDCDefaultRole string `envconfig:"AUTH_OIDC_DEFAULT_ROLE"`
+}
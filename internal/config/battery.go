@@ -0,0 +1,9 @@
+package config
+
+import "time"
+
+type Battery struct {
+	BatteryLowPercent            int32         `envconfig:"BATTERY_LOW_PERCENT" default:"20"`
+	BatteryChargeCompletePercent int32         `envconfig:"BATTERY_CHARGE_COMPLETE_PERCENT" default:"100"`
+	BatterySampleInterval        time.Duration `envconfig:"BATTERY_SAMPLE_INTERVAL" default:"15m"`
+}
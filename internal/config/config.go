@@ -12,6 +12,10 @@ import (
 type Config struct {
 	Service
 	Logging
+	Scheduler
+	Battery
+	Auth
+	Secrets
 }
 
 func Load(filenames ...string) (*Config, error) {
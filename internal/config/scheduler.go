@@ -0,0 +1,6 @@
+package config
+
+type Scheduler struct {
+	SchedulerLatitude  float64 `envconfig:"SCHEDULER_LATITUDE"`
+	SchedulerLongitude float64 `envconfig:"SCHEDULER_LONGITUDE"`
+}
@@ -0,0 +1,9 @@
+package config
+
+type Secrets struct {
+	// SecretsKey is the base64 encoded 32 byte master key encrypting the device keys, it takes precedence over
+	// SecretsKeyFile
+	SecretsKey string `envconfig:"SECRETS_KEY"`
+	// SecretsKeyFile holds the master key when SecretsKey is empty, it is created with a new key when it does not exist
+	SecretsKeyFile string `envconfig:"SECRETS_KEY_FILE" default:"./fingerbot-web.key"`
+}
@@ -0,0 +1,188 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+const (
+	DefaultCalibrationStart  int32 = 30
+	DefaultCalibrationStep   int32 = 5
+	DefaultCalibrationMargin int32 = 5
+	// CalibrationTimeout is how long an idle calibration is kept before it is discarded
+	CalibrationTimeout = 15 * time.Minute
+)
+
+var (
+	ErrCalibrationNotStarted = errors.New("calibration not started")
+	ErrCalibrationNoPress    = errors.New("no test press made yet")
+)
+
+// Calibration finds the arm down percent needed to actuate a button. Test presses are made at increasing depths
+// until the user confirms the button actuated, the confirmed depth plus the margin is then saved.
+type Calibration struct {
+	Address string
+	Step    int32
+	Margin  int32
+	// Depth is the arm down percent of the next test press, or of the last one once a press was made
+	Depth   int32
+	Presses []CalibrationPress
+	// UpdatedAt is used to discard abandoned calibrations
+	UpdatedAt time.Time
+}
+
+// CalibrationPress is a test press made during a calibration
+type CalibrationPress struct {
+	Depth  int32
+	Result fingerbot.PressResult
+	Error  string
+}
+
+// NextDepth is the depth of the test press after the current one
+func (c *Calibration) NextDepth() int32 {
+	return min(c.Depth+c.Step, fingerbot.MaxArmPercent)
+}
+
+// Result is the arm down percent saved when the current depth is confirmed
+func (c *Calibration) Result() int32 {
+	return min(c.Depth+c.Margin, fingerbot.MaxArmPercent)
+}
+
+// GetCalibration returns a copy of the calibration running on a device, nil if there is none
+func (m *Manager) GetCalibration(address string) *Calibration {
+	m.calibrationsMutex.Lock()
+	defer m.calibrationsMutex.Unlock()
+
+	calibration := m.getCalibration(address)
+	if calibration == nil {
+		return nil
+	}
+
+	copied := *calibration
+	copied.Presses = append([]CalibrationPress(nil), calibration.Presses...)
+
+	return &copied
+}
+
+func (m *Manager) getCalibration(address string) *Calibration {
+	calibration, ok := m.calibrations[address]
+	if !ok {
+		return nil
+	}
+	if time.Since(calibration.UpdatedAt) > CalibrationTimeout {
+		delete(m.calibrations, address)
+		return nil
+	}
+
+	return calibration
+}
+
+// StartCalibration starts a calibration on a device, replacing the one already running.
+// The first test press is made at start, which is raised to the arm up percent if it is below it.
+func (m *Manager) StartCalibration(address string, start, step, margin int32) (*Calibration, error) {
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return nil, ErrDeviceNotConnected
+	}
+
+	if start < fingerbot.MinArmPercent || start > fingerbot.MaxArmPercent {
+		return nil, fmt.Errorf("invalid start depth: %d", start)
+	}
+	if step <= 0 || step > fingerbot.MaxArmPercent {
+		return nil, fmt.Errorf("invalid step: %d", step)
+	}
+	if margin < 0 || margin > fingerbot.MaxArmPercent {
+		return nil, fmt.Errorf("invalid margin: %d", margin)
+	}
+
+	m.calibrationsMutex.Lock()
+	m.calibrations[address] = &Calibration{
+		Address:   address,
+		Step:      step,
+		Margin:    margin,
+		Depth:     max(start, device.ArmUpPercent()),
+		UpdatedAt: time.Now(),
+	}
+	m.calibrationsMutex.Unlock()
+
+	return m.GetCalibration(address), nil
+}
+
+// PressCalibration makes a test press, at the next depth if deeper is set and at the current depth otherwise.
+// The device configuration is restored after the press. A failed press is recorded in the calibration.
+func (m *Manager) PressCalibration(ctx context.Context, address string, deeper bool) (*Calibration, error) {
+	m.calibrationsMutex.Lock()
+	calibration := m.getCalibration(address)
+	if calibration == nil {
+		m.calibrationsMutex.Unlock()
+		return nil, ErrCalibrationNotStarted
+	}
+	if deeper && len(calibration.Presses) > 0 {
+		calibration.Depth = calibration.NextDepth()
+	}
+	depth := calibration.Depth
+	calibration.UpdatedAt = time.Now()
+	m.calibrationsMutex.Unlock()
+
+	// Click mode makes the arm return on its own after every test press
+	mode := fingerbot.ModeClick
+	result, err := m.Press(ctx, address, fingerbot.PressOptions{Mode: &mode, ArmDownPercent: &depth})
+	if errors.Is(err, ErrDeviceNotConnected) {
+		return nil, err
+	}
+
+	press := CalibrationPress{Depth: depth, Result: result}
+	if err != nil {
+		press.Error = err.Error()
+	}
+
+	m.calibrationsMutex.Lock()
+	if calibration := m.getCalibration(address); calibration != nil {
+		calibration.Presses = append(calibration.Presses, press)
+		calibration.UpdatedAt = time.Now()
+	}
+	m.calibrationsMutex.Unlock()
+
+	return m.GetCalibration(address), nil
+}
+
+// ConfirmCalibration saves the depth of the last test press plus the margin as the arm down percent
+// of the device and ends the calibration
+func (m *Manager) ConfirmCalibration(ctx context.Context, address string) (int32, error) {
+	calibration := m.GetCalibration(address)
+	if calibration == nil {
+		return 0, ErrCalibrationNotStarted
+	}
+	if len(calibration.Presses) == 0 {
+		return 0, ErrCalibrationNoPress
+	}
+
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return 0, ErrDeviceNotConnected
+	}
+
+	result := calibration.Result()
+	if err := m.configure(ctx, device, func(t *fingerbot.FingerbotTransaction) error {
+		t.SetArmPercent(t.ArmUpPercent(), result)
+		return nil
+	}); err != nil {
+		return 0, err
+	}
+
+	m.CancelCalibration(address)
+
+	return result, nil
+}
+
+// CancelCalibration ends the calibration running on a device without changing its configuration
+func (m *Manager) CancelCalibration(address string) {
+	m.calibrationsMutex.Lock()
+	defer m.calibrationsMutex.Unlock()
+
+	delete(m.calibrations, address)
+}
@@ -0,0 +1,59 @@
+package devices
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+var (
+	ErrConfigurationConflict    = errors.New("configuration changed since it was loaded")
+	ErrConfigurationTagRequired = errors.New("the tag of the configuration the change is based on is required")
+)
+
+// ConfigurationTag identifies the state of a configuration, it changes whenever any of the fields changes
+func ConfigurationTag(config fingerbot.Configuration) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%d:%d:%d:%d:%d",
+		config.Mode, config.ClickSustainTime, config.ControlBack, config.ArmDownPercent, config.ArmUpPercent,
+	))
+
+	return hex.EncodeToString(sum[:8])
+}
+
+// GetConfiguration returns the current configuration of a device. For a device which is not connected it is the
+// queued configuration if there is one, the last known configuration otherwise.
+func (m *Manager) GetConfiguration(ctx context.Context, address string) (fingerbot.Configuration, error) {
+	if device := m.GetFingerbot(address); device != nil {
+		return device.Configuration(), nil
+	}
+
+	snapshot, pending, err := m.offlineConfiguration(ctx, address)
+	if err != nil {
+		return fingerbot.Configuration{}, err
+	}
+	if pending != nil {
+		return pending.Config, nil
+	}
+	if snapshot == nil {
+		return fingerbot.Configuration{}, nil
+	}
+
+	return snapshot.Configuration(), nil
+}
+
+// checkConfigurationTag returns ErrConfigurationConflict if the configuration no longer matches the tag it was loaded
+// with
+func checkConfigurationTag(current fingerbot.Configuration, tag string) error {
+	if tag == "" {
+		return ErrConfigurationTagRequired
+	}
+	if ConfigurationTag(current) != tag {
+		return ErrConfigurationConflict
+	}
+
+	return nil
+}
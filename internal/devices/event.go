@@ -0,0 +1,137 @@
+package devices
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/cybre/fingerbot-web/internal/tuyable"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+	"github.com/google/uuid"
+)
+
+// EventListenerBuffer is the number of events buffered per listener
+const EventListenerBuffer = 64
+
+type EventType string
+
+const (
+	EventDatapoint    EventType = "dp"
+	EventConnected    EventType = "connect"
+	EventDisconnected EventType = "disconnect"
+	EventPress        EventType = "press"
+	EventAlert        EventType = "alert"
+)
+
+// Event is published by the Manager whenever something happens to a device
+type Event struct {
+	Type    EventType
+	Address string
+	Name    string
+	Time    time.Time
+	// Source initiated connect, disconnect and press events
+	Source history.Source
+	// Datapoint is the reported datapoint of dp events
+	Datapoint *tuyable.DataPoint
+	// Previous is the previously reported value of the datapoint, nil if it was not known
+	Previous *tuyable.DataPoint
+	// Press is the result of press events
+	Press *fingerbot.PressResult
+	// Alert identifies the alert of alert events, e.g. battery_low
+	Alert string
+	// Message describes the alert
+	Message string
+	// Error describes why the operation failed
+	Error string
+}
+
+// Subscribe returns a channel receiving every device event and a function which cancels the subscription
+func (m *Manager) Subscribe() (<-chan Event, func()) {
+	m.listenersMutex.Lock()
+	defer m.listenersMutex.Unlock()
+
+	output := make(chan Event, EventListenerBuffer)
+	listenerID := uuid.NewString()
+	m.listeners[listenerID] = output
+
+	return output, func() {
+		m.listenersMutex.Lock()
+		defer m.listenersMutex.Unlock()
+
+		if _, ok := m.listeners[listenerID]; ok {
+			delete(m.listeners, listenerID)
+			close(output)
+		}
+	}
+}
+
+// PublishAlert notifies the event listeners, e.g. the rules engine, about a device condition that needs attention
+func (m *Manager) PublishAlert(address, name, alert, message string) {
+	m.publish(Event{Type: EventAlert, Address: address, Name: name, Alert: alert, Message: message})
+}
+
+func (m *Manager) publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	m.listenersMutex.Lock()
+	defer m.listenersMutex.Unlock()
+
+	for listenerID, listener := range m.listeners {
+		select {
+		case listener <- event:
+		default:
+			m.logger.Warn("event listener is not keeping up, dropping event", slog.String("listener_id", listenerID))
+		}
+	}
+}
+
+// publishPress publishes a press event and records the press in the history
+func (m *Manager) publishPress(ctx context.Context, device *fingerbot.Fingerbot, result fingerbot.PressResult, err error) {
+	m.recordPress(ctx, history.ActionPress, device, result, err)
+
+	event := Event{
+		Type:    EventPress,
+		Address: device.Address(),
+		Name:    device.Name(),
+		Source:  history.SourceFromContext(ctx),
+		Press:   &result,
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+
+	m.publish(event)
+}
+
+// forwardDatapoints publishes the datapoints reported by a connected device until unsubscribed
+func (m *Manager) forwardDatapoints(device *fingerbot.Fingerbot) func() {
+	reports, unsubscribe := device.SubscribeDatapoints()
+
+	go func() {
+		last := map[byte]tuyable.DataPoint{}
+		for dp := range reports {
+			event := Event{
+				Type:      EventDatapoint,
+				Address:   device.Address(),
+				Name:      device.Name(),
+				Datapoint: &dp,
+			}
+			if previous, ok := last[dp.ID]; ok {
+				event.Previous = &previous
+			}
+			last[dp.ID] = dp
+
+			if err := m.repository.SaveDatapoint(context.Background(), device.Address(), dp, time.Now()); err != nil {
+				m.logger.Error("failed to save datapoint snapshot", slog.String("address", device.Address()), logging.ErrAttr(err))
+			}
+
+			m.publish(event)
+		}
+	}()
+
+	return unsubscribe
+}
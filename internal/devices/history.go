@@ -0,0 +1,118 @@
+package devices
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+// GetHistory returns the history entries matching the filter and the total number of matching entries
+func (m *Manager) GetHistory(ctx context.Context, filter history.Filter) ([]*history.Entry, int, error) {
+	entries, total, err := m.history.Query(ctx, filter)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get history: %w", err)
+	}
+
+	return entries, total, nil
+}
+
+// record appends an entry to the history, failures are logged as they must not fail the action itself
+func (m *Manager) record(ctx context.Context, entry history.Entry, err error) {
+	entry.Time = time.Now()
+	source := history.SourceFromContext(ctx)
+	entry.Source, entry.SourceName = source.Kind, source.Name
+	if err != nil {
+		entry.Outcome = history.OutcomeFailure
+		entry.Error = err.Error()
+	} else if entry.Outcome == "" {
+		entry.Outcome = history.OutcomeSuccess
+	}
+
+	// The entry is recorded even if the request was cancelled meanwhile
+	if err := m.history.Append(context.WithoutCancel(ctx), &entry); err != nil {
+		m.logger.Error("failed to record history", slog.String("action", string(entry.Action)), logging.ErrAttr(err))
+	}
+}
+
+// recordRefused records an action refused before it reached the device, e.g. by an interlock
+func (m *Manager) recordRefused(ctx context.Context, entry history.Entry, err error) {
+	entry.Outcome = history.OutcomeRefused
+	entry.Error = err.Error()
+	m.record(ctx, entry, nil)
+}
+
+func (m *Manager) recordPress(ctx context.Context, action history.Action, device *fingerbot.Fingerbot, result fingerbot.PressResult, err error) {
+	entry := history.Entry{Action: action, Address: device.Address(), DeviceName: device.Name()}
+	if result.Confirmed {
+		entry.Details = fmt.Sprintf("confirmed in %d ms", result.Latency.Milliseconds())
+	} else {
+		entry.Outcome = history.OutcomeUnconfirmed
+	}
+	// A press the device did not confirm is recorded as unconfirmed rather than failed
+	if errors.Is(err, fingerbot.ErrPressNotConfirmed) {
+		err = nil
+	}
+
+	m.record(ctx, entry, err)
+}
+
+// configure runs a transaction on the device, records the resulting configuration diff and stores the
+// new configuration as a version. It is refused while the device is locked for maintenance.
+func (m *Manager) configure(ctx context.Context, device *fingerbot.Fingerbot, fn func(t *fingerbot.FingerbotTransaction) error) error {
+	if err := m.checkMaintenance(ctx, device.Address(), device.Name()); err != nil {
+		return err
+	}
+
+	// The device reports the new values asynchronously, so the diff is taken from the staged transaction
+	var before, after fingerbot.Configuration
+	err := device.Transaction(func(t *fingerbot.FingerbotTransaction) error {
+		before = device.Configuration()
+		if err := fn(t); err != nil {
+			return err
+		}
+		after = t.Configuration()
+
+		return nil
+	})
+
+	// A change based on an outdated configuration never reached the device
+	if errors.Is(err, ErrConfigurationConflict) || errors.Is(err, ErrConfigurationTagRequired) {
+		return err
+	}
+
+	diff := configurationDiff(before, after)
+	if err == nil && diff == "" {
+		return nil
+	}
+	m.record(ctx, history.Entry{
+		Action:     history.ActionConfigure,
+		Address:    device.Address(),
+		DeviceName: device.Name(),
+		Details:    diff,
+	}, err)
+	if err != nil {
+		return err
+	}
+
+	if err := m.saveConfigurationVersion(ctx, device.Address(), before, after); err != nil {
+		m.logger.Error("failed to save configuration version", slog.String("address", device.Address()), logging.ErrAttr(err))
+	}
+
+	return nil
+}
+
+func configurationDiff(before, after fingerbot.Configuration) string {
+	var changes []string
+	for _, change := range ConfigurationChanges(before, after) {
+		changes = append(changes, fmt.Sprintf("%s: %v → %v", change.Field, change.From, change.To))
+	}
+
+	return strings.Join(changes, ", ")
+}
@@ -0,0 +1,403 @@
+package devices
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+const (
+	// MaxPressWindow is the longest window presses can be limited over
+	MaxPressWindow = 24 * time.Hour
+	MaxCooldown    = time.Hour
+	// MaxMaintenanceReasonLength is the longest reason for a maintenance lock, in bytes
+	MaxMaintenanceReasonLength = 200
+)
+
+var ErrInvalidInterlocks = errors.New("invalid interlocks")
+
+// Interlock is a safeguard refusing presses of a device
+type Interlock string
+
+const (
+	InterlockRateLimit   Interlock = "rate_limit"
+	InterlockCooldown    Interlock = "cooldown"
+	InterlockQuietHours  Interlock = "quiet_hours"
+	InterlockMaintenance Interlock = "maintenance"
+)
+
+// InterlockError is returned for presses refused by an interlock, RetryAfter is how long until the press would be
+// allowed, 0 if that is not known
+type InterlockError struct {
+	Interlock  Interlock
+	RetryAfter time.Duration
+	// Reason is the reason of the maintenance lock
+	Reason string
+}
+
+func (e *InterlockError) Error() string {
+	retry := max(e.RetryAfter.Round(time.Second), time.Second)
+	switch e.Interlock {
+	case InterlockRateLimit:
+		return fmt.Sprintf("too many presses, try again in %s", retry)
+	case InterlockCooldown:
+		return fmt.Sprintf("the device is cooling down, try again in %s", retry)
+	case InterlockQuietHours:
+		return fmt.Sprintf("presses are not allowed during quiet hours, try again in %s", retry)
+	default:
+		if e.Reason != "" {
+			return "the device is locked for maintenance: " + e.Reason
+		}
+		return "the device is locked for maintenance"
+	}
+}
+
+// Interlocks protect a device from being worn out or drained by a stuck client. Unlike the protection they apply to
+// every press and hold, including those of schedules, rules, macros and guest links, releasing a held arm is never
+// refused. The maintenance lock also refuses configuration changes. Zero values disable an interlock.
+type Interlocks struct {
+	Address string `sql:"address"`
+	// MaxPresses is the number of presses allowed per PressWindow
+	MaxPresses  int           `sql:"max_presses"`
+	PressWindow time.Duration `sql:"press_window_seconds"`
+	// Cooldown is the minimum time between the start of two presses
+	Cooldown time.Duration `sql:"cooldown_ms"`
+	// QuietStart and QuietEnd are the times of day, as HH:MM in Timezone, between which presses are refused. Quiet
+	// hours may span midnight.
+	QuietStart string `sql:"quiet_start"`
+	QuietEnd   string `sql:"quiet_end"`
+	Timezone   string `sql:"timezone"`
+	// Maintenance refuses every press and configuration change until it is lifted
+	Maintenance       bool   `sql:"maintenance"`
+	MaintenanceReason string `sql:"maintenance_reason"`
+}
+
+func (i *Interlocks) HasQuietHours() bool {
+	return i.QuietStart != "" && i.QuietEnd != ""
+}
+
+// Validate checks the interlocks, normalizing the quiet hours and defaulting the timezone to the local one
+func (i *Interlocks) Validate() error {
+	switch {
+	case i.MaxPresses < 0:
+		return fmt.Errorf("%w: the number of presses cannot be negative", ErrInvalidInterlocks)
+	case i.MaxPresses > 0 && i.PressWindow <= 0:
+		return fmt.Errorf("%w: the rate limit needs a window", ErrInvalidInterlocks)
+	case i.PressWindow > MaxPressWindow:
+		return fmt.Errorf("%w: the rate limit window must be at most %d hours", ErrInvalidInterlocks, int(MaxPressWindow.Hours()))
+	case i.Cooldown < 0 || i.Cooldown > MaxCooldown:
+		return fmt.Errorf("%w: the cooldown must be at most %d minutes", ErrInvalidInterlocks, int(MaxCooldown.Minutes()))
+	case len(i.MaintenanceReason) > MaxMaintenanceReasonLength:
+		return fmt.Errorf("%w: the maintenance reason is too long", ErrInvalidInterlocks)
+	}
+	if i.MaxPresses == 0 {
+		i.PressWindow = 0
+	}
+
+	if (i.QuietStart == "") != (i.QuietEnd == "") {
+		return fmt.Errorf("%w: quiet hours need a start and an end", ErrInvalidInterlocks)
+	}
+	if i.HasQuietHours() {
+		for _, value := range []*string{&i.QuietStart, &i.QuietEnd} {
+			parsed, err := time.Parse("15:04", *value)
+			if err != nil {
+				return fmt.Errorf("%w: invalid quiet hours time: %s", ErrInvalidInterlocks, *value)
+			}
+			*value = parsed.Format("15:04")
+		}
+		if i.QuietStart == i.QuietEnd {
+			return fmt.Errorf("%w: quiet hours cannot start and end at the same time", ErrInvalidInterlocks)
+		}
+	}
+	if i.Timezone == "" {
+		i.Timezone = time.Local.String()
+	}
+	if _, err := time.LoadLocation(i.Timezone); err != nil {
+		return fmt.Errorf("%w: invalid timezone: %s", ErrInvalidInterlocks, i.Timezone)
+	}
+
+	return nil
+}
+
+// check returns the *InterlockError refusing a press at now, given the start times of the previous presses
+func (i *Interlocks) check(now time.Time, presses []time.Time) error {
+	if i.Maintenance {
+		return &InterlockError{Interlock: InterlockMaintenance, Reason: i.MaintenanceReason}
+	}
+
+	if until, ok := i.quietUntil(now); ok {
+		return &InterlockError{Interlock: InterlockQuietHours, RetryAfter: until.Sub(now)}
+	}
+
+	if i.Cooldown > 0 && len(presses) > 0 {
+		if wait := presses[len(presses)-1].Add(i.Cooldown).Sub(now); wait > 0 {
+			return &InterlockError{Interlock: InterlockCooldown, RetryAfter: wait}
+		}
+	}
+
+	if i.MaxPresses > 0 {
+		var inWindow []time.Time
+		for _, press := range presses {
+			if press.After(now.Add(-i.PressWindow)) {
+				inWindow = append(inWindow, press)
+			}
+		}
+		if len(inWindow) >= i.MaxPresses {
+			// The oldest press of the window has to fall out of it for another one to fit
+			oldest := inWindow[len(inWindow)-i.MaxPresses]
+			return &InterlockError{Interlock: InterlockRateLimit, RetryAfter: oldest.Add(i.PressWindow).Sub(now)}
+		}
+	}
+
+	return nil
+}
+
+// quietUntil returns the end of the quiet hours if now falls within them
+func (i *Interlocks) quietUntil(now time.Time) (time.Time, bool) {
+	if !i.HasQuietHours() {
+		return time.Time{}, false
+	}
+
+	location, err := time.LoadLocation(i.Timezone)
+	if err != nil {
+		location = time.Local
+	}
+	now = now.In(location)
+	start, _ := time.Parse("15:04", i.QuietStart)
+	end, _ := time.Parse("15:04", i.QuietEnd)
+
+	minutes := now.Hour()*60 + now.Minute()
+	startMinutes, endMinutes := start.Hour()*60+start.Minute(), end.Hour()*60+end.Minute()
+	quiet := minutes >= startMinutes && minutes < endMinutes
+	if startMinutes > endMinutes {
+		quiet = minutes >= startMinutes || minutes < endMinutes
+	}
+	if !quiet {
+		return time.Time{}, false
+	}
+
+	until := time.Date(now.Year(), now.Month(), now.Day(), end.Hour(), end.Minute(), 0, 0, location)
+	if !until.After(now) {
+		until = until.AddDate(0, 0, 1)
+	}
+
+	return until, true
+}
+
+// keep is how long the start of a press matters to the interlocks
+func (i *Interlocks) keep() time.Duration {
+	return max(i.PressWindow, i.Cooldown)
+}
+
+func (r *Repository) initInterlocks() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS device_interlocks (
+			address TEXT PRIMARY KEY,
+			max_presses INTEGER NOT NULL,
+			press_window_seconds INTEGER NOT NULL,
+			cooldown_ms INTEGER NOT NULL,
+			quiet_start TEXT NOT NULL,
+			quiet_end TEXT NOT NULL,
+			timezone TEXT NOT NULL,
+			maintenance BOOLEAN NOT NULL,
+			maintenance_reason TEXT NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating device interlocks table: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetInterlocks(ctx context.Context, address string) (*Interlocks, error) {
+	var (
+		i              Interlocks
+		windowSeconds  int64
+		cooldownMillis int64
+	)
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT address, max_presses, press_window_seconds, cooldown_ms, quiet_start, quiet_end, timezone, maintenance,
+			maintenance_reason
+		FROM device_interlocks WHERE address = $1`,
+		address,
+	).Scan(
+		&i.Address, &i.MaxPresses, &windowSeconds, &cooldownMillis, &i.QuietStart, &i.QuietEnd, &i.Timezone,
+		&i.Maintenance, &i.MaintenanceReason,
+	); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error getting device interlocks: %w", err)
+	}
+	i.PressWindow = time.Duration(windowSeconds) * time.Second
+	i.Cooldown = time.Duration(cooldownMillis) * time.Millisecond
+
+	return &i, nil
+}
+
+// SaveInterlocks creates or replaces the interlocks of the device
+func (r *Repository) SaveInterlocks(ctx context.Context, i *Interlocks) error {
+	if _, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO device_interlocks (address, max_presses, press_window_seconds, cooldown_ms, quiet_start, quiet_end,
+			timezone, maintenance, maintenance_reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (address) DO UPDATE SET max_presses = excluded.max_presses,
+			press_window_seconds = excluded.press_window_seconds, cooldown_ms = excluded.cooldown_ms,
+			quiet_start = excluded.quiet_start, quiet_end = excluded.quiet_end, timezone = excluded.timezone,
+			maintenance = excluded.maintenance, maintenance_reason = excluded.maintenance_reason`,
+		i.Address, i.MaxPresses, int64(i.PressWindow/time.Second), i.Cooldown.Milliseconds(), i.QuietStart, i.QuietEnd,
+		i.Timezone, i.Maintenance, i.MaintenanceReason,
+	); err != nil {
+		return fmt.Errorf("error saving device interlocks: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteInterlocks(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM device_interlocks WHERE address = $1", address); err != nil {
+		return fmt.Errorf("error deleting device interlocks: %w", err)
+	}
+
+	return nil
+}
+
+// GetInterlocks returns the interlocks of the device, all disabled if it has none
+func (m *Manager) GetInterlocks(ctx context.Context, address string) (*Interlocks, error) {
+	interlocks, err := m.repository.GetInterlocks(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device interlocks: %w", err)
+	}
+	if interlocks == nil {
+		return &Interlocks{Address: address, Timezone: time.Local.String()}, nil
+	}
+
+	return interlocks, nil
+}
+
+// SetInterlocks validates and saves the interlocks of the device, the changes are recorded in the history
+func (m *Manager) SetInterlocks(ctx context.Context, interlocks *Interlocks) error {
+	if err := interlocks.Validate(); err != nil {
+		return err
+	}
+
+	current, err := m.GetInterlocks(ctx, interlocks.Address)
+	if err != nil {
+		return err
+	}
+
+	if err := m.repository.SaveInterlocks(ctx, interlocks); err != nil {
+		return fmt.Errorf("failed to save device interlocks: %w", err)
+	}
+
+	if diff := interlocksDiff(current, interlocks); diff != "" {
+		entry := history.Entry{Action: history.ActionConfigure, Address: interlocks.Address, Details: diff}
+		if device, err := m.repository.GetDevice(ctx, interlocks.Address); err == nil && device != nil {
+			entry.DeviceName = device.Name
+		}
+		m.record(ctx, entry, nil)
+	}
+
+	return nil
+}
+
+func interlocksDiff(before, after *Interlocks) string {
+	describe := func(i *Interlocks) []string {
+		rateLimit, quietHours, maintenance := "off", "off", "off"
+		if i.MaxPresses > 0 {
+			rateLimit = fmt.Sprintf("%d per %s", i.MaxPresses, i.PressWindow)
+		}
+		if i.HasQuietHours() {
+			quietHours = fmt.Sprintf("%s–%s %s", i.QuietStart, i.QuietEnd, i.Timezone)
+		}
+		if i.Maintenance {
+			maintenance = "on"
+			if i.MaintenanceReason != "" {
+				maintenance += " (" + i.MaintenanceReason + ")"
+			}
+		}
+
+		return []string{rateLimit, i.Cooldown.String(), quietHours, maintenance}
+	}
+
+	var changes []string
+	from, to := describe(before), describe(after)
+	for n, field := range []string{"rate limit", "cooldown", "quiet hours", "maintenance lock"} {
+		if from[n] != to[n] {
+			changes = append(changes, fmt.Sprintf("%s: %s → %s", field, from[n], to[n]))
+		}
+	}
+
+	return strings.Join(changes, ", ")
+}
+
+// CheckInterlocks returns the *InterlockError refusing a press of the device right now, nil if it would be allowed.
+// Unlike the presses themselves the check is neither counted nor recorded.
+func (m *Manager) CheckInterlocks(ctx context.Context, address string) error {
+	interlocks, err := m.GetInterlocks(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	m.pressesMutex.Lock()
+	defer m.pressesMutex.Unlock()
+
+	return interlocks.check(time.Now(), m.presses[address])
+}
+
+// checkMaintenance refuses changing the configuration of a device locked for maintenance, the refusal is recorded in
+// the history
+func (m *Manager) checkMaintenance(ctx context.Context, address, name string) error {
+	interlocks, err := m.GetInterlocks(ctx, address)
+	if err != nil {
+		return err
+	}
+	if !interlocks.Maintenance {
+		return nil
+	}
+
+	err = &InterlockError{Interlock: InterlockMaintenance, Reason: interlocks.MaintenanceReason}
+	m.recordRefused(ctx, history.Entry{Action: history.ActionConfigure, Address: address, DeviceName: name}, err)
+
+	return err
+}
+
+// interlock counts the start of a press or hold of the device, unless the interlocks refuse it. Refused presses are
+// recorded in the history.
+func (m *Manager) interlock(ctx context.Context, action history.Action, device *fingerbot.Fingerbot) error {
+	interlocks, err := m.GetInterlocks(ctx, device.Address())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	m.pressesMutex.Lock()
+	presses := m.presses[device.Address()]
+	err = interlocks.check(now, presses)
+	if err == nil {
+		// Only the presses the interlocks still need are kept, the one just started is always kept for the cooldown
+		kept := []time.Time{}
+		for _, press := range presses {
+			if press.After(now.Add(-interlocks.keep())) {
+				kept = append(kept, press)
+			}
+		}
+		m.presses[device.Address()] = append(kept, now)
+	}
+	m.pressesMutex.Unlock()
+
+	if err != nil {
+		m.recordRefused(ctx, history.Entry{Action: action, Address: device.Address(), DeviceName: device.Name()}, err)
+	}
+
+	return err
+}
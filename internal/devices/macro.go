@@ -0,0 +1,304 @@
+package devices
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+type MacroStepType string
+
+const (
+	MacroStepPress   MacroStepType = "press"
+	MacroStepHold    MacroStepType = "hold"
+	MacroStepRelease MacroStepType = "release"
+	MacroStepWait    MacroStepType = "wait"
+	MacroStepArm     MacroStepType = "arm"
+	MacroStepMode    MacroStepType = "mode"
+)
+
+// MacroStep is a single action of a macro
+type MacroStep struct {
+	Type MacroStepType `json:"type"`
+	// Count is the number of presses for press steps
+	Count int `json:"count,omitempty"`
+	// Duration is the wait time for wait steps and the safety timeout for hold steps
+	Duration time.Duration `json:"duration,omitempty"`
+	// ArmUpPercent and ArmDownPercent are the arm position for arm steps
+	ArmUpPercent   int32 `json:"armUpPercent,omitempty"`
+	ArmDownPercent int32 `json:"armDownPercent,omitempty"`
+	// Mode is the mode for mode steps
+	Mode fingerbot.Mode `json:"mode,omitempty"`
+}
+
+func (s MacroStep) String() string {
+	switch s.Type {
+	case MacroStepPress:
+		if s.Count > 1 {
+			return fmt.Sprintf("press %d", s.Count)
+		}
+		return "press"
+	case MacroStepHold:
+		if s.Duration > 0 {
+			return fmt.Sprintf("hold %s", s.Duration)
+		}
+		return "hold"
+	case MacroStepWait:
+		return fmt.Sprintf("wait %s", s.Duration)
+	case MacroStepArm:
+		return fmt.Sprintf("arm %d %d", s.ArmUpPercent, s.ArmDownPercent)
+	case MacroStepMode:
+		return fmt.Sprintf("mode %s", s.Mode)
+	default:
+		return string(s.Type)
+	}
+}
+
+// Validate checks that the step can be executed
+func (s MacroStep) Validate() error {
+	switch s.Type {
+	case MacroStepPress:
+		if s.Count < 0 {
+			return fmt.Errorf("invalid press count: %d", s.Count)
+		}
+	case MacroStepHold:
+		if s.Duration < 0 || s.Duration > fingerbot.MaxHoldDuration {
+			return fmt.Errorf("invalid hold duration: %s", s.Duration)
+		}
+	case MacroStepRelease:
+	case MacroStepWait:
+		if s.Duration <= 0 {
+			return fmt.Errorf("invalid wait duration: %s", s.Duration)
+		}
+	case MacroStepArm:
+		if s.ArmUpPercent < 0 || s.ArmUpPercent > 100 || s.ArmDownPercent < 0 || s.ArmDownPercent > 100 {
+			return fmt.Errorf("invalid arm position: %d %d", s.ArmUpPercent, s.ArmDownPercent)
+		}
+		if s.ArmDownPercent < s.ArmUpPercent {
+			return fmt.Errorf("arm down percent cannot be less than arm up percent")
+		}
+	case MacroStepMode:
+		if !s.Mode.Valid() {
+			return fmt.Errorf("invalid mode: %d", s.Mode)
+		}
+	default:
+		return fmt.Errorf("unknown step type: %s", s.Type)
+	}
+
+	return nil
+}
+
+// ParseMacroSteps parses the text representation of macro steps, one step per line:
+//
+//	press [count]
+//	hold [max duration]
+//	release
+//	wait <duration>
+//	arm <up percent> <down percent>
+//	mode <click|long_press>
+func ParseMacroSteps(text string) ([]MacroStep, error) {
+	var steps []MacroStep
+	for i, line := range strings.Split(text, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		step, err := parseMacroStep(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if err := step.Validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+
+		steps = append(steps, step)
+	}
+
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("macro has no steps")
+	}
+
+	return steps, nil
+}
+
+// FormatMacroSteps returns the text representation of macro steps
+func FormatMacroSteps(steps []MacroStep) string {
+	lines := make([]string, len(steps))
+	for i, step := range steps {
+		lines[i] = step.String()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func parseMacroStep(fields []string) (MacroStep, error) {
+	step := MacroStep{Type: MacroStepType(strings.ToLower(fields[0]))}
+	args := fields[1:]
+
+	var err error
+	switch step.Type {
+	case MacroStepPress:
+		step.Count = 1
+		if len(args) > 0 {
+			step.Count, err = strconv.Atoi(args[0])
+		}
+	case MacroStepHold:
+		if len(args) > 0 {
+			step.Duration, err = time.ParseDuration(args[0])
+		}
+	case MacroStepWait:
+		if len(args) != 1 {
+			return step, fmt.Errorf("wait requires a duration")
+		}
+		step.Duration, err = time.ParseDuration(args[0])
+	case MacroStepArm:
+		if len(args) != 2 {
+			return step, fmt.Errorf("arm requires up and down percent")
+		}
+		var up, down int
+		if up, err = strconv.Atoi(args[0]); err == nil {
+			down, err = strconv.Atoi(args[1])
+		}
+		step.ArmUpPercent, step.ArmDownPercent = int32(up), int32(down)
+	case MacroStepMode:
+		if len(args) != 1 {
+			return step, fmt.Errorf("mode requires click or long_press")
+		}
+		step.Mode, err = fingerbot.ParseMode(args[0])
+	case MacroStepRelease:
+	default:
+		return step, fmt.Errorf("unknown step: %s", fields[0])
+	}
+	if err != nil {
+		return step, fmt.Errorf("invalid %s arguments: %w", step.Type, err)
+	}
+
+	return step, nil
+}
+
+// Macro is a named sequence of steps which can be run on one or more devices
+type Macro struct {
+	ID    int64       `sql:"id"`
+	Name  string      `sql:"name"`
+	Steps []MacroStep `sql:"steps"`
+}
+
+func (m *Macro) StepsText() string {
+	return FormatMacroSteps(m.Steps)
+}
+
+type MacroStepStatus string
+
+const (
+	MacroStepStatusRunning   MacroStepStatus = "running"
+	MacroStepStatusDone      MacroStepStatus = "done"
+	MacroStepStatusFailed    MacroStepStatus = "failed"
+	MacroStepStatusCancelled MacroStepStatus = "cancelled"
+)
+
+// MacroProgress reports the status of a macro step on a device
+type MacroProgress struct {
+	Address string          `json:"address"`
+	Step    int             `json:"step"`
+	Steps   int             `json:"steps"`
+	Action  string          `json:"action"`
+	Status  MacroStepStatus `json:"status"`
+	Error   string          `json:"error,omitempty"`
+}
+
+func (r *Repository) initMacros() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS macros (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			steps TEXT NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating macros table: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) CreateMacro(ctx context.Context, m *Macro) error {
+	steps, err := json.Marshal(m.Steps)
+	if err != nil {
+		return fmt.Errorf("error encoding macro steps: %w", err)
+	}
+
+	result, err := r.db.ExecContext(
+		ctx, "INSERT INTO macros (name, steps) VALUES ($1, $2)", m.Name, string(steps),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating macro: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting macro id: %w", err)
+	}
+	m.ID = id
+
+	return nil
+}
+
+func (r *Repository) DeleteMacro(ctx context.Context, id int64) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM macros WHERE id = $1", id,
+	); err != nil {
+		return fmt.Errorf("error deleting macro: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetMacro(ctx context.Context, id int64) (*Macro, error) {
+	var m Macro
+	var steps string
+	err := r.db.QueryRowContext(
+		ctx, "SELECT id, name, steps FROM macros WHERE id = $1", id,
+	).Scan(&m.ID, &m.Name, &steps)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting macro by id: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(steps), &m.Steps); err != nil {
+		return nil, fmt.Errorf("error decoding macro steps: %w", err)
+	}
+
+	return &m, nil
+}
+
+func (r *Repository) GetMacros(ctx context.Context) ([]*Macro, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, steps FROM macros ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error getting macros: %w", err)
+	}
+	defer rows.Close()
+
+	var macros []*Macro
+	for rows.Next() {
+		var m Macro
+		var steps string
+		if err := rows.Scan(&m.ID, &m.Name, &steps); err != nil {
+			return nil, fmt.Errorf("error scanning macro: %w", err)
+		}
+		if err := json.Unmarshal([]byte(steps), &m.Steps); err != nil {
+			return nil, fmt.Errorf("error decoding macro steps: %w", err)
+		}
+
+		macros = append(macros, &m)
+	}
+
+	return macros, nil
+}
@@ -6,12 +6,25 @@ import (
 	"fmt"
 	"log/slog"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/cybre/fingerbot-web/internal/history"
 	"github.com/cybre/fingerbot-web/internal/tuyable"
 	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
 	"github.com/cybre/fingerbot-web/internal/utils"
 )
 
+var (
+	ErrDeviceNotConnected = errors.New("device not connected")
+	ErrDeviceNotFound     = errors.New("device not found")
+	ErrPresetNotFound     = errors.New("preset not found")
+	ErrMacroNotFound      = errors.New("macro not found")
+	// ErrSwitchNotSettable is returned for the switch datapoint, which presses the device and so only goes through
+	// the press, protection and interlocks of Press
+	ErrSwitchNotSettable = errors.New("the switch datapoint cannot be set, press the device instead")
+)
+
 type DeviceView struct {
 	Name      string
 	Address   string
@@ -26,21 +39,55 @@ func (d DeviceView) ID() string {
 
 type Manager struct {
 	repository      *Repository
+	history         *history.Repository
 	discoverer      *tuyable.Discoverer
 	logger          *slog.Logger
 	conectedDevices map[string]*fingerbot.Fingerbot
+	unsubscribers   map[string]func()
+	devicesMutex    sync.RWMutex
+	listeners       map[string]chan Event
+	listenersMutex  sync.Mutex
+	// calibrations are the running arm calibrations by device address
+	calibrations      map[string]*Calibration
+	calibrationsMutex sync.Mutex
+	// confirmations are the presses of protected devices waiting to be confirmed by confirmation
+	confirmations      map[string]pendingConfirmation
+	confirmationsMutex sync.Mutex
+	// presses are the start times of the recent presses by device address, for the interlocks
+	presses      map[string][]time.Time
+	pressesMutex sync.Mutex
+	// forgetHooks remove what other packages keep about a device when it is forgotten
+	forgetHooks []ForgetHook
+	// presetDeleteHooks and macroDeleteHooks remove what other packages keep about a deleted preset or macro
+	presetDeleteHooks []DeleteHook
+	macroDeleteHooks  []DeleteHook
 }
 
-func NewManager(repository *Repository, discoverer *tuyable.Discoverer, logger *slog.Logger) *Manager {
+// ForgetHook removes what a package keeps about a device, e.g. its schedules, when the device is forgotten
+type ForgetHook func(ctx context.Context, address string) error
+
+// DeleteHook removes what a package keeps about a preset or macro, e.g. the schedules running it, when it is deleted
+type DeleteHook func(ctx context.Context, id int64) error
+
+func NewManager(repository *Repository, history *history.Repository, discoverer *tuyable.Discoverer, logger *slog.Logger) *Manager {
 	return &Manager{
 		repository:      repository,
+		history:         history,
 		discoverer:      discoverer,
 		logger:          logger,
 		conectedDevices: map[string]*fingerbot.Fingerbot{},
+		unsubscribers:   map[string]func(){},
+		listeners:       map[string]chan Event{},
+		calibrations:    map[string]*Calibration{},
+		confirmations:   map[string]pendingConfirmation{},
+		presses:         map[string][]time.Time{},
 	}
 }
 
 func (m *Manager) GetConnectedDevices() []*fingerbot.Fingerbot {
+	m.devicesMutex.RLock()
+	defer m.devicesMutex.RUnlock()
+
 	devices := make([]*fingerbot.Fingerbot, 0, len(m.conectedDevices))
 	for _, device := range m.conectedDevices {
 		devices = append(devices, device)
@@ -76,6 +123,9 @@ func (m *Manager) ConnectToSavedDevice(ctx context.Context, address string) (*De
 	if err != nil {
 		return nil, fmt.Errorf("failed to get device: %w", err)
 	}
+	if device == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
+	}
 
 	if err := m.connectDevice(ctx, device); err != nil {
 		return nil, err
@@ -151,7 +201,7 @@ func (m *Manager) Discover(ctx context.Context, output chan<- DeviceView) error
 		if saved != nil {
 			device.Name = saved.Name
 			device.Saved = true
-			device.Connected = m.conectedDevices[saved.Address] != nil
+			device.Connected = m.GetFingerbot(saved.Address) != nil
 		}
 
 		output <- device
@@ -161,9 +211,30 @@ func (m *Manager) Discover(ctx context.Context, output chan<- DeviceView) error
 }
 
 func (m *Manager) GetFingerbot(address string) *fingerbot.Fingerbot {
+	m.devicesMutex.RLock()
+	defer m.devicesMutex.RUnlock()
+
 	return m.conectedDevices[address]
 }
 
+func (m *Manager) GetSavedDevice(ctx context.Context, address string) (*DeviceView, error) {
+	device, err := m.repository.GetDevice(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+	if device == nil {
+		return nil, nil
+	}
+
+	return &DeviceView{
+		Name:      device.Name,
+		Address:   device.Address,
+		RSSI:      0,
+		Saved:     true,
+		Connected: m.GetFingerbot(device.Address) != nil,
+	}, nil
+}
+
 func (m *Manager) GetSavedDevices(ctx context.Context) ([]*DeviceView, error) {
 	devices, err := m.repository.GetDevices(ctx)
 	if err != nil {
@@ -176,25 +247,62 @@ func (m *Manager) GetSavedDevices(ctx context.Context) ([]*DeviceView, error) {
 			Address:   device.Address,
 			RSSI:      0,
 			Saved:     true,
-			Connected: m.conectedDevices[device.Address] != nil,
+			Connected: m.GetFingerbot(device.Address) != nil,
 		}
 	}), nil
 }
 
+// RenameDevice changes the name of a saved device, a connected device keeps its old name until it reconnects
+func (m *Manager) RenameDevice(ctx context.Context, address, name string) (*DeviceView, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, fmt.Errorf("name is required")
+	}
+
+	device, err := m.repository.GetDevice(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+	if device == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
+	}
+
+	if err := m.repository.UpdateDeviceName(ctx, address, name); err != nil {
+		return nil, fmt.Errorf("failed to rename device: %w", err)
+	}
+
+	return m.GetSavedDevice(ctx, address)
+}
+
 func (m *Manager) DisconnectDevice(ctx context.Context, address string) (*DeviceView, error) {
 	device, err := m.repository.GetDevice(ctx, address)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get device: %w", err)
 	}
 	if device == nil {
-		return nil, fmt.Errorf("device not found: %s", address)
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
+	}
+
+	fingerbot := m.GetFingerbot(device.Address)
+	if fingerbot == nil {
+		return nil, ErrDeviceNotConnected
 	}
 
-	if err := m.GetFingerbot(device.Address).Disconnect(); err != nil {
-		return nil, fmt.Errorf("failed to disconnect device: %w", err)
+	if err := fingerbot.Disconnect(); err != nil {
+		err = fmt.Errorf("failed to disconnect device: %w", err)
+		m.record(ctx, history.Entry{Action: history.ActionDisconnect, Address: device.Address, DeviceName: device.Name}, err)
+		return nil, err
 	}
 
+	m.devicesMutex.Lock()
 	delete(m.conectedDevices, device.Address)
+	if unsubscribe, ok := m.unsubscribers[device.Address]; ok {
+		unsubscribe()
+		delete(m.unsubscribers, device.Address)
+	}
+	m.devicesMutex.Unlock()
+
+	m.record(ctx, history.Entry{Action: history.ActionDisconnect, Address: device.Address, DeviceName: device.Name}, nil)
+	m.publish(Event{Type: EventDisconnected, Address: device.Address, Name: device.Name, Source: history.SourceFromContext(ctx)})
 
 	return &DeviceView{
 		Name:      device.Name,
@@ -206,15 +314,63 @@ func (m *Manager) DisconnectDevice(ctx context.Context, address string) (*Device
 }
 
 func (m *Manager) ForgetDevice(ctx context.Context, address string) error {
+	entry := history.Entry{Action: history.ActionForget, Address: address}
+	if device, err := m.repository.GetDevice(ctx, address); err == nil && device != nil {
+		entry.DeviceName = device.Name
+	}
+
+	err := m.forgetDevice(ctx, address)
+	m.record(ctx, entry, err)
+
+	return err
+}
+
+func (m *Manager) forgetDevice(ctx context.Context, address string) error {
 	if err := m.repository.DeleteDevice(ctx, address); err != nil {
 		return fmt.Errorf("failed to delete device: %w", err)
 	}
 
+	if err := m.repository.DeleteDevicePresets(ctx, address); err != nil {
+		return fmt.Errorf("failed to delete device presets: %w", err)
+	}
+
+	if err := m.repository.DeleteSnapshot(ctx, address); err != nil {
+		return fmt.Errorf("failed to delete device snapshot: %w", err)
+	}
+
+	if err := m.repository.DeletePendingConfiguration(ctx, address); err != nil {
+		return fmt.Errorf("failed to delete pending configuration: %w", err)
+	}
+
+	if err := m.repository.DeleteConfigurationVersions(ctx, address); err != nil {
+		return fmt.Errorf("failed to delete configuration versions: %w", err)
+	}
+
+	if err := m.repository.DeleteProtection(ctx, address); err != nil {
+		return fmt.Errorf("failed to delete device protection: %w", err)
+	}
+
+	if err := m.repository.DeleteInterlocks(ctx, address); err != nil {
+		return fmt.Errorf("failed to delete device interlocks: %w", err)
+	}
+
+	for _, hook := range m.forgetHooks {
+		if err := hook(ctx, address); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// OnForget registers a hook run whenever a device is forgotten. Hooks are registered while the app is wired up,
+// before the manager is used.
+func (m *Manager) OnForget(hook ForgetHook) {
+	m.forgetHooks = append(m.forgetHooks, hook)
+}
+
 func (m *Manager) DisconnectDevices() {
-	for _, device := range m.conectedDevices {
+	for _, device := range m.GetConnectedDevices() {
 		if err := device.Disconnect(); err != nil {
 			m.logger.Error("failed to disconnect device", slog.Any("error", err))
 		}
@@ -222,20 +378,429 @@ func (m *Manager) DisconnectDevices() {
 }
 
 func (m *Manager) connectDevice(ctx context.Context, device *Device) error {
+	entry := history.Entry{Action: history.ActionConnect, Address: device.Address, DeviceName: device.Name}
 	tuyadevice, err := tuyable.NewDevice(device.Address, device.Name, device.UUID, device.DeviceID, device.LocalKey, m.logger)
 	if err != nil {
+		m.record(ctx, entry, err)
 		return err
 	}
 
 	if err := tuyadevice.Connect(ctx); err != nil {
+		m.record(ctx, entry, err)
 		return err
 	}
 
 	if err := tuyadevice.Pair(); err != nil {
+		entry.Action = history.ActionPairFailed
+		m.record(ctx, entry, err)
 		return err
 	}
 
-	m.conectedDevices[device.Address] = fingerbot.NewFingerbot(tuyadevice)
+	connected := fingerbot.NewFingerbot(tuyadevice)
+	m.devicesMutex.Lock()
+	if unsubscribe, ok := m.unsubscribers[device.Address]; ok {
+		unsubscribe()
+	}
+	m.conectedDevices[device.Address] = connected
+	m.unsubscribers[device.Address] = m.forwardDatapoints(connected)
+	m.devicesMutex.Unlock()
+
+	m.record(ctx, entry, nil)
+	m.publish(Event{Type: EventConnected, Address: device.Address, Name: device.Name, Source: history.SourceFromContext(ctx)})
+
+	m.applyPendingConfiguration(ctx, connected)
+
+	return nil
+}
+
+// Toggle presses the device once with its current configuration
+func (m *Manager) Toggle(ctx context.Context, address string) (fingerbot.PressResult, error) {
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return fingerbot.PressResult{}, ErrDeviceNotConnected
+	}
+
+	return m.toggle(ctx, device)
+}
+
+func (m *Manager) toggle(ctx context.Context, device *fingerbot.Fingerbot) (fingerbot.PressResult, error) {
+	if err := m.interlock(ctx, history.ActionPress, device); err != nil {
+		return fingerbot.PressResult{}, err
+	}
+
+	result, err := device.Toggle(ctx)
+	m.publishPress(ctx, device, result, err)
+
+	return result, err
+}
+
+// Press presses the device once with the given one-off options
+func (m *Manager) Press(ctx context.Context, address string, opts fingerbot.PressOptions) (fingerbot.PressResult, error) {
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return fingerbot.PressResult{}, ErrDeviceNotConnected
+	}
+
+	if err := m.interlock(ctx, history.ActionPress, device); err != nil {
+		return fingerbot.PressResult{}, err
+	}
+
+	result, err := device.Press(ctx, opts)
+	m.publishPress(ctx, device, result, err)
+
+	return result, err
+}
+
+// SetDatapoint sets a writable datapoint by name, see fingerbot.DatapointNames. The switch datapoint is refused.
+func (m *Manager) SetDatapoint(ctx context.Context, address, name, value string) error {
+	if name == fingerbot.DatapointName(fingerbot.SwitchDP) {
+		return ErrSwitchNotSettable
+	}
+
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return ErrDeviceNotConnected
+	}
+
+	return m.configure(ctx, device, func(t *fingerbot.FingerbotTransaction) error {
+		return t.SetDatapoint(name, value)
+	})
+}
+
+// Hold keeps the arm down until Release or until maxDuration passes
+func (m *Manager) Hold(ctx context.Context, address string, maxDuration time.Duration) (fingerbot.PressResult, error) {
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return fingerbot.PressResult{}, ErrDeviceNotConnected
+	}
+
+	return m.hold(ctx, device, maxDuration)
+}
+
+// Release lifts the arm of a held device
+func (m *Manager) Release(ctx context.Context, address string) (fingerbot.PressResult, error) {
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return fingerbot.PressResult{}, ErrDeviceNotConnected
+	}
+
+	return m.release(ctx, device)
+}
+
+func (m *Manager) hold(ctx context.Context, device *fingerbot.Fingerbot, maxDuration time.Duration) (fingerbot.PressResult, error) {
+	if err := m.interlock(ctx, history.ActionHold, device); err != nil {
+		return fingerbot.PressResult{}, err
+	}
+
+	result, err := device.Hold(ctx, maxDuration)
+	m.recordPress(ctx, history.ActionHold, device, result, err)
+
+	return result, err
+}
+
+func (m *Manager) release(ctx context.Context, device *fingerbot.Fingerbot) (fingerbot.PressResult, error) {
+	result, err := device.Release()
+	m.recordPress(ctx, history.ActionRelease, device, result, err)
+
+	return result, err
+}
+
+// SaveConfiguration applies the configuration to a connected device. It fails with ErrConfigurationConflict if the
+// configuration of the device no longer matches the tag the change is based on, the tag is compared under the lock of
+// the device so no other change can come in between.
+func (m *Manager) SaveConfiguration(ctx context.Context, address, tag string, config fingerbot.Configuration) error {
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return ErrDeviceNotConnected
+	}
+
+	return m.configure(ctx, device, func(t *fingerbot.FingerbotTransaction) error {
+		if err := checkConfigurationTag(t.Configuration(), tag); err != nil {
+			return err
+		}
+
+		t.SetConfiguration(config)
+		return nil
+	})
+}
+
+func (m *Manager) GetPresets(ctx context.Context, address string) ([]*Preset, error) {
+	presets, err := m.repository.GetPresets(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presets: %w", err)
+	}
+
+	return presets, nil
+}
+
+func (m *Manager) CreatePreset(ctx context.Context, preset *Preset) error {
+	if err := preset.Configuration().Validate(); err != nil {
+		return err
+	}
+
+	if err := m.repository.CreatePreset(ctx, preset); err != nil {
+		return fmt.Errorf("failed to create preset: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) DeletePreset(ctx context.Context, address string, id int64) error {
+	if _, err := m.getPreset(ctx, address, id); err != nil {
+		return err
+	}
+
+	if err := m.repository.DeletePreset(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete preset: %w", err)
+	}
+
+	for _, hook := range m.presetDeleteHooks {
+		if err := hook(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OnDeletePreset registers a hook run whenever a preset is deleted, like OnForget
+func (m *Manager) OnDeletePreset(hook DeleteHook) {
+	m.presetDeleteHooks = append(m.presetDeleteHooks, hook)
+}
+
+// CopyPreset copies a preset to another saved device, keeping its name
+func (m *Manager) CopyPreset(ctx context.Context, address string, id int64, targetAddress string) (*Preset, error) {
+	preset, err := m.getPreset(ctx, address, id)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := m.repository.GetDevice(ctx, targetAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+	if target == nil {
+		return nil, fmt.Errorf("device not found: %s", targetAddress)
+	}
+
+	copied := *preset
+	copied.ID = 0
+	copied.Address = target.Address
+	if err := m.repository.CreatePreset(ctx, &copied); err != nil {
+		return nil, fmt.Errorf("failed to copy preset: %w", err)
+	}
+
+	return &copied, nil
+}
+
+// ApplyPreset saves the preset as the device configuration in a single transaction
+func (m *Manager) ApplyPreset(ctx context.Context, address string, id int64) error {
+	preset, err := m.getPreset(ctx, address, id)
+	if err != nil {
+		return err
+	}
+
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return ErrDeviceNotConnected
+	}
+
+	return m.configure(ctx, device, func(t *fingerbot.FingerbotTransaction) error {
+		t.SetConfiguration(preset.Configuration())
+		return nil
+	})
+}
+
+// PressWithPreset presses once using the preset and restores the previous configuration afterwards
+func (m *Manager) PressWithPreset(ctx context.Context, address string, id int64) (fingerbot.PressResult, error) {
+	preset, err := m.getPreset(ctx, address, id)
+	if err != nil {
+		return fingerbot.PressResult{}, err
+	}
+
+	return m.Press(ctx, address, preset.PressOptions())
+}
+
+func (m *Manager) getPreset(ctx context.Context, address string, id int64) (*Preset, error) {
+	preset, err := m.repository.GetPreset(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get preset: %w", err)
+	}
+	if preset == nil || preset.Address != address {
+		return nil, ErrPresetNotFound
+	}
+
+	return preset, nil
+}
+
+func (m *Manager) GetMacros(ctx context.Context) ([]*Macro, error) {
+	macros, err := m.repository.GetMacros(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get macros: %w", err)
+	}
+
+	return macros, nil
+}
+
+func (m *Manager) GetMacro(ctx context.Context, id int64) (*Macro, error) {
+	macro, err := m.repository.GetMacro(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get macro: %w", err)
+	}
+	if macro == nil {
+		return nil, ErrMacroNotFound
+	}
+
+	return macro, nil
+}
+
+func (m *Manager) CreateMacro(ctx context.Context, macro *Macro) error {
+	if macro.Name == "" {
+		return fmt.Errorf("macro name is required")
+	}
+	if len(macro.Steps) == 0 {
+		return fmt.Errorf("macro has no steps")
+	}
+	for i, step := range macro.Steps {
+		if err := step.Validate(); err != nil {
+			return fmt.Errorf("step %d: %w", i+1, err)
+		}
+	}
+
+	if err := m.repository.CreateMacro(ctx, macro); err != nil {
+		return fmt.Errorf("failed to create macro: %w", err)
+	}
+
+	return nil
+}
+
+func (m *Manager) DeleteMacro(ctx context.Context, id int64) error {
+	if err := m.repository.DeleteMacro(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete macro: %w", err)
+	}
+
+	for _, hook := range m.macroDeleteHooks {
+		if err := hook(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// OnDeleteMacro registers a hook run whenever a macro is deleted, like OnForget
+func (m *Manager) OnDeleteMacro(hook DeleteHook) {
+	m.macroDeleteHooks = append(m.macroDeleteHooks, hook)
+}
+
+// RunMacro runs the macro on all given devices in parallel and reports the progress of every step to output.
+// A failing step stops the macro on that device only, cancelling ctx stops it on all devices.
+func (m *Manager) RunMacro(ctx context.Context, id int64, addresses []string, output chan<- MacroProgress) error {
+	macro, err := m.GetMacro(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	devices := make([]*fingerbot.Fingerbot, 0, len(addresses))
+	for _, address := range addresses {
+		device := m.GetFingerbot(address)
+		if device == nil {
+			return fmt.Errorf("%w: %s", ErrDeviceNotConnected, address)
+		}
+		devices = append(devices, device)
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(devices))
+	for i, device := range devices {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.runMacro(ctx, device, macro, output)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (m *Manager) runMacro(ctx context.Context, device *fingerbot.Fingerbot, macro *Macro, output chan<- MacroProgress) error {
+	// Never leave the arm held down when the macro stops
+	defer func() {
+		if device.Holding() {
+			if _, err := m.release(ctx, device); err != nil {
+				m.logger.Error("failed to release device after macro", slog.String("address", device.Address()), slog.Any("error", err))
+			}
+		}
+	}()
+
+	for i, step := range macro.Steps {
+		progress := MacroProgress{
+			Address: device.Address(),
+			Step:    i + 1,
+			Steps:   len(macro.Steps),
+			Action:  step.String(),
+			Status:  MacroStepStatusRunning,
+		}
+		output <- progress
+
+		err := m.runMacroStep(ctx, device, step)
+		switch {
+		case ctx.Err() != nil:
+			progress.Status = MacroStepStatusCancelled
+			output <- progress
+			return ctx.Err()
+		case err != nil:
+			progress.Status = MacroStepStatusFailed
+			progress.Error = err.Error()
+			output <- progress
+			return fmt.Errorf("%s step %d: %w", device.Address(), i+1, err)
+		}
+
+		progress.Status = MacroStepStatusDone
+		output <- progress
+	}
+
+	return nil
+}
+
+func (m *Manager) runMacroStep(ctx context.Context, device *fingerbot.Fingerbot, step MacroStep) error {
+	switch step.Type {
+	case MacroStepPress:
+		for range max(step.Count, 1) {
+			if _, err := m.toggle(ctx, device); err != nil {
+				return err
+			}
+		}
+	case MacroStepHold:
+		if _, err := m.hold(ctx, device, step.Duration); err != nil {
+			return err
+		}
+	case MacroStepRelease:
+		if _, err := m.release(ctx, device); err != nil {
+			return err
+		}
+	case MacroStepWait:
+		timer := time.NewTimer(step.Duration)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	case MacroStepArm:
+		return m.configure(ctx, device, func(t *fingerbot.FingerbotTransaction) error {
+			t.SetArmPercent(step.ArmUpPercent, step.ArmDownPercent)
+			return nil
+		})
+	case MacroStepMode:
+		return m.configure(ctx, device, func(t *fingerbot.FingerbotTransaction) error {
+			t.SetMode(step.Mode)
+			return nil
+		})
+	default:
+		return fmt.Errorf("unknown step type: %s", step.Type)
+	}
 
 	return nil
 }
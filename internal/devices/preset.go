@@ -0,0 +1,144 @@
+package devices
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+// Preset is a named device configuration which can be applied or pressed with in one step
+type Preset struct {
+	ID               int64                 `sql:"id"`
+	Address          string                `sql:"address"`
+	Name             string                `sql:"name"`
+	Mode             fingerbot.Mode        `sql:"mode"`
+	ClickSustainTime int32                 `sql:"click_sustain_time"`
+	ControlBack      fingerbot.ControlBack `sql:"control_back"`
+	ArmDownPercent   int32                 `sql:"arm_down_percent"`
+	ArmUpPercent     int32                 `sql:"arm_up_percent"`
+}
+
+func (p *Preset) Configuration() fingerbot.Configuration {
+	return fingerbot.Configuration{
+		Mode:             p.Mode,
+		ClickSustainTime: p.ClickSustainTime,
+		ControlBack:      p.ControlBack,
+		ArmDownPercent:   p.ArmDownPercent,
+		ArmUpPercent:     p.ArmUpPercent,
+	}
+}
+
+func (p *Preset) PressOptions() fingerbot.PressOptions {
+	return fingerbot.PressOptions{
+		Mode:           &p.Mode,
+		HoldTime:       &p.ClickSustainTime,
+		ArmDownPercent: &p.ArmDownPercent,
+		ArmUpPercent:   &p.ArmUpPercent,
+		ControlBack:    &p.ControlBack,
+	}
+}
+
+func (r *Repository) initPresets() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS presets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			address TEXT NOT NULL,
+			name TEXT NOT NULL,
+			mode INTEGER NOT NULL,
+			click_sustain_time INTEGER NOT NULL,
+			control_back INTEGER NOT NULL,
+			arm_down_percent INTEGER NOT NULL,
+			arm_up_percent INTEGER NOT NULL,
+			UNIQUE (address, name)
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating presets table: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) CreatePreset(ctx context.Context, p *Preset) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO presets (address, name, mode, click_sustain_time, control_back, arm_down_percent, arm_up_percent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		p.Address, p.Name, p.Mode, p.ClickSustainTime, p.ControlBack, p.ArmDownPercent, p.ArmUpPercent,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating preset: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting preset id: %w", err)
+	}
+	p.ID = id
+
+	return nil
+}
+
+func (r *Repository) DeletePreset(ctx context.Context, id int64) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM presets WHERE id = $1", id,
+	); err != nil {
+		return fmt.Errorf("error deleting preset: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteDevicePresets(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM presets WHERE address = $1", address,
+	); err != nil {
+		return fmt.Errorf("error deleting device presets: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetPreset(ctx context.Context, id int64) (*Preset, error) {
+	var p Preset
+	err := r.db.QueryRowContext(
+		ctx,
+		`SELECT id, address, name, mode, click_sustain_time, control_back, arm_down_percent, arm_up_percent
+		FROM presets WHERE id = $1`,
+		id,
+	).Scan(&p.ID, &p.Address, &p.Name, &p.Mode, &p.ClickSustainTime, &p.ControlBack, &p.ArmDownPercent, &p.ArmUpPercent)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting preset by id: %w", err)
+	}
+
+	return &p, nil
+}
+
+func (r *Repository) GetPresets(ctx context.Context, address string) ([]*Preset, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		`SELECT id, address, name, mode, click_sustain_time, control_back, arm_down_percent, arm_up_percent
+		FROM presets WHERE address = $1 ORDER BY name`,
+		address,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting presets: %w", err)
+	}
+	defer rows.Close()
+
+	var presets []*Preset
+	for rows.Next() {
+		var p Preset
+		if err := rows.Scan(&p.ID, &p.Address, &p.Name, &p.Mode, &p.ClickSustainTime, &p.ControlBack, &p.ArmDownPercent, &p.ArmUpPercent); err != nil {
+			return nil, fmt.Errorf("error scanning preset: %w", err)
+		}
+
+		presets = append(presets, &p)
+	}
+
+	return presets, nil
+}
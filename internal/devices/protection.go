@@ -0,0 +1,386 @@
+package devices
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/history"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Protection guards the presses of a device operating e.g. a lock or a gate against stray taps. Only the presses
+// people make in the web app and the API are protected, schedules, rules, macros and guest links are not.
+type Protection string
+
+const (
+	ProtectionNone Protection = "none"
+	// ProtectionConfirm requires pressing a second time to confirm
+	ProtectionConfirm Protection = "confirm"
+	// ProtectionHold requires holding a confirmation button for HoldToConfirm, the client reports the start of the
+	// hold with StartHold and confirms the press once it was held long enough
+	ProtectionHold Protection = "hold"
+	// ProtectionPIN requires entering the PIN of the device
+	ProtectionPIN Protection = "pin"
+)
+
+var Protections = []Protection{ProtectionNone, ProtectionConfirm, ProtectionHold, ProtectionPIN}
+
+const (
+	// ConfirmationTimeout is how long a press can be confirmed after it was requested
+	ConfirmationTimeout = 30 * time.Second
+	// HoldToConfirm is how long the confirmation must be held for ProtectionHold
+	HoldToConfirm = 1500 * time.Millisecond
+	// MaxPINAttempts is the number of wrong PINs in a row after which presses are locked for PINLockout
+	MaxPINAttempts = 5
+	PINLockout     = 5 * time.Minute
+)
+
+var (
+	ErrInvalidProtection   = errors.New("invalid protection")
+	ErrInvalidPIN          = errors.New("PIN must be 4 to 8 digits")
+	ErrConfirmationInvalid = errors.New("the confirmation expired, press again")
+	ErrHeldTooShort        = errors.New("the confirmation was not held long enough")
+	ErrHoldNotRequired     = errors.New("this device does not need presses to be held")
+)
+
+// ProtectionRequiredError is returned for presses of protected devices without the proof the protection needs.
+// Confirmation identifies the press to confirm for ProtectionConfirm and ProtectionHold, HoldFor is how long the
+// confirmation must be held between StartHold and the confirmed press.
+type ProtectionRequiredError struct {
+	Protection   Protection
+	Confirmation string
+	HoldFor      time.Duration
+}
+
+func (e *ProtectionRequiredError) Error() string {
+	switch e.Protection {
+	case ProtectionPIN:
+		return "this device needs a PIN to be pressed"
+	case ProtectionHold:
+		return "hold to confirm pressing this device"
+	default:
+		return "confirm pressing this device"
+	}
+}
+
+// WrongPINError is returned for presses with a wrong PIN, AttemptsLeft are the attempts before the lockout
+type WrongPINError struct {
+	AttemptsLeft int
+}
+
+func (e *WrongPINError) Error() string {
+	return fmt.Sprintf("wrong PIN, %d attempts left", e.AttemptsLeft)
+}
+
+// PINLockedError is returned for presses after too many wrong PINs, until Until
+type PINLockedError struct {
+	Until time.Time
+}
+
+func (e *PINLockedError) Error() string {
+	return fmt.Sprintf("too many wrong PINs, try again in %s", time.Until(e.Until).Round(time.Second))
+}
+
+// PressProof is what the user sent to get past the protection of a device
+type PressProof struct {
+	PIN          string
+	Confirmation string
+}
+
+type DeviceProtection struct {
+	Address    string     `sql:"address"`
+	Protection Protection `sql:"protection"`
+	// PINHash is the bcrypt hash of the PIN for ProtectionPIN
+	PINHash        string       `sql:"pin_hash"`
+	FailedAttempts int          `sql:"failed_attempts"`
+	LockedUntil    sql.NullTime `sql:"locked_until"`
+}
+
+func (p *DeviceProtection) HasPIN() bool {
+	return p.PINHash != ""
+}
+
+// pendingConfirmation is a press waiting to be confirmed
+type pendingConfirmation struct {
+	address   string
+	createdAt time.Time
+	// heldAt is when the hold of the confirmation started for ProtectionHold, zero until it does
+	heldAt time.Time
+}
+
+func (r *Repository) initProtections() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS device_protections (
+			address TEXT PRIMARY KEY,
+			protection TEXT NOT NULL,
+			pin_hash TEXT NOT NULL,
+			failed_attempts INTEGER NOT NULL DEFAULT 0,
+			locked_until TIMESTAMP
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating device protections table: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetProtection(ctx context.Context, address string) (*DeviceProtection, error) {
+	var p DeviceProtection
+	if err := r.db.QueryRowContext(
+		ctx,
+		"SELECT address, protection, pin_hash, failed_attempts, locked_until FROM device_protections WHERE address = $1",
+		address,
+	).Scan(&p.Address, &p.Protection, &p.PINHash, &p.FailedAttempts, &p.LockedUntil); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+
+		return nil, fmt.Errorf("error getting device protection: %w", err)
+	}
+
+	return &p, nil
+}
+
+// SaveProtection creates or replaces the protection of the device, resetting the wrong PINs
+func (r *Repository) SaveProtection(ctx context.Context, p *DeviceProtection) error {
+	if _, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO device_protections (address, protection, pin_hash, failed_attempts, locked_until) VALUES ($1, $2, $3, 0, NULL)
+		ON CONFLICT (address) DO UPDATE SET protection = excluded.protection, pin_hash = excluded.pin_hash,
+			failed_attempts = 0, locked_until = NULL`,
+		p.Address, p.Protection, p.PINHash,
+	); err != nil {
+		return fmt.Errorf("error saving device protection: %w", err)
+	}
+
+	return nil
+}
+
+// FailPIN counts a wrong PIN, once there were maxAttempts in a row the device is locked until lockedUntil and the
+// count starts over. It returns the new count.
+func (r *Repository) FailPIN(ctx context.Context, address string, maxAttempts int, lockedUntil time.Time) (int, error) {
+	var attempts int
+	if err := r.db.QueryRowContext(
+		ctx,
+		"UPDATE device_protections SET failed_attempts = failed_attempts + 1 WHERE address = $1 RETURNING failed_attempts",
+		address,
+	).Scan(&attempts); err != nil {
+		return 0, fmt.Errorf("error counting wrong PIN: %w", err)
+	}
+
+	if attempts >= maxAttempts {
+		if _, err := r.db.ExecContext(
+			ctx, "UPDATE device_protections SET failed_attempts = 0, locked_until = $1 WHERE address = $2",
+			lockedUntil.UTC(), address,
+		); err != nil {
+			return 0, fmt.Errorf("error locking device: %w", err)
+		}
+	}
+
+	return attempts, nil
+}
+
+func (r *Repository) ResetPINAttempts(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE device_protections SET failed_attempts = 0, locked_until = NULL WHERE address = $1", address,
+	); err != nil {
+		return fmt.Errorf("error resetting wrong PINs: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteProtection(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(ctx, "DELETE FROM device_protections WHERE address = $1", address); err != nil {
+		return fmt.Errorf("error deleting device protection: %w", err)
+	}
+
+	return nil
+}
+
+// GetProtection returns the protection of the device, ProtectionNone if it has none
+func (m *Manager) GetProtection(ctx context.Context, address string) (*DeviceProtection, error) {
+	protection, err := m.repository.GetProtection(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device protection: %w", err)
+	}
+	if protection == nil {
+		return &DeviceProtection{Address: address, Protection: ProtectionNone}, nil
+	}
+
+	return protection, nil
+}
+
+// SetProtection sets the protection of the device. The PIN is only needed for ProtectionPIN, an empty PIN keeps the
+// current one.
+func (m *Manager) SetProtection(ctx context.Context, address string, protection Protection, pin string) error {
+	if !slices.Contains(Protections, protection) {
+		return fmt.Errorf("%w: %s", ErrInvalidProtection, protection)
+	}
+
+	current, err := m.GetProtection(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	updated := &DeviceProtection{Address: address, Protection: protection}
+	switch {
+	case protection != ProtectionPIN:
+	case pin != "":
+		if len(pin) < 4 || len(pin) > 8 || strings.Trim(pin, "0123456789") != "" {
+			return ErrInvalidPIN
+		}
+
+		hash, err := bcrypt.GenerateFromPassword([]byte(pin), bcrypt.DefaultCost)
+		if err != nil {
+			return fmt.Errorf("failed to hash PIN: %w", err)
+		}
+		updated.PINHash = string(hash)
+	case current.HasPIN():
+		updated.PINHash = current.PINHash
+	default:
+		return ErrInvalidPIN
+	}
+
+	if err := m.repository.SaveProtection(ctx, updated); err != nil {
+		return fmt.Errorf("failed to save device protection: %w", err)
+	}
+
+	return nil
+}
+
+// CheckProtection returns nil if the press of the device may go ahead. Presses without the PIN or confirmation the
+// protection needs fail with a *ProtectionRequiredError, for ProtectionConfirm and ProtectionHold it carries the
+// confirmation to send with the same press again. Wrong PINs are recorded in the history.
+func (m *Manager) CheckProtection(ctx context.Context, address string, proof PressProof) error {
+	protection, err := m.GetProtection(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	switch protection.Protection {
+	case ProtectionConfirm, ProtectionHold:
+		if proof.Confirmation == "" {
+			return m.requireConfirmation(address, protection.Protection)
+		}
+
+		return m.confirm(address, proof.Confirmation, protection.Protection)
+	case ProtectionPIN:
+		return m.checkPIN(ctx, protection, proof.PIN)
+	default:
+		return nil
+	}
+}
+
+func (m *Manager) requireConfirmation(address string, protection Protection) error {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("failed to generate confirmation: %w", err)
+	}
+	confirmation := base64.RawURLEncoding.EncodeToString(b)
+
+	m.confirmationsMutex.Lock()
+	defer m.confirmationsMutex.Unlock()
+
+	for key, pending := range m.confirmations {
+		if time.Since(pending.createdAt) > ConfirmationTimeout {
+			delete(m.confirmations, key)
+		}
+	}
+	m.confirmations[confirmation] = pendingConfirmation{address: address, createdAt: time.Now()}
+
+	err := &ProtectionRequiredError{Protection: protection, Confirmation: confirmation}
+	if protection == ProtectionHold {
+		err.HoldFor = HoldToConfirm
+	}
+
+	return err
+}
+
+// StartHold records the start of the hold of a confirmation for ProtectionHold, the press is confirmed with the same
+// confirmation once it was held for HoldToConfirm. Starting the hold again restarts it.
+func (m *Manager) StartHold(ctx context.Context, address, confirmation string) error {
+	protection, err := m.GetProtection(ctx, address)
+	if err != nil {
+		return err
+	}
+	if protection.Protection != ProtectionHold {
+		return ErrHoldNotRequired
+	}
+
+	m.confirmationsMutex.Lock()
+	defer m.confirmationsMutex.Unlock()
+
+	pending, ok := m.confirmations[confirmation]
+	if !ok || pending.address != address || time.Since(pending.createdAt) > ConfirmationTimeout {
+		return ErrConfirmationInvalid
+	}
+	pending.heldAt = time.Now()
+	m.confirmations[confirmation] = pending
+
+	return nil
+}
+
+// confirm consumes the confirmation, a confirmation held too briefly is consumed as well so it cannot be retried.
+// For ProtectionHold the hold must have been started with StartHold at least HoldToConfirm ago.
+func (m *Manager) confirm(address, confirmation string, protection Protection) error {
+	m.confirmationsMutex.Lock()
+	pending, ok := m.confirmations[confirmation]
+	delete(m.confirmations, confirmation)
+	m.confirmationsMutex.Unlock()
+
+	if !ok || pending.address != address || time.Since(pending.createdAt) > ConfirmationTimeout {
+		return ErrConfirmationInvalid
+	}
+	if protection == ProtectionHold && (pending.heldAt.IsZero() || time.Since(pending.heldAt) < HoldToConfirm) {
+		return ErrHeldTooShort
+	}
+
+	return nil
+}
+
+func (m *Manager) checkPIN(ctx context.Context, protection *DeviceProtection, pin string) error {
+	now := time.Now()
+	if protection.LockedUntil.Valid && now.Before(protection.LockedUntil.Time) {
+		return &PINLockedError{Until: protection.LockedUntil.Time}
+	}
+	if pin == "" {
+		return &ProtectionRequiredError{Protection: ProtectionPIN}
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(protection.PINHash), []byte(pin)); err == nil {
+		if protection.FailedAttempts > 0 || protection.LockedUntil.Valid {
+			if err := m.repository.ResetPINAttempts(ctx, protection.Address); err != nil {
+				return fmt.Errorf("failed to reset wrong PINs: %w", err)
+			}
+		}
+
+		return nil
+	}
+
+	lockedUntil := now.Add(PINLockout)
+	attempts, err := m.repository.FailPIN(ctx, protection.Address, MaxPINAttempts, lockedUntil)
+	if err != nil {
+		return fmt.Errorf("failed to record wrong PIN: %w", err)
+	}
+
+	var pinErr error = &WrongPINError{AttemptsLeft: MaxPINAttempts - attempts}
+	if attempts >= MaxPINAttempts {
+		pinErr = &PINLockedError{Until: lockedUntil}
+	}
+
+	entry := history.Entry{Action: history.ActionPress, Address: protection.Address}
+	if device, err := m.repository.GetDevice(ctx, protection.Address); err == nil && device != nil {
+		entry.DeviceName = device.Name
+	}
+	m.recordRefused(ctx, entry, pinErr)
+
+	return pinErr
+}
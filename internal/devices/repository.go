@@ -4,8 +4,12 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+
+	"github.com/cybre/fingerbot-web/internal/secrets"
 )
 
+// Device is a saved device, its local key and UUID are encrypted in the database
 type Device struct {
 	Address  string `sql:"address"`
 	DeviceID string `sql:"device_id"`
@@ -14,12 +18,22 @@ type Device struct {
 	UUID     string `sql:"uuid"`
 }
 
+// LogValue keeps the local key and UUID out of the logs, they are all it takes to control the device
+func (d Device) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("address", d.Address),
+		slog.String("device_id", d.DeviceID),
+		slog.String("name", d.Name),
+	)
+}
+
 type Repository struct {
-	db *sql.DB
+	db     *sql.DB
+	cipher *secrets.Cipher
 }
 
-func NewRepository(db *sql.DB) *Repository {
-	repo := &Repository{db: db}
+func NewRepository(db *sql.DB, cipher *secrets.Cipher) *Repository {
+	repo := &Repository{db: db, cipher: cipher}
 	if err := repo.init(); err != nil {
 		panic(err)
 	}
@@ -40,14 +54,54 @@ func (r *Repository) init() error {
 		return fmt.Errorf("error creating devices table: %w", err)
 	}
 
+	// Devices saved before the keys were encrypted
+	if _, err := secrets.EncryptColumns(
+		context.Background(), r.db, r.cipher, secrets.DeviceLocalKey, secrets.DeviceUUID,
+	); err != nil {
+		return fmt.Errorf("error encrypting device keys: %w", err)
+	}
+
+	if err := r.initPresets(); err != nil {
+		return err
+	}
+
+	if err := r.initMacros(); err != nil {
+		return err
+	}
+
+	if err := r.initSnapshots(); err != nil {
+		return err
+	}
+
+	if err := r.initConfigurationVersions(); err != nil {
+		return err
+	}
+
+	if err := r.initProtections(); err != nil {
+		return err
+	}
+
+	if err := r.initInterlocks(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 func (r *Repository) CreateDevice(ctx context.Context, d *Device) error {
+	localKey, err := r.cipher.Encrypt(secrets.DeviceLocalKey, d.Address, d.LocalKey)
+	if err != nil {
+		return fmt.Errorf("error encrypting local key: %w", err)
+	}
+	uuid, err := r.cipher.Encrypt(secrets.DeviceUUID, d.Address, d.UUID)
+	if err != nil {
+		return fmt.Errorf("error encrypting uuid: %w", err)
+	}
+
 	if _, err := r.db.ExecContext(
 		ctx,
 		"INSERT INTO devices (address, device_id, name, local_key, uuid) VALUES ($1, $2, $3, $4, $5)",
-		d.Address, d.DeviceID, d.Name, d.LocalKey, d.UUID,
+		d.Address, d.DeviceID, d.Name, localKey, uuid,
 	); err != nil {
 		return fmt.Errorf("error creating device: %w", err)
 	}
@@ -55,6 +109,16 @@ func (r *Repository) CreateDevice(ctx context.Context, d *Device) error {
 	return nil
 }
 
+func (r *Repository) UpdateDeviceName(ctx context.Context, address, name string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE devices SET name = $1 WHERE address = $2", name, address,
+	); err != nil {
+		return fmt.Errorf("error updating device name: %w", err)
+	}
+
+	return nil
+}
+
 func (r *Repository) DeleteDevice(ctx context.Context, address string) error {
 	if _, err := r.db.ExecContext(
 		ctx, "DELETE FROM devices WHERE address = $1", address,
@@ -76,6 +140,9 @@ func (r *Repository) GetDevice(ctx context.Context, address string) (*Device, er
 		}
 		return nil, fmt.Errorf("error getting device by address: %w", err)
 	}
+	if err := r.decrypt(&d); err != nil {
+		return nil, err
+	}
 
 	return &d, nil
 }
@@ -93,9 +160,25 @@ func (r *Repository) GetDevices(ctx context.Context) ([]*Device, error) {
 		if err := rows.Scan(&d.Address, &d.DeviceID, &d.Name, &d.LocalKey, &d.UUID); err != nil {
 			return nil, fmt.Errorf("error scanning device: %w", err)
 		}
+		if err := r.decrypt(&d); err != nil {
+			return nil, err
+		}
 
 		devices = append(devices, &d)
 	}
 
 	return devices, nil
 }
+
+// decrypt replaces the encrypted local key and UUID of the device read from the database with their values
+func (r *Repository) decrypt(d *Device) error {
+	var err error
+	if d.LocalKey, err = r.cipher.Decrypt(secrets.DeviceLocalKey, d.Address, d.LocalKey); err != nil {
+		return fmt.Errorf("error decrypting local key of %s: %w", d.Address, err)
+	}
+	if d.UUID, err = r.cipher.Decrypt(secrets.DeviceUUID, d.Address, d.UUID); err != nil {
+		return fmt.Errorf("error decrypting uuid of %s: %w", d.Address, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,371 @@
+package devices
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/cybre/fingerbot-web/internal/tuyable"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+// Snapshot is the last known state of a device, kept for when it is not connected
+type Snapshot struct {
+	Address    string
+	Datapoints map[byte]tuyable.DataPoint
+	// AsOf is the time the latest datapoint was reported
+	AsOf time.Time
+}
+
+func (s *Snapshot) Configuration() fingerbot.Configuration {
+	config := fingerbot.Configuration{Mode: fingerbot.ModeClick, ControlBack: fingerbot.ControlBackUp}
+	if dp, ok := s.Datapoints[fingerbot.ModeDP]; ok {
+		config.Mode = fingerbot.Mode(dp.Value.(uint32))
+	}
+	if dp, ok := s.Datapoints[fingerbot.ClickSustainTimeDP]; ok {
+		config.ClickSustainTime = dp.Value.(int32)
+	}
+	if dp, ok := s.Datapoints[fingerbot.ControlBackDP]; ok {
+		config.ControlBack = fingerbot.ControlBack(dp.Value.(uint32))
+	}
+	if dp, ok := s.Datapoints[fingerbot.ArmDownPercentDP]; ok {
+		config.ArmDownPercent = dp.Value.(int32)
+	}
+	if dp, ok := s.Datapoints[fingerbot.ArmUpPercentDP]; ok {
+		config.ArmUpPercent = dp.Value.(int32)
+	}
+
+	return config
+}
+
+func (s *Snapshot) BatteryPercent() int32 {
+	if dp, ok := s.Datapoints[fingerbot.BatteryPercentDP]; ok {
+		return dp.Value.(int32)
+	}
+
+	return 0
+}
+
+func (s *Snapshot) ChargeStatus() fingerbot.ChargeStatus {
+	if dp, ok := s.Datapoints[fingerbot.ChargeStatusDP]; ok {
+		return fingerbot.ChargeStatus(dp.Value.(uint32))
+	}
+
+	return fingerbot.ChargeStatusNone
+}
+
+// PendingConfiguration is a configuration saved while the device was not connected, it is applied on reconnect
+type PendingConfiguration struct {
+	Address  string                  `sql:"address"`
+	Config   fingerbot.Configuration `sql:"-"`
+	Source   history.Source          `sql:"-"`
+	QueuedAt time.Time               `sql:"queued_at"`
+}
+
+func (r *Repository) initSnapshots() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS device_datapoints (
+			address TEXT NOT NULL,
+			dp_id INTEGER NOT NULL,
+			type INTEGER NOT NULL,
+			value BLOB NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (address, dp_id)
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating device datapoints table: %w", err)
+	}
+
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS pending_configurations (
+			address TEXT PRIMARY KEY,
+			mode INTEGER NOT NULL,
+			click_sustain_time INTEGER NOT NULL,
+			control_back INTEGER NOT NULL,
+			arm_down_percent INTEGER NOT NULL,
+			arm_up_percent INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			source_name TEXT NOT NULL,
+			queued_at TIMESTAMP NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating pending configurations table: %w", err)
+	}
+
+	return nil
+}
+
+// SaveDatapoint stores the datapoint in its wire format so it can be parsed back like a device report
+func (r *Repository) SaveDatapoint(ctx context.Context, address string, dp tuyable.DataPoint, updatedAt time.Time) error {
+	payload, err := dp.Payload()
+	if err != nil {
+		return fmt.Errorf("error encoding datapoint: %w", err)
+	}
+
+	// The payload starts with the id, type and length header
+	if _, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO device_datapoints (address, dp_id, type, value, updated_at) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (address, dp_id) DO UPDATE SET type = excluded.type, value = excluded.value, updated_at = excluded.updated_at`,
+		address, dp.ID, dp.Type, payload[3:], updatedAt.UTC(),
+	); err != nil {
+		return fmt.Errorf("error saving datapoint: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetSnapshot(ctx context.Context, address string) (*Snapshot, error) {
+	rows, err := r.db.QueryContext(
+		ctx, "SELECT dp_id, type, value, updated_at FROM device_datapoints WHERE address = $1", address,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting device datapoints: %w", err)
+	}
+	defer rows.Close()
+
+	snapshot := &Snapshot{Address: address, Datapoints: map[byte]tuyable.DataPoint{}}
+	for rows.Next() {
+		var (
+			id        byte
+			dpType    tuyable.DPType
+			value     []byte
+			updatedAt time.Time
+		)
+		if err := rows.Scan(&id, &dpType, &value, &updatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning device datapoint: %w", err)
+		}
+
+		dp, err := tuyable.ParseDataPoint(id, dpType, value)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing device datapoint: %w", err)
+		}
+		snapshot.Datapoints[id] = dp
+		if updatedAt.After(snapshot.AsOf) {
+			snapshot.AsOf = updatedAt
+		}
+	}
+
+	if len(snapshot.Datapoints) == 0 {
+		return nil, nil
+	}
+
+	return snapshot, nil
+}
+
+func (r *Repository) DeleteSnapshot(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM device_datapoints WHERE address = $1", address,
+	); err != nil {
+		return fmt.Errorf("error deleting device datapoints: %w", err)
+	}
+
+	return nil
+}
+
+// ReplacePendingConfiguration queues the configuration in place of previous, nil when none was queued. It reports
+// false without changing anything if another configuration was queued meanwhile.
+func (r *Repository) ReplacePendingConfiguration(
+	ctx context.Context, p *PendingConfiguration, previous *fingerbot.Configuration,
+) (bool, error) {
+	var (
+		result sql.Result
+		err    error
+	)
+	if previous == nil {
+		result, err = r.db.ExecContext(
+			ctx,
+			`INSERT INTO pending_configurations
+			(address, mode, click_sustain_time, control_back, arm_down_percent, arm_up_percent, source, source_name, queued_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+			ON CONFLICT (address) DO NOTHING`,
+			p.Address, p.Config.Mode, p.Config.ClickSustainTime, p.Config.ControlBack, p.Config.ArmDownPercent,
+			p.Config.ArmUpPercent, p.Source.Kind, p.Source.Name, p.QueuedAt.UTC(),
+		)
+	} else {
+		result, err = r.db.ExecContext(
+			ctx,
+			`UPDATE pending_configurations SET mode = $1, click_sustain_time = $2, control_back = $3,
+			arm_down_percent = $4, arm_up_percent = $5, source = $6, source_name = $7, queued_at = $8
+			WHERE address = $9 AND mode = $10 AND click_sustain_time = $11 AND control_back = $12
+			AND arm_down_percent = $13 AND arm_up_percent = $14`,
+			p.Config.Mode, p.Config.ClickSustainTime, p.Config.ControlBack, p.Config.ArmDownPercent,
+			p.Config.ArmUpPercent, p.Source.Kind, p.Source.Name, p.QueuedAt.UTC(), p.Address, previous.Mode,
+			previous.ClickSustainTime, previous.ControlBack, previous.ArmDownPercent, previous.ArmUpPercent,
+		)
+	}
+	if err != nil {
+		return false, fmt.Errorf("error saving pending configuration: %w", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error saving pending configuration: %w", err)
+	}
+
+	return affected == 1, nil
+}
+
+func (r *Repository) GetPendingConfiguration(ctx context.Context, address string) (*PendingConfiguration, error) {
+	var p PendingConfiguration
+	if err := r.db.QueryRowContext(
+		ctx,
+		`SELECT address, mode, click_sustain_time, control_back, arm_down_percent, arm_up_percent, source, source_name, queued_at
+		FROM pending_configurations WHERE address = $1`,
+		address,
+	).Scan(
+		&p.Address, &p.Config.Mode, &p.Config.ClickSustainTime, &p.Config.ControlBack, &p.Config.ArmDownPercent,
+		&p.Config.ArmUpPercent, &p.Source.Kind, &p.Source.Name, &p.QueuedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting pending configuration: %w", err)
+	}
+
+	return &p, nil
+}
+
+func (r *Repository) DeletePendingConfiguration(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM pending_configurations WHERE address = $1", address,
+	); err != nil {
+		return fmt.Errorf("error deleting pending configuration: %w", err)
+	}
+
+	return nil
+}
+
+// GetSnapshot returns the last known state of a saved device, nil if nothing was reported yet
+func (m *Manager) GetSnapshot(ctx context.Context, address string) (*Snapshot, error) {
+	snapshot, err := m.repository.GetSnapshot(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device snapshot: %w", err)
+	}
+
+	return snapshot, nil
+}
+
+func (m *Manager) GetPendingConfiguration(ctx context.Context, address string) (*PendingConfiguration, error) {
+	pending, err := m.repository.GetPendingConfiguration(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pending configuration: %w", err)
+	}
+
+	return pending, nil
+}
+
+// QueueConfiguration stores a configuration for a device which is not connected, replacing any queued one.
+// It is applied the next time the device connects. It fails with ErrConfigurationConflict if the queued or last known
+// configuration no longer matches the tag the change is based on, including when another one is queued meanwhile.
+func (m *Manager) QueueConfiguration(ctx context.Context, address, tag string, config fingerbot.Configuration) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	device, err := m.repository.GetDevice(ctx, address)
+	if err != nil {
+		return fmt.Errorf("failed to get device: %w", err)
+	}
+	if device == nil {
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
+	}
+	if err := m.checkMaintenance(ctx, device.Address, device.Name); err != nil {
+		return err
+	}
+
+	snapshot, pending, err := m.offlineConfiguration(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+	var (
+		current  fingerbot.Configuration
+		previous *fingerbot.Configuration
+	)
+	if pending != nil {
+		current, previous = pending.Config, &pending.Config
+	} else if snapshot != nil {
+		current = snapshot.Configuration()
+	}
+	if err := checkConfigurationTag(current, tag); err != nil {
+		return err
+	}
+
+	queued, err := m.repository.ReplacePendingConfiguration(ctx, &PendingConfiguration{
+		Address:  device.Address,
+		Config:   config,
+		Source:   history.SourceFromContext(ctx),
+		QueuedAt: time.Now(),
+	}, previous)
+	if err != nil {
+		return fmt.Errorf("failed to queue configuration: %w", err)
+	}
+	if !queued {
+		return ErrConfigurationConflict
+	}
+
+	details := ""
+	if snapshot != nil {
+		details = configurationDiff(snapshot.Configuration(), config)
+	}
+	m.record(ctx, history.Entry{
+		Action:     history.ActionConfigure,
+		Address:    device.Address,
+		DeviceName: device.Name,
+		Outcome:    history.OutcomeQueued,
+		Details:    details,
+	}, nil)
+
+	return nil
+}
+
+// offlineConfiguration returns the last known state and the queued configuration of a device, either may be nil
+func (m *Manager) offlineConfiguration(ctx context.Context, address string) (*Snapshot, *PendingConfiguration, error) {
+	snapshot, err := m.GetSnapshot(ctx, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending, err := m.GetPendingConfiguration(ctx, address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return snapshot, pending, nil
+}
+
+// applyPendingConfiguration applies the configuration queued while the device was not connected
+func (m *Manager) applyPendingConfiguration(ctx context.Context, device *fingerbot.Fingerbot) {
+	pending, err := m.repository.GetPendingConfiguration(ctx, device.Address())
+	if err != nil {
+		m.logger.Error("failed to get pending configuration", slog.String("address", device.Address()), logging.ErrAttr(err))
+		return
+	}
+	if pending == nil {
+		return
+	}
+
+	// The change is attributed to whoever queued it
+	sourceCtx := history.WithSource(ctx, pending.Source)
+	err = m.configure(sourceCtx, device, func(t *fingerbot.FingerbotTransaction) error {
+		t.SetConfiguration(pending.Config)
+		return nil
+	})
+	if err != nil {
+		m.logger.Error("failed to apply pending configuration", slog.String("address", device.Address()), logging.ErrAttr(err))
+
+		// A configuration failing validation will never apply, any other failure, e.g. a lost connection or the
+		// maintenance lock, is retried on the next connect
+		if !errors.As(err, new(*tuyable.ValidationError)) {
+			return
+		}
+	}
+
+	if err := m.repository.DeletePendingConfiguration(ctx, device.Address()); err != nil {
+		m.logger.Error("failed to delete pending configuration", slog.String("address", device.Address()), logging.ErrAttr(err))
+	}
+}
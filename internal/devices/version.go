@@ -0,0 +1,244 @@
+package devices
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+var ErrConfigurationVersionNotFound = errors.New("configuration version not found")
+
+// ConfigurationVersion is a configuration which was applied to a device, kept so it can be compared and reverted to
+type ConfigurationVersion struct {
+	ID        int64
+	Address   string
+	Config    fingerbot.Configuration
+	Source    history.Source
+	CreatedAt time.Time
+}
+
+// ConfigurationChange is a single field which differs between two configurations
+type ConfigurationChange struct {
+	Field string
+	From  any
+	To    any
+}
+
+// ConfigurationChanges returns the fields which differ between two configurations
+func ConfigurationChanges(before, after fingerbot.Configuration) []ConfigurationChange {
+	var changes []ConfigurationChange
+	add := func(field string, from, to any) {
+		if from != to {
+			changes = append(changes, ConfigurationChange{Field: field, From: from, To: to})
+		}
+	}
+
+	add("mode", before.Mode, after.Mode)
+	add("click_sustain_time", before.ClickSustainTime, after.ClickSustainTime)
+	add("control_back", before.ControlBack, after.ControlBack)
+	add("arm_down_percent", before.ArmDownPercent, after.ArmDownPercent)
+	add("arm_up_percent", before.ArmUpPercent, after.ArmUpPercent)
+
+	return changes
+}
+
+func (r *Repository) initConfigurationVersions() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS configuration_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			address TEXT NOT NULL,
+			mode INTEGER NOT NULL,
+			click_sustain_time INTEGER NOT NULL,
+			control_back INTEGER NOT NULL,
+			arm_down_percent INTEGER NOT NULL,
+			arm_up_percent INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			source_name TEXT NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating configuration versions table: %w", err)
+	}
+
+	if _, err := r.db.Exec(
+		"CREATE INDEX IF NOT EXISTS configuration_versions_address ON configuration_versions (address, id)",
+	); err != nil {
+		return fmt.Errorf("error creating configuration versions index: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) CreateConfigurationVersion(ctx context.Context, v *ConfigurationVersion) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO configuration_versions
+		(address, mode, click_sustain_time, control_back, arm_down_percent, arm_up_percent, source, source_name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		v.Address, v.Config.Mode, v.Config.ClickSustainTime, v.Config.ControlBack, v.Config.ArmDownPercent,
+		v.Config.ArmUpPercent, v.Source.Kind, v.Source.Name, v.CreatedAt.UTC(),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating configuration version: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting configuration version id: %w", err)
+	}
+	v.ID = id
+
+	return nil
+}
+
+const configurationVersionColumns = `id, address, mode, click_sustain_time, control_back, arm_down_percent, arm_up_percent,
+	source, source_name, created_at`
+
+func scanConfigurationVersion(row interface{ Scan(...any) error }) (*ConfigurationVersion, error) {
+	var v ConfigurationVersion
+	if err := row.Scan(
+		&v.ID, &v.Address, &v.Config.Mode, &v.Config.ClickSustainTime, &v.Config.ControlBack, &v.Config.ArmDownPercent,
+		&v.Config.ArmUpPercent, &v.Source.Kind, &v.Source.Name, &v.CreatedAt,
+	); err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// GetConfigurationVersions returns the versions of a device, newest first
+func (r *Repository) GetConfigurationVersions(ctx context.Context, address string) ([]*ConfigurationVersion, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT "+configurationVersionColumns+" FROM configuration_versions WHERE address = $1 ORDER BY id DESC",
+		address,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting configuration versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*ConfigurationVersion
+	for rows.Next() {
+		v, err := scanConfigurationVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning configuration version: %w", err)
+		}
+		versions = append(versions, v)
+	}
+
+	return versions, rows.Err()
+}
+
+func (r *Repository) GetConfigurationVersion(ctx context.Context, address string, id int64) (*ConfigurationVersion, error) {
+	v, err := scanConfigurationVersion(r.db.QueryRowContext(
+		ctx,
+		"SELECT "+configurationVersionColumns+" FROM configuration_versions WHERE address = $1 AND id = $2",
+		address, id,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting configuration version: %w", err)
+	}
+
+	return v, nil
+}
+
+func (r *Repository) HasConfigurationVersions(ctx context.Context, address string) (bool, error) {
+	var exists bool
+	if err := r.db.QueryRowContext(
+		ctx, "SELECT EXISTS (SELECT 1 FROM configuration_versions WHERE address = $1)", address,
+	).Scan(&exists); err != nil {
+		return false, fmt.Errorf("error checking configuration versions: %w", err)
+	}
+
+	return exists, nil
+}
+
+func (r *Repository) DeleteConfigurationVersions(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM configuration_versions WHERE address = $1", address,
+	); err != nil {
+		return fmt.Errorf("error deleting configuration versions: %w", err)
+	}
+
+	return nil
+}
+
+// saveConfigurationVersion stores an applied configuration. The first version of a device is preceded by
+// the configuration it replaced, so the original one can be reverted to as well.
+func (m *Manager) saveConfigurationVersion(ctx context.Context, address string, before, after fingerbot.Configuration) error {
+	// The versions are stored even if the request was cancelled meanwhile, the device is already configured
+	ctx = context.WithoutCancel(ctx)
+	exists, err := m.repository.HasConfigurationVersions(ctx, address)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if !exists {
+		initial := &ConfigurationVersion{
+			Address:   address,
+			Config:    before,
+			Source:    history.Source{Kind: history.SourceSystem, Name: "initial"},
+			CreatedAt: now,
+		}
+		if err := m.repository.CreateConfigurationVersion(ctx, initial); err != nil {
+			return err
+		}
+	}
+
+	return m.repository.CreateConfigurationVersion(ctx, &ConfigurationVersion{
+		Address:   address,
+		Config:    after,
+		Source:    history.SourceFromContext(ctx),
+		CreatedAt: now,
+	})
+}
+
+// GetConfigurationVersions returns the configurations applied to a device, newest first
+func (m *Manager) GetConfigurationVersions(ctx context.Context, address string) ([]*ConfigurationVersion, error) {
+	versions, err := m.repository.GetConfigurationVersions(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configuration versions: %w", err)
+	}
+
+	return versions, nil
+}
+
+func (m *Manager) GetConfigurationVersion(ctx context.Context, address string, id int64) (*ConfigurationVersion, error) {
+	version, err := m.repository.GetConfigurationVersion(ctx, address, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get configuration version: %w", err)
+	}
+	if version == nil {
+		return nil, ErrConfigurationVersionNotFound
+	}
+
+	return version, nil
+}
+
+// RevertConfiguration applies an earlier configuration version again, which is stored as a new version
+func (m *Manager) RevertConfiguration(ctx context.Context, address string, id int64) error {
+	version, err := m.GetConfigurationVersion(ctx, address, id)
+	if err != nil {
+		return err
+	}
+
+	device := m.GetFingerbot(address)
+	if device == nil {
+		return ErrDeviceNotConnected
+	}
+
+	return m.configure(ctx, device, func(t *fingerbot.FingerbotTransaction) error {
+		t.SetConfiguration(version.Config)
+		return nil
+	})
+}
@@ -0,0 +1,217 @@
+package history
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Action string
+
+const (
+	ActionPress      Action = "press"
+	ActionHold       Action = "hold"
+	ActionRelease    Action = "release"
+	ActionConfigure  Action = "configure"
+	ActionConnect    Action = "connect"
+	ActionDisconnect Action = "disconnect"
+	ActionPairFailed Action = "pair_failed"
+	ActionForget     Action = "forget"
+)
+
+var Actions = []Action{
+	ActionPress, ActionHold, ActionRelease, ActionConfigure, ActionConnect, ActionDisconnect, ActionPairFailed, ActionForget,
+}
+
+type Outcome string
+
+const (
+	OutcomeSuccess Outcome = "success"
+	// OutcomeUnconfirmed is used for presses the device did not report back
+	OutcomeUnconfirmed Outcome = "unconfirmed"
+	OutcomeFailure     Outcome = "failure"
+	// OutcomeQueued is used for configuration changes saved while the device was not connected
+	OutcomeQueued Outcome = "queued"
+	// OutcomeRefused is used for actions refused before they reached the device, e.g. by an interlock
+	OutcomeRefused Outcome = "refused"
+)
+
+var Outcomes = []Outcome{OutcomeSuccess, OutcomeUnconfirmed, OutcomeFailure, OutcomeQueued, OutcomeRefused}
+
+type Entry struct {
+	ID         int64      `sql:"id" json:"id"`
+	Time       time.Time  `sql:"time" json:"time"`
+	Action     Action     `sql:"action" json:"action"`
+	Address    string     `sql:"address" json:"address"`
+	DeviceName string     `sql:"device_name" json:"deviceName"`
+	Source     SourceKind `sql:"source" json:"source"`
+	SourceName string     `sql:"source_name" json:"sourceName,omitempty"`
+	Outcome    Outcome    `sql:"outcome" json:"outcome"`
+	// Details describes the action, e.g. the configuration diff or the press latency
+	Details string `sql:"details" json:"details,omitempty"`
+	Error   string `sql:"error" json:"error,omitempty"`
+}
+
+// Filter selects history entries, zero values match everything
+type Filter struct {
+	Address string
+	Action  Action
+	Source  SourceKind
+	Outcome Outcome
+	// ExcludeOutcome leaves out the entries with the outcome, e.g. the refused presses
+	ExcludeOutcome Outcome
+	Since          time.Time
+	Until          time.Time
+	// Limit is the maximum number of entries returned, 0 returns all entries
+	Limit  int
+	Offset int
+}
+
+func (f Filter) where() (string, []any) {
+	var (
+		conditions []string
+		args       []any
+	)
+	add := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.Address != "" {
+		add("address = $%d", f.Address)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.Source != "" {
+		add("source = $%d", f.Source)
+	}
+	if f.Outcome != "" {
+		add("outcome = $%d", f.Outcome)
+	}
+	if f.ExcludeOutcome != "" {
+		add("outcome != $%d", f.ExcludeOutcome)
+	}
+	if !f.Since.IsZero() {
+		add("time >= $%d", f.Since.UTC())
+	}
+	if !f.Until.IsZero() {
+		add("time < $%d", f.Until.UTC())
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+
+	return " WHERE " + strings.Join(conditions, " AND "), args
+}
+
+// Repository stores the history, entries can only be appended
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	repo := &Repository{db: db}
+	if err := repo.init(); err != nil {
+		panic(err)
+	}
+
+	return repo
+}
+
+func (r *Repository) init() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			time TIMESTAMP NOT NULL,
+			action TEXT NOT NULL,
+			address TEXT NOT NULL,
+			device_name TEXT NOT NULL,
+			source TEXT NOT NULL,
+			source_name TEXT NOT NULL,
+			outcome TEXT NOT NULL,
+			details TEXT NOT NULL,
+			error TEXT NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating history table: %w", err)
+	}
+
+	if _, err := r.db.Exec(`CREATE INDEX IF NOT EXISTS history_time ON history (time)`); err != nil {
+		return fmt.Errorf("error creating history index: %w", err)
+	}
+
+	for _, statement := range []string{"UPDATE", "DELETE"} {
+		if _, err := r.db.Exec(fmt.Sprintf(`
+			CREATE TRIGGER IF NOT EXISTS history_no_%s BEFORE %s ON history
+			BEGIN
+				SELECT RAISE(ABORT, 'history is append-only');
+			END
+		`, strings.ToLower(statement), statement)); err != nil {
+			return fmt.Errorf("error creating history trigger: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Times are stored in UTC so that they compare correctly as text
+const entryColumns = `id, time, action, address, device_name, source, source_name, outcome, details, error`
+
+func (r *Repository) Append(ctx context.Context, e *Entry) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO history (time, action, address, device_name, source, source_name, outcome, details, error)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		e.Time.UTC(), e.Action, e.Address, e.DeviceName, e.Source, e.SourceName, e.Outcome, e.Details, e.Error,
+	)
+	if err != nil {
+		return fmt.Errorf("error appending history entry: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting history entry id: %w", err)
+	}
+	e.ID = id
+
+	return nil
+}
+
+// Query returns the entries matching the filter, newest first, and the total number of matching entries
+func (r *Repository) Query(ctx context.Context, filter Filter) ([]*Entry, int, error) {
+	where, args := filter.where()
+
+	var total int
+	if err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM history"+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting history entries: %w", err)
+	}
+
+	query := "SELECT " + entryColumns + " FROM history" + where + " ORDER BY time DESC, id DESC"
+	if filter.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d OFFSET %d", filter.Limit, filter.Offset)
+	}
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting history entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(
+			&e.ID, &e.Time, &e.Action, &e.Address, &e.DeviceName, &e.Source, &e.SourceName, &e.Outcome, &e.Details, &e.Error,
+		); err != nil {
+			return nil, 0, fmt.Errorf("error scanning history entry: %w", err)
+		}
+
+		entries = append(entries, &e)
+	}
+
+	return entries, total, nil
+}
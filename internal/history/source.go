@@ -0,0 +1,45 @@
+package history
+
+import "context"
+
+type SourceKind string
+
+const (
+	SourceSystem   SourceKind = "system"
+	SourceWeb      SourceKind = "web"
+	SourceAPIToken SourceKind = "api_token"
+	// SourceGuestLink is used for the actions of guests opening a shared link, named after the link
+	SourceGuestLink SourceKind = "guest_link"
+	SourceSchedule  SourceKind = "schedule"
+	SourceRule      SourceKind = "rule"
+)
+
+// Source describes who or what initiated an action, Name identifies the user, token, guest link, schedule or rule
+type Source struct {
+	Kind SourceKind `json:"kind"`
+	Name string     `json:"name,omitempty"`
+}
+
+func (s Source) String() string {
+	if s.Name == "" {
+		return string(s.Kind)
+	}
+
+	return string(s.Kind) + ": " + s.Name
+}
+
+type sourceKey struct{}
+
+// WithSource returns a context carrying the source of the actions run with it
+func WithSource(ctx context.Context, source Source) context.Context {
+	return context.WithValue(ctx, sourceKey{}, source)
+}
+
+// SourceFromContext returns the source carried by the context, SourceSystem if there is none
+func SourceFromContext(ctx context.Context) Source {
+	if source, ok := ctx.Value(sourceKey{}).(Source); ok {
+		return source
+	}
+
+	return Source{Kind: SourceSystem}
+}
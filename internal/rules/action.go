@@ -0,0 +1,141 @@
+package rules
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+type ActionType string
+
+const (
+	ActionPress   ActionType = "press"
+	ActionPreset  ActionType = "preset"
+	ActionSet     ActionType = "set"
+	ActionWebhook ActionType = "webhook"
+)
+
+// Action is run when a rule matches. Device actions without an address target the device of the event.
+type Action struct {
+	Type      ActionType `json:"type"`
+	Address   string     `json:"address,omitempty"`
+	PresetID  int64      `json:"presetId,omitempty"`
+	Datapoint string     `json:"datapoint,omitempty"`
+	Value     string     `json:"value,omitempty"`
+	URL       string     `json:"url,omitempty"`
+}
+
+// ParseActions parses the text form of rule actions, one action per line:
+//
+//	press [device=AA:BB:CC:DD:EE:FF]
+//	preset 3 [device=AA:BB:CC:DD:EE:FF]
+//	set control_back=up [device=AA:BB:CC:DD:EE:FF]
+//	webhook https://example.com/hook
+func ParseActions(text string) ([]Action, error) {
+	var actions []Action
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		action, err := parseAction(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		actions = append(actions, action)
+	}
+
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("at least one action is required")
+	}
+
+	return actions, nil
+}
+
+func parseAction(line string) (Action, error) {
+	fields := strings.Fields(line)
+	action := Action{Type: ActionType(strings.ToLower(fields[0]))}
+
+	args := fields[1:]
+	if len(args) > 0 && action.Type != ActionWebhook {
+		if address, ok := strings.CutPrefix(args[len(args)-1], "device="); ok {
+			action.Address = strings.ToUpper(address)
+			args = args[:len(args)-1]
+		}
+	}
+
+	switch action.Type {
+	case ActionPress:
+		if len(args) != 0 {
+			return Action{}, fmt.Errorf("press takes no arguments")
+		}
+	case ActionPreset:
+		if len(args) != 1 {
+			return Action{}, fmt.Errorf("preset requires a preset id")
+		}
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return Action{}, fmt.Errorf("invalid preset id: %s", args[0])
+		}
+		action.PresetID = id
+	case ActionSet:
+		if len(args) != 1 {
+			return Action{}, fmt.Errorf("set requires datapoint=value")
+		}
+		name, value, ok := strings.Cut(args[0], "=")
+		if !ok || name == "" || value == "" {
+			return Action{}, fmt.Errorf("set requires datapoint=value")
+		}
+		if name == fingerbot.DatapointName(fingerbot.SwitchDP) {
+			return Action{}, fmt.Errorf("set cannot change the switch, use press instead")
+		}
+		action.Datapoint, action.Value = name, value
+	case ActionWebhook:
+		if len(args) != 1 {
+			return Action{}, fmt.Errorf("webhook requires a url")
+		}
+		u, err := url.Parse(args[0])
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return Action{}, fmt.Errorf("invalid webhook url: %s", args[0])
+		}
+		action.URL = args[0]
+	default:
+		return Action{}, fmt.Errorf("unknown action: %s", fields[0])
+	}
+
+	return action, nil
+}
+
+// FormatActions returns the text form of the actions, the reverse of ParseActions
+func FormatActions(actions []Action) string {
+	lines := make([]string, 0, len(actions))
+	for _, action := range actions {
+		lines = append(lines, action.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func (a Action) String() string {
+	var s string
+	switch a.Type {
+	case ActionPreset:
+		s = fmt.Sprintf("preset %d", a.PresetID)
+	case ActionSet:
+		s = fmt.Sprintf("set %s=%s", a.Datapoint, a.Value)
+	case ActionWebhook:
+		return fmt.Sprintf("webhook %s", a.URL)
+	default:
+		s = string(a.Type)
+	}
+
+	if a.Address != "" {
+		s += " device=" + a.Address
+	}
+
+	return s
+}
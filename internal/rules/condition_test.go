@@ -0,0 +1,187 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestConditionEvaluate(t *testing.T) {
+	env := map[string]any{
+		"event":                "dp",
+		"device":               "AA:BB:CC:DD:EE:FF",
+		"hour":                 7,
+		"confirmed":            false,
+		"returned":             true,
+		"dp.battery_percent":   int32(12),
+		"prev.battery_percent": int32(15),
+		"latency_ms":           int64(350),
+		"state.mode":           "click",
+		"ratio":                0.5,
+	}
+
+	tests := []struct {
+		condition string
+		want      bool
+	}{
+		// Comparisons of numbers, whatever their type in the environment
+		{`hour == 7`, true},
+		{`hour != 7`, false},
+		{`dp.battery_percent < 15`, true},
+		{`dp.battery_percent <= 12`, true},
+		{`dp.battery_percent > 12`, false},
+		{`prev.battery_percent >= 15`, true},
+		{`latency_ms > 300.5`, true},
+		{`ratio == 0.5`, true},
+		{`hour > -1`, true},
+		// Comparisons of strings
+		{`event == "dp"`, true},
+		{`event == 'dp'`, true},
+		{`event != "press"`, true},
+		{`state.mode < "long_press"`, true},
+		{`device == "aa:bb:cc:dd:ee:ff"`, false},
+		{`hour == "7"`, false},
+		// Booleans and literals
+		{`returned`, true},
+		{`confirmed`, false},
+		{`not confirmed`, true},
+		{`!confirmed`, true},
+		{`confirmed == false`, true},
+		{`TRUE`, true},
+		{`false`, false},
+		// Precedence: not binds tighter than and, and tighter than or, comparisons tightest
+		{`confirmed or returned and hour == 7`, true},
+		{`returned or confirmed and hour == 8`, true},
+		{`(returned or confirmed) and hour == 8`, false},
+		{`not confirmed and hour == 8`, false},
+		{`not (confirmed and hour == 8)`, true},
+		{`not hour == 8`, true},
+		{`!!returned`, true},
+		{`event == "dp" && dp.battery_percent < 15 && prev.battery_percent >= 15`, true},
+		{`event == "press" || hour < 8`, true},
+		{`((event == "dp"))`, true},
+		// Keywords are case insensitive
+		{`returned AND NOT confirmed`, true},
+		// Unknown identifiers are null, which is never less or greater than anything
+		{`missing`, false},
+		{`missing == null`, true},
+		{`missing != 5`, true},
+		{`missing < 5`, false},
+		{`missing >= 5`, false},
+		{`5 > missing`, false},
+	}
+	for _, test := range tests {
+		condition, err := ParseCondition(test.condition)
+		if err != nil {
+			t.Errorf("%s: error parsing: %s", test.condition, err)
+			continue
+		}
+
+		got, err := condition.Evaluate(env)
+		if err != nil {
+			t.Errorf("%s: error evaluating: %s", test.condition, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %t, want %t", test.condition, got, test.want)
+		}
+	}
+}
+
+func TestConditionEvaluateErrors(t *testing.T) {
+	env := map[string]any{"event": "dp", "hour": 7, "confirmed": true}
+
+	for _, source := range []string{
+		`event < 5`,
+		`hour > "7"`,
+		`confirmed < true`,
+		// Errors are reported even when they are nested
+		`event == "dp" and hour > "7"`,
+		`not (event < 5)`,
+	} {
+		condition, err := ParseCondition(source)
+		if err != nil {
+			t.Errorf("%s: error parsing: %s", source, err)
+			continue
+		}
+
+		if _, err := condition.Evaluate(env); err == nil {
+			t.Errorf("%s: expected an error", source)
+		}
+	}
+}
+
+func TestConditionShortCircuits(t *testing.T) {
+	env := map[string]any{"event": "dp", "hour": 7}
+
+	for _, source := range []string{
+		`event == "press" and event < 5`,
+		`event == "dp" or event < 5`,
+	} {
+		condition, err := ParseCondition(source)
+		if err != nil {
+			t.Fatalf("%s: error parsing: %s", source, err)
+		}
+
+		if _, err := condition.Evaluate(env); err != nil {
+			t.Errorf("%s: expected the right side to be skipped, got %s", source, err)
+		}
+	}
+}
+
+func TestParseConditionErrors(t *testing.T) {
+	tests := []struct {
+		condition string
+		// message is a part of the expected error
+		message string
+	}{
+		{``, "unexpected end"},
+		{`   `, "unexpected end"},
+		{`event ==`, "unexpected end"},
+		{`event == "dp" and`, "unexpected end"},
+		{`not`, "unexpected end"},
+		{`(event == "dp"`, "missing )"},
+		{`event == "dp")`, `unexpected ")"`},
+		{`()`, `unexpected ")"`},
+		{`event == "dp`, "unterminated string"},
+		{`event = "dp"`, `unexpected "="`},
+		{`event === "dp"`, `unexpected "="`},
+		{`hour == 7 == 7`, `unexpected "=="`},
+		{`event "dp"`, `unexpected "dp"`},
+		{`hour & 1`, `unexpected "&"`},
+		{`hour | 1`, `unexpected "|"`},
+		{`hour + 1`, `unexpected "+"`},
+		{`hour == 1.2.3`, "invalid number"},
+		{`and hour`, `unexpected "and"`},
+		{`hour == or`, `unexpected "or"`},
+		{`== 7`, `unexpected "=="`},
+	}
+	for _, test := range tests {
+		_, err := ParseCondition(test.condition)
+		if err == nil {
+			t.Errorf("%q: expected an error", test.condition)
+			continue
+		}
+		if !strings.Contains(err.Error(), test.message) {
+			t.Errorf("%q: got error %q, want it to contain %q", test.condition, err, test.message)
+		}
+	}
+}
+
+func TestConditionMentions(t *testing.T) {
+	condition, err := ParseCondition(`event == "connect" and (device == "aa:bb:cc:dd:ee:ff" or not name == "Kitchen")`)
+	if err != nil {
+		t.Fatalf("error parsing: %s", err)
+	}
+
+	for value, want := range map[string]bool{
+		"AA:BB:CC:DD:EE:FF": true,
+		"kitchen":           true,
+		"connect":           true,
+		"device":            false,
+		"11:22:33:44:55:66": false,
+	} {
+		if got := condition.Mentions(value); got != want {
+			t.Errorf("%s: got %t, want %t", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,482 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+const (
+	// DefaultCooldown is used for rules created without a cooldown
+	DefaultCooldown = time.Minute
+	// MinCooldown outlasts the events caused by the actions of a rule, a press with the longest hold is confirmed
+	// within about 20 seconds, so a rule cannot keep triggering itself
+	MinCooldown = 30 * time.Second
+	// WebhookTimeout bounds the time a webhook action may take
+	WebhookTimeout = 10 * time.Second
+	// RecentEventsSize is the number of events kept for dry runs
+	RecentEventsSize = 50
+)
+
+var ErrRuleNotFound = errors.New("rule not found")
+
+type Engine struct {
+	repository    *Repository
+	deviceManager *devices.Manager
+	client        *http.Client
+	logger        *slog.Logger
+
+	recent      []devices.Event
+	recentMutex sync.Mutex
+
+	// compiled are the enabled rules with their parsed conditions, nil until they are loaded and after every change
+	compiled      []compiledRule
+	compiledMutex sync.Mutex
+}
+
+type compiledRule struct {
+	rule      *Rule
+	condition *Condition
+}
+
+func NewEngine(repository *Repository, deviceManager *devices.Manager, logger *slog.Logger) *Engine {
+	e := &Engine{
+		repository:    repository,
+		deviceManager: deviceManager,
+		client:        &http.Client{Timeout: WebhookTimeout},
+		logger:        logger.With("component", "RulesEngine"),
+	}
+	deviceManager.OnForget(e.deleteDeviceRules)
+
+	return e
+}
+
+// Run evaluates the enabled rules against every device event until the context is cancelled
+func (e *Engine) Run(ctx context.Context) {
+	events, unsubscribe := e.deviceManager.Subscribe()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			e.remember(event)
+			e.handle(ctx, event)
+		}
+	}
+}
+
+func (e *Engine) GetRules(ctx context.Context) ([]*Rule, error) {
+	rules, err := e.repository.GetRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules: %w", err)
+	}
+
+	return rules, nil
+}
+
+func (e *Engine) GetRule(ctx context.Context, id int64) (*Rule, error) {
+	rule, err := e.repository.GetRule(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rule: %w", err)
+	}
+	if rule == nil {
+		return nil, ErrRuleNotFound
+	}
+
+	return rule, nil
+}
+
+func (e *Engine) CreateRule(ctx context.Context, rule *Rule) error {
+	if err := e.validate(ctx, rule); err != nil {
+		return err
+	}
+
+	if err := e.repository.CreateRule(ctx, rule); err != nil {
+		return fmt.Errorf("failed to create rule: %w", err)
+	}
+	e.invalidate()
+
+	return nil
+}
+
+func (e *Engine) UpdateRule(ctx context.Context, rule *Rule) error {
+	if _, err := e.GetRule(ctx, rule.ID); err != nil {
+		return err
+	}
+	if err := e.validate(ctx, rule); err != nil {
+		return err
+	}
+
+	if err := e.repository.UpdateRule(ctx, rule); err != nil {
+		return fmt.Errorf("failed to update rule: %w", err)
+	}
+	e.invalidate()
+
+	return nil
+}
+
+func (e *Engine) SetEnabled(ctx context.Context, id int64, enabled bool) (*Rule, error) {
+	rule, err := e.GetRule(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.Enabled = enabled
+	if err := e.repository.SetEnabled(ctx, id, enabled); err != nil {
+		return nil, fmt.Errorf("failed to update rule: %w", err)
+	}
+	e.invalidate()
+
+	return rule, nil
+}
+
+func (e *Engine) DeleteRule(ctx context.Context, id int64) error {
+	if err := e.repository.DeleteRule(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete rule: %w", err)
+	}
+	e.invalidate()
+
+	return nil
+}
+
+// deleteDeviceRules deletes the rules referencing a forgotten device, in an action or in their condition
+func (e *Engine) deleteDeviceRules(ctx context.Context, address string) error {
+	rules, err := e.repository.GetRules(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get rules: %w", err)
+	}
+	defer e.invalidate()
+
+	for _, rule := range rules {
+		if !rule.references(address) {
+			continue
+		}
+
+		if err := e.repository.DeleteRule(ctx, rule.ID); err != nil {
+			return fmt.Errorf("failed to delete rule: %w", err)
+		}
+		e.logger.Info("deleted rule of forgotten device", slog.String("rule", rule.Name), slog.String("address", address))
+	}
+
+	return nil
+}
+
+func (e *Engine) validate(ctx context.Context, rule *Rule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if _, err := ParseCondition(rule.Condition); err != nil {
+		return fmt.Errorf("invalid condition: %w", err)
+	}
+	if len(rule.Actions) == 0 {
+		return fmt.Errorf("at least one action is required")
+	}
+	if rule.Cooldown < MinCooldown {
+		return fmt.Errorf("cooldown must be at least %s", MinCooldown)
+	}
+
+	for _, action := range rule.Actions {
+		if action.Address == "" {
+			continue
+		}
+
+		device, err := e.deviceManager.GetSavedDevice(ctx, action.Address)
+		if err != nil {
+			return err
+		}
+		if device == nil {
+			return fmt.Errorf("device not found: %s", action.Address)
+		}
+	}
+
+	return nil
+}
+
+// DryRunEvent is the outcome of evaluating a condition against one event
+type DryRunEvent struct {
+	Event   devices.Event
+	Matched bool
+	Error   string
+}
+
+// DryRunResult describes what a condition would have matched, without running any actions
+type DryRunResult struct {
+	Error  string
+	Events []DryRunEvent
+}
+
+// DryRun evaluates a condition against the recently received events, newest first
+func (e *Engine) DryRun(condition string) DryRunResult {
+	compiled, err := ParseCondition(condition)
+	if err != nil {
+		return DryRunResult{Error: err.Error()}
+	}
+
+	e.recentMutex.Lock()
+	recent := make([]devices.Event, len(e.recent))
+	copy(recent, e.recent)
+	e.recentMutex.Unlock()
+
+	result := DryRunResult{}
+	for i := len(recent) - 1; i >= 0; i-- {
+		event := recent[i]
+		matched, err := compiled.Evaluate(e.environment(event))
+		outcome := DryRunEvent{Event: event, Matched: matched}
+		if err != nil {
+			outcome.Error = err.Error()
+		}
+		result.Events = append(result.Events, outcome)
+	}
+
+	return result
+}
+
+func (e *Engine) remember(event devices.Event) {
+	e.recentMutex.Lock()
+	defer e.recentMutex.Unlock()
+
+	e.recent = append(e.recent, event)
+	if len(e.recent) > RecentEventsSize {
+		e.recent = e.recent[len(e.recent)-RecentEventsSize:]
+	}
+}
+
+// compiledRules returns the enabled rules with their parsed conditions, they are loaded again after every change
+func (e *Engine) compiledRules(ctx context.Context) ([]compiledRule, error) {
+	e.compiledMutex.Lock()
+	defer e.compiledMutex.Unlock()
+
+	if e.compiled != nil {
+		return e.compiled, nil
+	}
+
+	rules, err := e.repository.GetRules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rules: %w", err)
+	}
+
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+
+		condition, err := ParseCondition(rule.Condition)
+		if err != nil {
+			e.logger.Error("invalid rule condition", slog.Int64("rule_id", rule.ID), logging.ErrAttr(err))
+			continue
+		}
+		compiled = append(compiled, compiledRule{rule: rule, condition: condition})
+	}
+	e.compiled = compiled
+
+	return compiled, nil
+}
+
+// invalidate drops the compiled rules after a rule was changed
+func (e *Engine) invalidate() {
+	e.compiledMutex.Lock()
+	defer e.compiledMutex.Unlock()
+
+	e.compiled = nil
+}
+
+func (e *Engine) handle(ctx context.Context, event devices.Event) {
+	compiled, err := e.compiledRules(ctx)
+	if err != nil {
+		e.logger.Error("failed to get rules", logging.ErrAttr(err))
+		return
+	}
+
+	env := e.environment(event)
+	for _, c := range compiled {
+		rule := c.rule
+		// Rules saved before the minimum cooldown was enforced get it as well
+		if rule.LastFiredAt.Valid && event.Time.Sub(rule.LastFiredAt.Time) < max(rule.Cooldown, MinCooldown) {
+			continue
+		}
+
+		logger := e.logger.With(slog.Int64("rule_id", rule.ID))
+		matched, err := c.condition.Evaluate(env)
+		if err != nil {
+			logger.Warn("failed to evaluate rule", logging.ErrAttr(err))
+			continue
+		}
+		if !matched {
+			continue
+		}
+
+		// Record the firing before the actions run so events caused by the actions fall into the cooldown
+		if err := e.repository.SetLastFired(ctx, rule.ID, event.Time, ""); err != nil {
+			logger.Error("failed to record rule firing", logging.ErrAttr(err))
+			continue
+		}
+		rule.LastFiredAt = sql.NullTime{Time: event.Time, Valid: true}
+
+		go e.execute(ctx, rule, event)
+	}
+}
+
+func (e *Engine) execute(ctx context.Context, rule *Rule, event devices.Event) {
+	logger := e.logger.With(slog.Int64("rule_id", rule.ID), slog.String("address", event.Address))
+	logger.Info("running rule", slog.String("event", string(event.Type)))
+
+	ctx = history.WithSource(ctx, history.Source{Kind: history.SourceRule, Name: rule.Name})
+
+	var errs []string
+	for _, action := range rule.Actions {
+		if err := e.runAction(ctx, rule, action, event); err != nil {
+			logger.Error("rule action failed", slog.String("action", action.String()), logging.ErrAttr(err))
+			errs = append(errs, fmt.Sprintf("%s: %s", action, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return
+	}
+	if err := e.repository.SetLastFired(ctx, rule.ID, event.Time, strings.Join(errs, "; ")); err != nil {
+		logger.Error("failed to record rule error", logging.ErrAttr(err))
+	}
+}
+
+func (e *Engine) runAction(ctx context.Context, rule *Rule, action Action, event devices.Event) error {
+	address := action.Address
+	if address == "" {
+		address = event.Address
+	}
+
+	switch action.Type {
+	case ActionPress:
+		if _, err := e.deviceManager.Toggle(ctx, address); err != nil {
+			return err
+		}
+	case ActionPreset:
+		if _, err := e.deviceManager.PressWithPreset(ctx, address, action.PresetID); err != nil {
+			return err
+		}
+	case ActionSet:
+		return e.deviceManager.SetDatapoint(ctx, address, action.Datapoint, action.Value)
+	case ActionWebhook:
+		return e.callWebhook(ctx, rule, action.URL, event)
+	default:
+		return fmt.Errorf("invalid action: %s", action.Type)
+	}
+
+	return nil
+}
+
+type webhookPayload struct {
+	Rule    string         `json:"rule"`
+	RuleID  int64          `json:"ruleId"`
+	Event   string         `json:"event"`
+	Address string         `json:"address"`
+	Name    string         `json:"name"`
+	Time    time.Time      `json:"time"`
+	Values  map[string]any `json:"values"`
+}
+
+func (e *Engine) callWebhook(ctx context.Context, rule *Rule, url string, event devices.Event) error {
+	body, err := json.Marshal(webhookPayload{
+		Rule:    rule.Name,
+		RuleID:  rule.ID,
+		Event:   string(event.Type),
+		Address: event.Address,
+		Name:    event.Name,
+		Time:    event.Time,
+		Values:  e.environment(event),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// environment returns the values a condition can refer to for an event:
+//
+//	event, device, name, hour, weekday   always set
+//	source                                who initiated connect, disconnect and press events
+//	datapoint, dp.<name>, prev.<name>     the reported and previous value of dp events
+//	state.<name>                          the current datapoints of the device if it is connected
+//	confirmed, latency_ms, returned       the result of press events
+//	alert, message                        the alert of alert events, e.g. battery_low or charge_complete
+//	error                                 the error of failed press events
+func (e *Engine) environment(event devices.Event) map[string]any {
+	local := event.Time.Local()
+	env := map[string]any{
+		"event":   string(event.Type),
+		"device":  event.Address,
+		"name":    event.Name,
+		"hour":    local.Hour(),
+		"weekday": strings.ToLower(local.Weekday().String()[:3]),
+	}
+	if event.Type != devices.EventDatapoint && event.Type != devices.EventAlert {
+		env["source"] = string(event.Source.Kind)
+	}
+
+	if device := e.deviceManager.GetFingerbot(event.Address); device != nil {
+		for id, name := range fingerbot.DatapointNames {
+			if dp, ok := device.GetDatapoint(id); ok {
+				env["state."+name] = fingerbot.DatapointValue(dp)
+			}
+		}
+	}
+
+	if event.Datapoint != nil {
+		name := fingerbot.DatapointName(event.Datapoint.ID)
+		env["datapoint"] = name
+		env["dp."+name] = fingerbot.DatapointValue(*event.Datapoint)
+		if event.Previous != nil {
+			env["prev."+name] = fingerbot.DatapointValue(*event.Previous)
+		}
+	}
+
+	if event.Press != nil {
+		env["confirmed"] = event.Press.Confirmed
+		env["latency_ms"] = event.Press.Latency.Milliseconds()
+		env["returned"] = event.Press.Returned
+	}
+	if event.Alert != "" {
+		env["alert"] = event.Alert
+		env["message"] = event.Message
+	}
+	if event.Error != "" {
+		env["error"] = event.Error
+	}
+
+	return env
+}
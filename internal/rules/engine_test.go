@@ -0,0 +1,135 @@
+package rules
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/secrets"
+)
+
+const testAddress = "AA:BB:CC:DD:EE:FF"
+
+// newTestEngine returns an engine with one saved device, the URL of a webhook and a channel receiving its calls
+func newTestEngine(t *testing.T) (*Engine, string, <-chan struct{}) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("error opening database: %s", err)
+	}
+	// Every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	cipher, err := secrets.NewCipher(make([]byte, secrets.KeySize))
+	if err != nil {
+		t.Fatalf("error creating cipher: %s", err)
+	}
+
+	repository := devices.NewRepository(db, cipher)
+	if err := repository.CreateDevice(context.Background(), &devices.Device{
+		Address: testAddress, DeviceID: "device", Name: "Device", LocalKey: "key", UUID: "uuid",
+	}); err != nil {
+		t.Fatalf("error creating device: %s", err)
+	}
+	manager := devices.NewManager(repository, history.NewRepository(db), nil, slog.Default())
+
+	calls := make(chan struct{}, 16)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls <- struct{}{}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(webhook.Close)
+
+	return NewEngine(NewRepository(db), manager, slog.Default()), webhook.URL, calls
+}
+
+func newEvent(eventType devices.EventType, at time.Time) devices.Event {
+	return devices.Event{Type: eventType, Address: testAddress, Time: at}
+}
+
+// assertFired checks whether the rule fired for the event
+func assertFired(t *testing.T, e *Engine, calls <-chan struct{}, event devices.Event, want bool) {
+	t.Helper()
+
+	e.handle(context.Background(), event)
+
+	select {
+	case <-calls:
+		if !want {
+			t.Fatalf("%s event at %s: rule fired", event.Type, event.Time)
+		}
+	case <-time.After(200 * time.Millisecond):
+		if want {
+			t.Fatalf("%s event at %s: rule did not fire", event.Type, event.Time)
+		}
+	}
+}
+
+func TestCreateRuleRequiresMinimumCooldown(t *testing.T) {
+	e, url, _ := newTestEngine(t)
+
+	for _, cooldown := range []time.Duration{-time.Second, 0, MinCooldown - time.Second} {
+		rule := &Rule{
+			Name: "rule", Condition: `event == "press"`, Enabled: true, Cooldown: cooldown,
+			Actions: []Action{{Type: ActionWebhook, URL: url}},
+		}
+		if err := e.CreateRule(context.Background(), rule); err == nil {
+			t.Errorf("cooldown %s: expected an error", cooldown)
+		}
+	}
+}
+
+func TestHandleUsesChangedRules(t *testing.T) {
+	ctx := context.Background()
+	e, url, calls := newTestEngine(t)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	rule := &Rule{
+		Name: "rule", Condition: `event == "connect"`, Enabled: true, Cooldown: MinCooldown,
+		Actions: []Action{{Type: ActionWebhook, URL: url}},
+	}
+	if err := e.CreateRule(ctx, rule); err != nil {
+		t.Fatalf("error creating rule: %s", err)
+	}
+	assertFired(t, e, calls, newEvent(devices.EventConnected, start), true)
+
+	rule.Condition = `event == "disconnect"`
+	if err := e.UpdateRule(ctx, rule); err != nil {
+		t.Fatalf("error updating rule: %s", err)
+	}
+	assertFired(t, e, calls, newEvent(devices.EventConnected, start.Add(time.Hour)), false)
+	assertFired(t, e, calls, newEvent(devices.EventDisconnected, start.Add(time.Hour)), true)
+
+	if _, err := e.SetEnabled(ctx, rule.ID, false); err != nil {
+		t.Fatalf("error disabling rule: %s", err)
+	}
+	assertFired(t, e, calls, newEvent(devices.EventDisconnected, start.Add(2*time.Hour)), false)
+}
+
+func TestHandleEnforcesMinimumCooldownOnSavedRules(t *testing.T) {
+	ctx := context.Background()
+	e, url, calls := newTestEngine(t)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// A rule saved before the minimum cooldown was enforced
+	if err := e.repository.CreateRule(ctx, &Rule{
+		Name: "rule", Condition: `event == "press"`, Enabled: true,
+		Actions: []Action{{Type: ActionWebhook, URL: url}},
+	}); err != nil {
+		t.Fatalf("error creating rule: %s", err)
+	}
+
+	assertFired(t, e, calls, newEvent(devices.EventPress, start), true)
+	assertFired(t, e, calls, newEvent(devices.EventPress, start.Add(time.Second)), false)
+	assertFired(t, e, calls, newEvent(devices.EventPress, start.Add(MinCooldown)), true)
+}
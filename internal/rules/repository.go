@@ -0,0 +1,202 @@
+package rules
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+type Rule struct {
+	ID        int64    `sql:"id"`
+	Name      string   `sql:"name"`
+	Condition string   `sql:"condition"`
+	Actions   []Action `sql:"actions"`
+	Enabled   bool     `sql:"enabled"`
+	// Cooldown is the minimum time between two firings of the rule, at least MinCooldown so the events caused by
+	// its actions cannot trigger it again
+	Cooldown    time.Duration `sql:"cooldown_seconds"`
+	LastFiredAt sql.NullTime  `sql:"last_fired_at"`
+	LastError   string        `sql:"last_error"`
+}
+
+func (r *Rule) ActionsText() string {
+	return FormatActions(r.Actions)
+}
+
+// references reports whether an action of the rule targets the device or its condition mentions it
+func (r *Rule) references(address string) bool {
+	for _, action := range r.Actions {
+		if strings.EqualFold(action.Address, address) {
+			return true
+		}
+	}
+
+	condition, err := ParseCondition(r.Condition)
+	return err == nil && condition.Mentions(address)
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	repo := &Repository{db: db}
+	if err := repo.init(); err != nil {
+		panic(err)
+	}
+
+	return repo
+}
+
+func (r *Repository) init() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			condition TEXT NOT NULL,
+			actions TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			cooldown_seconds INTEGER NOT NULL,
+			last_fired_at TIMESTAMP,
+			last_error TEXT NOT NULL DEFAULT ''
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating rules table: %w", err)
+	}
+
+	return nil
+}
+
+const ruleColumns = `id, name, condition, actions, enabled, cooldown_seconds, last_fired_at, last_error`
+
+func (r *Repository) CreateRule(ctx context.Context, rule *Rule) error {
+	actions, err := json.Marshal(rule.Actions)
+	if err != nil {
+		return fmt.Errorf("error encoding rule actions: %w", err)
+	}
+
+	result, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO rules (name, condition, actions, enabled, cooldown_seconds) VALUES ($1, $2, $3, $4, $5)`,
+		rule.Name, rule.Condition, string(actions), rule.Enabled, int64(rule.Cooldown.Seconds()),
+	)
+	if err != nil {
+		return fmt.Errorf("error creating rule: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting rule id: %w", err)
+	}
+	rule.ID = id
+
+	return nil
+}
+
+func (r *Repository) UpdateRule(ctx context.Context, rule *Rule) error {
+	actions, err := json.Marshal(rule.Actions)
+	if err != nil {
+		return fmt.Errorf("error encoding rule actions: %w", err)
+	}
+
+	if _, err := r.db.ExecContext(
+		ctx,
+		`UPDATE rules SET name = $1, condition = $2, actions = $3, enabled = $4, cooldown_seconds = $5 WHERE id = $6`,
+		rule.Name, rule.Condition, string(actions), rule.Enabled, int64(rule.Cooldown.Seconds()), rule.ID,
+	); err != nil {
+		return fmt.Errorf("error updating rule: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) SetEnabled(ctx context.Context, id int64, enabled bool) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE rules SET enabled = $1 WHERE id = $2", enabled, id,
+	); err != nil {
+		return fmt.Errorf("error updating rule: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) SetLastFired(ctx context.Context, id int64, firedAt time.Time, lastError string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE rules SET last_fired_at = $1, last_error = $2 WHERE id = $3", firedAt, lastError, id,
+	); err != nil {
+		return fmt.Errorf("error updating rule last fired: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteRule(ctx context.Context, id int64) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM rules WHERE id = $1", id,
+	); err != nil {
+		return fmt.Errorf("error deleting rule: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetRule(ctx context.Context, id int64) (*Rule, error) {
+	rule, err := scanRule(r.db.QueryRowContext(
+		ctx, "SELECT "+ruleColumns+" FROM rules WHERE id = $1", id,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting rule by id: %w", err)
+	}
+
+	return rule, nil
+}
+
+func (r *Repository) GetRules(ctx context.Context) ([]*Rule, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+ruleColumns+" FROM rules ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error getting rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rules []*Rule
+	for rows.Next() {
+		rule, err := scanRule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning rule: %w", err)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRule(row scanner) (*Rule, error) {
+	var (
+		rule     Rule
+		actions  string
+		cooldown int64
+	)
+	if err := row.Scan(
+		&rule.ID, &rule.Name, &rule.Condition, &actions, &rule.Enabled, &cooldown, &rule.LastFiredAt, &rule.LastError,
+	); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(actions), &rule.Actions); err != nil {
+		return nil, fmt.Errorf("error decoding rule actions: %w", err)
+	}
+	rule.Cooldown = time.Duration(cooldown) * time.Second
+
+	return &rule, nil
+}
@@ -0,0 +1,219 @@
+package scheduler
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxCronLookahead bounds the search for the next run of expressions which never match (e.g. 30 February)
+const maxCronLookahead = 5 * 366 * 24 * time.Hour
+
+const (
+	// everyHour, everyDayOfMonth and everyDayOfWeek are the bit sets of fields matching every value
+	everyHour       uint64 = 1<<24 - 1
+	everyDayOfMonth uint64 = 1<<32 - 2
+	everyDayOfWeek  uint64 = 1<<7 - 1
+)
+
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}
+	weekdayNames = map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+)
+
+// cronTrigger fires at the times matching a standard five field cron expression
+// (minute, hour, day of month, month, day of week). Times skipped when the clocks are set forward do not run, times
+// repeated when they are set back run once unless the hour field matches every hour.
+type cronTrigger struct {
+	minute, hour, dayOfMonth, month, dayOfWeek uint64
+	dayOfMonthAny, dayOfWeekAny                bool
+	location                                   *time.Location
+}
+
+func parseCron(expression string, location *time.Location) (*cronTrigger, error) {
+	if alias, ok := cronAliases[strings.ToLower(expression)]; ok {
+		expression = alias
+	}
+
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("expected 5 fields in cron expression, got %d", len(fields))
+	}
+
+	trigger := &cronTrigger{location: location}
+
+	var err error
+	if trigger.minute, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute field: %w", err)
+	}
+	if trigger.hour, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour field: %w", err)
+	}
+	if trigger.dayOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month field: %w", err)
+	}
+	if trigger.month, err = parseCronField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid month field: %w", err)
+	}
+	if trigger.dayOfWeek, err = parseCronField(fields[4], 0, 7, weekdayNames); err != nil {
+		return nil, fmt.Errorf("invalid day of week field: %w", err)
+	}
+	// Both 0 and 7 mean Sunday
+	if trigger.dayOfWeek&(1<<7) != 0 {
+		trigger.dayOfWeek |= 1
+	}
+	// A day field is unrestricted when it matches every day, however it is written (*, */1, 1-31, ...)
+	trigger.dayOfMonthAny = trigger.dayOfMonth&everyDayOfMonth == everyDayOfMonth
+	trigger.dayOfWeekAny = trigger.dayOfWeek&everyDayOfWeek == everyDayOfWeek
+
+	return trigger, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps into a bit set
+func parseCronField(field string, min, max int, names map[string]int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step: %s", stepPart)
+			}
+		}
+
+		var start, end int
+		switch {
+		case rangePart == "*":
+			start, end = min, max
+		case strings.Contains(rangePart, "-"):
+			startPart, endPart, _ := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = parseCronValue(startPart, names); err != nil {
+				return 0, err
+			}
+			if end, err = parseCronValue(endPart, names); err != nil {
+				return 0, err
+			}
+		default:
+			var err error
+			if start, err = parseCronValue(rangePart, names); err != nil {
+				return 0, err
+			}
+			end = start
+			if hasStep {
+				end = max
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return 0, fmt.Errorf("value out of range %d-%d: %s", min, max, part)
+		}
+
+		for value := start; value <= end; value += step {
+			bits |= 1 << value
+		}
+	}
+
+	return bits, nil
+}
+
+func parseCronValue(value string, names map[string]int) (int, error) {
+	if number, ok := names[strings.ToLower(value)]; ok {
+		return number, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value: %s", value)
+	}
+
+	return number, nil
+}
+
+// Next returns the first time after the given time matching the expression
+func (t *cronTrigger) Next(after time.Time) (time.Time, bool) {
+	next := after.In(t.location).Truncate(time.Minute).Add(time.Minute)
+	limit := next.Add(maxCronLookahead)
+
+	for next.Before(limit) {
+		if t.month&(1<<uint(next.Month())) == 0 {
+			next = advance(next, time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, t.location))
+			continue
+		}
+		if !t.matchesDay(next) {
+			next = advance(next, time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, t.location))
+			continue
+		}
+		// Hours and minutes are stepped in elapsed time, so every hour is visited once however the clocks change
+		if t.hour&(1<<uint(next.Hour())) == 0 {
+			next = next.Add(time.Duration(60-next.Minute()) * time.Minute)
+			continue
+		}
+		if t.minute&(1<<uint(next.Minute())) == 0 || t.repeated(next) {
+			next = next.Add(time.Minute)
+			continue
+		}
+
+		return next, true
+	}
+
+	return time.Time{}, false
+}
+
+// advance returns the start of the month or day to skip to, time.Date moves a midnight that does not exist because
+// of a DST transition either forward or back, a step back is replaced by a step of a minute so the search always
+// moves on
+func advance(current, target time.Time) time.Time {
+	if target.After(current) {
+		return target
+	}
+
+	return current.Add(time.Minute)
+}
+
+// repeated reports whether the wall clock time already occurred an hour (or the DST shift) earlier because the
+// clocks were set back, which only counts for expressions with fixed hours
+func (t *cronTrigger) repeated(next time.Time) bool {
+	if t.hour == everyHour {
+		return false
+	}
+
+	_, offset := next.Zone()
+	_, previousOffset := next.Add(-24 * time.Hour).Zone()
+	if previousOffset <= offset {
+		return false
+	}
+
+	_, earlierOffset := next.Add(-time.Duration(previousOffset-offset) * time.Second).Zone()
+	return earlierOffset == previousOffset
+}
+
+// matchesDay follows the cron convention of matching either day field when both are restricted
+func (t *cronTrigger) matchesDay(day time.Time) bool {
+	dayOfMonth := t.dayOfMonth&(1<<uint(day.Day())) != 0
+	dayOfWeek := t.dayOfWeek&(1<<uint(day.Weekday())) != 0
+
+	if t.dayOfMonthAny || t.dayOfWeekAny {
+		return dayOfMonth && dayOfWeek
+	}
+
+	return dayOfMonth || dayOfWeek
+}
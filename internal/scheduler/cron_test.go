@@ -0,0 +1,195 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoadLocation(t *testing.T, name string) *time.Location {
+	t.Helper()
+
+	location, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("error loading location %s: %s", name, err)
+	}
+
+	return location
+}
+
+func mustParseTime(t *testing.T, value string) time.Time {
+	t.Helper()
+
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		t.Fatalf("error parsing time %s: %s", value, err)
+	}
+
+	return parsed
+}
+
+// assertRuns checks the runs of the trigger following after, in order
+func assertRuns(t *testing.T, trigger Trigger, after string, want ...string) {
+	t.Helper()
+
+	next := mustParseTime(t, after)
+	for i, expected := range want {
+		var ok bool
+		if next, ok = trigger.Next(next); !ok {
+			t.Fatalf("run %d: no run found, want %s", i+1, expected)
+		}
+		if !next.Equal(mustParseTime(t, expected)) {
+			t.Fatalf("run %d: got %s, want %s", i+1, next.Format(time.RFC3339), expected)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, expression := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"1-x * * * *",
+		"* * * foo *",
+		"@never",
+	} {
+		if _, err := parseCron(expression, time.UTC); err == nil {
+			t.Errorf("%q: expected an error", expression)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		after      string
+		want       []string
+	}{
+		{
+			name: "step", expression: "*/15 * * * *", after: "2024-05-01T10:07:30Z",
+			want: []string{"2024-05-01T10:15:00Z", "2024-05-01T10:30:00Z", "2024-05-01T10:45:00Z", "2024-05-01T11:00:00Z"},
+		},
+		{
+			name: "range with step", expression: "0 9-17/4 * * *", after: "2024-05-01T09:00:00Z",
+			want: []string{"2024-05-01T13:00:00Z", "2024-05-01T17:00:00Z", "2024-05-02T09:00:00Z"},
+		},
+		{
+			name: "value with step runs to the end of the range", expression: "50/5 10 * * *", after: "2024-05-01T00:00:00Z",
+			want: []string{"2024-05-01T10:50:00Z", "2024-05-01T10:55:00Z", "2024-05-02T10:50:00Z"},
+		},
+		{
+			name: "list and names", expression: "0 0 1 jan,JUL *", after: "2024-01-01T00:00:00Z",
+			want: []string{"2024-07-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		},
+		{
+			name: "alias", expression: "@weekly", after: "2024-05-01T00:00:00Z",
+			want: []string{"2024-05-05T00:00:00Z", "2024-05-12T00:00:00Z"},
+		},
+		{
+			name: "sunday as 7", expression: "0 12 * * 7", after: "2024-05-01T00:00:00Z",
+			want: []string{"2024-05-05T12:00:00Z"},
+		},
+		{
+			name: "day of month or day of week", expression: "0 0 13 * fri", after: "2024-09-01T00:00:00Z",
+			want: []string{
+				"2024-09-06T00:00:00Z", "2024-09-13T00:00:00Z", "2024-09-20T00:00:00Z", "2024-09-27T00:00:00Z",
+				"2024-10-04T00:00:00Z", "2024-10-11T00:00:00Z", "2024-10-13T00:00:00Z", "2024-10-18T00:00:00Z",
+			},
+		},
+		{
+			name: "every day of month written as a step", expression: "0 0 */1 * mon", after: "2024-01-27T00:00:00Z",
+			want: []string{"2024-01-29T00:00:00Z", "2024-02-05T00:00:00Z"},
+		},
+		{
+			name: "every day of month written as a range", expression: "0 0 1-31 * 1", after: "2024-01-27T00:00:00Z",
+			want: []string{"2024-01-29T00:00:00Z", "2024-02-05T00:00:00Z"},
+		},
+		{
+			name: "every day of week written as a range", expression: "0 0 1 * 0-6", after: "2024-01-27T00:00:00Z",
+			want: []string{"2024-02-01T00:00:00Z", "2024-03-01T00:00:00Z"},
+		},
+		{
+			name: "leap day", expression: "0 0 29 2 *", after: "2024-03-01T00:00:00Z",
+			want: []string{"2028-02-29T00:00:00Z"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trigger, err := parseCron(test.expression, time.UTC)
+			if err != nil {
+				t.Fatalf("error parsing %q: %s", test.expression, err)
+			}
+
+			assertRuns(t, trigger, test.after, test.want...)
+		})
+	}
+}
+
+func TestCronNextNeverMatches(t *testing.T) {
+	trigger, err := parseCron("0 0 30 2 *", time.UTC)
+	if err != nil {
+		t.Fatalf("error parsing: %s", err)
+	}
+
+	if next, ok := trigger.Next(mustParseTime(t, "2024-01-01T00:00:00Z")); ok {
+		t.Fatalf("expected no run, got %s", next)
+	}
+}
+
+func TestCronNextDST(t *testing.T) {
+	tests := []struct {
+		name       string
+		expression string
+		location   string
+		after      string
+		want       []string
+	}{
+		{
+			name: "skipped time does not run", expression: "30 2 * * *", location: "Europe/Berlin",
+			after: "2024-03-30T03:00:00+01:00",
+			want:  []string{"2024-04-01T02:30:00+02:00"},
+		},
+		{
+			name: "skipped time does not run when midnight is moved back", expression: "30 2 * * *",
+			location: "America/New_York", after: "2024-03-09T12:00:00-05:00",
+			want: []string{"2024-03-11T02:30:00-04:00"},
+		},
+		{
+			name: "steps across the skipped hour", expression: "*/30 * * * *", location: "America/New_York",
+			after: "2024-03-10T01:00:00-05:00",
+			want:  []string{"2024-03-10T01:30:00-05:00", "2024-03-10T03:00:00-04:00", "2024-03-10T03:30:00-04:00"},
+		},
+		{
+			name: "repeated time runs once", expression: "30 2 * * *", location: "Europe/Berlin",
+			after: "2024-10-26T12:00:00+02:00",
+			want:  []string{"2024-10-27T02:30:00+02:00", "2024-10-28T02:30:00+01:00"},
+		},
+		{
+			name: "repeated time runs once when started within it", expression: "30 2 * * *", location: "Europe/Berlin",
+			after: "2024-10-27T02:10:00+02:00",
+			want:  []string{"2024-10-27T02:30:00+02:00", "2024-10-28T02:30:00+01:00"},
+		},
+		{
+			name: "repeated hour runs twice for every hour", expression: "0 * * * *", location: "Europe/Berlin",
+			after: "2024-10-27T01:30:00+02:00",
+			want:  []string{"2024-10-27T02:00:00+02:00", "2024-10-27T02:00:00+01:00", "2024-10-27T03:00:00+01:00"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			trigger, err := parseCron(test.expression, mustLoadLocation(t, test.location))
+			if err != nil {
+				t.Fatalf("error parsing %q: %s", test.expression, err)
+			}
+
+			assertRuns(t, trigger, test.after, test.want...)
+		})
+	}
+}
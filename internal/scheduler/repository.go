@@ -0,0 +1,228 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type ActionType string
+
+const (
+	ActionPress  ActionType = "press"
+	ActionPreset ActionType = "preset"
+	ActionMacro  ActionType = "macro"
+)
+
+type MissedRunPolicy string
+
+const (
+	// MissedRunSkip drops runs which were due while the app was not running
+	MissedRunSkip MissedRunPolicy = "skip"
+	// MissedRunOnce runs once on startup if at least one run was missed
+	MissedRunOnce MissedRunPolicy = "run_once"
+)
+
+func (p MissedRunPolicy) Valid() bool {
+	return p == MissedRunSkip || p == MissedRunOnce
+}
+
+type Schedule struct {
+	ID         int64           `sql:"id"`
+	Name       string          `sql:"name"`
+	Address    string          `sql:"address"`
+	Expression string          `sql:"expression"`
+	Timezone   string          `sql:"timezone"`
+	Enabled    bool            `sql:"enabled"`
+	MissedRun  MissedRunPolicy `sql:"missed_run"`
+	Action     ActionType      `sql:"action"`
+	PresetID   int64           `sql:"preset_id"`
+	MacroID    int64           `sql:"macro_id"`
+	// Checkpoint is the time up to which runs have been handled
+	Checkpoint time.Time    `sql:"checkpoint"`
+	LastRunAt  sql.NullTime `sql:"last_run_at"`
+	LastError  string       `sql:"last_error"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	repo := &Repository{db: db}
+	if err := repo.init(); err != nil {
+		panic(err)
+	}
+
+	return repo
+}
+
+func (r *Repository) init() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS schedules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			address TEXT NOT NULL,
+			expression TEXT NOT NULL,
+			timezone TEXT NOT NULL,
+			enabled BOOLEAN NOT NULL,
+			missed_run TEXT NOT NULL,
+			action TEXT NOT NULL,
+			preset_id INTEGER NOT NULL DEFAULT 0,
+			macro_id INTEGER NOT NULL DEFAULT 0,
+			checkpoint TIMESTAMP NOT NULL,
+			last_run_at TIMESTAMP,
+			last_error TEXT NOT NULL DEFAULT ''
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating schedules table: %w", err)
+	}
+
+	return nil
+}
+
+const scheduleColumns = `id, name, address, expression, timezone, enabled, missed_run, action, preset_id, macro_id, checkpoint, last_run_at, last_error`
+
+func (r *Repository) CreateSchedule(ctx context.Context, s *Schedule) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		`INSERT INTO schedules (name, address, expression, timezone, enabled, missed_run, action, preset_id, macro_id, checkpoint)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		s.Name, s.Address, s.Expression, s.Timezone, s.Enabled, s.MissedRun, s.Action, s.PresetID, s.MacroID, s.Checkpoint,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating schedule: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting schedule id: %w", err)
+	}
+	s.ID = id
+
+	return nil
+}
+
+func (r *Repository) SetEnabled(ctx context.Context, id int64, enabled bool, checkpoint time.Time) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE schedules SET enabled = $1, checkpoint = $2 WHERE id = $3", enabled, checkpoint, id,
+	); err != nil {
+		return fmt.Errorf("error updating schedule: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) SetCheckpoint(ctx context.Context, id int64, checkpoint time.Time) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE schedules SET checkpoint = $1 WHERE id = $2", checkpoint, id,
+	); err != nil {
+		return fmt.Errorf("error updating schedule checkpoint: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) SetLastRun(ctx context.Context, id int64, lastRunAt time.Time, lastError string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "UPDATE schedules SET last_run_at = $1, last_error = $2 WHERE id = $3", lastRunAt, lastError, id,
+	); err != nil {
+		return fmt.Errorf("error updating schedule last run: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) DeleteSchedule(ctx context.Context, id int64) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM schedules WHERE id = $1", id,
+	); err != nil {
+		return fmt.Errorf("error deleting schedule: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteDeviceSchedules deletes every schedule of the device
+func (r *Repository) DeleteDeviceSchedules(ctx context.Context, address string) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM schedules WHERE address = $1", address,
+	); err != nil {
+		return fmt.Errorf("error deleting device schedules: %w", err)
+	}
+
+	return nil
+}
+
+// DeletePresetSchedules deletes every schedule pressing with the preset
+func (r *Repository) DeletePresetSchedules(ctx context.Context, presetID int64) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM schedules WHERE action = $1 AND preset_id = $2", ActionPreset, presetID,
+	); err != nil {
+		return fmt.Errorf("error deleting preset schedules: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteMacroSchedules deletes every schedule running the macro
+func (r *Repository) DeleteMacroSchedules(ctx context.Context, macroID int64) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM schedules WHERE action = $1 AND macro_id = $2", ActionMacro, macroID,
+	); err != nil {
+		return fmt.Errorf("error deleting macro schedules: %w", err)
+	}
+
+	return nil
+}
+
+func (r *Repository) GetSchedule(ctx context.Context, id int64) (*Schedule, error) {
+	s, err := scanSchedule(r.db.QueryRowContext(
+		ctx, "SELECT "+scheduleColumns+" FROM schedules WHERE id = $1", id,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting schedule by id: %w", err)
+	}
+
+	return s, nil
+}
+
+func (r *Repository) GetSchedules(ctx context.Context) ([]*Schedule, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT "+scheduleColumns+" FROM schedules ORDER BY name")
+	if err != nil {
+		return nil, fmt.Errorf("error getting schedules: %w", err)
+	}
+	defer rows.Close()
+
+	var schedules []*Schedule
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning schedule: %w", err)
+		}
+
+		schedules = append(schedules, s)
+	}
+
+	return schedules, nil
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSchedule(row scanner) (*Schedule, error) {
+	var s Schedule
+	if err := row.Scan(
+		&s.ID, &s.Name, &s.Address, &s.Expression, &s.Timezone, &s.Enabled, &s.MissedRun,
+		&s.Action, &s.PresetID, &s.MacroID, &s.Checkpoint, &s.LastRunAt, &s.LastError,
+	); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
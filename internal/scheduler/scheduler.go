@@ -0,0 +1,312 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+	// Load timezone data for schedules on systems without a zoneinfo database
+	_ "time/tzdata"
+
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/logging"
+)
+
+const (
+	// TickInterval is how often schedules are checked for due runs
+	TickInterval = 15 * time.Second
+	// MissedRunThreshold is how late a run can be before it is handled by the missed run policy
+	MissedRunThreshold = 2 * time.Minute
+	// maxCatchUpRuns bounds the search for the latest due run after a long downtime
+	maxCatchUpRuns = 100000
+)
+
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Trigger calculates the run times of a schedule
+type Trigger interface {
+	Next(after time.Time) (time.Time, bool)
+}
+
+type Scheduler struct {
+	repository    *Repository
+	deviceManager *devices.Manager
+	latitude      float64
+	longitude     float64
+	logger        *slog.Logger
+}
+
+func NewScheduler(repository *Repository, deviceManager *devices.Manager, latitude, longitude float64, logger *slog.Logger) *Scheduler {
+	s := &Scheduler{
+		repository:    repository,
+		deviceManager: deviceManager,
+		latitude:      latitude,
+		longitude:     longitude,
+		logger:        logger.With("component", "Scheduler"),
+	}
+	deviceManager.OnForget(s.deleteDeviceSchedules)
+	deviceManager.OnDeletePreset(s.deletePresetSchedules)
+	deviceManager.OnDeleteMacro(s.deleteMacroSchedules)
+
+	return s
+}
+
+// Run checks for due schedules until the context is cancelled
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(TickInterval)
+	defer ticker.Stop()
+
+	for {
+		s.tick(ctx, time.Now())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ParseTrigger parses a cron expression or a sunrise/sunset expression in the given timezone
+func (s *Scheduler) ParseTrigger(expression, timezone string) (Trigger, error) {
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid timezone: %w", err)
+	}
+
+	expression = strings.TrimSpace(expression)
+	lower := strings.ToLower(expression)
+	if strings.HasPrefix(lower, string(sunrise)) || strings.HasPrefix(lower, string(sunset)) {
+		if s.latitude == 0 && s.longitude == 0 {
+			return nil, fmt.Errorf("sunrise and sunset schedules require SCHEDULER_LATITUDE and SCHEDULER_LONGITUDE")
+		}
+		return parseSun(expression, s.latitude, s.longitude, location)
+	}
+
+	return parseCron(expression, location)
+}
+
+// NextRun returns the next time the schedule will run
+func (s *Scheduler) NextRun(schedule *Schedule) (time.Time, bool) {
+	if !schedule.Enabled {
+		return time.Time{}, false
+	}
+
+	trigger, err := s.ParseTrigger(schedule.Expression, schedule.Timezone)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return trigger.Next(time.Now())
+}
+
+func (s *Scheduler) GetSchedules(ctx context.Context) ([]*Schedule, error) {
+	schedules, err := s.repository.GetSchedules(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedules: %w", err)
+	}
+
+	return schedules, nil
+}
+
+func (s *Scheduler) CreateSchedule(ctx context.Context, schedule *Schedule) error {
+	if schedule.Name == "" {
+		return fmt.Errorf("schedule name is required")
+	}
+	if _, err := s.ParseTrigger(schedule.Expression, schedule.Timezone); err != nil {
+		return err
+	}
+	if !schedule.MissedRun.Valid() {
+		return fmt.Errorf("invalid missed run policy: %s", schedule.MissedRun)
+	}
+
+	device, err := s.deviceManager.GetSavedDevice(ctx, schedule.Address)
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return fmt.Errorf("device not found: %s", schedule.Address)
+	}
+
+	switch schedule.Action {
+	case ActionPress:
+	case ActionPreset:
+		presets, err := s.deviceManager.GetPresets(ctx, schedule.Address)
+		if err != nil {
+			return err
+		}
+		if !slices.ContainsFunc(presets, func(p *devices.Preset) bool { return p.ID == schedule.PresetID }) {
+			return devices.ErrPresetNotFound
+		}
+	case ActionMacro:
+		if _, err := s.deviceManager.GetMacro(ctx, schedule.MacroID); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("invalid action: %s", schedule.Action)
+	}
+
+	schedule.Checkpoint = time.Now()
+	if err := s.repository.CreateSchedule(ctx, schedule); err != nil {
+		return fmt.Errorf("failed to create schedule: %w", err)
+	}
+
+	return nil
+}
+
+// SetEnabled enables or disables a schedule, runs due while it was disabled are not caught up
+func (s *Scheduler) SetEnabled(ctx context.Context, id int64, enabled bool) (*Schedule, error) {
+	schedule, err := s.repository.GetSchedule(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get schedule: %w", err)
+	}
+	if schedule == nil {
+		return nil, ErrScheduleNotFound
+	}
+
+	schedule.Enabled = enabled
+	schedule.Checkpoint = time.Now()
+	if err := s.repository.SetEnabled(ctx, id, schedule.Enabled, schedule.Checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	return schedule, nil
+}
+
+func (s *Scheduler) DeleteSchedule(ctx context.Context, id int64) error {
+	if err := s.repository.DeleteSchedule(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete schedule: %w", err)
+	}
+
+	return nil
+}
+
+// deleteDeviceSchedules deletes the schedules of a forgotten device, they could only fail from now on
+func (s *Scheduler) deleteDeviceSchedules(ctx context.Context, address string) error {
+	if err := s.repository.DeleteDeviceSchedules(ctx, address); err != nil {
+		return fmt.Errorf("failed to delete device schedules: %w", err)
+	}
+
+	return nil
+}
+
+// deletePresetSchedules deletes the schedules pressing with a deleted preset, they could only fail from now on
+func (s *Scheduler) deletePresetSchedules(ctx context.Context, id int64) error {
+	if err := s.repository.DeletePresetSchedules(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete preset schedules: %w", err)
+	}
+
+	return nil
+}
+
+// deleteMacroSchedules deletes the schedules running a deleted macro
+func (s *Scheduler) deleteMacroSchedules(ctx context.Context, id int64) error {
+	if err := s.repository.DeleteMacroSchedules(ctx, id); err != nil {
+		return fmt.Errorf("failed to delete macro schedules: %w", err)
+	}
+
+	return nil
+}
+
+func (s *Scheduler) tick(ctx context.Context, now time.Time) {
+	schedules, err := s.repository.GetSchedules(ctx)
+	if err != nil {
+		s.logger.Error("failed to get schedules", logging.ErrAttr(err))
+		return
+	}
+
+	for _, schedule := range schedules {
+		if !schedule.Enabled {
+			continue
+		}
+
+		trigger, err := s.ParseTrigger(schedule.Expression, schedule.Timezone)
+		if err != nil {
+			s.logger.Error("invalid schedule", slog.Int64("schedule_id", schedule.ID), logging.ErrAttr(err))
+			continue
+		}
+
+		due, ok := latestDueRun(trigger, schedule.Checkpoint, now)
+		if !ok {
+			continue
+		}
+
+		if err := s.repository.SetCheckpoint(ctx, schedule.ID, now); err != nil {
+			s.logger.Error("failed to update schedule checkpoint", slog.Int64("schedule_id", schedule.ID), logging.ErrAttr(err))
+			continue
+		}
+
+		if now.Sub(due) > MissedRunThreshold && schedule.MissedRun == MissedRunSkip {
+			s.logger.Info("skipping missed schedule run", slog.Int64("schedule_id", schedule.ID), slog.Time("due", due))
+			continue
+		}
+
+		go s.execute(ctx, schedule)
+	}
+}
+
+// latestDueRun returns the last run time between the checkpoint and now, several missed runs collapse into one
+func latestDueRun(trigger Trigger, checkpoint, now time.Time) (time.Time, bool) {
+	due, ok := trigger.Next(checkpoint)
+	if !ok || due.After(now) {
+		return time.Time{}, false
+	}
+
+	for range maxCatchUpRuns {
+		next, ok := trigger.Next(due)
+		if !ok || next.After(now) {
+			break
+		}
+		due = next
+	}
+
+	return due, true
+}
+
+func (s *Scheduler) execute(ctx context.Context, schedule *Schedule) {
+	logger := s.logger.With(slog.Int64("schedule_id", schedule.ID), slog.String("address", schedule.Address))
+	logger.Info("running schedule", slog.String("action", string(schedule.Action)))
+
+	ctx = history.WithSource(ctx, history.Source{Kind: history.SourceSchedule, Name: schedule.Name})
+
+	err := s.runAction(ctx, schedule)
+	lastError := ""
+	if err != nil {
+		lastError = err.Error()
+		logger.Error("schedule run failed", logging.ErrAttr(err))
+	}
+
+	if err := s.repository.SetLastRun(ctx, schedule.ID, time.Now(), lastError); err != nil {
+		logger.Error("failed to record schedule run", logging.ErrAttr(err))
+	}
+}
+
+func (s *Scheduler) runAction(ctx context.Context, schedule *Schedule) error {
+	switch schedule.Action {
+	case ActionPress:
+		if _, err := s.deviceManager.Toggle(ctx, schedule.Address); err != nil {
+			return err
+		}
+	case ActionPreset:
+		if _, err := s.deviceManager.PressWithPreset(ctx, schedule.Address, schedule.PresetID); err != nil {
+			return err
+		}
+	case ActionMacro:
+		output := make(chan devices.MacroProgress)
+		go func() {
+			for range output {
+			}
+		}()
+		defer close(output)
+
+		return s.deviceManager.RunMacro(ctx, schedule.MacroID, []string{schedule.Address}, output)
+	default:
+		return fmt.Errorf("invalid action: %s", schedule.Action)
+	}
+
+	return nil
+}
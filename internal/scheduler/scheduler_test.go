@@ -0,0 +1,119 @@
+package scheduler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"slices"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/secrets"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+const testAddress = "AA:BB:CC:DD:EE:FF"
+
+// newTestScheduler returns a scheduler and the device manager it is wired to, with one saved device
+func newTestScheduler(t *testing.T) (*Scheduler, *devices.Manager) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("error opening database: %s", err)
+	}
+	// Every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	cipher, err := secrets.NewCipher(make([]byte, secrets.KeySize))
+	if err != nil {
+		t.Fatalf("error creating cipher: %s", err)
+	}
+
+	repository := devices.NewRepository(db, cipher)
+	if err := repository.CreateDevice(context.Background(), &devices.Device{
+		Address: testAddress, DeviceID: "device", Name: "Device", LocalKey: "key", UUID: "uuid",
+	}); err != nil {
+		t.Fatalf("error creating device: %s", err)
+	}
+
+	manager := devices.NewManager(repository, history.NewRepository(db), nil, slog.Default())
+
+	return NewScheduler(NewRepository(db), manager, 0, 0, slog.Default()), manager
+}
+
+func createTestSchedule(t *testing.T, s *Scheduler, name string, action ActionType, presetID, macroID int64) {
+	t.Helper()
+
+	if err := s.repository.CreateSchedule(context.Background(), &Schedule{
+		Name: name, Address: testAddress, Expression: "0 8 * * *", Timezone: "UTC", Enabled: true,
+		MissedRun: MissedRunSkip, Action: action, PresetID: presetID, MacroID: macroID,
+	}); err != nil {
+		t.Fatalf("error creating schedule: %s", err)
+	}
+}
+
+func scheduleNames(t *testing.T, s *Scheduler) []string {
+	t.Helper()
+
+	schedules, err := s.repository.GetSchedules(context.Background())
+	if err != nil {
+		t.Fatalf("error getting schedules: %s", err)
+	}
+
+	names := make([]string, 0, len(schedules))
+	for _, schedule := range schedules {
+		names = append(names, schedule.Name)
+	}
+	slices.Sort(names)
+
+	return names
+}
+
+func TestDeletingPresetsAndMacrosDeletesTheirSchedules(t *testing.T) {
+	ctx := context.Background()
+	s, manager := newTestScheduler(t)
+
+	presets := make([]*devices.Preset, 2)
+	for i := range presets {
+		presets[i] = &devices.Preset{
+			Address: testAddress, Name: fmt.Sprintf("preset %d", i),
+			Mode: fingerbot.ModeClick, ArmDownPercent: 80, ArmUpPercent: 20,
+		}
+		if err := manager.CreatePreset(ctx, presets[i]); err != nil {
+			t.Fatalf("error creating preset: %s", err)
+		}
+	}
+	macros := make([]*devices.Macro, 2)
+	for i := range macros {
+		macros[i] = &devices.Macro{
+			Name: fmt.Sprintf("macro %d", i), Steps: []devices.MacroStep{{Type: devices.MacroStepPress, Count: 1}},
+		}
+		if err := manager.CreateMacro(ctx, macros[i]); err != nil {
+			t.Fatalf("error creating macro: %s", err)
+		}
+	}
+
+	createTestSchedule(t, s, "press", ActionPress, 0, 0)
+	createTestSchedule(t, s, "deleted preset", ActionPreset, presets[0].ID, 0)
+	createTestSchedule(t, s, "kept preset", ActionPreset, presets[1].ID, 0)
+	createTestSchedule(t, s, "deleted macro", ActionMacro, 0, macros[0].ID)
+	createTestSchedule(t, s, "kept macro", ActionMacro, 0, macros[1].ID)
+
+	if err := manager.DeletePreset(ctx, testAddress, presets[0].ID); err != nil {
+		t.Fatalf("error deleting preset: %s", err)
+	}
+	if err := manager.DeleteMacro(ctx, macros[0].ID); err != nil {
+		t.Fatalf("error deleting macro: %s", err)
+	}
+
+	want := []string{"kept macro", "kept preset", "press"}
+	if got := scheduleNames(t, s); !slices.Equal(got, want) {
+		t.Fatalf("got schedules %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// sunZenith is the official zenith for sunrise and sunset, accounting for refraction and the solar disc
+const sunZenith = 90.833
+
+type sunEvent string
+
+const (
+	sunrise sunEvent = "sunrise"
+	sunset  sunEvent = "sunset"
+)
+
+// sunTrigger fires at sunrise or sunset, shifted by an offset, at the given coordinates
+type sunTrigger struct {
+	event     sunEvent
+	offset    time.Duration
+	latitude  float64
+	longitude float64
+	location  *time.Location
+}
+
+// parseSun parses expressions such as "sunrise", "sunset-30m" or "sunrise+1h15m"
+func parseSun(expression string, latitude, longitude float64, location *time.Location) (*sunTrigger, error) {
+	trigger := &sunTrigger{latitude: latitude, longitude: longitude, location: location}
+
+	expression = strings.ToLower(strings.TrimSpace(expression))
+	var offset string
+	switch {
+	case strings.HasPrefix(expression, string(sunrise)):
+		trigger.event, offset = sunrise, strings.TrimPrefix(expression, string(sunrise))
+	case strings.HasPrefix(expression, string(sunset)):
+		trigger.event, offset = sunset, strings.TrimPrefix(expression, string(sunset))
+	default:
+		return nil, fmt.Errorf("not a sun expression: %s", expression)
+	}
+
+	if offset != "" {
+		if offset[0] != '+' && offset[0] != '-' {
+			return nil, fmt.Errorf("invalid offset: %s", offset)
+		}
+		duration, err := time.ParseDuration(offset)
+		if err != nil {
+			return nil, fmt.Errorf("invalid offset: %w", err)
+		}
+		trigger.offset = duration
+	}
+
+	return trigger, nil
+}
+
+// Next returns the first sun event after the given time, skipping days on which the sun does not rise or set
+func (t *sunTrigger) Next(after time.Time) (time.Time, bool) {
+	local := after.In(t.location)
+	// Start a day early so negative offsets on the next event are not missed. Days are stepped from noon, midnight
+	// does not exist on the days some timezones change to DST.
+	day := time.Date(local.Year(), local.Month(), local.Day()-1, 12, 0, 0, 0, t.location)
+
+	for i := 0; i < 370; i++ {
+		event, ok := sunTime(day.AddDate(0, 0, i), t.latitude, t.longitude, t.event)
+		if !ok {
+			continue
+		}
+
+		next := event.Add(t.offset).Truncate(time.Minute)
+		if next.After(after) {
+			return next.In(t.location), true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// sunTime calculates sunrise or sunset for the local calendar day using the Almanac for Computers algorithm
+func sunTime(day time.Time, latitude, longitude float64, event sunEvent) (time.Time, bool) {
+	lngHour := longitude / 15
+
+	approx := 6.0
+	if event == sunset {
+		approx = 18.0
+	}
+	t := float64(day.YearDay()) + (approx-lngHour)/24
+
+	meanAnomaly := 0.9856*t - 3.289
+	trueLongitude := normalizeDegrees(meanAnomaly + 1.916*sinDeg(meanAnomaly) + 0.020*sinDeg(2*meanAnomaly) + 282.634)
+
+	rightAscension := normalizeDegrees(atanDeg(0.91764 * tanDeg(trueLongitude)))
+	rightAscension += math.Floor(trueLongitude/90)*90 - math.Floor(rightAscension/90)*90
+	rightAscension /= 15
+
+	sinDec := 0.39782 * sinDeg(trueLongitude)
+	cosDec := math.Cos(math.Asin(sinDec))
+
+	cosH := (cosDeg(sunZenith) - sinDec*sinDeg(latitude)) / (cosDec * cosDeg(latitude))
+	if cosH > 1 || cosH < -1 {
+		// The sun never rises or never sets on this day
+		return time.Time{}, false
+	}
+
+	hourAngle := acosDeg(cosH)
+	if event == sunrise {
+		hourAngle = 360 - hourAngle
+	}
+	hourAngle /= 15
+
+	localMeanTime := hourAngle + rightAscension - 0.06571*t - 6.622
+	universalTime := math.Mod(localMeanTime-lngHour+48, 24)
+
+	result := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC).
+		Add(time.Duration(universalTime * float64(time.Hour)))
+
+	// The UTC date can differ from the local date, keep the event on the requested local day
+	localDay := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	switch {
+	case result.Before(localDay):
+		result = result.Add(24 * time.Hour)
+	case !result.Before(localDay.AddDate(0, 0, 1)):
+		result = result.Add(-24 * time.Hour)
+	}
+
+	return result, true
+}
+
+func normalizeDegrees(degrees float64) float64 {
+	return math.Mod(math.Mod(degrees, 360)+360, 360)
+}
+
+func sinDeg(degrees float64) float64 {
+	return math.Sin(degrees * math.Pi / 180)
+}
+
+func cosDeg(degrees float64) float64 {
+	return math.Cos(degrees * math.Pi / 180)
+}
+
+func tanDeg(degrees float64) float64 {
+	return math.Tan(degrees * math.Pi / 180)
+}
+
+func atanDeg(x float64) float64 {
+	return math.Atan(x) * 180 / math.Pi
+}
+
+func acosDeg(x float64) float64 {
+	return math.Acos(x) * 180 / math.Pi
+}
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"testing"
+	"time"
+)
+
+// Tromsø is above the arctic circle, with a polar day in summer and a polar night in winter
+const tromsoLatitude, tromsoLongitude = 69.6492, 18.9553
+
+func TestSunTime(t *testing.T) {
+	tests := []struct {
+		location            string
+		latitude, longitude float64
+		date                string
+		event               sunEvent
+		want                string
+	}{
+		{"Europe/Berlin", 52.52, 13.405, "2024-06-21", sunrise, "04:43"},
+		{"Europe/Berlin", 52.52, 13.405, "2024-06-21", sunset, "21:33"},
+		{"Europe/London", 51.5074, -0.1278, "2024-12-21", sunrise, "08:04"},
+		{"Europe/London", 51.5074, -0.1278, "2024-12-21", sunset, "15:53"},
+		{"Australia/Sydney", -33.8688, 151.2093, "2024-12-21", sunrise, "05:41"},
+		{"Australia/Sydney", -33.8688, 151.2093, "2024-12-21", sunset, "20:05"},
+	}
+	for _, test := range tests {
+		t.Run(test.location+" "+test.date+" "+string(test.event), func(t *testing.T) {
+			location := mustLoadLocation(t, test.location)
+			day, err := time.ParseInLocation("2006-01-02", test.date, location)
+			if err != nil {
+				t.Fatalf("error parsing date: %s", err)
+			}
+			want, err := time.ParseInLocation("2006-01-02 15:04", test.date+" "+test.want, location)
+			if err != nil {
+				t.Fatalf("error parsing time: %s", err)
+			}
+
+			got, ok := sunTime(day.Add(12*time.Hour), test.latitude, test.longitude, test.event)
+			if !ok {
+				t.Fatalf("expected a %s", test.event)
+			}
+			// The algorithm is accurate to about a minute
+			if diff := got.Sub(want).Abs(); diff > 2*time.Minute {
+				t.Fatalf("got %s, want %s", got.In(location).Format(time.RFC3339), want.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+func TestSunNextDST(t *testing.T) {
+	location := mustLoadLocation(t, "Europe/Berlin")
+	trigger, err := parseSun("sunrise", 52.52, 13.405, location)
+	if err != nil {
+		t.Fatalf("error parsing: %s", err)
+	}
+
+	before, ok := trigger.Next(mustParseTime(t, "2024-03-30T00:00:00+01:00"))
+	if !ok {
+		t.Fatalf("expected a sunrise")
+	}
+	after, ok := trigger.Next(before)
+	if !ok {
+		t.Fatalf("expected a sunrise")
+	}
+
+	if before.Day() != 30 || after.Day() != 31 {
+		t.Fatalf("expected sunrises on 30 and 31 March, got %s and %s", before, after)
+	}
+	// The sun rises about two minutes earlier every day, an hour later on the clock once DST starts
+	if _, offset := after.Zone(); offset != 2*60*60 {
+		t.Fatalf("expected the sunrise of 31 March in CEST, got %s", after)
+	}
+	if elapsed := after.Sub(before); elapsed < 23*time.Hour+55*time.Minute || elapsed > 24*time.Hour {
+		t.Fatalf("expected the sunrises a day apart, got %s", elapsed)
+	}
+}
+
+func TestSunPolar(t *testing.T) {
+	location := mustLoadLocation(t, "Europe/Oslo")
+
+	midsummer := time.Date(2024, 6, 21, 12, 0, 0, 0, location)
+	if _, ok := sunTime(midsummer, tromsoLatitude, tromsoLongitude, sunset); ok {
+		t.Errorf("expected no sunset during the polar day")
+	}
+	midwinter := time.Date(2024, 12, 21, 12, 0, 0, 0, location)
+	if _, ok := sunTime(midwinter, tromsoLatitude, tromsoLongitude, sunrise); ok {
+		t.Errorf("expected no sunrise during the polar night")
+	}
+
+	tests := []struct {
+		event       sunEvent
+		after       time.Time
+		from, until time.Time
+	}{
+		{sunset, time.Date(2024, 6, 1, 0, 0, 0, 0, location), time.Date(2024, 7, 15, 0, 0, 0, 0, location), time.Date(2024, 8, 1, 0, 0, 0, 0, location)},
+		{sunrise, time.Date(2024, 12, 1, 0, 0, 0, 0, location), time.Date(2025, 1, 10, 0, 0, 0, 0, location), time.Date(2025, 1, 21, 0, 0, 0, 0, location)},
+	}
+	for _, test := range tests {
+		trigger, err := parseSun(string(test.event), tromsoLatitude, tromsoLongitude, location)
+		if err != nil {
+			t.Fatalf("error parsing: %s", err)
+		}
+
+		next, ok := trigger.Next(test.after)
+		if !ok {
+			t.Fatalf("expected a %s after %s", test.event, test.after)
+		}
+		if next.Before(test.from) || !next.Before(test.until) {
+			t.Errorf("expected the first %s after %s between %s and %s, got %s",
+				test.event, test.after, test.from, test.until, next)
+		}
+	}
+}
+
+func TestSunOffset(t *testing.T) {
+	location := mustLoadLocation(t, "Europe/Berlin")
+	after := time.Date(2024, 6, 21, 0, 0, 0, 0, location)
+
+	event, ok := sunTime(after.Add(12*time.Hour), 52.52, 13.405, sunset)
+	if !ok {
+		t.Fatalf("expected a sunset")
+	}
+
+	for expression, offset := range map[string]time.Duration{
+		"sunset":        0,
+		"SUNSET-30m":    -30 * time.Minute,
+		"sunset+1h15m":  75 * time.Minute,
+		" sunset-1h30m": -90 * time.Minute,
+	} {
+		trigger, err := parseSun(expression, 52.52, 13.405, location)
+		if err != nil {
+			t.Fatalf("%q: error parsing: %s", expression, err)
+		}
+
+		next, ok := trigger.Next(after)
+		if !ok {
+			t.Fatalf("%q: expected a run", expression)
+		}
+		if want := event.Add(offset).Truncate(time.Minute); !next.Equal(want) {
+			t.Errorf("%q: got %s, want %s", expression, next, want)
+		}
+	}
+}
+
+func TestParseSunErrors(t *testing.T) {
+	for _, expression := range []string{"noon", "sunrise30m", "sunset+", "sunset+abc", "sunrise*2"} {
+		if _, err := parseSun(expression, 0, 0, time.UTC); err == nil {
+			t.Errorf("%q: expected an error", expression)
+		}
+	}
+}
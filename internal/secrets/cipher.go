@@ -0,0 +1,173 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeySize is the size of the master key in bytes, it is an AES-256 key
+const KeySize = 32
+
+// prefix marks encrypted values, it is followed by the ID of the key and the base64 encoded nonce and ciphertext
+const prefix = "enc:v1:"
+
+var (
+	ErrInvalidKey   = fmt.Errorf("the master key must be %d base64 encoded bytes", KeySize)
+	ErrNotEncrypted = errors.New("value is not encrypted")
+	ErrWrongKey     = errors.New("value was encrypted with another master key")
+)
+
+// Cipher encrypts values with AES-GCM under the master key. Each value is bound to its column and row, so a value
+// copied to another row does not decrypt.
+type Cipher struct {
+	aead  cipher.AEAD
+	keyID string
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	sum := sha256.Sum256(key)
+
+	return &Cipher{aead: aead, keyID: hex.EncodeToString(sum[:4])}, nil
+}
+
+// KeyID identifies the master key in the encrypted values without revealing it
+func (c *Cipher) KeyID() string {
+	return c.keyID
+}
+
+// Encrypt encrypts the value of the column in the row identified by key
+func (c *Cipher) Encrypt(column Column, key, value string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	sealed := c.aead.Seal(nonce, nonce, []byte(value), column.associatedData(key))
+
+	return prefix + c.keyID + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt decrypts the value of the column in the row identified by key
+func (c *Cipher) Decrypt(column Column, key, value string) (string, error) {
+	keyID, sealed, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	if keyID != c.keyID {
+		return "", fmt.Errorf("%w: %s, the configured key is %s", ErrWrongKey, keyID, c.keyID)
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("encrypted value is too short")
+	}
+
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, ciphertext, column.associatedData(key))
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return string(plaintext), nil
+}
+
+// encryptedWith reports whether the value was encrypted with the key of the cipher
+func (c *Cipher) encryptedWith(value string) bool {
+	keyID, _, err := parse(value)
+	return err == nil && keyID == c.keyID
+}
+
+// IsEncrypted reports whether the value was encrypted by a Cipher, values written before encryption are not
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+func parse(value string) (string, []byte, error) {
+	if !IsEncrypted(value) {
+		return "", nil, ErrNotEncrypted
+	}
+
+	keyID, encoded, ok := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+	if !ok {
+		return "", nil, errors.New("encrypted value is malformed")
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to decode encrypted value: %w", err)
+	}
+
+	return keyID, sealed, nil
+}
+
+// ParseKey decodes a base64 encoded master key
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != KeySize {
+		return nil, ErrInvalidKey
+	}
+
+	return key, nil
+}
+
+// LoadKey returns the base64 encoded master key, or the one in the file when it is empty. The error wraps
+// os.ErrNotExist when only the file was given and it does not exist.
+func LoadKey(encoded, file string) ([]byte, error) {
+	if encoded != "" {
+		return ParseKey(encoded)
+	}
+	if file == "" {
+		return nil, errors.New("no master key configured")
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := ParseKey(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", file, err)
+	}
+
+	return key, nil
+}
+
+// CreateKeyFile writes a new master key to the file, readable only by the owner. It fails if the file exists.
+func CreateKeyFile(file string) ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := f.WriteString(base64.StdEncoding.EncodeToString(key) + "\n"); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,129 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func newTestCipher(t *testing.T, seed byte) *Cipher {
+	t.Helper()
+
+	c, err := NewCipher(bytes.Repeat([]byte{seed}, KeySize))
+	if err != nil {
+		t.Fatalf("error creating cipher: %s", err)
+	}
+
+	return c
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	c := newTestCipher(t, 1)
+
+	for _, value := range []string{"", "local key", "ünïcödé"} {
+		encrypted, err := c.Encrypt(DeviceLocalKey, "AA:BB:CC:DD:EE:FF", value)
+		if err != nil {
+			t.Fatalf("error encrypting: %s", err)
+		}
+		if !IsEncrypted(encrypted) {
+			t.Fatalf("expected %q to be encrypted, got %q", value, encrypted)
+		}
+
+		decrypted, err := c.Decrypt(DeviceLocalKey, "AA:BB:CC:DD:EE:FF", encrypted)
+		if err != nil {
+			t.Fatalf("error decrypting: %s", err)
+		}
+		if decrypted != value {
+			t.Fatalf("got %q, want %q", decrypted, value)
+		}
+	}
+}
+
+func TestCipherAssociatedData(t *testing.T) {
+	c := newTestCipher(t, 1)
+
+	encrypted, err := c.Encrypt(DeviceLocalKey, "AA:BB:CC:DD:EE:FF", "local key")
+	if err != nil {
+		t.Fatalf("error encrypting: %s", err)
+	}
+
+	tests := []struct {
+		name   string
+		column Column
+		key    string
+	}{
+		{name: "copied to another row", column: DeviceLocalKey, key: "11:22:33:44:55:66"},
+		{name: "copied to another column", column: DeviceUUID, key: "AA:BB:CC:DD:EE:FF"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := c.Decrypt(test.column, test.key, encrypted); err == nil {
+				t.Fatalf("expected an error")
+			} else if errors.Is(err, ErrWrongKey) {
+				t.Fatalf("expected a decryption error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestCipherWrongKey(t *testing.T) {
+	encrypted, err := newTestCipher(t, 1).Encrypt(DeviceLocalKey, "AA:BB:CC:DD:EE:FF", "local key")
+	if err != nil {
+		t.Fatalf("error encrypting: %s", err)
+	}
+
+	other := newTestCipher(t, 2)
+	_, err = other.Decrypt(DeviceLocalKey, "AA:BB:CC:DD:EE:FF", encrypted)
+	if !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("expected ErrWrongKey, got %v", err)
+	}
+	if !strings.Contains(err.Error(), other.KeyID()) {
+		t.Fatalf("expected the configured key ID in %q", err)
+	}
+}
+
+func TestCipherDecryptErrors(t *testing.T) {
+	c := newTestCipher(t, 1)
+	encrypted, err := c.Encrypt(DeviceLocalKey, "AA:BB:CC:DD:EE:FF", "local key")
+	if err != nil {
+		t.Fatalf("error encrypting: %s", err)
+	}
+
+	// The last character may only hold padding bits, change one within the ciphertext
+	tampered := []byte(encrypted)
+	i := len(tampered) - 8
+	if tampered[i] == 'A' {
+		tampered[i] = 'B'
+	} else {
+		tampered[i] = 'A'
+	}
+
+	for name, value := range map[string]string{
+		"plain text": "local key",
+		"malformed":  prefix + c.KeyID(),
+		"not base64": prefix + c.KeyID() + ":***",
+		"too short":  prefix + c.KeyID() + ":AAAA",
+		"tampered":   string(tampered),
+	} {
+		if _, err := c.Decrypt(DeviceLocalKey, "AA:BB:CC:DD:EE:FF", value); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+
+	if _, err := c.Decrypt(DeviceLocalKey, "AA:BB:CC:DD:EE:FF", "local key"); !errors.Is(err, ErrNotEncrypted) {
+		t.Errorf("expected ErrNotEncrypted, got %v", err)
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	if _, err := ParseKey(" AQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQEBAQE=\n"); err != nil {
+		t.Errorf("error parsing key: %s", err)
+	}
+
+	for _, encoded := range []string{"", "not base64", "AQEBAQ=="} {
+		if _, err := ParseKey(encoded); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("%q: expected ErrInvalidKey, got %v", encoded, err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package secrets
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Column is a database column holding encrypted values, Key is the primary key column of its table
+type Column struct {
+	Table string
+	Name  string
+	Key   string
+}
+
+var (
+	// DeviceLocalKey and DeviceUUID are all it takes to control a device
+	DeviceLocalKey = Column{Table: "devices", Name: "local_key", Key: "address"}
+	DeviceUUID     = Column{Table: "devices", Name: "uuid", Key: "address"}
+
+	// Columns are every column holding encrypted values, RotateKey re-encrypts them all
+	Columns = []Column{DeviceLocalKey, DeviceUUID}
+)
+
+func (c Column) String() string {
+	return c.Table + "." + c.Name
+}
+
+func (c Column) associatedData(key string) []byte {
+	return []byte(c.String() + ":" + key)
+}
+
+// EncryptColumns encrypts the values of the columns still stored in plain text, e.g. those written before values were
+// encrypted, and returns how many it encrypted
+func EncryptColumns(ctx context.Context, db *sql.DB, c *Cipher, columns ...Column) (int, error) {
+	return reencrypt(ctx, db, columns, func(column Column, key, value string) (string, bool, error) {
+		if IsEncrypted(value) {
+			return "", false, nil
+		}
+
+		encrypted, err := c.Encrypt(column, key, value)
+		return encrypted, true, err
+	})
+}
+
+// RotateKey re-encrypts the values of the columns from one cipher to the other, along with those still stored in
+// plain text, and returns how many it re-encrypted. Values already encrypted with the new cipher are left alone, so
+// an interrupted rotation can be run again.
+func RotateKey(ctx context.Context, db *sql.DB, from, to *Cipher, columns ...Column) (int, error) {
+	return reencrypt(ctx, db, columns, func(column Column, key, value string) (string, bool, error) {
+		if to.encryptedWith(value) {
+			return "", false, nil
+		}
+
+		if IsEncrypted(value) {
+			var err error
+			if value, err = from.Decrypt(column, key, value); err != nil {
+				return "", false, fmt.Errorf("failed to decrypt %s of %s: %w", column, key, err)
+			}
+		}
+
+		encrypted, err := to.Encrypt(column, key, value)
+		return encrypted, true, err
+	})
+}
+
+// reencrypt replaces the values of the columns the function changes in a single transaction
+func reencrypt(
+	ctx context.Context,
+	db *sql.DB,
+	columns []Column,
+	change func(column Column, key, value string) (string, bool, error),
+) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	changed := 0
+	for _, column := range columns {
+		values, err := columnValues(ctx, tx, column)
+		if err != nil {
+			return 0, err
+		}
+
+		for key, value := range values {
+			value, ok, err := change(column, key, value)
+			if err != nil {
+				return 0, err
+			}
+			if !ok {
+				continue
+			}
+
+			if _, err := tx.ExecContext(
+				ctx, fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", column.Table, column.Name, column.Key), value, key,
+			); err != nil {
+				return 0, fmt.Errorf("error updating %s: %w", column, err)
+			}
+			changed++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	return changed, nil
+}
+
+// columnValues returns the values of the column by the key of their row
+func columnValues(ctx context.Context, tx *sql.Tx, column Column) (map[string]string, error) {
+	rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s, %s FROM %s", column.Key, column.Name, column.Table))
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s: %w", column, err)
+	}
+	defer rows.Close()
+
+	values := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("error scanning %s: %w", column, err)
+		}
+		values[key] = value
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error getting %s: %w", column, err)
+	}
+
+	return values, nil
+}
@@ -0,0 +1,217 @@
+package secrets
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	testSecret = Column{Table: "things", Name: "secret", Key: "id"}
+	testToken  = Column{Table: "things", Name: "token", Key: "id"}
+)
+
+func newTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("error opening database: %s", err)
+	}
+	// Every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec("CREATE TABLE things (id TEXT PRIMARY KEY, secret TEXT NOT NULL, token TEXT NOT NULL)"); err != nil {
+		t.Fatalf("error creating table: %s", err)
+	}
+
+	return db
+}
+
+func insertThing(t *testing.T, db *sql.DB, id, secret, token string) {
+	t.Helper()
+
+	if _, err := db.Exec("INSERT INTO things (id, secret, token) VALUES ($1, $2, $3)", id, secret, token); err != nil {
+		t.Fatalf("error inserting %s: %s", id, err)
+	}
+}
+
+func mustEncrypt(t *testing.T, c *Cipher, column Column, key, value string) string {
+	t.Helper()
+
+	encrypted, err := c.Encrypt(column, key, value)
+	if err != nil {
+		t.Fatalf("error encrypting: %s", err)
+	}
+
+	return encrypted
+}
+
+// columnSnapshot returns the stored values of the column by row
+func columnSnapshot(t *testing.T, db *sql.DB, column Column) map[string]string {
+	t.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("error starting transaction: %s", err)
+	}
+	defer tx.Rollback()
+
+	values, err := columnValues(context.Background(), tx, column)
+	if err != nil {
+		t.Fatalf("error getting values: %s", err)
+	}
+
+	return values
+}
+
+// assertDecrypts checks that every value of the column decrypts with the cipher to the wanted plain text
+func assertDecrypts(t *testing.T, db *sql.DB, c *Cipher, column Column, want map[string]string) {
+	t.Helper()
+
+	values := columnSnapshot(t, db, column)
+	if len(values) != len(want) {
+		t.Fatalf("got %d values of %s, want %d", len(values), column, len(want))
+	}
+	for key, value := range values {
+		decrypted, err := c.Decrypt(column, key, value)
+		if err != nil {
+			t.Fatalf("error decrypting %s of %s: %s", column, key, err)
+		}
+		if decrypted != want[key] {
+			t.Fatalf("%s of %s: got %q, want %q", column, key, decrypted, want[key])
+		}
+	}
+}
+
+func TestEncryptColumns(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	c := newTestCipher(t, 1)
+
+	insertThing(t, db, "a", "secret a", "token a")
+	insertThing(t, db, "b", mustEncrypt(t, c, testSecret, "b", "secret b"), "token b")
+
+	encrypted, err := EncryptColumns(ctx, db, c, testSecret, testToken)
+	if err != nil {
+		t.Fatalf("error encrypting columns: %s", err)
+	}
+	if encrypted != 3 {
+		t.Fatalf("expected 3 values to be encrypted, got %d", encrypted)
+	}
+	assertDecrypts(t, db, c, testSecret, map[string]string{"a": "secret a", "b": "secret b"})
+	assertDecrypts(t, db, c, testToken, map[string]string{"a": "token a", "b": "token b"})
+
+	// Running it again leaves the encrypted values alone
+	before := columnSnapshot(t, db, testSecret)
+	encrypted, err = EncryptColumns(ctx, db, c, testSecret, testToken)
+	if err != nil {
+		t.Fatalf("error encrypting columns again: %s", err)
+	}
+	if encrypted != 0 {
+		t.Fatalf("expected nothing to be encrypted again, got %d", encrypted)
+	}
+	for key, value := range columnSnapshot(t, db, testSecret) {
+		if value != before[key] {
+			t.Fatalf("expected %s of %s to be unchanged", testSecret, key)
+		}
+	}
+}
+
+func TestEncryptColumnsBindsRows(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	c := newTestCipher(t, 1)
+
+	insertThing(t, db, "a", "secret a", "token a")
+	insertThing(t, db, "b", "secret b", "token b")
+	if _, err := EncryptColumns(ctx, db, c, testSecret, testToken); err != nil {
+		t.Fatalf("error encrypting columns: %s", err)
+	}
+
+	// Copy the secret of a over the secret and the token of b
+	values := columnSnapshot(t, db, testSecret)
+	if _, err := db.Exec("UPDATE things SET secret = $1, token = $1 WHERE id = 'b'", values["a"]); err != nil {
+		t.Fatalf("error copying value: %s", err)
+	}
+
+	if _, err := c.Decrypt(testSecret, "b", columnSnapshot(t, db, testSecret)["b"]); err == nil {
+		t.Errorf("expected a value copied to another row not to decrypt")
+	}
+	if _, err := c.Decrypt(testToken, "b", columnSnapshot(t, db, testToken)["b"]); err == nil {
+		t.Errorf("expected a value copied to another column not to decrypt")
+	}
+}
+
+func TestRotateKey(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	from, to := newTestCipher(t, 1), newTestCipher(t, 2)
+
+	insertThing(t, db, "a", mustEncrypt(t, from, testSecret, "a", "secret a"), mustEncrypt(t, from, testToken, "a", "token a"))
+	insertThing(t, db, "b", "secret b", mustEncrypt(t, from, testToken, "b", "token b"))
+
+	rotated, err := RotateKey(ctx, db, from, to, testSecret, testToken)
+	if err != nil {
+		t.Fatalf("error rotating key: %s", err)
+	}
+	if rotated != 4 {
+		t.Fatalf("expected 4 values to be rotated, got %d", rotated)
+	}
+	assertDecrypts(t, db, to, testSecret, map[string]string{"a": "secret a", "b": "secret b"})
+	assertDecrypts(t, db, to, testToken, map[string]string{"a": "token a", "b": "token b"})
+
+	if _, err := from.Decrypt(testSecret, "a", columnSnapshot(t, db, testSecret)["a"]); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("expected the old key to be refused, got %v", err)
+	}
+}
+
+func TestRotateKeyResumes(t *testing.T) {
+	ctx := context.Background()
+	db := newTestDB(t)
+	from, to, other := newTestCipher(t, 1), newTestCipher(t, 2), newTestCipher(t, 3)
+
+	// A rotation stopped halfway: a is done, b is not, c was encrypted with a key nobody has anymore
+	insertThing(t, db, "a", mustEncrypt(t, to, testSecret, "a", "secret a"), mustEncrypt(t, to, testToken, "a", "token a"))
+	insertThing(t, db, "b", mustEncrypt(t, from, testSecret, "b", "secret b"), mustEncrypt(t, from, testToken, "b", "token b"))
+	insertThing(t, db, "c", mustEncrypt(t, other, testSecret, "c", "secret c"), "token c")
+
+	secrets, tokens := columnSnapshot(t, db, testSecret), columnSnapshot(t, db, testToken)
+	if _, err := RotateKey(ctx, db, from, to, testSecret, testToken); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("expected ErrWrongKey, got %v", err)
+	}
+	// The failed rotation is rolled back as a whole
+	for key, value := range columnSnapshot(t, db, testSecret) {
+		if value != secrets[key] {
+			t.Fatalf("expected %s of %s to be unchanged", testSecret, key)
+		}
+	}
+	for key, value := range columnSnapshot(t, db, testToken) {
+		if value != tokens[key] {
+			t.Fatalf("expected %s of %s to be unchanged", testToken, key)
+		}
+	}
+
+	if _, err := db.Exec("DELETE FROM things WHERE id = 'c'"); err != nil {
+		t.Fatalf("error deleting row: %s", err)
+	}
+
+	rotated, err := RotateKey(ctx, db, from, to, testSecret, testToken)
+	if err != nil {
+		t.Fatalf("error rotating key: %s", err)
+	}
+	if rotated != 2 {
+		t.Fatalf("expected only the values of b to be rotated, got %d", rotated)
+	}
+	assertDecrypts(t, db, to, testSecret, map[string]string{"a": "secret a", "b": "secret b"})
+	assertDecrypts(t, db, to, testToken, map[string]string{"a": "token a", "b": "token b"})
+
+	// Once done, running it again changes nothing
+	if rotated, err := RotateKey(ctx, db, from, to, testSecret, testToken); err != nil || rotated != 0 {
+		t.Fatalf("expected nothing to be rotated again, got %d, %v", rotated, err)
+	}
+}
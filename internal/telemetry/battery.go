@@ -0,0 +1,306 @@
+package telemetry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+const (
+	// SampleRetention is how long battery samples are kept
+	SampleRetention = 90 * 24 * time.Hour
+	// EstimateWindow bounds how far back samples are used to estimate the drain
+	EstimateWindow = 30 * 24 * time.Hour
+	// LowBatteryHysteresis is how many percent the battery has to recover before another low battery alert is raised
+	LowBatteryHysteresis = 5
+	// minEstimatePeriod is the shortest discharge period an estimate is made from
+	minEstimatePeriod = time.Hour
+)
+
+const (
+	AlertBatteryLow     = "battery_low"
+	AlertChargeComplete = "charge_complete"
+)
+
+var (
+	chargeStatusNone     = fingerbot.ChargeStatusNone.String()
+	chargeStatusCharging = fingerbot.ChargeStatusCharging.String()
+	chargeStatusDone     = fingerbot.ChargeStatusChargeDone.String()
+)
+
+type Thresholds struct {
+	// LowPercent raises a low battery alert when the battery drops to it while not charging
+	LowPercent int32
+	// ChargeCompletePercent raises a charge complete alert when reached while charging,
+	// the alert is also raised when the device reports that charging is done
+	ChargeCompletePercent int32
+}
+
+type batteryState struct {
+	percent       int32
+	chargeStatus  string
+	sampledAt     time.Time
+	lowAlerted    bool
+	chargeAlerted bool
+}
+
+// BatteryMonitor samples the battery of connected devices, stores the samples and raises battery alerts
+type BatteryMonitor struct {
+	repository    *Repository
+	deviceManager *devices.Manager
+	thresholds    Thresholds
+	interval      time.Duration
+	logger        *slog.Logger
+
+	states      map[string]*batteryState
+	statesMutex sync.Mutex
+}
+
+func NewBatteryMonitor(repository *Repository, deviceManager *devices.Manager, thresholds Thresholds, interval time.Duration, logger *slog.Logger) *BatteryMonitor {
+	return &BatteryMonitor{
+		repository:    repository,
+		deviceManager: deviceManager,
+		thresholds:    thresholds,
+		interval:      interval,
+		logger:        logger.With("component", "BatteryMonitor"),
+		states:        map[string]*batteryState{},
+	}
+}
+
+// Run samples battery changes as they are reported and all connected devices every interval until the context is cancelled
+func (b *BatteryMonitor) Run(ctx context.Context) {
+	events, unsubscribe := b.deviceManager.Subscribe()
+	defer unsubscribe()
+
+	ticker := time.NewTicker(b.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			if event.Type != devices.EventDatapoint {
+				continue
+			}
+			if id := event.Datapoint.ID; id != fingerbot.BatteryPercentDP && id != fingerbot.ChargeStatusDP {
+				continue
+			}
+			if device := b.deviceManager.GetFingerbot(event.Address); device != nil {
+				b.sample(ctx, device, false)
+			}
+		case now := <-ticker.C:
+			for _, device := range b.deviceManager.GetConnectedDevices() {
+				b.sample(ctx, device, true)
+			}
+			if err := b.repository.DeleteSamplesBefore(ctx, now.Add(-SampleRetention)); err != nil {
+				b.logger.Error("failed to delete old battery samples", logging.ErrAttr(err))
+			}
+		}
+	}
+}
+
+// sample stores the current battery state of the device, unless it did not change and force is false
+func (b *BatteryMonitor) sample(ctx context.Context, device *fingerbot.Fingerbot, force bool) {
+	if _, ok := device.GetDatapoint(fingerbot.BatteryPercentDP); !ok {
+		return
+	}
+
+	sample := &BatterySample{
+		Address:      device.Address(),
+		Time:         time.Now(),
+		Percent:      device.BatteryPercent(),
+		ChargeStatus: device.ChargeStatus().String(),
+	}
+
+	b.statesMutex.Lock()
+	state, known := b.states[sample.Address]
+	if known && !force && state.percent == sample.Percent && state.chargeStatus == sample.ChargeStatus {
+		b.statesMutex.Unlock()
+		return
+	}
+	if !known {
+		state = &batteryState{
+			// Charging that completed before the app started is not alerted
+			chargeAlerted: b.chargeComplete(sample),
+		}
+		b.states[sample.Address] = state
+	}
+	state.percent, state.chargeStatus, state.sampledAt = sample.Percent, sample.ChargeStatus, sample.Time
+	alerts := b.checkAlerts(state)
+	b.statesMutex.Unlock()
+
+	if err := b.repository.CreateSample(ctx, sample); err != nil {
+		b.logger.Error("failed to store battery sample", slog.String("address", sample.Address), logging.ErrAttr(err))
+	}
+
+	for alert, message := range alerts {
+		b.logger.Warn("battery alert", slog.String("address", sample.Address), slog.String("alert", alert))
+		b.deviceManager.PublishAlert(device.Address(), device.Name(), alert, message)
+	}
+}
+
+func (b *BatteryMonitor) chargeComplete(sample *BatterySample) bool {
+	return sample.ChargeStatus == chargeStatusDone ||
+		(sample.ChargeStatus == chargeStatusCharging && sample.Percent >= b.thresholds.ChargeCompletePercent)
+}
+
+// checkAlerts updates the alert state and returns the alerts which were raised
+func (b *BatteryMonitor) checkAlerts(state *batteryState) map[string]string {
+	alerts := map[string]string{}
+	sample := &BatterySample{Percent: state.percent, ChargeStatus: state.chargeStatus}
+
+	switch {
+	case state.chargeStatus != chargeStatusNone || state.percent > b.thresholds.LowPercent+LowBatteryHysteresis:
+		state.lowAlerted = false
+	case state.percent <= b.thresholds.LowPercent && !state.lowAlerted:
+		state.lowAlerted = true
+		alerts[AlertBatteryLow] = fmt.Sprintf("battery is at %d%%", state.percent)
+	}
+
+	switch {
+	case state.chargeStatus == chargeStatusNone:
+		state.chargeAlerted = false
+	case b.chargeComplete(sample) && !state.chargeAlerted:
+		state.chargeAlerted = true
+		alerts[AlertChargeComplete] = fmt.Sprintf("battery is charged to %d%%", state.percent)
+	}
+
+	return alerts
+}
+
+// Estimate is the expected remaining battery life based on the drain since the last charge
+type Estimate struct {
+	Since       time.Time
+	Percent     int32
+	DrainPerDay float64
+	// DaysRemaining is the time until the battery is empty at the current drain
+	DaysRemaining float64
+	// Presses is the number of presses since the last charge
+	Presses int
+	// PressesRemaining is the number of presses until the battery is empty, 0 if it cannot be estimated
+	PressesRemaining int
+}
+
+type BatteryReport struct {
+	Samples  []*BatterySample
+	Estimate *Estimate
+	// LowBattery is set while a low battery alert is active
+	LowBattery bool
+	LowPercent int32
+}
+
+// Report returns the battery samples of a device taken since the given time together with a drain estimate
+func (b *BatteryMonitor) Report(ctx context.Context, address string, since time.Time) (*BatteryReport, error) {
+	from := time.Now().Add(-EstimateWindow)
+	if since.Before(from) {
+		from = since
+	}
+
+	samples, err := b.repository.GetSamples(ctx, address, from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get battery samples: %w", err)
+	}
+
+	estimate, err := b.estimate(ctx, address, samples)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BatteryReport{Estimate: estimate, LowPercent: b.thresholds.LowPercent}
+	for _, sample := range samples {
+		if !sample.Time.Before(since) {
+			report.Samples = append(report.Samples, sample)
+		}
+	}
+
+	b.statesMutex.Lock()
+	if state, ok := b.states[address]; ok {
+		report.LowBattery = state.lowAlerted
+	}
+	b.statesMutex.Unlock()
+
+	return report, nil
+}
+
+// estimate fits a line through the samples taken since the last charge
+func (b *BatteryMonitor) estimate(ctx context.Context, address string, samples []*BatterySample) (*Estimate, error) {
+	start := len(samples)
+	for start > 0 {
+		sample := samples[start-1]
+		if sample.ChargeStatus != chargeStatusNone {
+			break
+		}
+		// A rise of more than a percent means the battery was charged or replaced
+		if start < len(samples) && sample.Percent+1 < samples[start].Percent {
+			break
+		}
+		start--
+	}
+
+	discharge := samples[start:]
+	if len(discharge) < 2 {
+		return nil, nil
+	}
+
+	first, last := discharge[0], discharge[len(discharge)-1]
+	if last.Time.Sub(first.Time) < minEstimatePeriod {
+		return nil, nil
+	}
+
+	// Least squares slope of percent over days
+	var sumX, sumY, sumXY, sumXX float64
+	for _, sample := range discharge {
+		x := sample.Time.Sub(first.Time).Hours() / 24
+		y := float64(sample.Percent)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+	n := float64(len(discharge))
+	denominator := n*sumXX - sumX*sumX
+	if denominator == 0 {
+		return nil, nil
+	}
+	drainPerDay := -(n*sumXY - sumX*sumY) / denominator
+	if drainPerDay <= 0 {
+		return nil, nil
+	}
+
+	estimate := &Estimate{
+		Since:         first.Time,
+		Percent:       last.Percent,
+		DrainPerDay:   drainPerDay,
+		DaysRemaining: float64(last.Percent) / drainPerDay,
+	}
+
+	_, presses, err := b.deviceManager.GetHistory(ctx, history.Filter{
+		Address:        address,
+		Action:         history.ActionPress,
+		ExcludeOutcome: history.OutcomeRefused,
+		Since:          first.Time,
+		Limit:          1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	estimate.Presses = presses
+
+	drained := float64(first.Percent - last.Percent)
+	if presses > 0 && drained > 0 {
+		estimate.PressesRemaining = int(float64(last.Percent) / (drained / float64(presses)))
+	}
+
+	return estimate, nil
+}
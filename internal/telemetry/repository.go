@@ -0,0 +1,104 @@
+package telemetry
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type BatterySample struct {
+	ID           int64     `sql:"id"`
+	Address      string    `sql:"address"`
+	Time         time.Time `sql:"time"`
+	Percent      int32     `sql:"percent"`
+	ChargeStatus string    `sql:"charge_status"`
+}
+
+type Repository struct {
+	db *sql.DB
+}
+
+func NewRepository(db *sql.DB) *Repository {
+	repo := &Repository{db: db}
+	if err := repo.init(); err != nil {
+		panic(err)
+	}
+
+	return repo
+}
+
+func (r *Repository) init() error {
+	if _, err := r.db.Exec(`
+		CREATE TABLE IF NOT EXISTS battery_samples (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			address TEXT NOT NULL,
+			time TIMESTAMP NOT NULL,
+			percent INTEGER NOT NULL,
+			charge_status TEXT NOT NULL
+		)
+	`); err != nil {
+		return fmt.Errorf("error creating battery samples table: %w", err)
+	}
+
+	if _, err := r.db.Exec(`CREATE INDEX IF NOT EXISTS battery_samples_address_time ON battery_samples (address, time)`); err != nil {
+		return fmt.Errorf("error creating battery samples index: %w", err)
+	}
+
+	return nil
+}
+
+// CreateSample stores the sample, times are stored in UTC so that they compare correctly as text
+func (r *Repository) CreateSample(ctx context.Context, s *BatterySample) error {
+	result, err := r.db.ExecContext(
+		ctx,
+		"INSERT INTO battery_samples (address, time, percent, charge_status) VALUES ($1, $2, $3, $4)",
+		s.Address, s.Time.UTC(), s.Percent, s.ChargeStatus,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating battery sample: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return fmt.Errorf("error getting battery sample id: %w", err)
+	}
+	s.ID = id
+
+	return nil
+}
+
+// GetSamples returns the samples of a device taken since the given time, oldest first
+func (r *Repository) GetSamples(ctx context.Context, address string, since time.Time) ([]*BatterySample, error) {
+	rows, err := r.db.QueryContext(
+		ctx,
+		"SELECT id, address, time, percent, charge_status FROM battery_samples WHERE address = $1 AND time >= $2 ORDER BY time",
+		address, since.UTC(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error getting battery samples: %w", err)
+	}
+	defer rows.Close()
+
+	var samples []*BatterySample
+	for rows.Next() {
+		var s BatterySample
+		if err := rows.Scan(&s.ID, &s.Address, &s.Time, &s.Percent, &s.ChargeStatus); err != nil {
+			return nil, fmt.Errorf("error scanning battery sample: %w", err)
+		}
+
+		samples = append(samples, &s)
+	}
+
+	return samples, nil
+}
+
+func (r *Repository) DeleteSamplesBefore(ctx context.Context, before time.Time) error {
+	if _, err := r.db.ExecContext(
+		ctx, "DELETE FROM battery_samples WHERE time < $1", before.UTC(),
+	); err != nil {
+		return fmt.Errorf("error deleting battery samples: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package tuyable
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// FieldError is a validation failure of a single field
+type FieldError struct {
+	Field   string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists every field which failed validation
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Error())
+	}
+
+	return "invalid " + strings.Join(messages, ", ")
+}
+
+// FieldMessages returns the validation messages by field, the first one wins if a field failed several checks
+func (e *ValidationError) FieldMessages() map[string]string {
+	messages := make(map[string]string, len(e.Fields))
+	for _, field := range e.Fields {
+		if _, ok := messages[field.Field]; !ok {
+			messages[field.Field] = field.Message
+		}
+	}
+
+	return messages
+}
+
+// Constraint restricts the values of a single datapoint. Value datapoints are checked against Min and Max,
+// enum datapoints against Values.
+type Constraint struct {
+	ID     byte
+	Field  string
+	Min    int32
+	Max    int32
+	Values []uint32
+}
+
+func (c Constraint) check(value any) string {
+	if c.Values != nil {
+		v, ok := value.(uint32)
+		if !ok {
+			return fmt.Sprintf("unexpected value type %T", value)
+		}
+		if !slices.Contains(c.Values, v) {
+			return fmt.Sprintf("%d is not a valid value", v)
+		}
+
+		return ""
+	}
+
+	v, ok := value.(int32)
+	if !ok {
+		return fmt.Sprintf("unexpected value type %T", value)
+	}
+	if v < c.Min || v > c.Max {
+		return fmt.Sprintf("must be between %d and %d", c.Min, c.Max)
+	}
+
+	return ""
+}
+
+// Invariant is a rule between several datapoints. A failure is reported on the field of each changed datapoint, with
+// its message in Messages, so it points at what the user changed.
+type Invariant struct {
+	IDs      []byte
+	Messages map[byte]string
+	Valid    func(values map[byte]any) bool
+}
+
+// Constraints declares the valid values of a device model
+type Constraints struct {
+	Datapoints []Constraint
+	Invariants []Invariant
+}
+
+// Validate checks the changed values. Invariants involving a changed value are checked against the current values
+// overlaid with the changes, so values which were already invalid do not block unrelated changes. A change to the
+// current value does not count as changed, transactions stage related datapoints together.
+func (c Constraints) Validate(current, changes map[byte]any) error {
+	var fields []FieldError
+	for _, constraint := range c.Datapoints {
+		value, ok := changes[constraint.ID]
+		if !ok {
+			continue
+		}
+		if message := constraint.check(value); message != "" {
+			fields = append(fields, FieldError{Field: constraint.Field, Message: message})
+		}
+	}
+
+	merged := make(map[byte]any, len(current)+len(changes))
+	for id, value := range current {
+		merged[id] = value
+	}
+	for id, value := range changes {
+		merged[id] = value
+	}
+
+	for _, invariant := range c.Invariants {
+		changed, known := false, true
+		for _, id := range invariant.IDs {
+			if differs(current, changes, id) {
+				changed = true
+			}
+			if _, ok := merged[id]; !ok {
+				known = false
+			}
+		}
+		if !changed || !known || invariant.Valid(merged) {
+			continue
+		}
+		for _, id := range invariant.IDs {
+			if differs(current, changes, id) {
+				fields = append(fields, FieldError{Field: c.field(id), Message: invariant.Messages[id]})
+			}
+		}
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+
+	return nil
+}
+
+// differs reports whether the changes set the datapoint to another value than the current one
+func differs(current, changes map[byte]any, id byte) bool {
+	value, ok := changes[id]
+	if !ok {
+		return false
+	}
+	previous, ok := current[id]
+
+	return !ok || previous != value
+}
+
+// field returns the field name of the datapoint
+func (c Constraints) field(id byte) string {
+	for _, constraint := range c.Datapoints {
+		if constraint.ID == id {
+			return constraint.Field
+		}
+	}
+
+	return fmt.Sprintf("datapoint_%d", id)
+}
@@ -0,0 +1,190 @@
+package tuyable
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+const (
+	testLevelDP = 1
+	testColorDP = 2
+	testLowDP   = 3
+	testHighDP  = 4
+	testFlagDP  = 5
+)
+
+var testConstraints = Constraints{
+	Datapoints: []Constraint{
+		{ID: testLevelDP, Field: "level", Min: 0, Max: 10},
+		{ID: testColorDP, Field: "color", Values: []uint32{0, 1, 2}},
+		{ID: testLowDP, Field: "low", Min: 0, Max: 100},
+		{ID: testHighDP, Field: "high", Min: 0, Max: 100},
+	},
+	Invariants: []Invariant{
+		{
+			IDs: []byte{testLowDP, testHighDP},
+			Messages: map[byte]string{
+				testLowDP:  "must not be more than high",
+				testHighDP: "must not be less than low",
+			},
+			Valid: func(values map[byte]any) bool {
+				low, lowOK := values[testLowDP].(int32)
+				high, highOK := values[testHighDP].(int32)
+				return !lowOK || !highOK || low <= high
+			},
+		},
+		{
+			IDs:      []byte{testLevelDP, testFlagDP},
+			Messages: map[byte]string{testFlagDP: "must be off at level 10"},
+			Valid: func(values map[byte]any) bool {
+				return values[testLevelDP] != int32(10) || values[testFlagDP] == false
+			},
+		},
+	},
+}
+
+func TestConstraintsValidate(t *testing.T) {
+	current := map[byte]any{
+		testLevelDP: int32(5), testColorDP: uint32(1), testLowDP: int32(20), testHighDP: int32(80), testFlagDP: true,
+	}
+
+	tests := []struct {
+		name    string
+		current map[byte]any
+		changes map[byte]any
+		want    []FieldError
+	}{
+		{
+			name:    "valid",
+			current: current,
+			changes: map[byte]any{testLevelDP: int32(9), testColorDP: uint32(2), testLowDP: int32(80)},
+		},
+		{
+			name:    "bounds are inclusive",
+			current: current,
+			changes: map[byte]any{testLowDP: int32(0), testHighDP: int32(100)},
+		},
+		{
+			name:    "out of range",
+			current: current,
+			changes: map[byte]any{testLevelDP: int32(11), testLowDP: int32(-1)},
+			want: []FieldError{
+				{Field: "level", Message: "must be between 0 and 10"},
+				{Field: "low", Message: "must be between 0 and 100"},
+			},
+		},
+		{
+			name:    "not an enum value",
+			current: current,
+			changes: map[byte]any{testColorDP: uint32(3)},
+			want:    []FieldError{{Field: "color", Message: "3 is not a valid value"}},
+		},
+		{
+			name:    "wrong type",
+			current: current,
+			changes: map[byte]any{testColorDP: int32(1)},
+			want:    []FieldError{{Field: "color", Message: "unexpected value type int32"}},
+		},
+		{
+			name:    "wrong type for a range",
+			current: current,
+			changes: map[byte]any{testLevelDP: uint32(1)},
+			want:    []FieldError{{Field: "level", Message: "unexpected value type uint32"}},
+		},
+		{
+			name:    "invariant is reported on the changed field",
+			current: current,
+			changes: map[byte]any{testLowDP: int32(90)},
+			want:    []FieldError{{Field: "low", Message: "must not be more than high"}},
+		},
+		{
+			name:    "invariant is reported on the other changed field",
+			current: current,
+			changes: map[byte]any{testHighDP: int32(10)},
+			want:    []FieldError{{Field: "high", Message: "must not be less than low"}},
+		},
+		{
+			name:    "invariant is reported on every changed field",
+			current: current,
+			changes: map[byte]any{testLowDP: int32(90), testHighDP: int32(10)},
+			want: []FieldError{
+				{Field: "low", Message: "must not be more than high"},
+				{Field: "high", Message: "must not be less than low"},
+			},
+		},
+		{
+			name:    "invariant is not reported on a field changed to its current value",
+			current: current,
+			changes: map[byte]any{testLowDP: int32(90), testHighDP: int32(80)},
+			want:    []FieldError{{Field: "low", Message: "must not be more than high"}},
+		},
+		{
+			name:    "invariant is not checked for changes to the current values",
+			current: map[byte]any{testLowDP: int32(90), testHighDP: int32(10)},
+			changes: map[byte]any{testLowDP: int32(90), testHighDP: int32(10)},
+		},
+		{
+			name:    "invariant is reported on a datapoint without a constraint",
+			current: map[byte]any{testLevelDP: int32(10), testFlagDP: false},
+			changes: map[byte]any{testFlagDP: true},
+			want:    []FieldError{{Field: "datapoint_5", Message: "must be off at level 10"}},
+		},
+		{
+			name:    "invalid current values do not block unrelated changes",
+			current: map[byte]any{testLowDP: int32(90), testHighDP: int32(10)},
+			changes: map[byte]any{testColorDP: uint32(0)},
+		},
+		{
+			name:    "invariant with unknown values is not checked",
+			current: map[byte]any{},
+			changes: map[byte]any{testLowDP: int32(90)},
+		},
+		{
+			name:    "changes are checked on their own without current values",
+			current: nil,
+			changes: map[byte]any{testLowDP: int32(90), testHighDP: int32(10)},
+			want: []FieldError{
+				{Field: "low", Message: "must not be more than high"},
+				{Field: "high", Message: "must not be less than low"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := testConstraints.Validate(test.current, test.changes)
+			if len(test.want) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %s", err)
+				}
+				return
+			}
+
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("expected a ValidationError, got %v", err)
+			}
+			if !slices.Equal(validationErr.Fields, test.want) {
+				t.Fatalf("got %v, want %v", validationErr.Fields, test.want)
+			}
+		})
+	}
+}
+
+func TestValidationErrorMessages(t *testing.T) {
+	err := &ValidationError{Fields: []FieldError{
+		{Field: "low", Message: "must be between 0 and 100"},
+		{Field: "low", Message: "must not be more than high"},
+		{Field: "high", Message: "must not be less than low"},
+	}}
+
+	want := "invalid low: must be between 0 and 100, low: must not be more than high, high: must not be less than low"
+	if err.Error() != want {
+		t.Errorf("got %q, want %q", err.Error(), want)
+	}
+
+	messages := err.FieldMessages()
+	if len(messages) != 2 || messages["low"] != "must be between 0 and 100" || messages["high"] != "must not be less than low" {
+		t.Errorf("unexpected field messages %v", messages)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"github.com/cybre/fingerbot-web/internal/logging"
 	"github.com/cybre/fingerbot-web/internal/tuyable/packet"
 	"github.com/go-ble/ble"
+	"github.com/google/uuid"
 )
 
 const (
@@ -22,6 +23,9 @@ const (
 	// ResponseWaitTimeout is the timeout for waiting for a response from the device
 	ResponseWaitTimeout = 15 * time.Second
 
+	// DatapointListenerBuffer is the number of datapoint reports buffered per listener
+	DatapointListenerBuffer = 16
+
 	// GattMTU is the maximum size of a GATT packet
 	// https://developer.tuya.com/en/docs/iot-device-dev/tuya-ble-sdk-user-guide?id=K9h5zc4e5djd9#title-6-MTU
 	GattMTU = 20
@@ -60,6 +64,9 @@ type Device struct {
 	flags             byte
 	isBound           bool
 	datapoints        map[byte]DataPoint
+	datapointsMutex   sync.RWMutex
+	listeners         map[string]chan DataPoint
+	listenersMutex    sync.Mutex
 	assembler         *packet.Assembler
 	logger            *slog.Logger
 }
@@ -87,6 +94,7 @@ func NewDevice(address, name, uuid, deviceID, localKey string, logger *slog.Logg
 		responseCh:      make(map[uint32]chan []byte),
 		protocolVersion: 3, // Default protocol version
 		datapoints:      make(map[byte]DataPoint),
+		listeners:       make(map[string]chan DataPoint),
 		logger:          logger.With("component", "Device", "address", address),
 		assembler:       packet.NewAssemmbler(logger.With("component", "Assembler")),
 	}, nil
@@ -94,6 +102,10 @@ func NewDevice(address, name, uuid, deviceID, localKey string, logger *slog.Logg
 
 // Connect connects to the Tuya BLE device
 func (d *Device) Connect(ctx context.Context) error {
+	if err := Init(); err != nil {
+		return err
+	}
+
 	d.logger.Info("Connecting to device...")
 	client, err := ble.Dial(ble.WithSigHandler(context.WithTimeout(ctx, BLEConnectTimeout)), ble.NewAddr(d.address))
 	if err != nil {
@@ -203,10 +215,34 @@ func (d *Device) Update() error {
 
 // GetDatapoint returns the requested data point
 func (d *Device) GetDatapoint(id byte) (DataPoint, bool) {
+	d.datapointsMutex.RLock()
+	defer d.datapointsMutex.RUnlock()
+
 	dp, exists := d.datapoints[id]
 	return dp, exists
 }
 
+// SubscribeDatapoints returns a channel receiving every datapoint reported by the device
+// and a function which cancels the subscription
+func (d *Device) SubscribeDatapoints() (<-chan DataPoint, func()) {
+	d.listenersMutex.Lock()
+	defer d.listenersMutex.Unlock()
+
+	output := make(chan DataPoint, DatapointListenerBuffer)
+	listenerID := uuid.NewString()
+	d.listeners[listenerID] = output
+
+	return output, func() {
+		d.listenersMutex.Lock()
+		defer d.listenersMutex.Unlock()
+
+		if _, ok := d.listeners[listenerID]; ok {
+			delete(d.listeners, listenerID)
+			close(output)
+		}
+	}
+}
+
 // SetDatapointValue sets the value of a data point
 func (d *Device) SetDatapoint(dp DataPoint) error {
 	if err := dp.Validate(); err != nil {
@@ -494,11 +530,14 @@ func (d *Device) parseDatapoints(payload []byte) error {
 			return fmt.Errorf("error creating datapoint: %w", err)
 		}
 
+		d.datapointsMutex.Lock()
 		d.datapoints[id] = datapoint
+		d.datapointsMutex.Unlock()
 		d.logger.Debug(
 			"Received datapoint",
 			slog.Any("datapoint", datapoint),
 		)
+		d.notifyListeners(datapoint)
 
 		pos = nextPos
 	}
@@ -506,6 +545,20 @@ func (d *Device) parseDatapoints(payload []byte) error {
 	return nil
 }
 
+// notifyListeners forwards a received datapoint to all subscribers without blocking packet processing
+func (d *Device) notifyListeners(datapoint DataPoint) {
+	d.listenersMutex.Lock()
+	defer d.listenersMutex.Unlock()
+
+	for listenerID, listener := range d.listeners {
+		select {
+		case listener <- datapoint:
+		default:
+			d.logger.Warn("Datapoint listener is not keeping up, dropping datapoint", slog.String("listener_id", listenerID))
+		}
+	}
+}
+
 // decryptPacket decrypts the packet data
 func (d *Device) decryptPacket(data []byte) (*packet.Packet, error) {
 	securityFlag := packet.SecurityFlag(data[0])
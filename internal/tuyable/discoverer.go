@@ -94,6 +94,11 @@ func (d *Discoverer) Discover() (<-chan *DiscoveredDevice, func()) {
 				d.cancelDiscovery = nil
 			}()
 
+			if err := Init(); err != nil {
+				d.logger.Error("error scanning", slog.Any("error", err))
+				return
+			}
+
 			if err := ble.Scan(ctx, true, func(a ble.Advertisement) {
 				if len(a.ManufacturerData()) < 2 {
 					return
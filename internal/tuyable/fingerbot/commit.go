@@ -0,0 +1,178 @@
+package fingerbot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable"
+)
+
+// CommitConfirmTimeout is the time to wait for the device to report the datapoints sent by a transaction
+const CommitConfirmTimeout = 5 * time.Second
+
+// FailedDatapoint is a datapoint of a transaction which the device did not apply
+type FailedDatapoint struct {
+	Field    string
+	Expected any
+	// Reported is the value the device reported instead, nil if it reported nothing
+	Reported any
+}
+
+func (f FailedDatapoint) String() string {
+	if f.Reported == nil {
+		return fmt.Sprintf("%s (expected %v, not reported)", f.Field, f.Expected)
+	}
+
+	return fmt.Sprintf("%s (expected %v, reported %v)", f.Field, f.Expected, f.Reported)
+}
+
+// CommitError reports a transaction the device did not fully apply. The changed datapoints are rolled back
+// to their previous values.
+type CommitError struct {
+	Failed []FailedDatapoint
+	// Err is the error sending the datapoints, nil if they were sent but not applied
+	Err error
+	// RollbackErr is the error restoring the previous values, nil if the device reported them all back
+	RollbackErr error
+}
+
+func (e *CommitError) Error() string {
+	var message strings.Builder
+	message.WriteString("transaction not applied")
+	if e.Err != nil {
+		fmt.Fprintf(&message, ": %v", e.Err)
+	}
+	if len(e.Failed) > 0 {
+		fmt.Fprintf(&message, ", failed fields: %s", joinFailed(e.Failed))
+	}
+	if e.RollbackErr != nil {
+		fmt.Fprintf(&message, ", rollback failed: %v", e.RollbackErr)
+	} else {
+		message.WriteString(", rolled back")
+	}
+
+	return message.String()
+}
+
+func (e *CommitError) Unwrap() error {
+	return e.Err
+}
+
+func joinFailed(failed []FailedDatapoint) string {
+	fields := make([]string, 0, len(failed))
+	for _, f := range failed {
+		fields = append(fields, f.String())
+	}
+
+	return strings.Join(fields, ", ")
+}
+
+// commit sends the datapoints and waits for the device to report each changed value back.
+// If sending fails or a value is not reported back, the changed datapoints are rolled back.
+func (c *Fingerbot) commit(datapoints []tuyable.DataPoint) error {
+	if len(datapoints) == 0 {
+		return nil
+	}
+
+	// Only changed values are verified, the device does not always report values which stay the same
+	previous := make(map[byte]tuyable.DataPoint)
+	pending := make(map[byte]tuyable.DataPoint)
+	for _, dp := range datapoints {
+		current, ok := c.GetDatapoint(dp.ID)
+		if ok && current.Value == dp.Value {
+			continue
+		}
+		if ok {
+			previous[dp.ID] = current
+		}
+		pending[dp.ID] = dp
+	}
+
+	reports, unsubscribe := c.SubscribeDatapoints()
+	defer unsubscribe()
+
+	if err := c.SetDatapoints(datapoints); err != nil {
+		failed := make([]FailedDatapoint, 0, len(pending))
+		for _, dp := range pending {
+			failed = append(failed, FailedDatapoint{Field: DatapointName(dp.ID), Expected: DatapointValue(dp)})
+		}
+
+		return c.rollback(&CommitError{Failed: failed, Err: err}, reports, previous)
+	}
+
+	failed := waitForCommit(reports, pending)
+	if len(failed) == 0 {
+		return nil
+	}
+
+	return c.rollback(&CommitError{Failed: failed}, reports, previous)
+}
+
+// waitForCommit waits until every pending datapoint is reported and returns the ones reported with another value
+// or not reported at all
+func waitForCommit(reports <-chan tuyable.DataPoint, pending map[byte]tuyable.DataPoint) []FailedDatapoint {
+	timer := time.NewTimer(CommitConfirmTimeout)
+	defer timer.Stop()
+
+	var failed []FailedDatapoint
+	for len(pending) > 0 {
+		select {
+		case report, ok := <-reports:
+			if !ok {
+				return append(failed, unreported(pending)...)
+			}
+			expected, ok := pending[report.ID]
+			if !ok {
+				continue
+			}
+			delete(pending, report.ID)
+			if report.Value != expected.Value {
+				failed = append(failed, FailedDatapoint{
+					Field:    DatapointName(expected.ID),
+					Expected: DatapointValue(expected),
+					Reported: DatapointValue(report),
+				})
+			}
+		case <-timer.C:
+			return append(failed, unreported(pending)...)
+		}
+	}
+
+	return failed
+}
+
+func unreported(pending map[byte]tuyable.DataPoint) []FailedDatapoint {
+	failed := make([]FailedDatapoint, 0, len(pending))
+	for _, dp := range pending {
+		failed = append(failed, FailedDatapoint{Field: DatapointName(dp.ID), Expected: DatapointValue(dp)})
+	}
+
+	return failed
+}
+
+// rollback restores the previous values of the changed datapoints and waits for the device to report them back like
+// commit does, the values it did not restore are reported in RollbackErr
+func (c *Fingerbot) rollback(
+	commitErr *CommitError, reports <-chan tuyable.DataPoint, previous map[byte]tuyable.DataPoint,
+) error {
+	if len(previous) == 0 {
+		return commitErr
+	}
+
+	datapoints := make([]tuyable.DataPoint, 0, len(previous))
+	for _, dp := range previous {
+		datapoints = append(datapoints, dp)
+	}
+
+	if err := c.SetDatapoints(datapoints); err != nil {
+		commitErr.RollbackErr = err
+		return commitErr
+	}
+
+	if failed := waitForCommit(reports, previous); len(failed) > 0 {
+		commitErr.RollbackErr = fmt.Errorf("fields not restored: %s", joinFailed(failed))
+	}
+
+	return commitErr
+}
@@ -0,0 +1,317 @@
+package fingerbot
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable"
+)
+
+// fakeDevice stores the datapoints it is sent and reports them back to the subscribers like a device would
+type fakeDevice struct {
+	mutex      sync.Mutex
+	datapoints map[byte]tuyable.DataPoint
+	listeners  map[chan tuyable.DataPoint]struct{}
+	// sent holds the datapoints of every SetDatapoints call
+	sent [][]tuyable.DataPoint
+	// errs are returned by the SetDatapoints calls in order, a call past the end succeeds
+	errs []error
+	// reply returns the datapoint the device reports for a datapoint sent in the call, or false to report nothing.
+	// The device applies and reports every datapoint if it is nil.
+	reply func(call int, dp tuyable.DataPoint) (tuyable.DataPoint, bool)
+}
+
+func newFakeDevice(config Configuration) *fakeDevice {
+	return &fakeDevice{
+		datapoints: map[byte]tuyable.DataPoint{
+			SwitchDP:           tuyable.NewDataPoint(SwitchDP, tuyable.DPTypeBool, false),
+			ModeDP:             tuyable.NewDataPoint(ModeDP, tuyable.DPTypeEnum, uint32(config.Mode)),
+			ClickSustainTimeDP: tuyable.NewDataPoint(ClickSustainTimeDP, tuyable.DPTypeValue, config.ClickSustainTime),
+			ControlBackDP:      tuyable.NewDataPoint(ControlBackDP, tuyable.DPTypeEnum, uint32(config.ControlBack)),
+			ArmDownPercentDP:   tuyable.NewDataPoint(ArmDownPercentDP, tuyable.DPTypeValue, config.ArmDownPercent),
+			ArmUpPercentDP:     tuyable.NewDataPoint(ArmUpPercentDP, tuyable.DPTypeValue, config.ArmUpPercent),
+		},
+		listeners: map[chan tuyable.DataPoint]struct{}{},
+	}
+}
+
+func (d *fakeDevice) Connect(ctx context.Context) error { return nil }
+func (d *fakeDevice) Disconnect() error                 { return nil }
+func (d *fakeDevice) Pair() error                       { return nil }
+func (d *fakeDevice) Update() error                     { return nil }
+func (d *fakeDevice) GetAddress() string                { return "AA:BB:CC:DD:EE:FF" }
+func (d *fakeDevice) GetName() string                   { return "Fingerbot" }
+
+func (d *fakeDevice) GetDatapoint(id byte) (tuyable.DataPoint, bool) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	dp, ok := d.datapoints[id]
+	return dp, ok
+}
+
+func (d *fakeDevice) SubscribeDatapoints() (<-chan tuyable.DataPoint, func()) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	output := make(chan tuyable.DataPoint, tuyable.DatapointListenerBuffer)
+	d.listeners[output] = struct{}{}
+
+	return output, func() {
+		d.mutex.Lock()
+		defer d.mutex.Unlock()
+
+		if _, ok := d.listeners[output]; ok {
+			delete(d.listeners, output)
+			close(output)
+		}
+	}
+}
+
+func (d *fakeDevice) SetDatapoint(dp tuyable.DataPoint) error {
+	return d.SetDatapoints([]tuyable.DataPoint{dp})
+}
+
+func (d *fakeDevice) SetDatapoints(datapoints []tuyable.DataPoint) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	call := len(d.sent)
+	d.sent = append(d.sent, slices.Clone(datapoints))
+	if call < len(d.errs) && d.errs[call] != nil {
+		return d.errs[call]
+	}
+
+	for _, dp := range datapoints {
+		reported, ok := dp, true
+		if d.reply != nil {
+			reported, ok = d.reply(call, dp)
+		}
+		if !ok {
+			continue
+		}
+		d.datapoints[reported.ID] = reported
+		for listener := range d.listeners {
+			listener <- reported
+		}
+	}
+
+	return nil
+}
+
+// sentValues returns the values sent in the call by datapoint
+func (d *fakeDevice) sentValues(call int) map[byte]any {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	values := map[byte]any{}
+	if call < len(d.sent) {
+		for _, dp := range d.sent[call] {
+			values[dp.ID] = dp.Value
+		}
+	}
+
+	return values
+}
+
+// testConfiguration is the configuration of the fake devices
+var testConfiguration = Configuration{
+	Mode: ModeClick, ClickSustainTime: 1, ControlBack: ControlBackUp, ArmDownPercent: 80, ArmUpPercent: 20,
+}
+
+// setArmPercent changes the arm up and down percents to 30 and 70
+func setArmPercent(t *FingerbotTransaction) error {
+	t.SetArmPercent(30, 70)
+	return nil
+}
+
+func assertCommitError(t *testing.T, err error) *CommitError {
+	t.Helper()
+
+	var commitErr *CommitError
+	if !errors.As(err, &commitErr) {
+		t.Fatalf("expected a CommitError, got %v", err)
+	}
+
+	return commitErr
+}
+
+// assertFailed checks the failed datapoints regardless of their order
+func assertFailed(t *testing.T, got []FailedDatapoint, want ...FailedDatapoint) {
+	t.Helper()
+
+	sortFailed := func(x, y FailedDatapoint) int { return strings.Compare(x.Field, y.Field) }
+	slices.SortFunc(got, sortFailed)
+	slices.SortFunc(want, sortFailed)
+	if !slices.Equal(got, want) {
+		t.Fatalf("got failed datapoints %v, want %v", got, want)
+	}
+}
+
+// assertRolledBack checks that the call restored the arm percents of testConfiguration
+func assertRolledBack(t *testing.T, device *fakeDevice, call int) {
+	t.Helper()
+
+	values := device.sentValues(call)
+	if len(values) != 2 || values[ArmUpPercentDP] != int32(20) || values[ArmDownPercentDP] != int32(80) {
+		t.Fatalf("expected the previous arm percents to be sent, got %v", values)
+	}
+}
+
+func TestCommitConfirmed(t *testing.T) {
+	device := newFakeDevice(testConfiguration)
+	bot := NewFingerbot(device)
+
+	if err := bot.Transaction(setArmPercent); err != nil {
+		t.Fatalf("error committing: %s", err)
+	}
+
+	if len(device.sent) != 1 {
+		t.Fatalf("expected a single send, got %d", len(device.sent))
+	}
+	if config := bot.Configuration(); config.ArmUpPercent != 30 || config.ArmDownPercent != 70 {
+		t.Fatalf("expected the arm percents to be applied, got %+v", config)
+	}
+}
+
+func TestCommitUnchanged(t *testing.T) {
+	device := newFakeDevice(testConfiguration)
+	// The device does not report values which stay the same
+	device.reply = func(call int, dp tuyable.DataPoint) (tuyable.DataPoint, bool) {
+		current := device.datapoints[dp.ID]
+		return dp, current.Value != dp.Value
+	}
+	bot := NewFingerbot(device)
+
+	if err := bot.Transaction(func(t *FingerbotTransaction) error {
+		t.SetMode(ModeClick)
+		t.SetArmPercent(30, 80)
+		return nil
+	}); err != nil {
+		t.Fatalf("error committing: %s", err)
+	}
+}
+
+func TestCommitTimeout(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for the commit timeout")
+	}
+
+	device := newFakeDevice(testConfiguration)
+	// The first send is never reported, the rollback is
+	device.reply = func(call int, dp tuyable.DataPoint) (tuyable.DataPoint, bool) {
+		return dp, call > 0
+	}
+	bot := NewFingerbot(device)
+
+	commitErr := assertCommitError(t, bot.Transaction(setArmPercent))
+	assertFailed(t, commitErr.Failed,
+		FailedDatapoint{Field: "arm_down_percent", Expected: int32(70)},
+		FailedDatapoint{Field: "arm_up_percent", Expected: int32(30)},
+	)
+	if commitErr.Err != nil || commitErr.RollbackErr != nil {
+		t.Fatalf("expected only failed datapoints, got %v", commitErr)
+	}
+	assertRolledBack(t, device, 1)
+	if !strings.Contains(commitErr.Error(), "not reported") || !strings.HasSuffix(commitErr.Error(), "rolled back") {
+		t.Fatalf("unexpected message %q", commitErr)
+	}
+}
+
+func TestCommitReportedOtherValue(t *testing.T) {
+	device := newFakeDevice(testConfiguration)
+	// The device caps the arm down percent on the first send
+	device.reply = func(call int, dp tuyable.DataPoint) (tuyable.DataPoint, bool) {
+		if call == 0 && dp.ID == ArmDownPercentDP {
+			return tuyable.NewDataPoint(dp.ID, dp.Type, int32(60)), true
+		}
+		return dp, true
+	}
+	bot := NewFingerbot(device)
+
+	commitErr := assertCommitError(t, bot.Transaction(setArmPercent))
+	assertFailed(t, commitErr.Failed,
+		FailedDatapoint{Field: "arm_down_percent", Expected: int32(70), Reported: int32(60)},
+	)
+	if commitErr.Err != nil || commitErr.RollbackErr != nil {
+		t.Fatalf("expected only failed datapoints, got %v", commitErr)
+	}
+	assertRolledBack(t, device, 1)
+	if config := bot.Configuration(); config != testConfiguration {
+		t.Fatalf("expected the configuration to be restored, got %+v", config)
+	}
+}
+
+func TestCommitSendError(t *testing.T) {
+	sendErr := errors.New("not connected")
+	device := newFakeDevice(testConfiguration)
+	device.errs = []error{sendErr}
+	bot := NewFingerbot(device)
+
+	err := bot.Transaction(setArmPercent)
+	commitErr := assertCommitError(t, err)
+	if !errors.Is(err, sendErr) {
+		t.Fatalf("expected the send error to be wrapped, got %v", err)
+	}
+	assertFailed(t, commitErr.Failed,
+		FailedDatapoint{Field: "arm_down_percent", Expected: int32(70)},
+		FailedDatapoint{Field: "arm_up_percent", Expected: int32(30)},
+	)
+	if commitErr.RollbackErr != nil {
+		t.Fatalf("expected the rollback to succeed, got %s", commitErr.RollbackErr)
+	}
+	assertRolledBack(t, device, 1)
+}
+
+func TestCommitRollbackFailure(t *testing.T) {
+	t.Run("send error", func(t *testing.T) {
+		rollbackErr := errors.New("disconnected")
+		device := newFakeDevice(testConfiguration)
+		device.errs = []error{nil, rollbackErr}
+		device.reply = func(call int, dp tuyable.DataPoint) (tuyable.DataPoint, bool) {
+			return tuyable.NewDataPoint(dp.ID, dp.Type, int32(50)), true
+		}
+		bot := NewFingerbot(device)
+
+		commitErr := assertCommitError(t, bot.Transaction(setArmPercent))
+		assertFailed(t, commitErr.Failed,
+			FailedDatapoint{Field: "arm_down_percent", Expected: int32(70), Reported: int32(50)},
+			FailedDatapoint{Field: "arm_up_percent", Expected: int32(30), Reported: int32(50)},
+		)
+		if !errors.Is(commitErr.RollbackErr, rollbackErr) {
+			t.Fatalf("expected the rollback error, got %v", commitErr.RollbackErr)
+		}
+		if !strings.HasSuffix(commitErr.Error(), "rollback failed: disconnected") {
+			t.Fatalf("unexpected message %q", commitErr)
+		}
+	})
+
+	t.Run("not restored", func(t *testing.T) {
+		device := newFakeDevice(testConfiguration)
+		// The device reports 50 for every value, so the rollback is not applied either
+		device.reply = func(call int, dp tuyable.DataPoint) (tuyable.DataPoint, bool) {
+			return tuyable.NewDataPoint(dp.ID, dp.Type, int32(50)), true
+		}
+		bot := NewFingerbot(device)
+
+		commitErr := assertCommitError(t, bot.Transaction(setArmPercent))
+		if commitErr.Err != nil {
+			t.Fatalf("expected no send error, got %s", commitErr.Err)
+		}
+		assertRolledBack(t, device, 1)
+		if commitErr.RollbackErr == nil {
+			t.Fatalf("expected a rollback error")
+		}
+		for _, field := range []string{
+			"arm_down_percent (expected 80, reported 50)", "arm_up_percent (expected 20, reported 50)",
+		} {
+			if !strings.Contains(commitErr.RollbackErr.Error(), field) {
+				t.Fatalf("expected %q in the rollback error, got %q", field, commitErr.RollbackErr)
+			}
+		}
+	})
+}
@@ -0,0 +1,46 @@
+package fingerbot
+
+import "github.com/cybre/fingerbot-web/internal/tuyable"
+
+// Constraints declares the valid configuration of a Fingerbot, it is used by the setters, transactions and
+// Configuration.Validate alike
+var Constraints = tuyable.Constraints{
+	Datapoints: []tuyable.Constraint{
+		{ID: ModeDP, Field: "mode", Values: []uint32{uint32(ModeClick), uint32(ModelongPress)}},
+		{ID: ClickSustainTimeDP, Field: "click_sustain_time", Min: MinClickSustainTime, Max: MaxClickSustainTime},
+		{ID: ControlBackDP, Field: "control_back", Values: []uint32{uint32(ControlBackUp), uint32(ControlBackDown)}},
+		{ID: ArmDownPercentDP, Field: "arm_down_percent", Min: MinArmPercent, Max: MaxArmPercent},
+		{ID: ArmUpPercentDP, Field: "arm_up_percent", Min: MinArmPercent, Max: MaxArmPercent},
+	},
+	Invariants: []tuyable.Invariant{
+		{
+			IDs: []byte{ArmDownPercentDP, ArmUpPercentDP},
+			Messages: map[byte]string{
+				ArmDownPercentDP: "must not be less than the arm up percent",
+				ArmUpPercentDP:   "must not be more than the arm down percent",
+			},
+			Valid: func(values map[byte]any) bool {
+				down, downOK := values[ArmDownPercentDP].(int32)
+				up, upOK := values[ArmUpPercentDP].(int32)
+				// Values of the wrong type are reported by the datapoint constraints
+				return !downOK || !upOK || down >= up
+			},
+		},
+	},
+}
+
+// values returns the configuration as datapoint values
+func (c Configuration) values() map[byte]any {
+	return map[byte]any{
+		ModeDP:             uint32(c.Mode),
+		ClickSustainTimeDP: c.ClickSustainTime,
+		ControlBackDP:      uint32(c.ControlBack),
+		ArmDownPercentDP:   c.ArmDownPercent,
+		ArmUpPercentDP:     c.ArmUpPercent,
+	}
+}
+
+// validate checks datapoint changes against the current configuration of the device
+func (c *Fingerbot) validate(changes map[byte]any) error {
+	return Constraints.Validate(c.Configuration().values(), changes)
+}
@@ -0,0 +1,172 @@
+package fingerbot
+
+import (
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable"
+)
+
+// assertFieldErrors checks the validation messages by field
+func assertFieldErrors(t *testing.T, err error, want map[string]string) {
+	t.Helper()
+
+	if len(want) == 0 {
+		if err != nil {
+			t.Fatalf("expected no error, got %s", err)
+		}
+		return
+	}
+
+	var validationErr *tuyable.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a ValidationError, got %v", err)
+	}
+	if got := validationErr.FieldMessages(); !maps.Equal(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+}
+
+func TestConfigurationValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(config *Configuration)
+		want   map[string]string
+	}{
+		{name: "valid", modify: func(config *Configuration) {}},
+		{name: "arm up equal to arm down", modify: func(config *Configuration) {
+			config.ArmUpPercent, config.ArmDownPercent = 50, 50
+		}},
+		{
+			name: "arm up above arm down",
+			modify: func(config *Configuration) {
+				config.ArmUpPercent, config.ArmDownPercent = 60, 40
+			},
+			want: map[string]string{
+				"arm_up_percent":   "must not be more than the arm down percent",
+				"arm_down_percent": "must not be less than the arm up percent",
+			},
+		},
+		{
+			name: "out of range",
+			modify: func(config *Configuration) {
+				config.ClickSustainTime, config.ArmDownPercent = MaxClickSustainTime+1, MaxArmPercent+1
+			},
+			want: map[string]string{
+				"click_sustain_time": "must be between 0 and 10",
+				"arm_down_percent":   "must be between 0 and 100",
+			},
+		},
+		{
+			name: "unknown enum values",
+			modify: func(config *Configuration) {
+				config.Mode, config.ControlBack = Mode(7), ControlBack(7)
+			},
+			want: map[string]string{
+				"mode":         "7 is not a valid value",
+				"control_back": "7 is not a valid value",
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfiguration
+			test.modify(&config)
+
+			assertFieldErrors(t, config.Validate(), test.want)
+		})
+	}
+}
+
+// TestSetArmPercent covers the setters which used to reject any arm up percent below the arm down percent
+func TestSetArmPercent(t *testing.T) {
+	tests := []struct {
+		name  string
+		set   func(bot *Fingerbot) error
+		want  map[string]string
+		check func(config Configuration) bool
+	}{
+		{
+			name:  "arm up below arm down",
+			set:   func(bot *Fingerbot) error { return bot.SetArmUpPercent(30) },
+			check: func(config Configuration) bool { return config.ArmUpPercent == 30 },
+		},
+		{
+			name:  "arm up equal to arm down",
+			set:   func(bot *Fingerbot) error { return bot.SetArmUpPercent(80) },
+			check: func(config Configuration) bool { return config.ArmUpPercent == 80 },
+		},
+		{
+			name: "arm up above arm down",
+			set:  func(bot *Fingerbot) error { return bot.SetArmUpPercent(90) },
+			want: map[string]string{"arm_up_percent": "must not be more than the arm down percent"},
+		},
+		{
+			name:  "arm down above arm up",
+			set:   func(bot *Fingerbot) error { return bot.SetArmDownPercent(50) },
+			check: func(config Configuration) bool { return config.ArmDownPercent == 50 },
+		},
+		{
+			name: "arm down below arm up",
+			set:  func(bot *Fingerbot) error { return bot.SetArmDownPercent(10) },
+			want: map[string]string{"arm_down_percent": "must not be less than the arm up percent"},
+		},
+		{
+			name: "arm up out of range",
+			set:  func(bot *Fingerbot) error { return bot.SetArmUpPercent(-1) },
+			want: map[string]string{"arm_up_percent": "must be between 0 and 100"},
+		},
+		{
+			name: "both in a transaction",
+			set: func(bot *Fingerbot) error {
+				return bot.Transaction(func(t *FingerbotTransaction) error {
+					t.SetArmPercent(40, 60)
+					return nil
+				})
+			},
+			check: func(config Configuration) bool { return config.ArmUpPercent == 40 && config.ArmDownPercent == 60 },
+		},
+		{
+			name: "swapped in a transaction",
+			set: func(bot *Fingerbot) error {
+				return bot.Transaction(func(t *FingerbotTransaction) error {
+					t.SetArmPercent(60, 40)
+					return nil
+				})
+			},
+			want: map[string]string{
+				"arm_up_percent":   "must not be more than the arm down percent",
+				"arm_down_percent": "must not be less than the arm up percent",
+			},
+		},
+		{
+			name: "by name in a transaction",
+			set: func(bot *Fingerbot) error {
+				return bot.Transaction(func(t *FingerbotTransaction) error {
+					return t.SetDatapoint("arm_down_percent", "10")
+				})
+			},
+			// The arm up percent is staged unchanged, the failure points at the arm down percent only
+			want: map[string]string{"arm_down_percent": "must not be less than the arm up percent"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			device := newFakeDevice(testConfiguration)
+			bot := NewFingerbot(device)
+
+			err := test.set(bot)
+			assertFieldErrors(t, err, test.want)
+			if err != nil {
+				if len(device.sent) > 0 {
+					t.Fatalf("expected nothing to be sent, got %v", device.sent)
+				}
+				return
+			}
+			if config := bot.Configuration(); !test.check(config) {
+				t.Fatalf("unexpected configuration %+v", config)
+			}
+		})
+	}
+}
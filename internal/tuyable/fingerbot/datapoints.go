@@ -0,0 +1,89 @@
+package fingerbot
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable"
+)
+
+// DatapointNames maps the Fingerbot datapoint IDs to readable names
+var DatapointNames = map[byte]string{
+	SwitchDP:           "switch",
+	ModeDP:             "mode",
+	ClickSustainTimeDP: "click_sustain_time",
+	ControlBackDP:      "control_back",
+	ArmDownPercentDP:   "arm_down_percent",
+	ArmUpPercentDP:     "arm_up_percent",
+	ChargeStatusDP:     "charge_status",
+	BatteryPercentDP:   "battery_percent",
+}
+
+// DatapointName returns the readable name of a datapoint, falling back to its ID
+func DatapointName(id byte) string {
+	if name, ok := DatapointNames[id]; ok {
+		return name
+	}
+
+	return strconv.Itoa(int(id))
+}
+
+// DatapointValue returns the readable value of a datapoint, enums are converted to their names
+func DatapointValue(dp tuyable.DataPoint) any {
+	switch dp.ID {
+	case ModeDP:
+		return Mode(dp.Value.(uint32)).String()
+	case ControlBackDP:
+		return ControlBack(dp.Value.(uint32)).String()
+	case ChargeStatusDP:
+		return ChargeStatus(dp.Value.(uint32)).String()
+	default:
+		return dp.Value
+	}
+}
+
+// SetDatapoint stages a writable datapoint by name, parsing the value from its text form
+func (c *FingerbotTransaction) SetDatapoint(name, value string) error {
+	switch name {
+	case "switch":
+		open, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid switch value: %s", value)
+		}
+		c.SetSwitch(open)
+	case "mode":
+		mode, err := ParseMode(value)
+		if err != nil {
+			return err
+		}
+		c.SetMode(mode)
+	case "click_sustain_time":
+		seconds, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid click sustain time: %s", value)
+		}
+		c.SetClickSustainTime(int32(seconds))
+	case "control_back":
+		back, err := ParseControlBack(value)
+		if err != nil {
+			return err
+		}
+		c.SetControlBack(back)
+	case "arm_down_percent":
+		percent, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid arm down percent: %s", value)
+		}
+		c.SetArmPercent(c.ArmUpPercent(), int32(percent))
+	case "arm_up_percent":
+		percent, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid arm up percent: %s", value)
+		}
+		c.SetArmPercent(int32(percent), c.ArmDownPercent())
+	default:
+		return fmt.Errorf("datapoint is not writable: %s", name)
+	}
+
+	return nil
+}
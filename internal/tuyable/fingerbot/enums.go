@@ -1,5 +1,10 @@
 package fingerbot
 
+import (
+	"fmt"
+	"strconv"
+)
+
 type Mode uint32
 
 const (
@@ -22,6 +27,22 @@ func (m Mode) Valid() bool {
 	return m >= ModeClick && m <= ModelongPress
 }
 
+// ParseMode parses a mode from its name or number
+func ParseMode(value string) (Mode, error) {
+	for _, mode := range []Mode{ModeClick, ModelongPress} {
+		if value == mode.String() {
+			return mode, nil
+		}
+	}
+
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || !Mode(number).Valid() {
+		return 0, fmt.Errorf("invalid mode: %s", value)
+	}
+
+	return Mode(number), nil
+}
+
 type ControlBack uint32
 
 const (
@@ -44,6 +65,22 @@ func (c ControlBack) Valid() bool {
 	return c >= ControlBackUp && c <= ControlBackDown
 }
 
+// ParseControlBack parses a control back value from its name or number
+func ParseControlBack(value string) (ControlBack, error) {
+	for _, back := range []ControlBack{ControlBackUp, ControlBackDown} {
+		if value == back.String() {
+			return back, nil
+		}
+	}
+
+	number, err := strconv.ParseUint(value, 10, 32)
+	if err != nil || !ControlBack(number).Valid() {
+		return 0, fmt.Errorf("invalid control back: %s", value)
+	}
+
+	return ControlBack(number), nil
+}
+
 type ChargeStatus uint32
 
 const (
@@ -1,7 +1,9 @@
 package fingerbot
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/cybre/fingerbot-web/internal/tuyable"
 	"github.com/cybre/fingerbot-web/internal/utils"
@@ -21,19 +23,74 @@ const (
 const (
 	MinClickSustainTime = 0
 	MaxClickSustainTime = 10
+	MinArmPercent       = 0
+	MaxArmPercent       = 100
 )
 
+// Device is the connection to a Tuya BLE device a Fingerbot sends its datapoints through, it is implemented by
+// *tuyable.Device
+type Device interface {
+	Connect(ctx context.Context) error
+	Disconnect() error
+	Pair() error
+	Update() error
+	GetDatapoint(id byte) (tuyable.DataPoint, bool)
+	SubscribeDatapoints() (<-chan tuyable.DataPoint, func())
+	SetDatapoint(dp tuyable.DataPoint) error
+	SetDatapoints(datapoints []tuyable.DataPoint) error
+	GetAddress() string
+	GetName() string
+}
+
 type Fingerbot struct {
-	*tuyable.Device
+	Device
+	mu   sync.Mutex
+	hold *hold
 }
 
-func NewFingerbot(device *tuyable.Device) *Fingerbot {
+// Configuration represents the user configurable datapoints of a Fingerbot
+type Configuration struct {
+	Mode             Mode
+	ClickSustainTime int32
+	ControlBack      ControlBack
+	ArmDownPercent   int32
+	ArmUpPercent     int32
+}
+
+func NewFingerbot(device Device) *Fingerbot {
 	return &Fingerbot{
 		Device: device,
 	}
 }
 
 func (c *Fingerbot) Transaction(callback func(*FingerbotTransaction) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.transaction(callback)
+}
+
+// Configuration returns the current configuration of the device
+func (c *Fingerbot) Configuration() Configuration {
+	return Configuration{
+		Mode:             c.Mode(),
+		ClickSustainTime: c.ClickSustainTime(),
+		ControlBack:      c.ControlBack(),
+		ArmDownPercent:   c.ArmDownPercent(),
+		ArmUpPercent:     c.ArmUpPercent(),
+	}
+}
+
+// Validate checks that the configuration can be applied to a device, see Constraints
+func (c Configuration) Validate() error {
+	return Constraints.Validate(nil, c.values())
+}
+
+func (c *Fingerbot) transaction(callback func(*FingerbotTransaction) error) error {
+	if c.hold != nil {
+		return ErrHolding
+	}
+
 	transaction := &FingerbotTransaction{
 		unconmmited: make(map[byte]tuyable.DataPoint),
 		parent:      c,
@@ -43,11 +100,15 @@ func (c *Fingerbot) Transaction(callback func(*FingerbotTransaction) error) erro
 		return fmt.Errorf("error in transaction: %w", err)
 	}
 
-	if len(transaction.errors) > 0 {
-		return fmt.Errorf("transaction errors: %v", transaction.errors)
+	changes := make(map[byte]any, len(transaction.unconmmited))
+	for id, dp := range transaction.unconmmited {
+		changes[id] = dp.Value
+	}
+	if err := c.validate(changes); err != nil {
+		return err
 	}
 
-	return c.SetDatapoints(utils.MapValues(transaction.unconmmited))
+	return c.commit(utils.MapValues(transaction.unconmmited))
 }
 
 func (c *Fingerbot) Switch() bool {
@@ -73,8 +134,8 @@ func (c *Fingerbot) Mode() Mode {
 }
 
 func (c *Fingerbot) SetMode(mode Mode) error {
-	if !mode.Valid() {
-		return fmt.Errorf("invalid mode: %d", mode)
+	if err := c.validate(map[byte]any{ModeDP: uint32(mode)}); err != nil {
+		return err
 	}
 
 	return c.SetDatapoint(tuyable.NewDataPoint(ModeDP, tuyable.DPTypeEnum, uint32(mode)))
@@ -90,8 +151,8 @@ func (c *Fingerbot) ClickSustainTime() int32 {
 }
 
 func (c *Fingerbot) SetClickSustainTime(seconds int32) error {
-	if seconds < MinClickSustainTime || seconds > MaxClickSustainTime {
-		return fmt.Errorf("invalid click sustain time: %d", seconds)
+	if err := c.validate(map[byte]any{ClickSustainTimeDP: seconds}); err != nil {
+		return err
 	}
 
 	return c.SetDatapoint(tuyable.NewDataPoint(ClickSustainTimeDP, tuyable.DPTypeValue, seconds))
@@ -107,8 +168,8 @@ func (c *Fingerbot) ControlBack() ControlBack {
 }
 
 func (c *Fingerbot) SetControlBack(back ControlBack) error {
-	if !back.Valid() {
-		return fmt.Errorf("invalid control back: %d", back)
+	if err := c.validate(map[byte]any{ControlBackDP: uint32(back)}); err != nil {
+		return err
 	}
 
 	return c.SetDatapoint(tuyable.NewDataPoint(ControlBackDP, tuyable.DPTypeEnum, uint32(back)))
@@ -124,11 +185,8 @@ func (c *Fingerbot) ArmDownPercent() int32 {
 }
 
 func (c *Fingerbot) SetArmDownPercent(percent int32) error {
-	if percent < 0 || percent > 100 {
-		return fmt.Errorf("invalid arm down percent: %d", percent)
-	}
-	if percent < c.ArmUpPercent() {
-		return fmt.Errorf("arm down percent cannot be less than arm up percent")
+	if err := c.validate(map[byte]any{ArmDownPercentDP: percent}); err != nil {
+		return err
 	}
 
 	return c.SetDatapoint(tuyable.NewDataPoint(ArmDownPercentDP, tuyable.DPTypeValue, percent))
@@ -144,11 +202,8 @@ func (c *Fingerbot) ArmUpPercent() int32 {
 }
 
 func (c *Fingerbot) SetArmUpPercent(percent int32) error {
-	if percent < 0 || percent > 100 {
-		return fmt.Errorf("invalid arm up percent: %d", percent)
-	}
-	if percent < c.ArmDownPercent() {
-		return fmt.Errorf("arm up percent cannot be greater than arm down percent")
+	if err := c.validate(map[byte]any{ArmUpPercentDP: percent}); err != nil {
+		return err
 	}
 
 	return c.SetDatapoint(tuyable.NewDataPoint(ArmUpPercentDP, tuyable.DPTypeValue, percent))
@@ -0,0 +1,140 @@
+package fingerbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/logging"
+)
+
+const (
+	// DefaultHoldDuration is the safety timeout used when no maximum hold duration is given
+	DefaultHoldDuration = 1 * time.Minute
+	// MaxHoldDuration is the longest the arm can be held down before it is released automatically
+	MaxHoldDuration = 10 * time.Minute
+	// MaxReleaseAttempts is how many times a failed release is tried before the hold is given up
+	MaxReleaseAttempts = 3
+)
+
+var (
+	ErrHolding    = errors.New("device is being held")
+	ErrNotHolding = errors.New("device is not being held")
+)
+
+type hold struct {
+	previousMode Mode
+	timer        *time.Timer
+	// failedReleases counts the releases that failed, the safety timeout retries them
+	failedReleases int
+}
+
+// Hold switches the device to long press mode and keeps the arm down until Release is called
+// or maxDuration elapses, whichever comes first
+func (c *Fingerbot) Hold(ctx context.Context, maxDuration time.Duration) (PressResult, error) {
+	if maxDuration == 0 {
+		maxDuration = DefaultHoldDuration
+	}
+	if maxDuration < 0 || maxDuration > MaxHoldDuration {
+		return PressResult{}, fmt.Errorf("invalid hold duration: %s", maxDuration)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hold != nil {
+		return PressResult{}, ErrHolding
+	}
+
+	previousMode := c.Mode()
+	if previousMode != ModelongPress {
+		if err := c.SetMode(ModelongPress); err != nil {
+			return PressResult{}, fmt.Errorf("error switching to long press mode: %w", err)
+		}
+	}
+
+	result, err := c.setSwitch(ctx, true)
+	if err != nil || !result.Confirmed {
+		if previousMode != ModelongPress {
+			err = errors.Join(err, c.SetMode(previousMode))
+		}
+		return result, err
+	}
+
+	logger := logging.FromContext(ctx)
+	h := &hold{previousMode: previousMode}
+	h.timer = time.AfterFunc(maxDuration, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		// The timer of a hold released meanwhile must not release the next one
+		if c.hold != h {
+			return
+		}
+
+		logger.Warn("hold safety timeout reached, releasing", slog.String("address", c.Address()))
+		if _, err := c.release(); err != nil {
+			logger.Error("failed to release held device", slog.String("address", c.Address()), logging.ErrAttr(err))
+		}
+	})
+	c.hold = h
+
+	return result, nil
+}
+
+// Release lifts the arm held down by Hold and restores the previous mode
+func (c *Fingerbot) Release() (PressResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.hold == nil {
+		return PressResult{}, ErrNotHolding
+	}
+
+	return c.release()
+}
+
+// release releases the current hold, the lock must be held. A failed release is retried by the safety timeout until
+// MaxReleaseAttempts is reached, then the hold is given up so the device is not stuck refusing everything else.
+func (c *Fingerbot) release() (PressResult, error) {
+	h := c.hold
+	h.timer.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), PressConfirmTimeout)
+	defer cancel()
+
+	result, err := c.setSwitch(ctx, false)
+	// The arm may still be down if the device did not report the release, so it is retried like a failed one
+	if err == nil && !result.Confirmed {
+		err = ErrPressNotConfirmed
+	}
+	if err != nil {
+		h.failedReleases++
+		if h.failedReleases >= MaxReleaseAttempts {
+			c.hold = nil
+			return result, fmt.Errorf("error releasing, gave up after %d attempts: %w", h.failedReleases, err)
+		}
+
+		h.timer.Reset(PressConfirmTimeout)
+		return result, fmt.Errorf("error releasing: %w", err)
+	}
+
+	c.hold = nil
+	if h.previousMode != ModelongPress {
+		if err := c.SetMode(h.previousMode); err != nil {
+			return result, fmt.Errorf("error restoring mode: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// Holding reports whether the arm is currently held down
+func (c *Fingerbot) Holding() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.hold != nil
+}
@@ -0,0 +1,167 @@
+package fingerbot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/tuyable"
+)
+
+const (
+	// PressConfirmTimeout is the time to wait for the device to report the switch datapoint after a press
+	PressConfirmTimeout = 5 * time.Second
+	// ReturnConfirmTimeout is the time to wait for the arm return report on top of the click sustain time
+	ReturnConfirmTimeout = 5 * time.Second
+)
+
+// ErrPressNotConfirmed is returned when the switch command was sent but the device never reported the new state
+var ErrPressNotConfirmed = errors.New("press not confirmed by the device")
+
+// PressOptions overrides the device configuration for a single press, nil fields keep the current value
+type PressOptions struct {
+	Mode           *Mode
+	HoldTime       *int32
+	ArmDownPercent *int32
+	ArmUpPercent   *int32
+	ControlBack    *ControlBack
+}
+
+// PressResult describes what the device reported back after a press
+type PressResult struct {
+	// Confirmed is true when the device reported the new switch state
+	Confirmed bool
+	// Latency is the time between sending the command and receiving the switch report
+	Latency time.Duration
+	// ExpectReturn is true when the arm is expected to return on its own (click mode)
+	ExpectReturn bool
+	// Returned is true when the device reported the arm returning
+	Returned bool
+	// ReturnLatency is the time between sending the command and receiving the return report
+	ReturnLatency time.Duration
+}
+
+// Toggle flips the switch and waits for the device to confirm it
+func (c *Fingerbot) Toggle(ctx context.Context) (PressResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.press(ctx)
+}
+
+// Press triggers a single press using the given one-off options and restores the previous configuration afterwards
+func (c *Fingerbot) Press(ctx context.Context, opts PressOptions) (PressResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous := c.Configuration()
+	if err := c.transaction(func(t *FingerbotTransaction) error {
+		if opts.Mode != nil {
+			t.SetMode(*opts.Mode)
+		}
+		if opts.HoldTime != nil {
+			t.SetClickSustainTime(*opts.HoldTime)
+		}
+		if opts.ControlBack != nil {
+			t.SetControlBack(*opts.ControlBack)
+		}
+		if opts.ArmUpPercent != nil || opts.ArmDownPercent != nil {
+			armUpPercent, armDownPercent := t.ArmUpPercent(), t.ArmDownPercent()
+			if opts.ArmUpPercent != nil {
+				armUpPercent = *opts.ArmUpPercent
+			}
+			if opts.ArmDownPercent != nil {
+				armDownPercent = *opts.ArmDownPercent
+			}
+			t.SetArmPercent(armUpPercent, armDownPercent)
+		}
+
+		return nil
+	}); err != nil {
+		return PressResult{}, fmt.Errorf("error applying press options: %w", err)
+	}
+
+	result, pressErr := c.press(ctx)
+
+	if err := c.transaction(func(t *FingerbotTransaction) error {
+		t.SetConfiguration(previous)
+		return nil
+	}); err != nil {
+		return result, errors.Join(pressErr, fmt.Errorf("error restoring configuration: %w", err))
+	}
+
+	return result, pressErr
+}
+
+// press flips the switch and waits for the switch report and, in click mode, the arm return report
+func (c *Fingerbot) press(ctx context.Context) (PressResult, error) {
+	if c.hold != nil {
+		return PressResult{}, ErrHolding
+	}
+
+	return c.setSwitch(ctx, !c.Switch())
+}
+
+// setSwitch sets the switch to the given value and waits for the device to report it, ErrPressNotConfirmed is
+// returned if it does not report it in time
+func (c *Fingerbot) setSwitch(ctx context.Context, value bool) (PressResult, error) {
+	reports, unsubscribe := c.SubscribeDatapoints()
+	defer unsubscribe()
+
+	result := PressResult{ExpectReturn: c.Mode() == ModeClick}
+
+	start := time.Now()
+	if err := c.SetSwitch(value); err != nil {
+		return result, err
+	}
+
+	dp, ok := waitForDatapoint(ctx, reports, PressConfirmTimeout, func(dp tuyable.DataPoint) bool {
+		return dp.ID == SwitchDP && dp.Value == value
+	})
+	if !ok {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+		return result, ErrPressNotConfirmed
+	}
+	result.Confirmed = true
+	result.Latency = time.Since(start)
+
+	if !result.ExpectReturn {
+		return result, nil
+	}
+
+	returnTimeout := time.Duration(c.ClickSustainTime())*time.Second + ReturnConfirmTimeout
+	if _, ok := waitForDatapoint(ctx, reports, returnTimeout, func(report tuyable.DataPoint) bool {
+		// Only the arm actually returning counts, not a repeated report of the pressed state
+		return report.ID == dp.ID && report.Value != value
+	}); ok {
+		result.Returned = true
+		result.ReturnLatency = time.Since(start)
+	}
+
+	return result, ctx.Err()
+}
+
+// waitForDatapoint waits for the first reported datapoint matching the predicate
+func waitForDatapoint(ctx context.Context, reports <-chan tuyable.DataPoint, timeout time.Duration, match func(tuyable.DataPoint) bool) (tuyable.DataPoint, bool) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case dp, ok := <-reports:
+			if !ok {
+				return tuyable.DataPoint{}, false
+			}
+			if match(dp) {
+				return dp, true
+			}
+		case <-timer.C:
+			return tuyable.DataPoint{}, false
+		case <-ctx.Done():
+			return tuyable.DataPoint{}, false
+		}
+	}
+}
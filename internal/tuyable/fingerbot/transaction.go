@@ -1,15 +1,13 @@
 package fingerbot
 
 import (
-	"fmt"
-
 	"github.com/cybre/fingerbot-web/internal/tuyable"
 )
 
+// FingerbotTransaction stages datapoint changes, they are validated against Constraints and sent together on commit
 type FingerbotTransaction struct {
 	unconmmited map[byte]tuyable.DataPoint
 	parent      *Fingerbot
-	errors      []error
 }
 
 func (c *FingerbotTransaction) Switch() bool {
@@ -33,11 +31,6 @@ func (c *FingerbotTransaction) Mode() Mode {
 }
 
 func (c *FingerbotTransaction) SetMode(mode Mode) {
-	if !mode.Valid() {
-		c.errors = append(c.errors, fmt.Errorf("invalid mode: %d", mode))
-		return
-	}
-
 	c.unconmmited[ModeDP] = tuyable.NewDataPoint(ModeDP, tuyable.DPTypeEnum, uint32(mode))
 }
 
@@ -50,11 +43,6 @@ func (c *FingerbotTransaction) ClickSustainTime() int32 {
 }
 
 func (c *FingerbotTransaction) SetClickSustainTime(seconds int32) {
-	if seconds < MinClickSustainTime || seconds > MaxClickSustainTime {
-		c.errors = append(c.errors, fmt.Errorf("invalid click sustain time: %d", seconds))
-		return
-	}
-
 	c.unconmmited[ClickSustainTimeDP] = tuyable.NewDataPoint(ClickSustainTimeDP, tuyable.DPTypeValue, seconds)
 }
 
@@ -67,11 +55,6 @@ func (c *FingerbotTransaction) ControlBack() ControlBack {
 }
 
 func (c *FingerbotTransaction) SetControlBack(back ControlBack) {
-	if !back.Valid() {
-		c.errors = append(c.errors, fmt.Errorf("invalid control back: %d", back))
-		return
-	}
-
 	c.unconmmited[ControlBackDP] = tuyable.NewDataPoint(ControlBackDP, tuyable.DPTypeEnum, uint32(back))
 }
 
@@ -92,22 +75,33 @@ func (c *FingerbotTransaction) ArmUpPercent() int32 {
 }
 
 func (c *FingerbotTransaction) SetArmPercent(armUpPercent, armDownPercent int32) {
-	if armDownPercent < 0 || armDownPercent > 100 {
-		c.errors = append(c.errors, fmt.Errorf("invalid arm down percent: %d", armDownPercent))
-	}
+	c.unconmmited[ArmUpPercentDP] = tuyable.NewDataPoint(ArmUpPercentDP, tuyable.DPTypeValue, armUpPercent)
+	c.unconmmited[ArmDownPercentDP] = tuyable.NewDataPoint(ArmDownPercentDP, tuyable.DPTypeValue, armDownPercent)
+}
 
-	if armUpPercent < 0 || armUpPercent > 100 {
-		c.errors = append(c.errors, fmt.Errorf("invalid arm up percent: %d", armUpPercent))
+// Configuration returns the configuration the device will have once the transaction is committed
+func (c *FingerbotTransaction) Configuration() Configuration {
+	return Configuration{
+		Mode:             c.Mode(),
+		ClickSustainTime: c.ClickSustainTime(),
+		ControlBack:      c.ControlBack(),
+		ArmDownPercent:   c.ArmDownPercent(),
+		ArmUpPercent:     c.ArmUpPercent(),
 	}
+}
 
-	if armDownPercent < armUpPercent {
-		c.errors = append(c.errors, fmt.Errorf("arm down percent cannot be less than arm up percent"))
+// SetConfiguration stages every field of the configuration that differs from the current value
+func (c *FingerbotTransaction) SetConfiguration(config Configuration) {
+	if config.Mode != c.Mode() {
+		c.SetMode(config.Mode)
 	}
-
-	if armUpPercent > armDownPercent {
-		c.errors = append(c.errors, fmt.Errorf("arm up percent cannot be greater than arm down percent"))
+	if config.ClickSustainTime != c.ClickSustainTime() {
+		c.SetClickSustainTime(config.ClickSustainTime)
+	}
+	if config.ControlBack != c.ControlBack() {
+		c.SetControlBack(config.ControlBack)
+	}
+	if config.ArmDownPercent != c.ArmDownPercent() || config.ArmUpPercent != c.ArmUpPercent() {
+		c.SetArmPercent(config.ArmUpPercent, config.ArmDownPercent)
 	}
-
-	c.unconmmited[ArmUpPercentDP] = tuyable.NewDataPoint(ArmUpPercentDP, tuyable.DPTypeValue, armUpPercent)
-	c.unconmmited[ArmDownPercentDP] = tuyable.NewDataPoint(ArmDownPercentDP, tuyable.DPTypeValue, armDownPercent)
 }
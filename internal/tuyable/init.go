@@ -2,15 +2,28 @@ package tuyable
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/go-ble/ble"
 	"github.com/go-ble/ble/linux"
 )
 
-func init() {
-	d, err := linux.NewDevice()
-	if err != nil {
-		panic(fmt.Errorf("error creating BLE device: %w", err))
-	}
-	ble.SetDefaultDevice(d)
+var (
+	initOnce sync.Once
+	initErr  error
+)
+
+// Init sets up the BLE adapter the devices are reached through. It runs once, connecting and scanning call it, so
+// packages importing tuyable can be loaded, e.g. in tests, without an adapter.
+func Init() error {
+	initOnce.Do(func() {
+		d, err := linux.NewDevice()
+		if err != nil {
+			initErr = fmt.Errorf("error creating BLE device: %w", err)
+			return
+		}
+		ble.SetDefaultDevice(d)
+	})
+
+	return initErr
 }
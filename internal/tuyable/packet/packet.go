@@ -7,6 +7,7 @@ import (
 	"crypto/rand"
 	"encoding/binary"
 	"fmt"
+	"log/slog"
 )
 
 // CommandType represents a command in the Tuya BLE protocol
@@ -43,6 +44,24 @@ type Packet struct {
 	Data         []byte // Encrypted data
 }
 
+// LogValue leaves out the payload of pairing requests, which carries the UUID and local key of the device
+func (p *Packet) LogValue() slog.Value {
+	payload := slog.Any("payload", p.Payload)
+	if p.CommandType == FUN_SENDER_PAIR {
+		payload = slog.String("payload", "[redacted]")
+	}
+
+	return slog.GroupValue(
+		slog.Any("seq_num", p.SeqNum),
+		slog.Any("response_to", p.ResponseTo),
+		slog.Any("command_type", p.CommandType),
+		payload,
+		slog.Any("security_flag", p.SecurityFlag),
+		slog.Any("iv", p.IV),
+		slog.Any("data", p.Data),
+	)
+}
+
 // NewPacket creates a new Packet instance
 func NewPacket(seqNum, responseTo uint32, commandType CommandType, payload []byte, securityFlag SecurityFlag) *Packet {
 	return &Packet{
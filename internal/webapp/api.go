@@ -0,0 +1,638 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/cybre/fingerbot-web/internal/api"
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/cybre/fingerbot-web/internal/tuyable"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+)
+
+const (
+	// DefaultDiscoveryTimeout is how long the discovery endpoint scans when no timeout is given
+	DefaultDiscoveryTimeout = 5 * time.Second
+	MaxDiscoveryTimeout     = time.Minute
+)
+
+// registerAPIRoutes registers a handler for every route of api.Routes, a route without a handler is a bug
+func (a *WebApp) registerAPIRoutes(e *echo.Echo) {
+	handlers := map[string]echo.HandlerFunc{
+		"listDevices":         a.handleAPIListDevices,
+		"createDevice":        a.handleAPICreateDevice,
+		"getDevice":           a.handleAPIGetDevice,
+		"updateDevice":        a.handleAPIUpdateDevice,
+		"deleteDevice":        a.handleAPIDeleteDevice,
+		"connectDevice":       a.handleAPIConnectDevice,
+		"disconnectDevice":    a.handleAPIDisconnectDevice,
+		"pressDevice":         a.handleAPIPress,
+		"getConfiguration":    a.handleAPIGetConfiguration,
+		"updateConfiguration": a.handleAPIUpdateConfiguration,
+		"getBattery":          a.handleAPIGetBattery,
+		"listDatapoints":      a.handleAPIListDatapoints,
+		"setDatapoint":        a.handleAPISetDatapoint,
+		"discoverDevices":     a.handleAPIDiscover,
+	}
+
+	group := e.Group(api.Prefix)
+	group.GET("/openapi.json", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, api.Spec)
+	})
+	for _, route := range api.Routes {
+		handler, ok := handlers[route.OperationID]
+		if !ok {
+			panic(fmt.Sprintf("no handler for API operation %s", route.OperationID))
+		}
+		group.Add(route.Method, route.EchoPath(), apiHandler(handler))
+	}
+	group.RouteNotFound("/*", apiHandler(func(c echo.Context) error {
+		return echo.ErrNotFound
+	}))
+}
+
+// apiHandler writes the errors returned by an API handler as an api.ErrorResponse
+func apiHandler(handler echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := handler(c)
+		if err == nil {
+			return nil
+		}
+
+		setRetryAfter(c, err)
+		status, apiErr := apiError(err)
+		if status == http.StatusInternalServerError {
+			logging.FromContext(c.Request().Context()).Error("API request failed", logging.ErrAttr(err))
+		}
+
+		return c.JSON(status, api.ErrorResponse{Error: apiErr})
+	}
+}
+
+func apiError(err error) (int, api.Error) {
+	var (
+		httpErr    *echo.HTTPError
+		validation *tuyable.ValidationError
+		interlock  *devices.InterlockError
+	)
+	switch {
+	case errors.As(err, &httpErr):
+		code := api.CodeBadRequest
+		switch httpErr.Code {
+		case http.StatusUnauthorized:
+			code = api.CodeUnauthorized
+		case http.StatusForbidden:
+			code = api.CodeForbidden
+		case http.StatusNotFound:
+			code = api.CodeNotFound
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			code = api.CodeConflict
+		case http.StatusTooManyRequests:
+			code = api.CodeRateLimited
+		case http.StatusLocked:
+			code = api.CodeLocked
+		case http.StatusInternalServerError:
+			code = api.CodeInternal
+		}
+		return httpErr.Code, api.Error{Code: code, Message: fmt.Sprint(httpErr.Message)}
+	case errors.As(err, &validation):
+		return http.StatusUnprocessableEntity, api.Error{
+			Code: api.CodeValidationFailed, Message: validation.Error(), Fields: validation.FieldMessages(),
+		}
+	case errors.Is(err, devices.ErrSwitchNotSettable):
+		return http.StatusBadRequest, api.Error{Code: api.CodeBadRequest, Message: err.Error()}
+	case errors.Is(err, devices.ErrConfigurationTagRequired):
+		return http.StatusPreconditionRequired, api.Error{Code: api.CodeBadRequest, Message: err.Error()}
+	case errors.Is(err, devices.ErrDeviceNotFound):
+		return http.StatusNotFound, api.Error{Code: api.CodeNotFound, Message: err.Error()}
+	case errors.Is(err, devices.ErrDeviceNotConnected):
+		return http.StatusConflict, api.Error{Code: api.CodeNotConnected, Message: err.Error()}
+	case errors.Is(err, devices.ErrConfigurationConflict), errors.Is(err, fingerbot.ErrHolding),
+		errors.Is(err, fingerbot.ErrNotHolding):
+		return http.StatusConflict, api.Error{Code: api.CodeConflict, Message: err.Error()}
+	case errors.As(err, new(*devices.ProtectionRequiredError)):
+		return http.StatusPreconditionRequired, api.Error{Code: api.CodeProtectionRequired, Message: err.Error()}
+	case errors.As(err, new(*devices.WrongPINError)):
+		return http.StatusForbidden, api.Error{Code: api.CodeForbidden, Message: err.Error()}
+	case errors.As(err, new(*devices.PINLockedError)):
+		return http.StatusLocked, api.Error{Code: api.CodeLocked, Message: err.Error()}
+	case errors.Is(err, devices.ErrConfirmationInvalid), errors.Is(err, devices.ErrHeldTooShort):
+		return http.StatusConflict, api.Error{Code: api.CodeConflict, Message: err.Error()}
+	case errors.Is(err, devices.ErrHoldNotRequired):
+		return http.StatusBadRequest, api.Error{Code: api.CodeBadRequest, Message: err.Error()}
+	case errors.As(err, &interlock):
+		code := api.CodeLocked
+		if interlockStatus(interlock) == http.StatusTooManyRequests {
+			code = api.CodeRateLimited
+		}
+		return interlockStatus(interlock), api.Error{Code: code, Message: err.Error()}
+	case errors.As(err, new(*fingerbot.CommitError)):
+		return http.StatusBadGateway, api.Error{Code: api.CodeDeviceError, Message: err.Error()}
+	case errors.Is(err, fingerbot.ErrPressNotConfirmed):
+		return http.StatusGatewayTimeout, api.Error{Code: api.CodeDeviceError, Message: err.Error()}
+	default:
+		return http.StatusInternalServerError, api.Error{Code: api.CodeInternal, Message: "internal error"}
+	}
+}
+
+func badRequest(format string, args ...any) error {
+	return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf(format, args...))
+}
+
+// paginate returns the requested page of the items and its pagination
+func paginate[T any](c echo.Context, items []T) ([]T, api.Pagination, error) {
+	pagination := api.Pagination{Total: len(items), Limit: api.DefaultLimit}
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > api.MaxLimit {
+			return nil, api.Pagination{}, badRequest("limit must be between 1 and %d", api.MaxLimit)
+		}
+		pagination.Limit = limit
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return nil, api.Pagination{}, badRequest("offset must not be negative")
+		}
+		pagination.Offset = offset
+	}
+
+	start := min(pagination.Offset, len(items))
+	end := min(start+pagination.Limit, len(items))
+	page := items[start:end]
+	if page == nil {
+		page = []T{}
+	}
+
+	return page, pagination, nil
+}
+
+func newAPIDevice(device *devices.DeviceView) api.Device {
+	return api.Device{
+		Address:   device.Address,
+		Name:      device.Name,
+		Saved:     device.Saved,
+		Connected: device.Connected,
+		RSSI:      device.RSSI,
+	}
+}
+
+func newAPIConfiguration(config fingerbot.Configuration) api.Configuration {
+	return api.Configuration{
+		Mode:             config.Mode.String(),
+		ClickSustainTime: config.ClickSustainTime,
+		ControlBack:      config.ControlBack.String(),
+		ArmDownPercent:   config.ArmDownPercent,
+		ArmUpPercent:     config.ArmUpPercent,
+	}
+}
+
+func parseAPIConfiguration(config api.Configuration) (fingerbot.Configuration, error) {
+	mode, err := fingerbot.ParseMode(config.Mode)
+	if err != nil {
+		return fingerbot.Configuration{}, err
+	}
+	back, err := fingerbot.ParseControlBack(config.ControlBack)
+	if err != nil {
+		return fingerbot.Configuration{}, err
+	}
+
+	return fingerbot.Configuration{
+		Mode:             mode,
+		ClickSustainTime: config.ClickSustainTime,
+		ControlBack:      back,
+		ArmDownPercent:   config.ArmDownPercent,
+		ArmUpPercent:     config.ArmUpPercent,
+	}, nil
+}
+
+func (a *WebApp) getAPIDevice(ctx context.Context, address string) (*devices.DeviceView, error) {
+	device, err := a.deviceManager.GetSavedDevice(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+	if device == nil {
+		return nil, fmt.Errorf("%w: %s", devices.ErrDeviceNotFound, address)
+	}
+
+	return device, nil
+}
+
+func (a *WebApp) handleAPIListDevices(c echo.Context) error {
+	saved, err := a.deviceManager.GetSavedDevices(c.Request().Context())
+	if err != nil {
+		return err
+	}
+	if token := auth.APITokenFromContext(c.Request().Context()); token != nil {
+		saved = slices.DeleteFunc(saved, func(device *devices.DeviceView) bool {
+			return token.Allows(auth.ScopePress, device.Address) != nil
+		})
+	}
+	slices.SortFunc(saved, func(x, y *devices.DeviceView) int { return strings.Compare(x.Address, y.Address) })
+
+	page, pagination, err := paginate(c, saved)
+	if err != nil {
+		return err
+	}
+
+	items := make([]api.Device, 0, len(page))
+	for _, device := range page {
+		items = append(items, newAPIDevice(device))
+	}
+
+	return c.JSON(http.StatusOK, api.DevicePage{Pagination: pagination, Items: items})
+}
+
+func (a *WebApp) handleAPICreateDevice(c echo.Context) error {
+	if err := a.authorize(c, "", auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request api.CreateDeviceRequest
+	if err := c.Bind(&request); err != nil {
+		return badRequest("invalid request body")
+	}
+	if request.Address == "" || request.Name == "" || request.DeviceID == "" || request.LocalKey == "" {
+		return badRequest("address, name, deviceId and localKey are required")
+	}
+
+	device, err := a.deviceManager.Connect(c.Request().Context(), devices.DeviceConnection{
+		Address:  request.Address,
+		Name:     request.Name,
+		DeviceID: request.DeviceID,
+		LocalKey: request.LocalKey,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusCreated, newAPIDevice(device))
+}
+
+func (a *WebApp) handleAPIGetDevice(c echo.Context) error {
+	device, err := a.getAPIDevice(c.Request().Context(), c.Param("address"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newAPIDevice(device))
+}
+
+func (a *WebApp) handleAPIUpdateDevice(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request api.UpdateDeviceRequest
+	if err := c.Bind(&request); err != nil {
+		return badRequest("invalid request body")
+	}
+	if strings.TrimSpace(request.Name) == "" {
+		return badRequest("name is required")
+	}
+
+	device, err := a.deviceManager.RenameDevice(c.Request().Context(), c.Param("address"), request.Name)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newAPIDevice(device))
+}
+
+func (a *WebApp) handleAPIDeleteDevice(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.getAPIDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+
+	if device.Connected {
+		if _, err := a.deviceManager.DisconnectDevice(ctx, device.Address); err != nil {
+			return err
+		}
+	}
+	if err := a.deviceManager.ForgetDevice(ctx, device.Address); err != nil {
+		return err
+	}
+	if err := a.auth.DeleteDeviceGrants(ctx, device.Address); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *WebApp) handleAPIConnectDevice(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleOperator); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.getAPIDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device.Connected {
+		return c.JSON(http.StatusOK, newAPIDevice(device))
+	}
+
+	connected, err := a.deviceManager.ConnectToSavedDevice(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newAPIDevice(connected))
+}
+
+func (a *WebApp) handleAPIDisconnectDevice(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	device, err := a.deviceManager.DisconnectDevice(c.Request().Context(), c.Param("address"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, newAPIDevice(device))
+}
+
+func (a *WebApp) handleAPIPress(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleOperator); err != nil {
+		return err
+	}
+
+	var request api.PressRequest
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&request); err != nil {
+			return badRequest("invalid request body")
+		}
+	}
+
+	opts := fingerbot.PressOptions{
+		HoldTime:       request.HoldTime,
+		ArmDownPercent: request.ArmDownPercent,
+		ArmUpPercent:   request.ArmUpPercent,
+	}
+	if request.Mode != nil {
+		mode, err := fingerbot.ParseMode(*request.Mode)
+		if err != nil {
+			return badRequest("%s", err)
+		}
+		opts.Mode = &mode
+	}
+	if request.ControlBack != nil {
+		back, err := fingerbot.ParseControlBack(*request.ControlBack)
+		if err != nil {
+			return badRequest("%s", err)
+		}
+		opts.ControlBack = &back
+	}
+
+	proof := devices.PressProof{
+		PIN:          c.Request().Header.Get(pinHeader),
+		Confirmation: c.Request().Header.Get(confirmationHeader),
+	}
+	if startsHold(c) {
+		if err := a.deviceManager.StartHold(c.Request().Context(), c.Param("address"), proof.Confirmation); err != nil {
+			return err
+		}
+
+		return c.NoContent(http.StatusAccepted)
+	}
+	if err := a.checkProtection(c, c.Param("address"), proof); err != nil {
+		return err
+	}
+
+	var (
+		result fingerbot.PressResult
+		err    error
+	)
+	if opts == (fingerbot.PressOptions{}) {
+		result, err = a.deviceManager.Toggle(c.Request().Context(), c.Param("address"))
+	} else {
+		result, err = a.deviceManager.Press(c.Request().Context(), c.Param("address"), opts)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, api.PressResult{
+		Confirmed:       result.Confirmed,
+		LatencyMs:       result.Latency.Milliseconds(),
+		Returned:        result.Returned,
+		ReturnLatencyMs: result.ReturnLatency.Milliseconds(),
+	})
+}
+
+func (a *WebApp) handleAPIGetConfiguration(c echo.Context) error {
+	ctx := c.Request().Context()
+	device, err := a.getAPIDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+
+	config, err := a.deviceManager.GetConfiguration(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+	c.Response().Header().Set("ETag", strconv.Quote(devices.ConfigurationTag(config)))
+
+	return c.JSON(http.StatusOK, newAPIConfiguration(config))
+}
+
+func (a *WebApp) handleAPIUpdateConfiguration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request api.Configuration
+	if err := c.Bind(&request); err != nil {
+		return badRequest("invalid request body")
+	}
+	config, err := parseAPIConfiguration(request)
+	if err != nil {
+		return badRequest("%s", err)
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.getAPIDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+
+	tag := strings.Trim(c.Request().Header.Get("If-Match"), `"`)
+	if !device.Connected {
+		if err := a.deviceManager.QueueConfiguration(ctx, device.Address, tag, config); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusAccepted)
+	}
+
+	if err := a.deviceManager.SaveConfiguration(ctx, device.Address, tag, config); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *WebApp) handleAPIGetBattery(c echo.Context) error {
+	ctx := c.Request().Context()
+	device, err := a.getAPIDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+
+	if fingerbot := a.deviceManager.GetFingerbot(device.Address); fingerbot != nil {
+		return c.JSON(http.StatusOK, api.BatteryStatus{
+			Percent:      fingerbot.BatteryPercent(),
+			ChargeStatus: fingerbot.ChargeStatus().String(),
+		})
+	}
+
+	snapshot, err := a.deviceManager.GetSnapshot(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+	if snapshot == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "the device has not reported its battery status yet")
+	}
+
+	return c.JSON(http.StatusOK, api.BatteryStatus{
+		Percent:      snapshot.BatteryPercent(),
+		ChargeStatus: snapshot.ChargeStatus().String(),
+		AsOf:         &snapshot.AsOf,
+	})
+}
+
+func (a *WebApp) handleAPIListDatapoints(c echo.Context) error {
+	ctx := c.Request().Context()
+	device, err := a.getAPIDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+
+	// The snapshot is kept up to date with every report, so it serves connected devices as well
+	snapshot, err := a.deviceManager.GetSnapshot(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	var datapoints []api.Datapoint
+	if snapshot != nil {
+		for _, dp := range snapshot.Datapoints {
+			datapoints = append(datapoints, api.Datapoint{
+				ID:    int(dp.ID),
+				Name:  fingerbot.DatapointName(dp.ID),
+				Type:  dp.Type.String(),
+				Value: fingerbot.DatapointValue(dp),
+			})
+		}
+	}
+	slices.SortFunc(datapoints, func(x, y api.Datapoint) int { return x.ID - y.ID })
+
+	page, pagination, err := paginate(c, datapoints)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, api.DatapointPage{Pagination: pagination, Items: page})
+}
+
+func (a *WebApp) handleAPISetDatapoint(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request api.SetDatapointRequest
+	if err := c.Bind(&request); err != nil {
+		return badRequest("invalid request body")
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.getAPIDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+
+	if err := a.deviceManager.SetDatapoint(ctx, device.Address, c.Param("name"), request.Value); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (a *WebApp) handleAPIDiscover(c echo.Context) error {
+	if err := a.authorize(c, "", auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	timeout := DefaultDiscoveryTimeout
+	if value := c.QueryParam("timeout"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > MaxDiscoveryTimeout {
+			return badRequest("timeout must be between 1 and %d seconds", int(MaxDiscoveryTimeout.Seconds()))
+		}
+		timeout = time.Duration(seconds) * time.Second
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+	defer cancel()
+
+	output := make(chan devices.DeviceView)
+	go func() {
+		defer close(output)
+		if err := a.deviceManager.Discover(ctx, output); err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error("failed to discover devices", logging.ErrAttr(err))
+		}
+	}()
+
+	// A device is reported on every advertisement, only the latest one is kept
+	discovered := map[string]api.Device{}
+	func() {
+		for {
+			select {
+			case device, ok := <-output:
+				if !ok {
+					return
+				}
+				discovered[device.Address] = newAPIDevice(&device)
+			case <-ctx.Done():
+				// Discover returns on the next advertisement, which must not block
+				go func() {
+					for range output {
+					}
+				}()
+				return
+			}
+		}
+	}()
+
+	items := make([]api.Device, 0, len(discovered))
+	for _, device := range discovered {
+		items = append(items, device)
+	}
+	slices.SortFunc(items, func(x, y api.Device) int { return strings.Compare(x.Address, y.Address) })
+	logging.FromContext(ctx).Debug("discovery finished", slog.Int("devices", len(items)))
+
+	page, pagination, err := paginate(c, items)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, api.DevicePage{Pagination: pagination, Items: page})
+}
@@ -0,0 +1,307 @@
+package webapp
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/cybre/fingerbot-web/internal/api"
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/secrets"
+)
+
+// newTestAPI serves the API of saved devices that are never connected, to an admin token
+func newTestAPI(t *testing.T, addresses ...string) *echo.Echo {
+	t.Helper()
+
+	e, _ := newTestAPIWithManager(t, addresses...)
+	return e
+}
+
+// newTestAPIWithManager is newTestAPI, also returning the device manager behind the API
+func newTestAPIWithManager(t *testing.T, addresses ...string) (*echo.Echo, *devices.Manager) {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("error opening database: %s", err)
+	}
+	// Every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	cipher, err := secrets.NewCipher(make([]byte, secrets.KeySize))
+	if err != nil {
+		t.Fatalf("error creating cipher: %s", err)
+	}
+
+	repository := devices.NewRepository(db, cipher)
+	for _, address := range addresses {
+		if err := repository.CreateDevice(context.Background(), &devices.Device{
+			Address: address, DeviceID: "device", Name: "Device " + address, LocalKey: "key", UUID: "uuid",
+		}); err != nil {
+			t.Fatalf("error creating device: %s", err)
+		}
+	}
+
+	a := &WebApp{
+		deviceManager: devices.NewManager(repository, history.NewRepository(db), nil, slog.Default()),
+	}
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := &auth.APIToken{Name: "test", Scope: auth.ScopeAdmin}
+			c.SetRequest(c.Request().WithContext(auth.WithAPIToken(c.Request().Context(), token)))
+			return next(c)
+		}
+	})
+	a.registerAPIRoutes(e)
+
+	return e, a.deviceManager
+}
+
+func serveAPI(t *testing.T, e *echo.Echo, request *http.Request, response any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, request)
+
+	if response != nil {
+		if err := json.Unmarshal(recorder.Body.Bytes(), response); err != nil {
+			t.Fatalf("%s %s answered with a body that is not JSON: %s", request.Method, request.URL, recorder.Body)
+		}
+	}
+
+	return recorder
+}
+
+func newAPIRequest(method, path, body string) *http.Request {
+	request := httptest.NewRequest(method, api.Prefix+path, strings.NewReader(body))
+	if body != "" {
+		request.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+
+	return request
+}
+
+func TestAPIErrors(t *testing.T) {
+	e := newTestAPI(t, "AA:00:00:00:00:01")
+
+	tests := []struct {
+		name   string
+		path   string
+		status int
+		code   string
+	}{
+		{name: "unknown device", path: "/devices/AA:00:00:00:00:02", status: http.StatusNotFound, code: api.CodeNotFound},
+		{name: "unknown route", path: "/nothing", status: http.StatusNotFound, code: api.CodeNotFound},
+		{name: "limit too large", path: "/devices?limit=201", status: http.StatusBadRequest, code: api.CodeBadRequest},
+		{name: "negative offset", path: "/devices?offset=-1", status: http.StatusBadRequest, code: api.CodeBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var response api.ErrorResponse
+			recorder := serveAPI(t, e, newAPIRequest(http.MethodGet, test.path, ""), &response)
+
+			if recorder.Code != test.status {
+				t.Errorf("status = %d, want %d", recorder.Code, test.status)
+			}
+			if response.Error.Code != test.code {
+				t.Errorf("code = %q, want %q", response.Error.Code, test.code)
+			}
+			if response.Error.Message == "" {
+				t.Error("message is empty")
+			}
+		})
+	}
+}
+
+func TestAPIPagination(t *testing.T) {
+	addresses := make([]string, 5)
+	for i := range addresses {
+		addresses[i] = fmt.Sprintf("AA:00:00:00:00:%02d", i+1)
+	}
+	e := newTestAPI(t, addresses...)
+
+	tests := []struct {
+		name       string
+		query      string
+		pagination api.Pagination
+		items      []string
+	}{
+		{
+			name:       "default limit",
+			pagination: api.Pagination{Total: 5, Limit: api.DefaultLimit},
+			items:      addresses,
+		},
+		{
+			name:       "first page",
+			query:      "?limit=2",
+			pagination: api.Pagination{Total: 5, Limit: 2},
+			items:      addresses[:2],
+		},
+		{
+			name:       "last page",
+			query:      "?limit=2&offset=4",
+			pagination: api.Pagination{Total: 5, Limit: 2, Offset: 4},
+			items:      addresses[4:],
+		},
+		{
+			name:       "past the end",
+			query:      "?offset=10",
+			pagination: api.Pagination{Total: 5, Limit: api.DefaultLimit, Offset: 10},
+			items:      []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var response api.DevicePage
+			recorder := serveAPI(t, e, newAPIRequest(http.MethodGet, "/devices"+test.query, ""), &response)
+			if recorder.Code != http.StatusOK {
+				t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+			}
+
+			if response.Pagination != test.pagination {
+				t.Errorf("pagination = %+v, want %+v", response.Pagination, test.pagination)
+			}
+			if response.Items == nil {
+				t.Fatal("items is null, want a list")
+			}
+			items := make([]string, 0, len(response.Items))
+			for _, item := range response.Items {
+				items = append(items, item.Address)
+			}
+			if !slices.Equal(items, test.items) {
+				t.Errorf("items = %v, want %v", items, test.items)
+			}
+		})
+	}
+}
+
+func TestAPIUpdateConfigurationIsConditional(t *testing.T) {
+	const address = "AA:00:00:00:00:01"
+	e := newTestAPI(t, address)
+	path := "/devices/" + address + "/configuration"
+
+	recorder := serveAPI(t, e, newAPIRequest(http.MethodGet, path, ""), &api.Configuration{})
+	tag := recorder.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("configuration has no ETag")
+	}
+
+	update := func(tag string, armDown int) *httptest.ResponseRecorder {
+		body := fmt.Sprintf(
+			`{"mode":"click","clickSustainTime":1,"controlBack":"up","armDownPercent":%d,"armUpPercent":0}`, armDown,
+		)
+		request := newAPIRequest(http.MethodPut, path, body)
+		if tag != "" {
+			request.Header.Set("If-Match", tag)
+		}
+		return serveAPI(t, e, request, nil)
+	}
+
+	if recorder := update("", 80); recorder.Code != http.StatusPreconditionRequired {
+		t.Errorf("update without If-Match: status = %d, want %d", recorder.Code, http.StatusPreconditionRequired)
+	}
+	if recorder := update(tag, 80); recorder.Code != http.StatusAccepted {
+		t.Fatalf("update: status = %d, want %d: %s", recorder.Code, http.StatusAccepted, recorder.Body)
+	}
+
+	var response api.ErrorResponse
+	recorder = update(tag, 90)
+	if recorder.Code != http.StatusConflict {
+		t.Fatalf("update with the old tag: status = %d, want %d", recorder.Code, http.StatusConflict)
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil || response.Error.Code != api.CodeConflict {
+		t.Errorf("update with the old tag: body = %s, want a %s error", recorder.Body, api.CodeConflict)
+	}
+
+	var current api.Configuration
+	serveAPI(t, e, newAPIRequest(http.MethodGet, path, ""), &current)
+	if current.ArmDownPercent != 80 {
+		t.Errorf("arm down percent = %d, want the first update 80", current.ArmDownPercent)
+	}
+}
+
+func TestAPIPressHoldProtection(t *testing.T) {
+	const address = "AA:00:00:00:00:01"
+	e, manager := newTestAPIWithManager(t, address)
+	if err := manager.SetProtection(context.Background(), address, devices.ProtectionHold, ""); err != nil {
+		t.Fatalf("error setting protection: %s", err)
+	}
+
+	press := func(confirmation string, startHold bool) (*httptest.ResponseRecorder, string) {
+		request := newAPIRequest(http.MethodPost, "/devices/"+address+"/press", "")
+		if confirmation != "" {
+			request.Header.Set(confirmationHeader, confirmation)
+		}
+		if startHold {
+			request.Header.Set(holdStartHeader, "true")
+		}
+
+		recorder := serveAPI(t, e, request, nil)
+		var response api.ErrorResponse
+		if recorder.Code != http.StatusAccepted {
+			if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+				t.Fatalf("press answered with a body that is not JSON: %s", recorder.Body)
+			}
+		}
+
+		return recorder, response.Error.Code
+	}
+	confirmation := func() string {
+		t.Helper()
+
+		recorder, code := press("", false)
+		if recorder.Code != http.StatusPreconditionRequired || code != api.CodeProtectionRequired {
+			t.Fatalf("press: status = %d, code = %q, want %d", recorder.Code, code, http.StatusPreconditionRequired)
+		}
+		if hold := recorder.Header().Get(holdHeader); hold != "1500" {
+			t.Fatalf("hold = %q, want 1500", hold)
+		}
+
+		return recorder.Header().Get(confirmationHeader)
+	}
+
+	// Waiting before confirming is not holding
+	first := confirmation()
+	time.Sleep(devices.HoldToConfirm)
+	if recorder, code := press(first, false); recorder.Code != http.StatusConflict || code != api.CodeConflict {
+		t.Errorf("press without holding: status = %d, code = %q, want %d", recorder.Code, code, http.StatusConflict)
+	}
+	// The confirmation is used up
+	if recorder, _ := press(first, true); recorder.Code != http.StatusConflict {
+		t.Errorf("holding a used confirmation: status = %d, want %d", recorder.Code, http.StatusConflict)
+	}
+
+	second := confirmation()
+	if recorder, _ := press(second, true); recorder.Code != http.StatusAccepted {
+		t.Fatalf("hold: status = %d, want %d", recorder.Code, http.StatusAccepted)
+	}
+	if recorder, code := press(second, false); recorder.Code != http.StatusConflict || code != api.CodeConflict {
+		t.Errorf("press held too briefly: status = %d, code = %q, want %d", recorder.Code, code, http.StatusConflict)
+	}
+
+	third := confirmation()
+	if recorder, _ := press(third, true); recorder.Code != http.StatusAccepted {
+		t.Fatalf("hold: status = %d, want %d", recorder.Code, http.StatusAccepted)
+	}
+	time.Sleep(devices.HoldToConfirm)
+	// The protection lets the press through to the device, which is not connected
+	if recorder, code := press(third, false); recorder.Code != http.StatusConflict || code != api.CodeNotConnected {
+		t.Errorf("press held long enough: status = %d, code = %q, want %s", recorder.Code, code, api.CodeNotConnected)
+	}
+}
@@ -0,0 +1,329 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/utils"
+	"github.com/labstack/echo/v4"
+)
+
+func (a *WebApp) handleLoginPage(c echo.Context) error {
+	var request LoginRequest
+	if err := c.Bind(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.Render(http.StatusOK, "login.html", a.loginData(request.NextURL()))
+}
+
+// loginData returns the data of the login page, which offers the ways of logging in that are enabled
+func (a *WebApp) loginData(next string) LoginData {
+	return LoginData{Next: next, LocalPasswords: a.auth.LocalPasswords(), SSO: a.oidc != nil}
+}
+
+func (a *WebApp) handleLogin(c echo.Context) error {
+	var request LoginRequest
+	if err := c.Bind(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	token, session, err := a.auth.Login(c.Request().Context(), request.Username, request.Password)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) || errors.Is(err, auth.ErrLocalPasswordsDisabled) {
+			data := a.loginData(request.NextURL())
+			data.Username, data.Error = request.Username, err.Error()
+			status := http.StatusUnauthorized
+			if errors.Is(err, auth.ErrLocalPasswordsDisabled) {
+				status = http.StatusForbidden
+			}
+
+			return c.Render(status, "login.html", data)
+		}
+
+		return fmt.Errorf("failed to log in: %w", err)
+	}
+
+	c.SetCookie(a.newSessionCookie(c, token, session.ExpiresAt))
+
+	return c.Redirect(http.StatusSeeOther, request.NextURL())
+}
+
+func (a *WebApp) handleLogout(c echo.Context) error {
+	if cookie, err := c.Cookie(sessionCookie); err == nil {
+		if err := a.auth.Logout(c.Request().Context(), cookie.Value); err != nil {
+			return fmt.Errorf("failed to log out: %w", err)
+		}
+	}
+
+	c.SetCookie(a.newSessionCookie(c, "", time.Unix(0, 0)))
+
+	return c.Redirect(http.StatusSeeOther, "/login")
+}
+
+// newSessionCookie returns the session cookie, it is secure whenever the app is served over HTTPS, e.g. through ngrok
+func (a *WebApp) newSessionCookie(c echo.Context, token string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   a.secureCookies || c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (a *WebApp) deviceNames(ctx context.Context) ([]*devices.DeviceView, map[string]string, error) {
+	savedDevices, err := a.deviceManager.GetSavedDevices(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get saved devices: %w", err)
+	}
+
+	names := map[string]string{}
+	for _, device := range savedDevices {
+		names[device.Address] = device.Name
+	}
+
+	return savedDevices, names, nil
+}
+
+func (a *WebApp) handleAPITokens(c echo.Context) error {
+	ctx := c.Request().Context()
+	tokens, err := a.auth.GetAPITokens(ctx)
+	if err != nil {
+		return err
+	}
+
+	savedDevices, names, err := a.deviceNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "api_tokens.html", APITokensData{
+		Tokens: utils.Map(tokens, func(token *auth.APIToken) APITokenItemData {
+			return NewAPITokenItemData(token, names)
+		}),
+		Devices: savedDevices,
+		Scopes:  auth.Scopes,
+	})
+}
+
+func (a *WebApp) handleCreateAPIToken(c echo.Context) error {
+	var request APITokenRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	expiresAt, err := request.ExpiresAt()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	secret, token, err := a.auth.CreateAPIToken(
+		ctx, history.SourceFromContext(ctx).String(), request.Name, auth.Scope(request.Scope), request.Devices, expiresAt,
+	)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidTokenName) || errors.Is(err, auth.ErrInvalidScope) ||
+			errors.Is(err, auth.ErrExpiryInThePast) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return err
+	}
+
+	_, names, err := a.deviceNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	item := NewAPITokenItemData(token, names)
+	item.Secret = secret
+
+	return c.Render(http.StatusOK, "fragments/api_token.html", item)
+}
+
+func (a *WebApp) handleRevokeAPIToken(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	ctx := c.Request().Context()
+	token, err := a.auth.RevokeAPIToken(ctx, id)
+	if err != nil {
+		if errors.Is(err, auth.ErrAPITokenNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return err
+	}
+
+	_, names, err := a.deviceNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/api_token.html", NewAPITokenItemData(token, names))
+}
+
+// authorize returns a 403 error unless the user of the request has the role on the device, or globally when the
+// address is empty. Requests with an API token were already checked against its scope by authenticate.
+func (a *WebApp) authorize(c echo.Context, address string, role auth.Role) error {
+	ctx := c.Request().Context()
+	if auth.APITokenFromContext(ctx) != nil {
+		return nil
+	}
+
+	if err := a.auth.Authorize(ctx, auth.UserFromContext(ctx), address, role); err != nil {
+		if errors.Is(err, auth.ErrPermissionDenied) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		return err
+	}
+
+	return nil
+}
+
+// requireAdmin guards the routes only admins may use
+func (a *WebApp) requireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if err := a.authorize(c, "", auth.RoleAdmin); err != nil {
+			return err
+		}
+
+		return next(c)
+	}
+}
+
+// permissions returns what the user or API token of the request may do on the device
+func (a *WebApp) permissions(c echo.Context, address string) (Permissions, error) {
+	ctx := c.Request().Context()
+	if token := auth.APITokenFromContext(ctx); token != nil {
+		return Permissions{
+			Press:     token.Allows(auth.ScopePress, address) == nil,
+			Configure: token.Allows(auth.ScopeConfigure, address) == nil,
+			Admin:     token.Allows(auth.ScopeAdmin, "") == nil,
+		}, nil
+	}
+
+	user := auth.UserFromContext(ctx)
+	role, err := a.auth.DeviceRole(ctx, user, address)
+	if err != nil {
+		return Permissions{}, err
+	}
+
+	return Permissions{
+		Press:     role.Includes(auth.RoleOperator),
+		Configure: role.Includes(auth.RoleAdmin),
+		Admin:     user != nil && user.Role == auth.RoleAdmin,
+	}, nil
+}
+
+func (a *WebApp) savedDeviceData(c echo.Context, device *devices.DeviceView) (SavedDeviceData, error) {
+	permissions, err := a.permissions(c, device.Address)
+	if err != nil {
+		return SavedDeviceData{}, err
+	}
+
+	return SavedDeviceData{DeviceView: device, Permissions: permissions}, nil
+}
+
+func (a *WebApp) userItemData(c echo.Context, user *auth.User) (UserItemData, error) {
+	ctx := c.Request().Context()
+	grants, err := a.auth.GetGrants(ctx, user.ID)
+	if err != nil {
+		return UserItemData{}, err
+	}
+
+	savedDevices, err := a.deviceManager.GetSavedDevices(ctx)
+	if err != nil {
+		return UserItemData{}, fmt.Errorf("failed to get saved devices: %w", err)
+	}
+
+	return NewUserItemData(user, grants, savedDevices, auth.UserFromContext(ctx)), nil
+}
+
+func (a *WebApp) handleUsers(c echo.Context) error {
+	users, err := a.auth.GetUsers(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	data := UsersData{Roles: auth.Roles, LocalPasswords: a.auth.LocalPasswords()}
+	for _, user := range users {
+		item, err := a.userItemData(c, user)
+		if err != nil {
+			return err
+		}
+		data.Users = append(data.Users, item)
+	}
+
+	return c.Render(http.StatusOK, "users.html", data)
+}
+
+func (a *WebApp) handleCreateUser(c echo.Context) error {
+	var request UserRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	user, err := a.auth.CreateUser(c.Request().Context(), request.Username, request.Password, auth.Role(request.Role))
+	if err != nil {
+		return httpError(err)
+	}
+
+	item, err := a.userItemData(c, user)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/user.html", item)
+}
+
+func (a *WebApp) handleUpdateUser(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	var request UpdateUserRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	user, err := a.auth.UpdateUser(c.Request().Context(), id, auth.Role(request.Role), request.Grants())
+	if err != nil {
+		return httpError(err)
+	}
+
+	item, err := a.userItemData(c, user)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/user.html", item)
+}
+
+func (a *WebApp) handleDeleteUser(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	if user := auth.UserFromContext(c.Request().Context()); user != nil && user.ID == id {
+		return echo.NewHTTPError(http.StatusBadRequest, "you cannot delete yourself")
+	}
+
+	if err := a.auth.DeleteUser(c.Request().Context(), id); err != nil {
+		return httpError(err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -1,6 +1,21 @@
 package webapp
 
 import (
+	"database/sql"
+	"fmt"
+	"html/template"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/rules"
+	"github.com/cybre/fingerbot-web/internal/scheduler"
+	"github.com/cybre/fingerbot-web/internal/telemetry"
+	"github.com/cybre/fingerbot-web/internal/tuyable"
 	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
 	"github.com/cybre/fingerbot-web/internal/utils"
 )
@@ -24,16 +39,48 @@ type IndexData struct {
 	Name          string
 	Address       string
 	Devices       []DeviceDropdownItem
+	Presets       []*devices.Preset
+	Battery       BatteryReportData
+	// Offline is set when the page shows the last known state of a device which is not connected
+	Offline bool
+	// AsOf is the time of the last known state, empty if the device never reported it
+	AsOf          string
+	Configuration fingerbot.Configuration
+	Permissions   Permissions
+	// Protection guards the presses, the press and hold button is hidden for protected devices as a hold cannot wait
+	// for a confirmation
+	Protection devices.Protection
+	// Maintenance is set while the device is locked for maintenance, every press is refused then
+	Maintenance       bool
+	MaintenanceReason string
 }
 
-func NewIndexData(device *fingerbot.Fingerbot, allDevices []*fingerbot.Fingerbot) IndexData {
+func NewIndexData(device *fingerbot.Fingerbot, allDevices []*fingerbot.Fingerbot, presets []*devices.Preset) IndexData {
 	currentDevice, _ := utils.Find(allDevices, func(d *fingerbot.Fingerbot) bool { return d.Address() == device.Address() })
 	return IndexData{
 		BatteryStatus: NewBatteryStatusData(device),
 		Address:       currentDevice.Address(),
 		Name:          currentDevice.Name(),
 		Devices:       NewDeviceDropdownItems(utils.Filter(allDevices, func(d *fingerbot.Fingerbot) bool { return d.Address() != currentDevice.Address() })),
+		Presets:       presets,
+	}
+}
+
+func NewOfflineIndexData(device *devices.DeviceView, snapshot *devices.Snapshot, allDevices []*fingerbot.Fingerbot, presets []*devices.Preset) IndexData {
+	data := IndexData{
+		Address: device.Address,
+		Name:    device.Name,
+		Devices: NewDeviceDropdownItems(allDevices),
+		Presets: presets,
+		Offline: true,
+	}
+	if snapshot != nil {
+		data.BatteryStatus = NewSnapshotBatteryStatusData(snapshot)
+		data.AsOf = snapshot.AsOf.Local().Format(time.DateTime)
+		data.Configuration = snapshot.Configuration()
 	}
+
+	return data
 }
 
 type ConfigurationData struct {
@@ -43,6 +90,28 @@ type ConfigurationData struct {
 	ControlBack      uint32 `json:"controlBack"`
 	ArmDownPercent   int32  `json:"armDownPercent"`
 	ArmUpPercent     int32  `json:"armUpPercent"`
+	// Offline is set when the form shows the last known configuration of a device which is not connected
+	Offline bool   `json:"-"`
+	AsOf    string `json:"-"`
+	// QueuedAt is set when the form shows a configuration waiting for the device to reconnect
+	QueuedAt string `json:"-"`
+	// Base is the configuration the form was loaded with, it is used to merge conflicting changes
+	Base *ConfigurationData `json:"base,omitempty"`
+}
+
+// Tag identifies the configuration the form was loaded with, see devices.ConfigurationTag
+func (d ConfigurationData) Tag() string {
+	return devices.ConfigurationTag(d.Configuration())
+}
+
+func (d ConfigurationData) Configuration() fingerbot.Configuration {
+	return fingerbot.Configuration{
+		Mode:             fingerbot.Mode(d.Mode),
+		ClickSustainTime: d.ClickSustainTime,
+		ControlBack:      fingerbot.ControlBack(d.ControlBack),
+		ArmDownPercent:   d.ArmDownPercent,
+		ArmUpPercent:     d.ArmUpPercent,
+	}
 }
 
 func NewConfigurationData(device *fingerbot.Fingerbot) ConfigurationData {
@@ -56,9 +125,116 @@ func NewConfigurationData(device *fingerbot.Fingerbot) ConfigurationData {
 	}
 }
 
+// NewOfflineConfigurationData shows the queued configuration if there is one, the last known configuration otherwise
+func NewOfflineConfigurationData(address string, snapshot *devices.Snapshot, pending *devices.PendingConfiguration) ConfigurationData {
+	var config fingerbot.Configuration
+	data := ConfigurationData{ID: address, Offline: true}
+	if snapshot != nil {
+		config = snapshot.Configuration()
+		data.AsOf = snapshot.AsOf.Local().Format(time.DateTime)
+	}
+	if pending != nil {
+		config = pending.Config
+		data.QueuedAt = pending.QueuedAt.Local().Format(time.DateTime)
+	}
+
+	data.Mode = uint32(config.Mode)
+	data.ClickSustainTime = config.ClickSustainTime
+	data.ControlBack = uint32(config.ControlBack)
+	data.ArmDownPercent = config.ArmDownPercent
+	data.ArmUpPercent = config.ArmUpPercent
+
+	return data
+}
+
+// ConfigurationConflictData is the merge view shown when the configuration changed after the form was loaded
+type ConfigurationConflictData struct {
+	// Tag is the tag of the current configuration, saving the merged values with it succeeds
+	Tag    string
+	Fields []ConfigurationConflictField
+}
+
+// ConfigurationConflictField is a field whose submitted value differs from the current one
+type ConfigurationConflictField struct {
+	// Name is the name of the form field
+	Name    string
+	Label   string
+	Base    any
+	Yours   any
+	Current any
+	// YoursValue and CurrentValue are the raw values set on the form when picking a side
+	YoursValue   int64
+	CurrentValue int64
+	// Conflict is set when both sides changed the field, otherwise the changed side is preselected
+	Conflict   bool
+	UseCurrent bool
+}
+
+func NewConfigurationConflictData(base *ConfigurationData, yours ConfigurationData, current fingerbot.Configuration) ConfigurationConflictData {
+	data := ConfigurationConflictData{Tag: devices.ConfigurationTag(current)}
+	mine := yours.Configuration()
+	var original *fingerbot.Configuration
+	if base != nil {
+		config := base.Configuration()
+		original = &config
+	}
+
+	add := func(name, label string, display func(fingerbot.Configuration) any, raw func(fingerbot.Configuration) int64) {
+		if raw(mine) == raw(current) {
+			return
+		}
+
+		field := ConfigurationConflictField{
+			Name:         name,
+			Label:        label,
+			Yours:        display(mine),
+			Current:      display(current),
+			YoursValue:   raw(mine),
+			CurrentValue: raw(current),
+			Conflict:     true,
+		}
+		if original != nil {
+			field.Base = display(*original)
+			changedByYou := raw(mine) != raw(*original)
+			changedByOthers := raw(current) != raw(*original)
+			field.Conflict = changedByYou && changedByOthers
+			field.UseCurrent = changedByOthers && !changedByYou
+		}
+		data.Fields = append(data.Fields, field)
+	}
+
+	add("mode", "Mode",
+		func(c fingerbot.Configuration) any { return c.Mode },
+		func(c fingerbot.Configuration) int64 { return int64(c.Mode) })
+	add("clickSustainTime", "Click sustain time",
+		func(c fingerbot.Configuration) any { return fmt.Sprintf("%ds", c.ClickSustainTime) },
+		func(c fingerbot.Configuration) int64 { return int64(c.ClickSustainTime) })
+	add("controlBack", "Control back",
+		func(c fingerbot.Configuration) any { return c.ControlBack },
+		func(c fingerbot.Configuration) int64 { return int64(c.ControlBack) })
+	add("armUpPercent", "Arm up",
+		func(c fingerbot.Configuration) any { return fmt.Sprintf("%d%%", c.ArmUpPercent) },
+		func(c fingerbot.Configuration) int64 { return int64(c.ArmUpPercent) })
+	add("armDownPercent", "Arm down",
+		func(c fingerbot.Configuration) any { return fmt.Sprintf("%d%%", c.ArmDownPercent) },
+		func(c fingerbot.Configuration) int64 { return int64(c.ArmDownPercent) })
+
+	return data
+}
+
 type BatteryStatusData struct {
 	BatteryLevel int32 `json:"batteryLevel"`
 	IsCharging   bool  `json:"isCharging"`
+	// AsOf is set when the status is the last known one of a device which is not connected
+	AsOf *time.Time `json:"asOf,omitempty"`
+}
+
+func NewSnapshotBatteryStatusData(snapshot *devices.Snapshot) BatteryStatusData {
+	return BatteryStatusData{
+		BatteryLevel: snapshot.BatteryPercent(),
+		IsCharging:   snapshot.ChargeStatus() != fingerbot.ChargeStatusNone,
+		AsOf:         &snapshot.AsOf,
+	}
 }
 
 func NewBatteryStatusData(device *fingerbot.Fingerbot) BatteryStatusData {
@@ -74,3 +250,939 @@ type ConnectDeviceRequest struct {
 	Name     string `form:"name"`
 	LocalKey string `form:"localKey"`
 }
+
+type PressRequest struct {
+	HoldTime       *int32  `json:"holdTime" form:"holdTime"`
+	ArmDownPercent *int32  `json:"armDownPercent" form:"armDownPercent"`
+	ArmUpPercent   *int32  `json:"armUpPercent" form:"armUpPercent"`
+	ControlBack    *uint32 `json:"controlBack" form:"controlBack"`
+}
+
+func (r PressRequest) Options() fingerbot.PressOptions {
+	opts := fingerbot.PressOptions{
+		HoldTime:       r.HoldTime,
+		ArmDownPercent: r.ArmDownPercent,
+		ArmUpPercent:   r.ArmUpPercent,
+	}
+	if r.ControlBack != nil {
+		controlBack := fingerbot.ControlBack(*r.ControlBack)
+		opts.ControlBack = &controlBack
+	}
+
+	return opts
+}
+
+type PressResultData struct {
+	Confirmed       bool  `json:"confirmed"`
+	LatencyMs       int64 `json:"latencyMs"`
+	ExpectReturn    bool  `json:"expectReturn"`
+	Returned        bool  `json:"returned"`
+	ReturnLatencyMs int64 `json:"returnLatencyMs"`
+}
+
+func NewPressResultData(result fingerbot.PressResult) PressResultData {
+	return PressResultData{
+		Confirmed:       result.Confirmed,
+		LatencyMs:       result.Latency.Milliseconds(),
+		ExpectReturn:    result.ExpectReturn,
+		Returned:        result.Returned,
+		ReturnLatencyMs: result.ReturnLatency.Milliseconds(),
+	}
+}
+
+type HoldRequest struct {
+	MaxDuration int `json:"maxDuration" form:"maxDuration"`
+}
+
+type PresetRequest struct {
+	Name             string `json:"name" form:"name"`
+	Mode             uint32 `json:"mode" form:"mode"`
+	ClickSustainTime int32  `json:"clickSustainTime" form:"clickSustainTime"`
+	ControlBack      uint32 `json:"controlBack" form:"controlBack"`
+	ArmDownPercent   int32  `json:"armDownPercent" form:"armDownPercent"`
+	ArmUpPercent     int32  `json:"armUpPercent" form:"armUpPercent"`
+}
+
+func (r PresetRequest) Preset(address string) *devices.Preset {
+	return &devices.Preset{
+		Address:          address,
+		Name:             r.Name,
+		Mode:             fingerbot.Mode(r.Mode),
+		ClickSustainTime: r.ClickSustainTime,
+		ControlBack:      fingerbot.ControlBack(r.ControlBack),
+		ArmDownPercent:   r.ArmDownPercent,
+		ArmUpPercent:     r.ArmUpPercent,
+	}
+}
+
+type CopyPresetRequest struct {
+	Target string `json:"target" form:"target"`
+}
+
+type PresetItemData struct {
+	Preset      *devices.Preset
+	CopyTargets []*devices.DeviceView
+}
+
+type PresetsData struct {
+	Name          string
+	Address       string
+	Configuration ConfigurationData
+	Presets       []PresetItemData
+}
+
+func NewPresetsData(device *devices.DeviceView, configuration ConfigurationData, presets []*devices.Preset, savedDevices []*devices.DeviceView) PresetsData {
+	copyTargets := utils.Filter(savedDevices, func(d *devices.DeviceView) bool { return d.Address != device.Address })
+	return PresetsData{
+		Name:          device.Name,
+		Address:       device.Address,
+		Configuration: configuration,
+		Presets: utils.Map(presets, func(p *devices.Preset) PresetItemData {
+			return PresetItemData{Preset: p, CopyTargets: copyTargets}
+		}),
+	}
+}
+
+type MacroRequest struct {
+	Name  string `json:"name" form:"name"`
+	Steps string `json:"steps" form:"steps"`
+}
+
+type RunMacroRequest struct {
+	Addresses []string `json:"addresses" form:"address" query:"address"`
+}
+
+type MacrosData struct {
+	Macros  []*devices.Macro
+	Devices []DeviceDropdownItem
+}
+
+type MacroItemData struct {
+	Macro   *devices.Macro
+	Devices []DeviceDropdownItem
+}
+
+func (d MacrosData) Items() []MacroItemData {
+	return utils.Map(d.Macros, func(m *devices.Macro) MacroItemData {
+		return MacroItemData{Macro: m, Devices: d.Devices}
+	})
+}
+
+type ScheduleRequest struct {
+	Name       string `json:"name" form:"name"`
+	Address    string `json:"address" form:"address"`
+	Expression string `json:"expression" form:"expression"`
+	Timezone   string `json:"timezone" form:"timezone"`
+	MissedRun  string `json:"missedRun" form:"missedRun"`
+	Action     string `json:"action" form:"action"`
+	PresetID   int64  `json:"presetId" form:"presetId"`
+	MacroID    int64  `json:"macroId" form:"macroId"`
+}
+
+func (r ScheduleRequest) Schedule() *scheduler.Schedule {
+	return &scheduler.Schedule{
+		Name:       r.Name,
+		Address:    r.Address,
+		Expression: r.Expression,
+		Timezone:   r.Timezone,
+		Enabled:    true,
+		MissedRun:  scheduler.MissedRunPolicy(r.MissedRun),
+		Action:     scheduler.ActionType(r.Action),
+		PresetID:   r.PresetID,
+		MacroID:    r.MacroID,
+	}
+}
+
+type PresetOption struct {
+	ID         int64
+	Address    string
+	DeviceName string
+	Name       string
+}
+
+type ScheduleItemData struct {
+	Schedule   *scheduler.Schedule
+	DeviceName string
+	NextRun    string
+	LastRun    string
+}
+
+func NewScheduleItemData(schedule *scheduler.Schedule, deviceNames map[string]string, nextRun time.Time, hasNextRun bool) ScheduleItemData {
+	item := ScheduleItemData{
+		Schedule:   schedule,
+		DeviceName: deviceNames[schedule.Address],
+		NextRun:    "-",
+		LastRun:    "never",
+	}
+	if item.DeviceName == "" {
+		item.DeviceName = schedule.Address
+	}
+	if hasNextRun {
+		item.NextRun = nextRun.Format(time.DateTime + " MST")
+	}
+	if schedule.LastRunAt.Valid {
+		item.LastRun = schedule.LastRunAt.Time.Local().Format(time.DateTime)
+	}
+
+	return item
+}
+
+type SchedulesData struct {
+	Schedules []ScheduleItemData
+	Devices   []*devices.DeviceView
+	Presets   []PresetOption
+	Macros    []*devices.Macro
+}
+
+type RuleRequest struct {
+	Name      string `json:"name" form:"name"`
+	Condition string `json:"condition" form:"condition"`
+	Actions   string `json:"actions" form:"actions"`
+	Enabled   *bool  `json:"enabled" form:"enabled"`
+	// Cooldown is in seconds, rules.DefaultCooldown is used when it is not set
+	Cooldown *int64 `json:"cooldown" form:"cooldown"`
+}
+
+func (r RuleRequest) Rule() (*rules.Rule, error) {
+	actions, err := rules.ParseActions(r.Actions)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := &rules.Rule{
+		Name:      r.Name,
+		Condition: r.Condition,
+		Actions:   actions,
+		Enabled:   r.Enabled == nil || *r.Enabled,
+		Cooldown:  rules.DefaultCooldown,
+	}
+	if r.Cooldown != nil {
+		rule.Cooldown = time.Duration(*r.Cooldown) * time.Second
+	}
+
+	return rule, nil
+}
+
+type RuleItemData struct {
+	Rule      *rules.Rule
+	LastFired string
+}
+
+func NewRuleItemData(rule *rules.Rule) RuleItemData {
+	item := RuleItemData{Rule: rule, LastFired: "never"}
+	if rule.LastFiredAt.Valid {
+		item.LastFired = rule.LastFiredAt.Time.Local().Format(time.DateTime)
+	}
+
+	return item
+}
+
+type RulesData struct {
+	Rules   []RuleItemData
+	Devices []*devices.DeviceView
+	Presets []PresetOption
+	// Editing is the rule loaded into the form, nil when creating a new rule
+	Editing *rules.Rule
+}
+
+func (d RulesData) CooldownSeconds() int64 {
+	if d.Editing == nil {
+		return int64(rules.DefaultCooldown.Seconds())
+	}
+
+	// Rules saved before the minimum was enforced are shown with the cooldown they get
+	return int64(max(d.Editing.Cooldown, rules.MinCooldown).Seconds())
+}
+
+func (d RulesData) MinCooldownSeconds() int64 {
+	return int64(rules.MinCooldown.Seconds())
+}
+
+type DryRunRequest struct {
+	Condition string `json:"condition" form:"condition"`
+}
+
+type DryRunEventData struct {
+	Time    string
+	Type    string
+	Name    string
+	Details string
+	Matched bool
+	Error   string
+}
+
+type DryRunData struct {
+	Error   string
+	Matches int
+	Events  []DryRunEventData
+}
+
+func NewDryRunData(result rules.DryRunResult) DryRunData {
+	data := DryRunData{Error: result.Error}
+	for _, outcome := range result.Events {
+		event := outcome.Event
+		item := DryRunEventData{
+			Time:    event.Time.Local().Format(time.TimeOnly),
+			Type:    string(event.Type),
+			Name:    event.Name,
+			Matched: outcome.Matched,
+			Error:   outcome.Error,
+		}
+		if item.Name == "" {
+			item.Name = event.Address
+		}
+
+		switch {
+		case event.Datapoint != nil:
+			item.Details = fmt.Sprintf("%s = %v", fingerbot.DatapointName(event.Datapoint.ID), fingerbot.DatapointValue(*event.Datapoint))
+		case event.Press != nil && event.Error != "":
+			item.Details = event.Error
+		case event.Press != nil && event.Press.Confirmed:
+			item.Details = fmt.Sprintf("confirmed in %d ms", event.Press.Latency.Milliseconds())
+		case event.Press != nil:
+			item.Details = "not confirmed"
+		}
+
+		if outcome.Matched {
+			data.Matches++
+		}
+		data.Events = append(data.Events, item)
+	}
+
+	return data
+}
+
+// HistoryPageSize is the number of entries shown per history page
+const HistoryPageSize = 50
+
+type HistoryRequest struct {
+	Address string `query:"address"`
+	Action  string `query:"action"`
+	Source  string `query:"source"`
+	Outcome string `query:"outcome"`
+	// From and To are inclusive dates in the 2006-01-02 format
+	From string `query:"from"`
+	To   string `query:"to"`
+	Page int    `query:"page"`
+}
+
+func (r HistoryRequest) Filter() (history.Filter, error) {
+	filter := history.Filter{
+		Address: r.Address,
+		Action:  history.Action(r.Action),
+		Source:  history.SourceKind(r.Source),
+		Outcome: history.Outcome(r.Outcome),
+	}
+
+	if r.From != "" {
+		from, err := time.ParseInLocation(time.DateOnly, r.From, time.Local)
+		if err != nil {
+			return history.Filter{}, fmt.Errorf("invalid from date: %s", r.From)
+		}
+		filter.Since = from
+	}
+	if r.To != "" {
+		to, err := time.ParseInLocation(time.DateOnly, r.To, time.Local)
+		if err != nil {
+			return history.Filter{}, fmt.Errorf("invalid to date: %s", r.To)
+		}
+		filter.Until = to.AddDate(0, 0, 1)
+	}
+
+	return filter, nil
+}
+
+// Query returns the query string of the filter for the given page, page 0 leaves the page out
+func (r HistoryRequest) Query(page int) string {
+	values := url.Values{}
+	for key, value := range map[string]string{
+		"address": r.Address,
+		"action":  r.Action,
+		"source":  r.Source,
+		"outcome": r.Outcome,
+		"from":    r.From,
+		"to":      r.To,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	if page > 0 {
+		values.Set("page", strconv.Itoa(page))
+	}
+
+	return values.Encode()
+}
+
+type HistoryEntryData struct {
+	*history.Entry
+	LocalTime string
+	Source    string
+}
+
+type HistoryData struct {
+	Request  HistoryRequest
+	Entries  []HistoryEntryData
+	Devices  []*devices.DeviceView
+	Actions  []history.Action
+	Sources  []history.SourceKind
+	Outcomes []history.Outcome
+	Total    int
+	Page     int
+	Pages    int
+}
+
+func NewHistoryData(request HistoryRequest, entries []*history.Entry, total int, savedDevices []*devices.DeviceView) HistoryData {
+	return HistoryData{
+		Request: request,
+		Entries: utils.Map(entries, func(e *history.Entry) HistoryEntryData {
+			return HistoryEntryData{
+				Entry:     e,
+				LocalTime: e.Time.Local().Format(time.DateTime),
+				Source:    history.Source{Kind: e.Source, Name: e.SourceName}.String(),
+			}
+		}),
+		Devices:  savedDevices,
+		Actions:  history.Actions,
+		Sources:  []history.SourceKind{history.SourceWeb, history.SourceAPIToken, history.SourceGuestLink, history.SourceSchedule, history.SourceRule, history.SourceSystem},
+		Outcomes: history.Outcomes,
+		Total:    total,
+		Page:     request.Page,
+		Pages:    max((total+HistoryPageSize-1)/HistoryPageSize, 1),
+	}
+}
+
+func (d HistoryData) PreviousURL() template.URL {
+	return template.URL("/history?" + d.Request.Query(d.Page-1))
+}
+
+func (d HistoryData) NextURL() template.URL {
+	return template.URL("/history?" + d.Request.Query(d.Page+1))
+}
+
+func (d HistoryData) ExportURL(format string) template.URL {
+	query := d.Request.Query(0)
+	if query != "" {
+		query += "&"
+	}
+
+	return template.URL("/history/export?" + query + "format=" + url.QueryEscape(format))
+}
+
+// BatteryChartPeriod is the time span of the battery chart on the device page
+const BatteryChartPeriod = 7 * 24 * time.Hour
+
+const (
+	batteryChartWidth  = 300
+	batteryChartHeight = 100
+)
+
+type BatteryReportData struct {
+	// Points is the battery chart as SVG polyline points
+	Points     string
+	Samples    int
+	Estimate   *telemetry.Estimate
+	LowBattery bool
+	// LowLineY is the chart position of the low battery threshold
+	LowLineY int32
+}
+
+func NewBatteryReportData(report *telemetry.BatteryReport, period time.Duration) BatteryReportData {
+	data := BatteryReportData{
+		Samples:    len(report.Samples),
+		Estimate:   report.Estimate,
+		LowBattery: report.LowBattery,
+		LowLineY:   batteryChartHeight - report.LowPercent,
+	}
+
+	start := time.Now().Add(-period)
+	points := make([]string, 0, len(report.Samples))
+	for _, sample := range report.Samples {
+		x := float64(sample.Time.Sub(start)) / float64(period) * batteryChartWidth
+		y := float64(batteryChartHeight - sample.Percent)
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	data.Points = strings.Join(points, " ")
+
+	return data
+}
+
+func (d BatteryReportData) DaysRemaining() string {
+	if d.Estimate == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(d.Estimate.DaysRemaining, 'f', 1, 64)
+}
+
+func (d BatteryReportData) DrainPerDay() string {
+	if d.Estimate == nil {
+		return ""
+	}
+
+	return strconv.FormatFloat(d.Estimate.DrainPerDay, 'f', 1, 64)
+}
+
+type VersionsRequest struct {
+	From int64 `query:"from"`
+	To   int64 `query:"to"`
+}
+
+type VersionsData struct {
+	Name      string
+	Address   string
+	Connected bool
+	Versions  []*devices.ConfigurationVersion
+	// From and To are the compared versions, nil when there is nothing to compare
+	From    *devices.ConfigurationVersion
+	To      *devices.ConfigurationVersion
+	Changes []devices.ConfigurationChange
+}
+
+// NewVersionsData compares the requested versions, the latest version and the one before it by default
+func NewVersionsData(device *devices.DeviceView, connected bool, versions []*devices.ConfigurationVersion, request VersionsRequest) VersionsData {
+	data := VersionsData{
+		Name:      device.Name,
+		Address:   device.Address,
+		Connected: connected,
+		Versions:  versions,
+	}
+
+	if len(versions) > 1 {
+		data.To, data.From = versions[0], versions[1]
+	}
+	for _, version := range versions {
+		if version.ID == request.From {
+			data.From = version
+		}
+		if version.ID == request.To {
+			data.To = version
+		}
+	}
+
+	if data.From != nil && data.To != nil {
+		data.Changes = devices.ConfigurationChanges(data.From.Config, data.To.Config)
+	}
+
+	return data
+}
+
+type CalibrationRequest struct {
+	Start  int32 `form:"start"`
+	Step   int32 `form:"step"`
+	Margin int32 `form:"margin"`
+}
+
+type CalibrationData struct {
+	Name         string
+	Address      string
+	ArmUpPercent int32
+	// ArmDownPercent is the arm down percent currently configured on the device
+	ArmDownPercent int32
+	Calibration    *devices.Calibration
+	// SavedArmDownPercent is set once a calibration is confirmed
+	SavedArmDownPercent int32
+	Defaults            CalibrationRequest
+}
+
+func NewCalibrationData(device *fingerbot.Fingerbot, calibration *devices.Calibration) CalibrationData {
+	return CalibrationData{
+		Name:           device.Name(),
+		Address:        device.Address(),
+		ArmUpPercent:   device.ArmUpPercent(),
+		ArmDownPercent: device.ArmDownPercent(),
+		Calibration:    calibration,
+		Defaults: CalibrationRequest{
+			Start:  max(devices.DefaultCalibrationStart, device.ArmUpPercent()),
+			Step:   devices.DefaultCalibrationStep,
+			Margin: devices.DefaultCalibrationMargin,
+		},
+	}
+}
+
+// ValidationErrorData is the response to a request with invalid fields, Fields maps the field names to the messages
+type ValidationErrorData struct {
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields"`
+}
+
+func NewValidationErrorData(err *tuyable.ValidationError) ValidationErrorData {
+	return ValidationErrorData{Message: err.Error(), Fields: err.FieldMessages()}
+}
+
+type LoginRequest struct {
+	Username string `form:"username"`
+	Password string `form:"password"`
+	// Next is the page to return to after logging in
+	Next string `form:"next" query:"next"`
+}
+
+// NextURL returns the local page to return to, anything else could redirect to another site
+func (r LoginRequest) NextURL() string {
+	if !strings.HasPrefix(r.Next, "/") || strings.HasPrefix(r.Next, "//") || strings.HasPrefix(r.Next, "/\\") {
+		return "/"
+	}
+
+	return r.Next
+}
+
+type LoginData struct {
+	Username string
+	Next     string
+	Error    string
+	// LocalPasswords shows the password form, SSO the single sign-on button
+	LocalPasswords bool
+	SSO            bool
+}
+
+type APITokenRequest struct {
+	Name    string   `form:"name"`
+	Scope   string   `form:"scope"`
+	Devices []string `form:"device"`
+	// Expires is the last day the token is valid in the 2006-01-02 format, empty for a token that does not expire
+	Expires string `form:"expires"`
+}
+
+func (r APITokenRequest) ExpiresAt() (sql.NullTime, error) {
+	if r.Expires == "" {
+		return sql.NullTime{}, nil
+	}
+
+	day, err := time.ParseInLocation(time.DateOnly, r.Expires, time.Local)
+	if err != nil {
+		return sql.NullTime{}, fmt.Errorf("invalid expiry %q", r.Expires)
+	}
+
+	return sql.NullTime{Time: day.AddDate(0, 0, 1), Valid: true}, nil
+}
+
+type APITokenItemData struct {
+	Token *auth.APIToken
+	// Secret is only set right after the token was created, it cannot be shown again
+	Secret    string
+	Devices   string
+	Status    string
+	ExpiresAt string
+	LastUsed  string
+}
+
+func NewAPITokenItemData(token *auth.APIToken, deviceNames map[string]string) APITokenItemData {
+	item := APITokenItemData{
+		Token:     token,
+		Devices:   "all devices",
+		Status:    "active",
+		ExpiresAt: "never",
+		LastUsed:  "never",
+	}
+	if len(token.Devices) > 0 {
+		item.Devices = strings.Join(utils.Map(token.Devices, func(address string) string {
+			if name, ok := deviceNames[address]; ok {
+				return name
+			}
+			return address
+		}), ", ")
+	}
+	if token.ExpiresAt.Valid {
+		item.ExpiresAt = token.ExpiresAt.Time.Local().Format(time.DateTime)
+	}
+	if token.LastUsedAt.Valid {
+		item.LastUsed = token.LastUsedAt.Time.Local().Format(time.DateTime)
+	}
+	switch {
+	case token.Revoked():
+		item.Status = "revoked"
+	case token.Expired(time.Now()):
+		item.Status = "expired"
+	}
+
+	return item
+}
+
+type APITokensData struct {
+	Tokens  []APITokenItemData
+	Devices []*devices.DeviceView
+	Scopes  []auth.Scope
+}
+
+// Permissions are what the user may do on a device, the pages hide everything else
+type Permissions struct {
+	// Press allows pressing and connecting the device
+	Press bool
+	// Configure allows configuring, disconnecting and forgetting the device
+	Configure bool
+	// Admin allows managing devices, users, tokens, macros, schedules and rules
+	Admin bool
+}
+
+type SavedDeviceData struct {
+	*devices.DeviceView
+	Permissions Permissions
+}
+
+type DevicesData struct {
+	Devices []SavedDeviceData
+	// Admin allows discovering and adding devices
+	Admin bool
+}
+
+type UserRequest struct {
+	Username string `form:"username"`
+	Password string `form:"password"`
+	Role     string `form:"role"`
+}
+
+type UpdateUserRequest struct {
+	Role string `form:"role"`
+	// GrantAddresses and GrantRoles are parallel, an empty role means no grant on the device
+	GrantAddresses []string `form:"grantAddress"`
+	GrantRoles     []string `form:"grantRole"`
+}
+
+func (r UpdateUserRequest) Grants() auth.Grants {
+	grants := auth.Grants{}
+	for i, address := range r.GrantAddresses {
+		if i < len(r.GrantRoles) && r.GrantRoles[i] != "" {
+			grants[address] = auth.Role(r.GrantRoles[i])
+		}
+	}
+
+	return grants
+}
+
+type UserGrantData struct {
+	Address string
+	Name    string
+	Role    auth.Role
+}
+
+type UserItemData struct {
+	User   *auth.User
+	Grants []UserGrantData
+	Roles  []auth.Role
+	// Self is set for the user viewing the page, who cannot delete themselves
+	Self bool
+}
+
+func NewUserItemData(user *auth.User, grants auth.Grants, savedDevices []*devices.DeviceView, current *auth.User) UserItemData {
+	return UserItemData{
+		User: user,
+		Grants: utils.Map(savedDevices, func(device *devices.DeviceView) UserGrantData {
+			return UserGrantData{Address: device.Address, Name: device.Name, Role: grants[device.Address]}
+		}),
+		Roles: auth.Roles,
+		Self:  current != nil && current.ID == user.ID,
+	}
+}
+
+type UsersData struct {
+	Users []UserItemData
+	Roles []auth.Role
+	// LocalPasswords shows the new user form, users only log in with single sign-on without them
+	LocalPasswords bool
+}
+
+type GuestLinkRequest struct {
+	Name    string `form:"name"`
+	Address string `form:"address"`
+	// Action is "press", or "preset:<id>" to press with a preset of the device
+	Action string `form:"action"`
+	// Hours is how long the link is valid for
+	Hours   int    `form:"hours"`
+	MaxUses int    `form:"maxUses"`
+	PIN     string `form:"pin"`
+}
+
+func (r GuestLinkRequest) Link() (*auth.GuestLink, error) {
+	link := &auth.GuestLink{
+		Name:      r.Name,
+		Address:   r.Address,
+		Action:    auth.GuestAction(r.Action),
+		MaxUses:   r.MaxUses,
+		ExpiresAt: time.Now().Add(time.Duration(r.Hours) * time.Hour),
+	}
+	if preset, ok := strings.CutPrefix(r.Action, string(auth.GuestActionPreset)+":"); ok {
+		id, err := strconv.ParseInt(preset, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid preset %q", preset)
+		}
+		link.Action, link.PresetID = auth.GuestActionPreset, id
+	}
+
+	return link, nil
+}
+
+type GuestLinkItemData struct {
+	Link *auth.GuestLink
+	// URL is only set while the link can be used
+	URL       string
+	Device    string
+	Action    string
+	Status    string
+	ExpiresAt string
+	LastUsed  string
+}
+
+// NewGuestLinkItemData describes the link, presetNames are the names of the presets of its device by id
+func NewGuestLinkItemData(link *auth.GuestLink, url, deviceName string, presetNames map[int64]string) GuestLinkItemData {
+	item := GuestLinkItemData{
+		Link:      link,
+		Device:    deviceName,
+		Action:    guestActionName(link, presetNames),
+		Status:    "active",
+		ExpiresAt: link.ExpiresAt.Local().Format(time.DateTime),
+		LastUsed:  "never",
+	}
+	if link.LastUsedAt.Valid {
+		item.LastUsed = link.LastUsedAt.Time.Local().Format(time.DateTime)
+	}
+	switch err := link.Usable(time.Now()); err {
+	case nil:
+		item.URL = url
+	case auth.ErrGuestLinkRevoked:
+		item.Status = "revoked"
+	case auth.ErrGuestLinkExpired:
+		item.Status = "expired"
+	case auth.ErrGuestLinkUsedUp:
+		item.Status = "used up"
+	}
+
+	return item
+}
+
+func guestActionName(link *auth.GuestLink, presetNames map[int64]string) string {
+	if link.Action != auth.GuestActionPreset {
+		return "Press"
+	}
+	if name, ok := presetNames[link.PresetID]; ok {
+		return "Press with " + name
+	}
+
+	return "Press with a deleted preset"
+}
+
+type GuestLinkDeviceData struct {
+	Device  *devices.DeviceView
+	Presets []*devices.Preset
+}
+
+type GuestLinksData struct {
+	Links   []GuestLinkItemData
+	Devices []GuestLinkDeviceData
+	// MaxHours is the longest a link may be valid for
+	MaxHours int
+}
+
+// GuestData is the page guests open a link on, it only ever shows the device and action of the link
+type GuestData struct {
+	Token      string
+	DeviceName string
+	Action     string
+	PIN        bool
+	Remaining  int
+	ExpiresAt  string
+	// Unusable is why the link can no longer be used
+	Unusable string
+	Error    string
+	Message  string
+}
+
+// PressProtectionData asks for the PIN or confirmation a press of a protected device needs, it is sent back to Path
+type PressProtectionData struct {
+	Protection   devices.Protection
+	Confirmation string
+	HoldMs       int64
+	Path         string
+	Error        string
+	// Locked is set while presses are locked after too many wrong PINs
+	Locked bool
+}
+
+type ProtectionRequest struct {
+	Protection string `form:"protection"`
+	// PIN is the new PIN, empty to keep the current one
+	PIN string `form:"pin"`
+}
+
+type ProtectionData struct {
+	Name        string
+	Address     string
+	Protection  devices.Protection
+	Protections []devices.Protection
+	HasPIN      bool
+	// LockedUntil is set while presses are locked after too many wrong PINs
+	LockedUntil string
+}
+
+func NewProtectionData(device *devices.DeviceView, protection *devices.DeviceProtection) ProtectionData {
+	data := ProtectionData{
+		Name:        device.Name,
+		Address:     device.Address,
+		Protection:  protection.Protection,
+		Protections: devices.Protections,
+		HasPIN:      protection.HasPIN(),
+	}
+	if protection.LockedUntil.Valid && time.Now().Before(protection.LockedUntil.Time) {
+		data.LockedUntil = protection.LockedUntil.Time.Local().Format(time.DateTime)
+	}
+
+	return data
+}
+
+type InterlocksRequest struct {
+	MaxPresses        int    `form:"maxPresses"`
+	WindowMinutes     int    `form:"windowMinutes"`
+	CooldownSeconds   int    `form:"cooldownSeconds"`
+	QuietStart        string `form:"quietStart"`
+	QuietEnd          string `form:"quietEnd"`
+	Timezone          string `form:"timezone"`
+	Maintenance       bool   `form:"maintenance"`
+	MaintenanceReason string `form:"maintenanceReason"`
+}
+
+func (r InterlocksRequest) Interlocks(address string) *devices.Interlocks {
+	return &devices.Interlocks{
+		Address:           address,
+		MaxPresses:        r.MaxPresses,
+		PressWindow:       time.Duration(r.WindowMinutes) * time.Minute,
+		Cooldown:          time.Duration(r.CooldownSeconds) * time.Second,
+		QuietStart:        r.QuietStart,
+		QuietEnd:          r.QuietEnd,
+		Timezone:          strings.TrimSpace(r.Timezone),
+		Maintenance:       r.Maintenance,
+		MaintenanceReason: strings.TrimSpace(r.MaintenanceReason),
+	}
+}
+
+type InterlocksData struct {
+	Name              string
+	Address           string
+	MaxPresses        int
+	WindowMinutes     int
+	CooldownSeconds   int
+	QuietStart        string
+	QuietEnd          string
+	Timezone          string
+	Maintenance       bool
+	MaintenanceReason string
+	MaxWindowMinutes  int
+	MaxCooldown       int
+	MaxReasonLength   int
+}
+
+func NewInterlocksData(device *devices.DeviceView, interlocks *devices.Interlocks) InterlocksData {
+	return InterlocksData{
+		Name:              device.Name,
+		Address:           device.Address,
+		MaxPresses:        interlocks.MaxPresses,
+		WindowMinutes:     int(interlocks.PressWindow / time.Minute),
+		CooldownSeconds:   int(interlocks.Cooldown / time.Second),
+		QuietStart:        interlocks.QuietStart,
+		QuietEnd:          interlocks.QuietEnd,
+		Timezone:          interlocks.Timezone,
+		Maintenance:       interlocks.Maintenance,
+		MaintenanceReason: interlocks.MaintenanceReason,
+		MaxWindowMinutes:  int(devices.MaxPressWindow / time.Minute),
+		MaxCooldown:       int(devices.MaxCooldown / time.Second),
+		MaxReasonLength:   devices.MaxMaintenanceReasonLength,
+	}
+}
@@ -0,0 +1,314 @@
+package webapp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+	"github.com/labstack/echo/v4"
+)
+
+// guestPathPrefix is followed by the token of a guest link, which grants its action to anyone who has it
+const guestPathPrefix = "/guest/"
+
+// RedactURI hides the token of guest link URIs, e.g. for the request log
+func RedactURI(uri string) string {
+	token, ok := strings.CutPrefix(uri, guestPathPrefix)
+	if !ok || token == "" {
+		return uri
+	}
+
+	rest := ""
+	if i := strings.IndexAny(token, "/?#"); i >= 0 {
+		rest = token[i:]
+	}
+
+	return guestPathPrefix + "REDACTED" + rest
+}
+
+// guestLinkURL returns the absolute URL of the link to share with guests
+func (a *WebApp) guestLinkURL(c echo.Context, link *auth.GuestLink) (string, error) {
+	token, err := a.auth.GuestLinkToken(c.Request().Context(), link)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s://%s%s%s", c.Scheme(), c.Request().Host, guestPathPrefix, token), nil
+}
+
+// presetNames returns the names of the presets of the device by id
+func (a *WebApp) presetNames(ctx context.Context, address string) (map[int64]string, error) {
+	presets, err := a.deviceManager.GetPresets(ctx, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get presets: %w", err)
+	}
+
+	names := map[int64]string{}
+	for _, preset := range presets {
+		names[preset.ID] = preset.Name
+	}
+
+	return names, nil
+}
+
+func (a *WebApp) guestLinkItemData(c echo.Context, link *auth.GuestLink, deviceNames map[string]string) (GuestLinkItemData, error) {
+	url, err := a.guestLinkURL(c, link)
+	if err != nil {
+		return GuestLinkItemData{}, err
+	}
+
+	presetNames, err := a.presetNames(c.Request().Context(), link.Address)
+	if err != nil {
+		return GuestLinkItemData{}, err
+	}
+
+	deviceName, ok := deviceNames[link.Address]
+	if !ok {
+		deviceName = link.Address
+	}
+
+	return NewGuestLinkItemData(link, url, deviceName, presetNames), nil
+}
+
+func (a *WebApp) handleGuestLinks(c echo.Context) error {
+	ctx := c.Request().Context()
+	links, err := a.auth.GetGuestLinks(ctx)
+	if err != nil {
+		return err
+	}
+
+	savedDevices, names, err := a.deviceNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	data := GuestLinksData{MaxHours: int(auth.MaxGuestLinkTTL / time.Hour)}
+	for _, link := range links {
+		item, err := a.guestLinkItemData(c, link, names)
+		if err != nil {
+			return err
+		}
+		data.Links = append(data.Links, item)
+	}
+	for _, device := range savedDevices {
+		presets, err := a.deviceManager.GetPresets(ctx, device.Address)
+		if err != nil {
+			return fmt.Errorf("failed to get presets: %w", err)
+		}
+		data.Devices = append(data.Devices, GuestLinkDeviceData{Device: device, Presets: presets})
+	}
+
+	return c.Render(http.StatusOK, "guest_links.html", data)
+}
+
+func (a *WebApp) handleCreateGuestLink(c echo.Context) error {
+	var request GuestLinkRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	link, err := request.Link()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	_, names, err := a.deviceNames(ctx)
+	if err != nil {
+		return err
+	}
+	if _, ok := names[link.Address]; !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, devices.ErrDeviceNotFound.Error())
+	}
+	if link.Action == auth.GuestActionPreset {
+		presetNames, err := a.presetNames(ctx, link.Address)
+		if err != nil {
+			return err
+		}
+		if _, ok := presetNames[link.PresetID]; !ok {
+			return echo.NewHTTPError(http.StatusBadRequest, devices.ErrPresetNotFound.Error())
+		}
+	}
+
+	if err := a.auth.CreateGuestLink(ctx, history.SourceFromContext(ctx).String(), link, request.PIN); err != nil {
+		return httpError(err)
+	}
+
+	item, err := a.guestLinkItemData(c, link, names)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/guest_link.html", item)
+}
+
+func (a *WebApp) handleRevokeGuestLink(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	ctx := c.Request().Context()
+	link, err := a.auth.RevokeGuestLink(ctx, id)
+	if err != nil {
+		return httpError(err)
+	}
+
+	_, names, err := a.deviceNames(ctx)
+	if err != nil {
+		return err
+	}
+
+	item, err := a.guestLinkItemData(c, link, names)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/guest_link.html", item)
+}
+
+// guestData describes the link to the guest, without revealing anything else about the app
+func (a *WebApp) guestData(ctx context.Context, token string, link *auth.GuestLink) (GuestData, error) {
+	data := GuestData{
+		Token:      token,
+		DeviceName: link.Address,
+		PIN:        link.HasPIN(),
+		Remaining:  max(link.MaxUses-link.Uses, 0),
+		ExpiresAt:  link.ExpiresAt.Local().Format(time.DateTime),
+	}
+	if err := link.Usable(time.Now()); err != nil {
+		data.Unusable = err.Error()
+	}
+
+	device, err := a.deviceManager.GetSavedDevice(ctx, link.Address)
+	if err != nil {
+		return GuestData{}, fmt.Errorf("failed to get saved device: %w", err)
+	}
+	if device != nil {
+		data.DeviceName = device.Name
+	}
+
+	presetNames, err := a.presetNames(ctx, link.Address)
+	if err != nil {
+		return GuestData{}, err
+	}
+	data.Action = guestActionName(link, presetNames)
+
+	return data, nil
+}
+
+func (a *WebApp) handleGuestPage(c echo.Context) error {
+	ctx := c.Request().Context()
+	link, err := a.auth.GetGuestLink(ctx, c.Param("token"))
+	if err != nil {
+		if errors.Is(err, auth.ErrGuestLinkInvalid) {
+			return c.Render(http.StatusNotFound, "guest.html", GuestData{Unusable: err.Error()})
+		}
+		return err
+	}
+
+	data, err := a.guestData(ctx, c.Param("token"), link)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "guest.html", data)
+}
+
+// handleUseGuestLink runs the action of the link, the use is only counted once the device is known to be connected and
+// its interlocks allow a press so guests do not lose it to an outage or a cooldown
+func (a *WebApp) handleUseGuestLink(c echo.Context) error {
+	ctx := c.Request().Context()
+	token := c.Param("token")
+	link, err := a.auth.GetGuestLink(ctx, token)
+	if err != nil {
+		if errors.Is(err, auth.ErrGuestLinkInvalid) {
+			return c.Render(http.StatusNotFound, "guest.html", GuestData{Unusable: err.Error()})
+		}
+		return err
+	}
+
+	if a.deviceManager.GetFingerbot(link.Address) == nil {
+		data, err := a.guestData(ctx, token, link)
+		if err != nil {
+			return err
+		}
+		data.Error = "The device is not available right now, please try again later."
+
+		return c.Render(http.StatusServiceUnavailable, "guest.html", data)
+	}
+
+	var interlock *devices.InterlockError
+	if err := a.deviceManager.CheckInterlocks(ctx, link.Address); errors.As(err, &interlock) {
+		data, err := a.guestData(ctx, token, link)
+		if err != nil {
+			return err
+		}
+		data.Error = guestInterlockMessage(interlock)
+		setRetryAfter(c, interlock)
+
+		return c.Render(interlockStatus(interlock), "guest.html", data)
+	} else if err != nil {
+		return err
+	}
+
+	link, useErr := a.auth.UseGuestLink(ctx, token, c.FormValue("pin"))
+	if useErr != nil && link == nil {
+		return useErr
+	}
+
+	if useErr == nil {
+		ctx = history.WithSource(ctx, history.Source{Kind: history.SourceGuestLink, Name: link.Name})
+		if link.Action == auth.GuestActionPreset {
+			_, err = a.deviceManager.PressWithPreset(ctx, link.Address, link.PresetID)
+		} else {
+			_, err = a.deviceManager.Toggle(ctx, link.Address)
+		}
+	}
+
+	data, dataErr := a.guestData(ctx, token, link)
+	if dataErr != nil {
+		return dataErr
+	}
+	status := http.StatusOK
+	switch {
+	case errors.Is(useErr, auth.ErrGuestLinkWrongPIN):
+		status = http.StatusForbidden
+		data.Error = fmt.Sprintf("%s, %d attempts left.", useErr, auth.MaxGuestLinkPINAttempts-link.FailedPINAttempts)
+	case useErr != nil:
+		status = http.StatusForbidden
+		data.Unusable = useErr.Error()
+	case errors.As(err, &interlock):
+		status = interlockStatus(interlock)
+		data.Error = guestInterlockMessage(interlock)
+	case errors.Is(err, fingerbot.ErrPressNotConfirmed):
+		data.Message = "Done, but the device did not confirm it."
+	case err != nil:
+		status = http.StatusBadGateway
+		data.Error = "The device did not respond."
+		logging.FromContext(ctx).Error("failed to run guest link", slog.Int64("id", link.ID), logging.ErrAttr(err))
+	default:
+		data.Message = "Done."
+	}
+
+	return c.Render(status, "guest.html", data)
+}
+
+// guestInterlockMessage tells guests when to try again without revealing the reason of a maintenance lock
+func guestInterlockMessage(err *devices.InterlockError) string {
+	if err.RetryAfter <= 0 {
+		return "The device is not available right now, please try again later."
+	}
+
+	return fmt.Sprintf("The device cannot be pressed right now, please try again in %s.", max(err.RetryAfter.Round(time.Second), time.Second))
+}
@@ -0,0 +1,97 @@
+package webapp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+	"github.com/labstack/echo/v4"
+)
+
+// interlockStatus is the status of the presses refused by the interlock, 429 for those that can be retried shortly
+func interlockStatus(err *devices.InterlockError) int {
+	switch err.Interlock {
+	case devices.InterlockRateLimit, devices.InterlockCooldown:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusLocked
+	}
+}
+
+// setRetryAfter sets the Retry-After header if the press was refused by an interlock which lifts by itself
+func setRetryAfter(c echo.Context, err error) {
+	var interlock *devices.InterlockError
+	if errors.As(err, &interlock) && interlock.RetryAfter > 0 {
+		seconds := int64((interlock.RetryAfter + time.Second - 1) / time.Second)
+		c.Response().Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	}
+}
+
+// pressError renders presses refused by an interlock or not confirmed by the device in place of the press result,
+// other errors are mapped by httpError
+func (a *WebApp) pressError(c echo.Context, err error) error {
+	if errors.Is(err, fingerbot.ErrPressNotConfirmed) {
+		return c.Render(http.StatusGatewayTimeout, "fragments/press_result.html", PressResultData{})
+	}
+
+	var interlock *devices.InterlockError
+	if !errors.As(err, &interlock) {
+		return httpError(err)
+	}
+
+	setRetryAfter(c, err)
+
+	return c.Render(interlockStatus(interlock), "fragments/press_refused.html", err.Error())
+}
+
+func (a *WebApp) handleInterlocks(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.deviceManager.GetSavedDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return c.Redirect(http.StatusTemporaryRedirect, "/devices")
+	}
+
+	interlocks, err := a.deviceManager.GetInterlocks(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "device_interlocks.html", NewInterlocksData(device, interlocks))
+}
+
+func (a *WebApp) handleSaveInterlocks(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request InterlocksRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.deviceManager.GetSavedDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return echo.NewHTTPError(http.StatusNotFound, devices.ErrDeviceNotFound.Error())
+	}
+
+	if err := a.deviceManager.SetInterlocks(ctx, request.Interlocks(device.Address)); err != nil {
+		return httpError(err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,184 @@
+package webapp
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/cybre/fingerbot-web/internal/api"
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/labstack/echo/v4"
+)
+
+const sessionCookie = "session"
+
+// publicPaths are the routes served without a session, guest links are authenticated by their signed token
+var publicPaths = map[string]bool{
+	"/login":               true,
+	"/login/oidc":          true,
+	"/login/oidc/callback": true,
+	"/logout":              true,
+	"/guest/:token":        true,
+}
+
+// tokenScopes are the scopes API tokens need for the HTML routes, routes missing from it need auth.ScopeAdmin. The
+// scopes of the JSON routes are declared in api.Routes.
+var tokenScopes = map[string]auth.Scope{
+	"GET /":                                     auth.ScopePress,
+	"GET /devices/:address":                     auth.ScopePress,
+	"PUT /devices/:address/toggle":              auth.ScopePress,
+	"PUT /devices/:address/press":               auth.ScopePress,
+	"PUT /devices/:address/hold":                auth.ScopePress,
+	"PUT /devices/:address/release":             auth.ScopePress,
+	"GET /devices/:address/battery-status":      auth.ScopePress,
+	"PUT /devices/:address/presets/:id/press":   auth.ScopePress,
+	"POST /devices/:address/connect":            auth.ScopeConfigure,
+	"POST /devices/:address/disconnect":         auth.ScopeConfigure,
+	"GET /devices/:address/configure":           auth.ScopeConfigure,
+	"PUT /devices/:address/configure":           auth.ScopeConfigure,
+	"GET /devices/:address/presets":             auth.ScopeConfigure,
+	"POST /devices/:address/presets":            auth.ScopeConfigure,
+	"DELETE /devices/:address/presets/:id":      auth.ScopeConfigure,
+	"PUT /devices/:address/presets/:id/apply":   auth.ScopeConfigure,
+	"GET /devices/:address/calibrate":           auth.ScopeConfigure,
+	"POST /devices/:address/calibrate":          auth.ScopeConfigure,
+	"POST /devices/:address/calibrate/press":    auth.ScopeConfigure,
+	"POST /devices/:address/calibrate/confirm":  auth.ScopeConfigure,
+	"DELETE /devices/:address/calibrate":        auth.ScopeConfigure,
+	"GET /devices/:address/protection":          auth.ScopeConfigure,
+	"PUT /devices/:address/protection":          auth.ScopeConfigure,
+	"GET /devices/:address/interlocks":          auth.ScopeConfigure,
+	"PUT /devices/:address/interlocks":          auth.ScopeConfigure,
+	"GET /devices/:address/versions":            auth.ScopeConfigure,
+	"PUT /devices/:address/versions/:id/revert": auth.ScopeConfigure,
+	"GET " + api.Prefix + "/openapi.json":       auth.ScopePress,
+}
+
+// tokenScope returns the scope an API token needs for the route of the request
+func tokenScope(c echo.Context) auth.Scope {
+	if scope, ok := tokenScopes[c.Request().Method+" "+c.Path()]; ok {
+		return scope
+	}
+	for _, route := range api.Routes {
+		if route.Method == c.Request().Method && api.Prefix+route.EchoPath() == c.Path() {
+			return auth.Scope(route.Scope)
+		}
+	}
+
+	return auth.ScopeAdmin
+}
+
+// authenticate puts the user of the session cookie or the bearer API token on the request context, requests without
+// either are sent to the login page. API tokens are checked against the scope and devices the route needs.
+func (a *WebApp) authenticate(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if publicPaths[c.Path()] {
+			return next(c)
+		}
+
+		ctx := c.Request().Context()
+		if header := c.Request().Header.Get(echo.HeaderAuthorization); header != "" {
+			secret, ok := strings.CutPrefix(header, "Bearer ")
+			if !ok {
+				return invalidAPIToken(c)
+			}
+
+			token, err := a.auth.AuthenticateAPIToken(ctx, secret)
+			if err != nil {
+				return fmt.Errorf("failed to authenticate API token: %w", err)
+			}
+			if token == nil {
+				return invalidAPIToken(c)
+			}
+			if err := token.Allows(tokenScope(c), c.Param("address")); err != nil {
+				return forbidden(c, err)
+			}
+
+			c.SetRequest(c.Request().WithContext(auth.WithAPIToken(ctx, token)))
+
+			return next(c)
+		}
+
+		var user *auth.User
+		if cookie, err := c.Cookie(sessionCookie); err == nil {
+			user, err = a.auth.Authenticate(ctx, cookie.Value)
+			if err != nil {
+				return fmt.Errorf("failed to authenticate: %w", err)
+			}
+		}
+		if user == nil {
+			return unauthenticated(c)
+		}
+
+		c.SetRequest(c.Request().WithContext(auth.WithUser(ctx, user)))
+
+		return next(c)
+	}
+}
+
+// unauthenticated responds in the way the client can act on, API clients get a JSON error, htmx a client side
+// redirect and browsers a redirect to the login page that returns to the requested page
+func unauthenticated(c echo.Context) error {
+	request := c.Request()
+	if strings.HasPrefix(request.URL.Path, api.Prefix) {
+		return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Error: api.Error{
+			Code: api.CodeUnauthorized, Message: "authentication required",
+		}})
+	}
+
+	login := "/login?next=" + url.QueryEscape(request.URL.RequestURI())
+	if request.Header.Get("HX-Request") == "true" {
+		c.Response().Header().Set("HX-Redirect", login)
+		return c.NoContent(http.StatusUnauthorized)
+	}
+	if request.Method == http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, login)
+	}
+
+	return c.String(http.StatusUnauthorized, "authentication required")
+}
+
+// invalidAPIToken responds to requests with an unknown, expired or revoked API token, they are never redirected since
+// the clients are scripts
+func invalidAPIToken(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+	message := "invalid, expired or revoked API token"
+	if strings.HasPrefix(c.Request().URL.Path, api.Prefix) {
+		return c.JSON(http.StatusUnauthorized, api.ErrorResponse{Error: api.Error{
+			Code: api.CodeUnauthorized, Message: message,
+		}})
+	}
+
+	return c.String(http.StatusUnauthorized, message)
+}
+
+func forbidden(c echo.Context, err error) error {
+	if strings.HasPrefix(c.Request().URL.Path, api.Prefix) {
+		return c.JSON(http.StatusForbidden, api.ErrorResponse{Error: api.Error{
+			Code: api.CodeForbidden, Message: err.Error(),
+		}})
+	}
+
+	return c.String(http.StatusForbidden, err.Error())
+}
+
+// webSource marks the actions of a request as coming from the web app, identified by the user or else the client
+// address, or from the API token the request was authenticated with
+func webSource(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		source := history.Source{Kind: history.SourceWeb, Name: c.RealIP()}
+		if user := auth.UserFromContext(c.Request().Context()); user != nil {
+			source.Name = user.Username
+		}
+		if token := auth.APITokenFromContext(c.Request().Context()); token != nil {
+			source = history.Source{Kind: history.SourceAPIToken, Name: token.Name}
+		}
+
+		ctx := history.WithSource(c.Request().Context(), source)
+		c.SetRequest(c.Request().WithContext(ctx))
+
+		return next(c)
+	}
+}
@@ -0,0 +1,135 @@
+package webapp
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/logging"
+	"github.com/labstack/echo/v4"
+)
+
+// oidcLoginCookie keeps the state, nonce and PKCE verifier of a single sign-on login until the provider redirects back
+const (
+	oidcLoginCookie = "oidc_login"
+	oidcLoginTTL    = 10 * time.Minute
+)
+
+func (a *WebApp) handleOIDCLogin(c echo.Context) error {
+	if a.oidc == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "single sign-on is not configured")
+	}
+
+	var request LoginRequest
+	if err := c.Bind(&request); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	ctx := c.Request().Context()
+	url, login, err := a.oidc.Start(ctx, request.NextURL())
+	if err != nil {
+		logging.FromContext(ctx).Error("failed to start single sign-on", logging.ErrAttr(err))
+		return a.renderLoginError(c, http.StatusBadGateway, request.NextURL(), "The identity provider is unreachable.")
+	}
+
+	value, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	c.SetCookie(a.newOIDCLoginCookie(c, base64.RawURLEncoding.EncodeToString(value), time.Now().Add(oidcLoginTTL)))
+
+	return c.Redirect(http.StatusSeeOther, url)
+}
+
+func (a *WebApp) handleOIDCCallback(c echo.Context) error {
+	if a.oidc == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "single sign-on is not configured")
+	}
+
+	login := oidcLogin(c)
+	c.SetCookie(a.newOIDCLoginCookie(c, "", time.Unix(0, 0)))
+
+	next := "/"
+	if login != nil {
+		next = LoginRequest{Next: login.Next}.NextURL()
+	}
+
+	if providerError := c.QueryParam("error"); providerError != "" {
+		if description := c.QueryParam("error_description"); description != "" {
+			providerError = description
+		}
+		return a.renderLoginError(c, http.StatusUnauthorized, next, "Single sign-on failed: "+providerError)
+	}
+
+	ctx := c.Request().Context()
+	identity, err := a.oidc.Finish(ctx, login, c.QueryParam("state"), c.QueryParam("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrOIDCLoginInvalid):
+			return a.renderLoginError(c, http.StatusBadRequest, next, err.Error())
+		case errors.Is(err, auth.ErrOIDCNoRole):
+			return a.renderLoginError(c, http.StatusForbidden, next, err.Error())
+		}
+
+		logging.FromContext(ctx).Error("failed to finish single sign-on", logging.ErrAttr(err))
+		return a.renderLoginError(c, http.StatusBadGateway, next, "Single sign-on failed, try again.")
+	}
+
+	token, session, err := a.auth.LoginOIDC(ctx, identity)
+	if err != nil {
+		if errors.Is(err, auth.ErrUsernameTaken) {
+			return a.renderLoginError(
+				c, http.StatusConflict, next, "The username "+identity.Username+" belongs to another account.",
+			)
+		}
+
+		return err
+	}
+
+	c.SetCookie(a.newSessionCookie(c, token, session.ExpiresAt))
+
+	return c.Redirect(http.StatusSeeOther, next)
+}
+
+func (a *WebApp) renderLoginError(c echo.Context, status int, next, message string) error {
+	data := a.loginData(next)
+	data.Error = message
+
+	return c.Render(status, "login.html", data)
+}
+
+// oidcLogin returns the login in progress, nil when the cookie is missing or malformed
+func oidcLogin(c echo.Context) *auth.OIDCLogin {
+	cookie, err := c.Cookie(oidcLoginCookie)
+	if err != nil {
+		return nil
+	}
+
+	value, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return nil
+	}
+
+	var login auth.OIDCLogin
+	if err := json.Unmarshal(value, &login); err != nil {
+		return nil
+	}
+
+	return &login
+}
+
+// newOIDCLoginCookie returns the login cookie, it is lax so the browser sends it along the redirect of the provider
+func (a *WebApp) newOIDCLoginCookie(c echo.Context, value string, expires time.Time) *http.Cookie {
+	return &http.Cookie{
+		Name:     oidcLoginCookie,
+		Value:    value,
+		Path:     "/login/oidc",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   a.secureCookies || c.Scheme() == "https",
+		SameSite: http.SameSiteLaxMode,
+	}
+}
@@ -0,0 +1,166 @@
+package webapp
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/labstack/echo/v4"
+)
+
+// Presses of protected devices carry their proof in these headers, responses asking for it name the protection and
+// the confirmation to send back. A press with the confirmation and holdStartHeader set to true only starts the hold
+// of a device protected with ProtectionHold, the press itself is sent with the confirmation once it was held.
+const (
+	pinHeader          = "X-Press-PIN"
+	confirmationHeader = "X-Press-Confirmation"
+	protectionHeader   = "X-Press-Protection"
+	holdHeader         = "X-Press-Hold-Ms"
+	holdStartHeader    = "X-Press-Hold-Start"
+)
+
+// pressProof returns the proof sent with the press, the PIN may also be a form value
+func pressProof(c echo.Context) devices.PressProof {
+	proof := devices.PressProof{
+		PIN:          c.Request().Header.Get(pinHeader),
+		Confirmation: c.Request().Header.Get(confirmationHeader),
+	}
+	if proof.PIN == "" {
+		proof.PIN = c.FormValue("pin")
+	}
+
+	return proof
+}
+
+// startsHold reports whether the request only starts holding the confirmation of a press
+func startsHold(c echo.Context) bool {
+	return c.Request().Header.Get(holdStartHeader) == "true"
+}
+
+// checkProtection returns nil if the press may go ahead, otherwise the protection error with the headers telling the
+// client how to confirm the press
+func (a *WebApp) checkProtection(c echo.Context, address string, proof devices.PressProof) error {
+	err := a.deviceManager.CheckProtection(c.Request().Context(), address, proof)
+
+	var required *devices.ProtectionRequiredError
+	if errors.As(err, &required) {
+		header := c.Response().Header()
+		header.Set(protectionHeader, string(required.Protection))
+		if required.Confirmation != "" {
+			header.Set(confirmationHeader, required.Confirmation)
+		}
+		if required.HoldFor > 0 {
+			header.Set(holdHeader, strconv.FormatInt(required.HoldFor.Milliseconds(), 10))
+		}
+	}
+
+	return err
+}
+
+// protectPress checks the protection of the device for every press of the pages. htmx requests are asked for the PIN
+// or confirmation in place of the press result, other requests get the protection error and its headers. Requests
+// starting the hold of a confirmation are answered with 202 Accepted. The handler must return as soon as it returns
+// true.
+func (a *WebApp) protectPress(c echo.Context, address string) (bool, error) {
+	var err error
+	if startsHold(c) {
+		if err = a.deviceManager.StartHold(
+			c.Request().Context(), address, c.Request().Header.Get(confirmationHeader),
+		); err == nil {
+			return true, c.NoContent(http.StatusAccepted)
+		}
+	} else {
+		err = a.checkProtection(c, address, pressProof(c))
+	}
+	if err == nil {
+		return false, nil
+	}
+	if c.Request().Header.Get("HX-Request") != "true" {
+		return true, httpError(err)
+	}
+
+	data := PressProtectionData{Path: c.Request().URL.Path}
+	status := http.StatusPreconditionRequired
+
+	var (
+		required *devices.ProtectionRequiredError
+		wrongPIN *devices.WrongPINError
+		locked   *devices.PINLockedError
+	)
+	switch {
+	case errors.As(err, &wrongPIN):
+		status = http.StatusForbidden
+		data.Protection, data.Error = devices.ProtectionPIN, err.Error()
+	case errors.As(err, &locked):
+		status = http.StatusLocked
+		data.Protection, data.Error, data.Locked = devices.ProtectionPIN, err.Error(), true
+	case errors.Is(err, devices.ErrConfirmationInvalid), errors.Is(err, devices.ErrHeldTooShort):
+		// The press is asked to be confirmed again
+		data.Error = err.Error()
+		if err := a.checkProtection(c, address, devices.PressProof{}); !errors.As(err, &required) {
+			return true, err
+		}
+	case errors.As(err, &required):
+	default:
+		return true, err
+	}
+	if required != nil {
+		data.Protection = required.Protection
+		data.Confirmation = required.Confirmation
+		data.HoldMs = required.HoldFor.Milliseconds()
+	}
+
+	return true, c.Render(status, "fragments/press_protection.html", data)
+}
+
+func (a *WebApp) handleProtection(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.deviceManager.GetSavedDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return c.Redirect(http.StatusTemporaryRedirect, "/devices")
+	}
+
+	protection, err := a.deviceManager.GetProtection(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "device_protection.html", NewProtectionData(device, protection))
+}
+
+func (a *WebApp) handleSaveProtection(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request ProtectionRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.deviceManager.GetSavedDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return echo.NewHTTPError(http.StatusNotFound, devices.ErrDeviceNotFound.Error())
+	}
+
+	if err := a.deviceManager.SetProtection(
+		ctx, device.Address, devices.Protection(request.Protection), request.PIN,
+	); err != nil {
+		return httpError(err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
@@ -0,0 +1,91 @@
+package webapp
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+
+	"github.com/cybre/fingerbot-web/internal/auth"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	// csrfField is the form field and query parameter of the CSRF token, htmx and fetch send it as csrfHeader
+	csrfField  = "_csrf"
+	csrfHeader = "X-CSRF-Token"
+	// csrfContextKey and nonceContextKey hold the CSRF token and the script nonce of the request for the templates
+	csrfContextKey  = "csrf"
+	nonceContextKey = "nonce"
+)
+
+// contentSecurityPolicy only runs scripts carrying the nonce of the request, which every script tag of the pages has,
+// including those loaded from unpkg, jsdelivr and cdnjs. Styles may be inline as the pages use style attributes.
+const contentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'nonce-%s'; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://cdnjs.cloudflare.com; " +
+	"font-src 'self' https://cdn.jsdelivr.net; " +
+	"img-src 'self' data:; " +
+	"connect-src 'self'; " +
+	"object-src 'none'; " +
+	"base-uri 'none'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// securityHeaders sets the content security policy with a fresh script nonce and the usual security headers
+func (a *WebApp) securityHeaders(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate nonce: %w", err)
+		}
+		nonce := base64.RawURLEncoding.EncodeToString(b)
+		c.Set(nonceContextKey, nonce)
+
+		header := c.Response().Header()
+		header.Set(echo.HeaderContentSecurityPolicy, fmt.Sprintf(contentSecurityPolicy, nonce))
+		header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+		header.Set(echo.HeaderXFrameOptions, "DENY")
+		header.Set(echo.HeaderReferrerPolicy, "same-origin")
+		header.Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
+		header.Set("Cross-Origin-Opener-Policy", "same-origin")
+		if a.secureCookies || c.Scheme() == "https" {
+			header.Set(echo.HeaderStrictTransportSecurity, "max-age=31536000")
+		}
+
+		return next(c)
+	}
+}
+
+// csrf checks the CSRF token of every unsafe request against the token cookie. Requests authenticated with an API
+// token carry no cookies a forged request could ride on, so they are not checked.
+func (a *WebApp) csrf() echo.MiddlewareFunc {
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper: func(c echo.Context) bool {
+			return c.Request().Header.Get(echo.HeaderAuthorization) != ""
+		},
+		TokenLookup:    "header:" + csrfHeader + ",form:" + csrfField,
+		ContextKey:     csrfContextKey,
+		CookieName:     csrfField,
+		CookiePath:     "/",
+		CookieHTTPOnly: true,
+		CookieSecure:   a.secureCookies,
+		CookieSameSite: http.SameSiteStrictMode,
+	})
+}
+
+// checkCSRFToken checks the token of a safe request which acts anyway, e.g. one opening an EventSource
+func checkCSRFToken(c echo.Context, token string) error {
+	if auth.APITokenFromContext(c.Request().Context()) != nil {
+		return nil
+	}
+
+	expected, _ := c.Get(csrfContextKey).(string)
+	if expected == "" || subtle.ConstantTimeCompare([]byte(expected), []byte(token)) != 1 {
+		return echo.NewHTTPError(http.StatusForbidden, "invalid csrf token")
+	}
+
+	return nil
+}
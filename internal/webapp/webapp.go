@@ -3,52 +3,198 @@ package webapp
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/cybre/fingerbot-web/internal/auth"
 	"github.com/cybre/fingerbot-web/internal/devices"
+	"github.com/cybre/fingerbot-web/internal/history"
+	"github.com/cybre/fingerbot-web/internal/rules"
+	"github.com/cybre/fingerbot-web/internal/scheduler"
+	"github.com/cybre/fingerbot-web/internal/telemetry"
+	"github.com/cybre/fingerbot-web/internal/tuyable"
 	"github.com/cybre/fingerbot-web/internal/tuyable/fingerbot"
+	"github.com/cybre/fingerbot-web/internal/utils"
 	recurparse "github.com/karelbilek/template-parse-recursive"
 	"github.com/labstack/echo/v4"
 )
 
 type WebApp struct {
 	deviceManager *devices.Manager
-	templates     *template.Template
+	scheduler     *scheduler.Scheduler
+	rules         *rules.Engine
+	battery       *telemetry.BatteryMonitor
+	auth          *auth.Manager
+	// oidc logs users in with single sign-on, nil when it is not configured
+	oidc      *auth.OIDC
+	templates *template.Template
+	// renderers are copies of templates bound to the values of the request they render, see Render
+	renderers sync.Pool
+	// secureCookies marks the session cookie secure regardless of the request scheme
+	secureCookies bool
 }
 
 func NewWebApp(
 	deviceManager *devices.Manager,
+	scheduler *scheduler.Scheduler,
+	rules *rules.Engine,
+	battery *telemetry.BatteryMonitor,
+	auth *auth.Manager,
+	oidc *auth.OIDC,
+	secureCookies bool,
 ) *WebApp {
 	return &WebApp{
 		deviceManager: deviceManager,
-		templates:     template.Must(recurparse.HTMLParse(nil, "public", "*.html")),
+		scheduler:     scheduler,
+		rules:         rules,
+		battery:       battery,
+		auth:          auth,
+		oidc:          oidc,
+		secureCookies: secureCookies,
+		templates:     template.Must(recurparse.HTMLParse(template.New("").Funcs(requestFuncs), "public", "*.html")),
 	}
 }
 
+// requestFuncs give the templates the script nonce and the CSRF token of the request, each renderer replaces these
+// placeholders
+var requestFuncs = template.FuncMap{
+	"nonce":     func() string { return "" },
+	"csrfToken": func() string { return "" },
+}
+
 func (a *WebApp) RegisterRoutes(e *echo.Echo) {
+	e.Use(a.securityHeaders)
+	e.Use(a.csrf())
+	e.Use(a.authenticate)
+	e.Use(webSource)
+
+	e.GET("/login", a.handleLoginPage)
+	e.POST("/login", a.handleLogin)
+	e.GET("/login/oidc", a.handleOIDCLogin)
+	e.GET("/login/oidc/callback", a.handleOIDCCallback)
+	e.POST("/logout", a.handleLogout)
 	e.GET("/", a.handleIndex)
+	e.GET("/history", a.handleHistory)
+	e.GET("/history/export", a.handleExportHistory)
 	e.GET("/discover", a.handleDiscover)
 	devicesGroup := e.Group("/devices")
 	devicesGroup.GET("", a.handleDevices)
 	devicesGroup.POST("", a.handleConnectDevice)
 
+	usersGroup := e.Group("/users", a.requireAdmin)
+	usersGroup.GET("", a.handleUsers)
+	usersGroup.POST("", a.handleCreateUser)
+	usersGroup.PUT("/:id", a.handleUpdateUser)
+	usersGroup.DELETE("/:id", a.handleDeleteUser)
+
+	tokensGroup := e.Group("/tokens", a.requireAdmin)
+	tokensGroup.GET("", a.handleAPITokens)
+	tokensGroup.POST("", a.handleCreateAPIToken)
+	tokensGroup.PUT("/:id/revoke", a.handleRevokeAPIToken)
+
+	guestLinksGroup := e.Group("/guest-links", a.requireAdmin)
+	guestLinksGroup.GET("", a.handleGuestLinks)
+	guestLinksGroup.POST("", a.handleCreateGuestLink)
+	guestLinksGroup.PUT("/:id/revoke", a.handleRevokeGuestLink)
+
+	e.GET("/guest/:token", a.handleGuestPage)
+	e.POST("/guest/:token", a.handleUseGuestLink)
+
+	macrosGroup := e.Group("/macros", a.requireAdmin)
+	macrosGroup.GET("", a.handleMacros)
+	macrosGroup.POST("", a.handleCreateMacro)
+	macrosGroup.DELETE("/:id", a.handleDeleteMacro)
+	macrosGroup.GET("/:id/run", a.handleRunMacroEvents)
+	macrosGroup.POST("/:id/run", a.handleRunMacro)
+
+	schedulesGroup := e.Group("/schedules", a.requireAdmin)
+	schedulesGroup.GET("", a.handleSchedules)
+	schedulesGroup.POST("", a.handleCreateSchedule)
+	schedulesGroup.PUT("/:id/enable", a.handleEnableSchedule)
+	schedulesGroup.PUT("/:id/disable", a.handleDisableSchedule)
+	schedulesGroup.DELETE("/:id", a.handleDeleteSchedule)
+
+	rulesGroup := e.Group("/rules", a.requireAdmin)
+	rulesGroup.GET("", a.handleRules)
+	rulesGroup.POST("", a.handleCreateRule)
+	rulesGroup.POST("/dry-run", a.handleDryRunRule)
+	rulesGroup.GET("/:id", a.handleEditRule)
+	rulesGroup.PUT("/:id", a.handleUpdateRule)
+	rulesGroup.PUT("/:id/enable", a.handleEnableRule)
+	rulesGroup.PUT("/:id/disable", a.handleDisableRule)
+	rulesGroup.DELETE("/:id", a.handleDeleteRule)
+
 	deviceGroup := e.Group("/devices/:address")
 	deviceGroup.POST("/connect", a.handleConnectToSavedDevice)
 	deviceGroup.POST("/disconnect", a.handleDisconnectDevice)
 	deviceGroup.POST("/forget", a.handleForgetDevice)
 	deviceGroup.PUT("/toggle", a.handleToggle)
+	deviceGroup.PUT("/press", a.handlePress)
+	deviceGroup.PUT("/hold", a.handleHold)
+	deviceGroup.PUT("/release", a.handleRelease)
 	deviceGroup.GET("", a.handleDeviceIndex)
 	deviceGroup.GET("/configure", a.handleGetConfiguration)
 	deviceGroup.PUT("/configure", a.handleSaveConfiguration)
 	deviceGroup.GET("/battery-status", a.handleGetBatteryStatus)
+	deviceGroup.GET("/presets", a.handlePresets)
+	deviceGroup.POST("/presets", a.handleCreatePreset)
+	deviceGroup.DELETE("/presets/:id", a.handleDeletePreset)
+	deviceGroup.PUT("/presets/:id/apply", a.handleApplyPreset)
+	deviceGroup.PUT("/presets/:id/press", a.handlePressPreset)
+	deviceGroup.POST("/presets/:id/copy", a.handleCopyPreset)
+	deviceGroup.GET("/calibrate", a.handleCalibration)
+	deviceGroup.POST("/calibrate", a.handleStartCalibration)
+	deviceGroup.POST("/calibrate/press", a.handlePressCalibration)
+	deviceGroup.POST("/calibrate/confirm", a.handleConfirmCalibration)
+	deviceGroup.DELETE("/calibrate", a.handleCancelCalibration)
+	deviceGroup.GET("/protection", a.handleProtection)
+	deviceGroup.PUT("/protection", a.handleSaveProtection)
+	deviceGroup.GET("/interlocks", a.handleInterlocks)
+	deviceGroup.PUT("/interlocks", a.handleSaveInterlocks)
+	deviceGroup.GET("/versions", a.handleConfigurationVersions)
+	deviceGroup.PUT("/versions/:id/revert", a.handleRevertConfiguration)
+
+	a.registerAPIRoutes(e)
+}
+
+// renderer is a copy of the templates whose request funcs return its fields, it renders one request at a time
+type renderer struct {
+	templates *template.Template
+	nonce     string
+	csrfToken string
 }
 
+// Render executes the templates with the request funcs of the request. The parsed templates are never executed
+// themselves so they can always be copied, the copies are reused across requests.
 func (t *WebApp) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return t.templates.ExecuteTemplate(w, name, data)
+	r, ok := t.renderers.Get().(*renderer)
+	if !ok {
+		templates, err := t.templates.Clone()
+		if err != nil {
+			return fmt.Errorf("failed to clone templates: %w", err)
+		}
+
+		r = &renderer{templates: templates}
+		templates.Funcs(template.FuncMap{
+			"nonce":     func() string { return r.nonce },
+			"csrfToken": func() string { return r.csrfToken },
+		})
+	}
+	defer t.renderers.Put(r)
+
+	r.nonce, _ = c.Get(nonceContextKey).(string)
+	r.csrfToken, _ = c.Get(csrfContextKey).(string)
+	defer func() { r.nonce, r.csrfToken = "", "" }()
+
+	return r.templates.ExecuteTemplate(w, name, data)
 }
 
 func (a *WebApp) handleIndex(c echo.Context) error {
@@ -70,10 +216,28 @@ func (a *WebApp) handleDevices(c echo.Context) error {
 		return fmt.Errorf("failed to get saved devices: %w", err)
 	}
 
-	return c.Render(http.StatusOK, "devices.html", savedDevices)
+	permissions, err := a.permissions(c, "")
+	if err != nil {
+		return err
+	}
+
+	data := DevicesData{Admin: permissions.Admin}
+	for _, device := range savedDevices {
+		item, err := a.savedDeviceData(c, device)
+		if err != nil {
+			return err
+		}
+		data.Devices = append(data.Devices, item)
+	}
+
+	return c.Render(http.StatusOK, "devices.html", data)
 }
 
 func (a *WebApp) handleDiscover(c echo.Context) error {
+	if err := a.authorize(c, "", auth.RoleAdmin); err != nil {
+		return err
+	}
+
 	w := c.Response()
 	w.Header().Set("Content-Type", "text/event-stream")
 	w.Header().Set("Cache-Control", "no-cache")
@@ -125,6 +289,10 @@ func (a *WebApp) handleDiscover(c echo.Context) error {
 }
 
 func (a *WebApp) handleConnectDevice(c echo.Context) error {
+	if err := a.authorize(c, "", auth.RoleAdmin); err != nil {
+		return err
+	}
+
 	var request devices.DeviceConnection
 	if err := c.Bind(&request); err != nil {
 		return err
@@ -135,30 +303,60 @@ func (a *WebApp) handleConnectDevice(c echo.Context) error {
 		return err
 	}
 
-	return c.Render(http.StatusOK, "fragments/saved_device.html", device)
+	data, err := a.savedDeviceData(c, device)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/saved_device.html", data)
 }
 
 func (a *WebApp) handleConnectToSavedDevice(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleOperator); err != nil {
+		return err
+	}
+
 	device, err := a.deviceManager.ConnectToSavedDevice(c.Request().Context(), c.Param("address"))
 	if err != nil {
 		return err
 	}
 
-	return c.Render(http.StatusOK, "fragments/saved_device.html", device)
+	data, err := a.savedDeviceData(c, device)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/saved_device.html", data)
 }
 
 func (a *WebApp) handleDisconnectDevice(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
 	device, err := a.deviceManager.DisconnectDevice(c.Request().Context(), c.Param("address"))
 	if err != nil {
 		return err
 	}
 
-	return c.Render(http.StatusOK, "fragments/saved_device.html", device)
+	data, err := a.savedDeviceData(c, device)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/saved_device.html", data)
 }
 func (a *WebApp) handleForgetDevice(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
 	if err := a.deviceManager.ForgetDevice(c.Request().Context(), c.Param("address")); err != nil {
 		return err
 	}
+	if err := a.auth.DeleteDeviceGrants(c.Request().Context(), c.Param("address")); err != nil {
+		return err
+	}
 
 	time.Sleep(2 * time.Second)
 
@@ -166,77 +364,1064 @@ func (a *WebApp) handleForgetDevice(c echo.Context) error {
 }
 
 func (a *WebApp) handleToggle(c echo.Context) error {
-	fingerbot := a.deviceManager.GetFingerbot(c.Param("address"))
-	if fingerbot == nil {
-		return c.NoContent(http.StatusBadRequest)
+	if err := a.authorize(c, c.Param("address"), auth.RoleOperator); err != nil {
+		return err
+	}
+	if protected, err := a.protectPress(c, c.Param("address")); protected {
+		return err
+	}
+
+	result, err := a.deviceManager.Toggle(c.Request().Context(), c.Param("address"))
+	if err != nil {
+		return a.pressError(c, err)
+	}
+
+	return c.Render(http.StatusOK, "fragments/press_result.html", NewPressResultData(result))
+}
+
+func (a *WebApp) handlePress(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleOperator); err != nil {
+		return err
+	}
+
+	var request PressRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if protected, err := a.protectPress(c, c.Param("address")); protected {
+		return err
+	}
+
+	result, err := a.deviceManager.Press(c.Request().Context(), c.Param("address"), request.Options())
+	if err != nil {
+		setRetryAfter(c, err)
+		return httpError(err)
+	}
+
+	return c.JSON(http.StatusOK, NewPressResultData(result))
+}
+
+func (a *WebApp) handleHold(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleOperator); err != nil {
+		return err
+	}
+
+	var request HoldRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if protected, err := a.protectPress(c, c.Param("address")); protected {
+		return err
+	}
+
+	result, err := a.deviceManager.Hold(c.Request().Context(), c.Param("address"), time.Duration(request.MaxDuration)*time.Second)
+	if err != nil {
+		return a.pressError(c, err)
+	}
+
+	return c.Render(http.StatusOK, "fragments/press_result.html", NewPressResultData(result))
+}
+
+func (a *WebApp) handleRelease(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleOperator); err != nil {
+		return err
 	}
 
-	return fingerbot.SetSwitch(!fingerbot.Switch())
+	result, err := a.deviceManager.Release(c.Request().Context(), c.Param("address"))
+	if err != nil {
+		return httpError(err)
+	}
+
+	return c.Render(http.StatusOK, "fragments/press_result.html", NewPressResultData(result))
 }
 
 func (a *WebApp) handleDeviceIndex(c echo.Context) error {
 	fingerbot := a.deviceManager.GetFingerbot(c.Param("address"))
 	if fingerbot == nil {
-		return c.Redirect(http.StatusTemporaryRedirect, "/devices")
+		return a.handleOfflineDeviceIndex(c)
 	}
 
 	c.SetCookie(&http.Cookie{
-		Name:  "selectedDevice",
-		Value: fingerbot.Address(),
-		Path:  "/",
+		Name:     "selectedDevice",
+		Value:    fingerbot.Address(),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
 	})
 
-	return c.Render(http.StatusOK, "device.html", NewIndexData(fingerbot, a.deviceManager.GetConnectedDevices()))
+	ctx := c.Request().Context()
+	presets, err := a.deviceManager.GetPresets(ctx, fingerbot.Address())
+	if err != nil {
+		return err
+	}
+
+	batteryReport, err := a.battery.Report(ctx, fingerbot.Address(), time.Now().Add(-BatteryChartPeriod))
+	if err != nil {
+		return err
+	}
+
+	data := NewIndexData(fingerbot, a.deviceManager.GetConnectedDevices(), presets)
+	data.Battery = NewBatteryReportData(batteryReport, BatteryChartPeriod)
+
+	data.Permissions, err = a.permissions(c, fingerbot.Address())
+	if err != nil {
+		return err
+	}
+
+	protection, err := a.deviceManager.GetProtection(ctx, fingerbot.Address())
+	if err != nil {
+		return err
+	}
+	data.Protection = protection.Protection
+
+	interlocks, err := a.deviceManager.GetInterlocks(ctx, fingerbot.Address())
+	if err != nil {
+		return err
+	}
+	data.Maintenance, data.MaintenanceReason = interlocks.Maintenance, interlocks.MaintenanceReason
+
+	return c.Render(http.StatusOK, "device.html", data)
+}
+
+func (a *WebApp) handleOfflineDeviceIndex(c echo.Context) error {
+	ctx := c.Request().Context()
+	device, err := a.deviceManager.GetSavedDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return c.Redirect(http.StatusTemporaryRedirect, "/devices")
+	}
+
+	snapshot, err := a.deviceManager.GetSnapshot(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	presets, err := a.deviceManager.GetPresets(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	batteryReport, err := a.battery.Report(ctx, device.Address, time.Now().Add(-BatteryChartPeriod))
+	if err != nil {
+		return err
+	}
+
+	data := NewOfflineIndexData(device, snapshot, a.deviceManager.GetConnectedDevices(), presets)
+	data.Battery = NewBatteryReportData(batteryReport, BatteryChartPeriod)
+
+	data.Permissions, err = a.permissions(c, device.Address)
+	if err != nil {
+		return err
+	}
+
+	protection, err := a.deviceManager.GetProtection(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+	data.Protection = protection.Protection
+
+	interlocks, err := a.deviceManager.GetInterlocks(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+	data.Maintenance, data.MaintenanceReason = interlocks.Maintenance, interlocks.MaintenanceReason
+
+	return c.Render(http.StatusOK, "device.html", data)
 }
 
 func (a *WebApp) handleGetConfiguration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
 	fingerbot := a.deviceManager.GetFingerbot(c.Param("address"))
 	if fingerbot == nil {
+		return a.handleGetOfflineConfiguration(c)
+	}
+
+	data := NewConfigurationData(fingerbot)
+	c.Response().Header().Set("ETag", strconv.Quote(data.Tag()))
+
+	return c.Render(http.StatusOK, "device_configure.html", data)
+}
+
+func (a *WebApp) handleGetOfflineConfiguration(c echo.Context) error {
+	ctx := c.Request().Context()
+	device, err := a.deviceManager.GetSavedDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device == nil {
 		return c.Redirect(http.StatusTemporaryRedirect, "/devices")
 	}
 
-	return c.Render(http.StatusOK, "device_configure.html", NewConfigurationData(fingerbot))
+	snapshot, err := a.deviceManager.GetSnapshot(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	pending, err := a.deviceManager.GetPendingConfiguration(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	data := NewOfflineConfigurationData(device.Address, snapshot, pending)
+	c.Response().Header().Set("ETag", strconv.Quote(data.Tag()))
+
+	return c.Render(http.StatusOK, "device_configure.html", data)
 }
 
 func (a *WebApp) handleSaveConfiguration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
 	var config ConfigurationData
 	if err := c.Bind(&config); err != nil {
 		return err
 	}
 
-	device := a.deviceManager.GetFingerbot(c.Param("address"))
-	if device == nil {
-		return c.NoContent(http.StatusBadRequest)
-	}
+	ctx := c.Request().Context()
+	address := c.Param("address")
+	tag := strings.Trim(c.Request().Header.Get("If-Match"), `"`)
 
-	if err := device.Transaction(func(t *fingerbot.FingerbotTransaction) error {
-		if config.Mode != uint32(device.Mode()) {
-			t.SetMode(fingerbot.Mode(config.Mode))
-		}
-		if config.ClickSustainTime != device.ClickSustainTime() {
-			t.SetClickSustainTime(config.ClickSustainTime)
+	status := http.StatusOK
+	var err error
+	if a.deviceManager.GetFingerbot(address) == nil {
+		status = http.StatusAccepted
+		err = a.deviceManager.QueueConfiguration(ctx, address, tag, config.Configuration())
+	} else {
+		err = a.deviceManager.SaveConfiguration(ctx, address, tag, config.Configuration())
+	}
+	if errors.Is(err, devices.ErrConfigurationConflict) {
+		current, err := a.deviceManager.GetConfiguration(ctx, address)
+		if err != nil {
+			return err
 		}
-		if config.ControlBack != uint32(device.ControlBack()) {
-			t.SetControlBack(fingerbot.ControlBack(config.ControlBack))
+		return c.Render(http.StatusConflict, "fragments/configuration_conflict.html", NewConfigurationConflictData(config.Base, config, current))
+	}
+	if err != nil {
+		return httpError(err)
+	}
+
+	return c.NoContent(status)
+}
+
+func (a *WebApp) handleGetBatteryStatus(c echo.Context) error {
+	fingerbot := a.deviceManager.GetFingerbot(c.Param("address"))
+	if fingerbot == nil {
+		snapshot, err := a.deviceManager.GetSnapshot(c.Request().Context(), c.Param("address"))
+		if err != nil {
+			return err
 		}
-		if config.ArmDownPercent != device.ArmDownPercent() ||
-			config.ArmUpPercent != device.ArmUpPercent() {
-			t.SetArmPercent(config.ArmUpPercent, config.ArmDownPercent)
+		if snapshot == nil {
+			return c.NoContent(http.StatusBadRequest)
 		}
 
-		return nil
-	}); err != nil {
+		return c.JSON(http.StatusOK, NewSnapshotBatteryStatusData(snapshot))
+	}
+
+	return c.JSON(http.StatusOK, NewBatteryStatusData(fingerbot))
+}
+
+func (a *WebApp) handlePresets(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.deviceManager.GetSavedDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return c.Redirect(http.StatusTemporaryRedirect, "/devices")
+	}
+
+	configuration := ConfigurationData{ID: device.Address, ArmDownPercent: 100, ArmUpPercent: 20}
+	if fingerbot := a.deviceManager.GetFingerbot(device.Address); fingerbot != nil {
+		configuration = NewConfigurationData(fingerbot)
+	}
+
+	presets, err := a.deviceManager.GetPresets(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	savedDevices, err := a.deviceManager.GetSavedDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "device_presets.html", NewPresetsData(device, configuration, presets, savedDevices))
+}
+
+func (a *WebApp) handleCreatePreset(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request PresetRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	preset := request.Preset(c.Param("address"))
+	if err := a.deviceManager.CreatePreset(ctx, preset); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	savedDevices, err := a.deviceManager.GetSavedDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/preset.html", PresetItemData{
+		Preset:      preset,
+		CopyTargets: utils.Filter(savedDevices, func(d *devices.DeviceView) bool { return d.Address != preset.Address }),
+	})
+}
+
+func (a *WebApp) handleDeletePreset(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
 		return err
 	}
 
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	if err := a.deviceManager.DeletePreset(c.Request().Context(), c.Param("address"), id); err != nil {
+		return httpError(err)
+	}
+
 	return c.NoContent(http.StatusOK)
 }
 
-func (a *WebApp) handleGetBatteryStatus(c echo.Context) error {
-	fingerbot := a.deviceManager.GetFingerbot(c.Param("address"))
-	if fingerbot == nil {
+func (a *WebApp) handleApplyPreset(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
 		return c.NoContent(http.StatusBadRequest)
 	}
 
-	return c.JSON(http.StatusOK, NewBatteryStatusData(fingerbot))
+	if err := a.deviceManager.ApplyPreset(c.Request().Context(), c.Param("address"), id); err != nil {
+		return httpError(err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (a *WebApp) handleCalibration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	fingerbot := a.deviceManager.GetFingerbot(c.Param("address"))
+	if fingerbot == nil {
+		return c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("/devices/%s", c.Param("address")))
+	}
+
+	data := NewCalibrationData(fingerbot, a.deviceManager.GetCalibration(fingerbot.Address()))
+
+	return c.Render(http.StatusOK, "device_calibrate.html", data)
+}
+
+func (a *WebApp) handleStartCalibration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request CalibrationRequest
+	if err := c.Bind(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	calibration, err := a.deviceManager.StartCalibration(c.Param("address"), request.Start, request.Step, request.Margin)
+	if err != nil {
+		if errors.Is(err, devices.ErrDeviceNotConnected) {
+			return httpError(err)
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return a.renderCalibration(c, calibration)
+}
+
+func (a *WebApp) handlePressCalibration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	deeper, _ := strconv.ParseBool(c.FormValue("deeper"))
+	calibration, err := a.deviceManager.PressCalibration(c.Request().Context(), c.Param("address"), deeper)
+	if err != nil {
+		return httpError(err)
+	}
+
+	return a.renderCalibration(c, calibration)
+}
+
+func (a *WebApp) handleConfirmCalibration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	armDownPercent, err := a.deviceManager.ConfirmCalibration(c.Request().Context(), c.Param("address"))
+	if err != nil {
+		return httpError(err)
+	}
+
+	fingerbot := a.deviceManager.GetFingerbot(c.Param("address"))
+	if fingerbot == nil {
+		return httpError(devices.ErrDeviceNotConnected)
+	}
+
+	data := NewCalibrationData(fingerbot, nil)
+	data.SavedArmDownPercent = armDownPercent
+
+	return c.Render(http.StatusOK, "fragments/calibration.html", data)
+}
+
+func (a *WebApp) handleCancelCalibration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	a.deviceManager.CancelCalibration(c.Param("address"))
+
+	return a.renderCalibration(c, nil)
+}
+
+func (a *WebApp) renderCalibration(c echo.Context, calibration *devices.Calibration) error {
+	fingerbot := a.deviceManager.GetFingerbot(c.Param("address"))
+	if fingerbot == nil {
+		return httpError(devices.ErrDeviceNotConnected)
+	}
+
+	return c.Render(http.StatusOK, "fragments/calibration.html", NewCalibrationData(fingerbot, calibration))
+}
+
+func (a *WebApp) handleConfigurationVersions(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	device, err := a.deviceManager.GetSavedDevice(ctx, c.Param("address"))
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return c.Redirect(http.StatusTemporaryRedirect, "/devices")
+	}
+
+	var request VersionsRequest
+	if err := c.Bind(&request); err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	versions, err := a.deviceManager.GetConfigurationVersions(ctx, device.Address)
+	if err != nil {
+		return err
+	}
+
+	connected := a.deviceManager.GetFingerbot(device.Address) != nil
+
+	return c.Render(http.StatusOK, "device_versions.html", NewVersionsData(device, connected, versions, request))
+}
+
+func (a *WebApp) handleRevertConfiguration(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	if err := a.deviceManager.RevertConfiguration(c.Request().Context(), c.Param("address"), id); err != nil {
+		return httpError(err)
+	}
+
+	c.Response().Header().Set("HX-Redirect", fmt.Sprintf("/devices/%s/versions", c.Param("address")))
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (a *WebApp) handlePressPreset(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleOperator); err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+	if protected, err := a.protectPress(c, c.Param("address")); protected {
+		return err
+	}
+
+	result, err := a.deviceManager.PressWithPreset(c.Request().Context(), c.Param("address"), id)
+	if err != nil {
+		return a.pressError(c, err)
+	}
+
+	return c.Render(http.StatusOK, "fragments/press_result.html", NewPressResultData(result))
+}
+
+func (a *WebApp) handleCopyPreset(c echo.Context) error {
+	if err := a.authorize(c, c.Param("address"), auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	var request CopyPresetRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	if err := a.authorize(c, request.Target, auth.RoleAdmin); err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	if _, err := a.deviceManager.CopyPreset(c.Request().Context(), c.Param("address"), id, request.Target); err != nil {
+		return httpError(err)
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (a *WebApp) handleMacros(c echo.Context) error {
+	macros, err := a.deviceManager.GetMacros(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "macros.html", MacrosData{
+		Macros:  macros,
+		Devices: NewDeviceDropdownItems(a.deviceManager.GetConnectedDevices()),
+	})
+}
+
+func (a *WebApp) handleCreateMacro(c echo.Context) error {
+	var request MacroRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	steps, err := devices.ParseMacroSteps(request.Steps)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	macro := &devices.Macro{Name: request.Name, Steps: steps}
+	if err := a.deviceManager.CreateMacro(c.Request().Context(), macro); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.Render(http.StatusOK, "fragments/macro.html", MacroItemData{
+		Macro:   macro,
+		Devices: NewDeviceDropdownItems(a.deviceManager.GetConnectedDevices()),
+	})
+}
+
+func (a *WebApp) handleDeleteMacro(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	if err := a.deviceManager.DeleteMacro(c.Request().Context(), id); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// handleRunMacroEvents runs the macro streaming its progress to an EventSource, which can only send GET requests
+func (a *WebApp) handleRunMacroEvents(c echo.Context) error {
+	if err := checkCSRFToken(c, c.QueryParam(csrfField)); err != nil {
+		return err
+	}
+
+	var request RunMacroRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	w := c.Response()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	output := make(chan devices.MacroProgress)
+	// Keep draining if the client goes away so the macro can observe the cancellation
+	defer func() {
+		for range output {
+		}
+	}()
+
+	var runErr error
+	go func() {
+		runErr = a.deviceManager.RunMacro(c.Request().Context(), id, request.Addresses, output)
+		close(output)
+	}()
+
+	for progress := range output {
+		buff := bytes.NewBuffer(nil)
+		if err := c.Echo().Renderer.Render(buff, "fragments/macro_progress.html", progress, c); err != nil {
+			return fmt.Errorf("failed to render macro progress: %w", err)
+		}
+		event := Event{
+			Event: []byte("progress"),
+			Data:  buff.Bytes(),
+		}
+		if err := event.MarshalTo(w); err != nil {
+			return fmt.Errorf("failed to marshal event: %w", err)
+		}
+		w.Flush()
+	}
+
+	event := Event{
+		Event: []byte("finished"),
+		Data:  []byte("Macro finished"),
+	}
+	if runErr != nil {
+		event.Data = []byte(runErr.Error())
+	}
+	if err := event.MarshalTo(w); err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+	w.Flush()
+
+	return nil
+}
+
+func (a *WebApp) handleRunMacro(c echo.Context) error {
+	var request RunMacroRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	output := make(chan devices.MacroProgress)
+	progress := make([]devices.MacroProgress, 0)
+	done := make(chan struct{})
+	go func() {
+		for p := range output {
+			if p.Status != devices.MacroStepStatusRunning {
+				progress = append(progress, p)
+			}
+		}
+		close(done)
+	}()
+
+	runErr := a.deviceManager.RunMacro(c.Request().Context(), id, request.Addresses, output)
+	close(output)
+	<-done
+
+	if runErr != nil && len(progress) == 0 {
+		return httpError(runErr)
+	}
+
+	return c.JSON(http.StatusOK, progress)
+}
+
+func (a *WebApp) handleSchedules(c echo.Context) error {
+	ctx := c.Request().Context()
+	schedules, err := a.scheduler.GetSchedules(ctx)
+	if err != nil {
+		return err
+	}
+
+	savedDevices, err := a.deviceManager.GetSavedDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	macros, err := a.deviceManager.GetMacros(ctx)
+	if err != nil {
+		return err
+	}
+
+	deviceNames := map[string]string{}
+	presets := make([]PresetOption, 0)
+	for _, device := range savedDevices {
+		deviceNames[device.Address] = device.Name
+
+		devicePresets, err := a.deviceManager.GetPresets(ctx, device.Address)
+		if err != nil {
+			return err
+		}
+		for _, preset := range devicePresets {
+			presets = append(presets, PresetOption{ID: preset.ID, Address: preset.Address, DeviceName: device.Name, Name: preset.Name})
+		}
+	}
+
+	return c.Render(http.StatusOK, "schedules.html", SchedulesData{
+		Schedules: utils.Map(schedules, func(s *scheduler.Schedule) ScheduleItemData {
+			nextRun, ok := a.scheduler.NextRun(s)
+			return NewScheduleItemData(s, deviceNames, nextRun, ok)
+		}),
+		Devices: savedDevices,
+		Presets: presets,
+		Macros:  macros,
+	})
+}
+
+func (a *WebApp) handleCreateSchedule(c echo.Context) error {
+	var request ScheduleRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	schedule := request.Schedule()
+	if err := a.scheduler.CreateSchedule(ctx, schedule); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return a.renderSchedule(c, schedule)
+}
+
+func (a *WebApp) handleEnableSchedule(c echo.Context) error {
+	return a.setScheduleEnabled(c, true)
+}
+
+func (a *WebApp) handleDisableSchedule(c echo.Context) error {
+	return a.setScheduleEnabled(c, false)
+}
+
+func (a *WebApp) setScheduleEnabled(c echo.Context, enabled bool) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	schedule, err := a.scheduler.SetEnabled(c.Request().Context(), id, enabled)
+	if err != nil {
+		return httpError(err)
+	}
+
+	return a.renderSchedule(c, schedule)
+}
+
+func (a *WebApp) handleDeleteSchedule(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	if err := a.scheduler.DeleteSchedule(c.Request().Context(), id); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (a *WebApp) renderSchedule(c echo.Context, schedule *scheduler.Schedule) error {
+	device, err := a.deviceManager.GetSavedDevice(c.Request().Context(), schedule.Address)
+	if err != nil {
+		return err
+	}
+
+	deviceNames := map[string]string{}
+	if device != nil {
+		deviceNames[device.Address] = device.Name
+	}
+
+	nextRun, ok := a.scheduler.NextRun(schedule)
+	return c.Render(http.StatusOK, "fragments/schedule.html", NewScheduleItemData(schedule, deviceNames, nextRun, ok))
+}
+
+func (a *WebApp) handleRules(c echo.Context) error {
+	return a.renderRules(c, nil)
+}
+
+func (a *WebApp) handleEditRule(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	rule, err := a.rules.GetRule(c.Request().Context(), id)
+	if err != nil {
+		return httpError(err)
+	}
+
+	return a.renderRules(c, rule)
+}
+
+func (a *WebApp) renderRules(c echo.Context, editing *rules.Rule) error {
+	ctx := c.Request().Context()
+	ruleList, err := a.rules.GetRules(ctx)
+	if err != nil {
+		return err
+	}
+
+	savedDevices, err := a.deviceManager.GetSavedDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	presets := make([]PresetOption, 0)
+	for _, device := range savedDevices {
+		devicePresets, err := a.deviceManager.GetPresets(ctx, device.Address)
+		if err != nil {
+			return err
+		}
+		for _, preset := range devicePresets {
+			presets = append(presets, PresetOption{ID: preset.ID, Address: preset.Address, DeviceName: device.Name, Name: preset.Name})
+		}
+	}
+
+	return c.Render(http.StatusOK, "rules.html", RulesData{
+		Rules:   utils.Map(ruleList, NewRuleItemData),
+		Devices: savedDevices,
+		Presets: presets,
+		Editing: editing,
+	})
+}
+
+func (a *WebApp) handleCreateRule(c echo.Context) error {
+	var request RuleRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	rule, err := request.Rule()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := a.rules.CreateRule(c.Request().Context(), rule); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	return c.Render(http.StatusOK, "fragments/rule.html", NewRuleItemData(rule))
+}
+
+func (a *WebApp) handleUpdateRule(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	var request RuleRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	rule, err := request.Rule()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	rule.ID = id
+
+	if err := a.rules.UpdateRule(c.Request().Context(), rule); err != nil {
+		if errors.Is(err, rules.ErrRuleNotFound) {
+			return httpError(err)
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	c.Response().Header().Set("HX-Redirect", "/rules")
+	return c.NoContent(http.StatusOK)
+}
+
+func (a *WebApp) handleEnableRule(c echo.Context) error {
+	return a.setRuleEnabled(c, true)
+}
+
+func (a *WebApp) handleDisableRule(c echo.Context) error {
+	return a.setRuleEnabled(c, false)
+}
+
+func (a *WebApp) setRuleEnabled(c echo.Context, enabled bool) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	rule, err := a.rules.SetEnabled(c.Request().Context(), id, enabled)
+	if err != nil {
+		return httpError(err)
+	}
+
+	return c.Render(http.StatusOK, "fragments/rule.html", NewRuleItemData(rule))
+}
+
+func (a *WebApp) handleDeleteRule(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return c.NoContent(http.StatusBadRequest)
+	}
+
+	if err := a.rules.DeleteRule(c.Request().Context(), id); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+func (a *WebApp) handleDryRunRule(c echo.Context) error {
+	var request DryRunRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "fragments/rule_dry_run.html", NewDryRunData(a.rules.DryRun(request.Condition)))
+}
+
+func (a *WebApp) handleHistory(c echo.Context) error {
+	var request HistoryRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+	request.Page = max(request.Page, 1)
+
+	filter, err := request.Filter()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	filter.Limit = HistoryPageSize
+	filter.Offset = (request.Page - 1) * HistoryPageSize
+
+	ctx := c.Request().Context()
+	entries, total, err := a.deviceManager.GetHistory(ctx, filter)
+	if err != nil {
+		return err
+	}
+
+	savedDevices, err := a.deviceManager.GetSavedDevices(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.Render(http.StatusOK, "history.html", NewHistoryData(request, entries, total, savedDevices))
+}
+
+func (a *WebApp) handleExportHistory(c echo.Context) error {
+	var request HistoryRequest
+	if err := c.Bind(&request); err != nil {
+		return err
+	}
+
+	filter, err := request.Filter()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	entries, _, err := a.deviceManager.GetHistory(c.Request().Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	switch format := c.QueryParam("format"); format {
+	case "json":
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="history.json"`)
+		if entries == nil {
+			entries = []*history.Entry{}
+		}
+		return c.JSON(http.StatusOK, entries)
+	case "csv", "":
+		c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="history.csv"`)
+		c.Response().WriteHeader(http.StatusOK)
+
+		w := csv.NewWriter(c.Response())
+		if err := w.Write([]string{
+			"id", "time", "action", "address", "device_name", "source", "source_name", "outcome", "details", "error",
+		}); err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := w.Write([]string{
+				strconv.FormatInt(e.ID, 10), e.Time.Format(time.RFC3339), string(e.Action), e.Address, e.DeviceName,
+				string(e.Source), e.SourceName, string(e.Outcome), e.Details, e.Error,
+			}); err != nil {
+				return err
+			}
+		}
+		w.Flush()
+
+		return w.Error()
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("unsupported export format: %s", format))
+	}
+}
+
+// httpError maps known device errors to HTTP errors
+func httpError(err error) error {
+	var (
+		validation *tuyable.ValidationError
+		interlock  *devices.InterlockError
+	)
+	switch {
+	case errors.As(err, &validation):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, NewValidationErrorData(validation))
+	case errors.As(err, new(*fingerbot.CommitError)):
+		return echo.NewHTTPError(http.StatusBadGateway, err.Error())
+	case errors.Is(err, devices.ErrDeviceNotConnected), errors.Is(err, devices.ErrSwitchNotSettable):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, devices.ErrPresetNotFound), errors.Is(err, devices.ErrMacroNotFound),
+		errors.Is(err, devices.ErrConfigurationVersionNotFound),
+		errors.Is(err, scheduler.ErrScheduleNotFound), errors.Is(err, rules.ErrRuleNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, devices.ErrCalibrationNotStarted), errors.Is(err, devices.ErrCalibrationNoPress):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, fingerbot.ErrHolding), errors.Is(err, fingerbot.ErrNotHolding):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, fingerbot.ErrPressNotConfirmed):
+		return echo.NewHTTPError(http.StatusGatewayTimeout, err.Error())
+	case errors.As(err, new(*devices.ProtectionRequiredError)), errors.Is(err, devices.ErrConfigurationTagRequired):
+		return echo.NewHTTPError(http.StatusPreconditionRequired, err.Error())
+	case errors.Is(err, devices.ErrDeviceNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.As(err, new(*devices.WrongPINError)):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.As(err, new(*devices.PINLockedError)):
+		return echo.NewHTTPError(http.StatusLocked, err.Error())
+	case errors.Is(err, devices.ErrConfirmationInvalid), errors.Is(err, devices.ErrHeldTooShort):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, devices.ErrInvalidProtection), errors.Is(err, devices.ErrInvalidPIN),
+		errors.Is(err, devices.ErrInvalidInterlocks), errors.Is(err, devices.ErrHoldNotRequired):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.As(err, &interlock):
+		return echo.NewHTTPError(interlockStatus(interlock), err.Error())
+	case errors.Is(err, auth.ErrUsernameTaken), errors.Is(err, auth.ErrInvalidUsername),
+		errors.Is(err, auth.ErrPasswordTooShort), errors.Is(err, auth.ErrInvalidRole), errors.Is(err, auth.ErrLastAdmin):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.ErrLocalPasswordsDisabled):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, auth.ErrInvalidGuestAction), errors.Is(err, auth.ErrInvalidGuestLinkName),
+		errors.Is(err, auth.ErrInvalidGuestLinkExpiry), errors.Is(err, auth.ErrInvalidGuestLinkUses),
+		errors.Is(err, auth.ErrInvalidGuestLinkPIN):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.ErrUserNotFound), errors.Is(err, auth.ErrGuestLinkNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	default:
+		return err
+	}
 }